@@ -0,0 +1,382 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Results of checking an item during an audit
+const (
+	AuditConfirmed = "confirmed"
+	AuditAdjusted  = "adjusted"
+	AuditMissing   = "missing"
+)
+
+// Boxes not verified in this many months are reported by default
+const defaultAuditMonths = 6
+
+// Returned when checking items of or finishing an audit that is already finished
+var ErrAuditFinished = errors.New("audit is already finished")
+
+// Returned when finishing an audit before every item of the box was checked
+var ErrAuditIncomplete = errors.New("check every item before finishing the audit")
+
+// A stocktake of a box. Counted quantities are applied to the items when the audit is finished.
+type Audit struct {
+	ID         int          `json:"id"`
+	BoxID      int          `json:"box_id"`
+	BoxName    string       `json:"box_name"`
+	StartedAt  time.Time    `json:"started_at"`
+	FinishedAt JSONNullTime `json:"finished_at"`
+	Entries    []AuditEntry `json:"entries"`
+}
+
+// The result of checking one item during an audit.
+// Expected is what should have been in the box: the quantity of the item without what is lent out.
+type AuditEntry struct {
+	AuditID   int       `json:"audit_id"`
+	ItemID    int       `json:"item_id"`
+	ItemName  string    `json:"item_name"`
+	Expected  int       `json:"expected"`
+	Counted   int       `json:"counted"`
+	Status    string    `json:"status"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// Returns how many more were counted than expected, negative if some are missing
+func (e AuditEntry) Difference() int {
+	return e.Counted - e.Expected
+}
+
+// An item of the box being audited together with its result if it was checked already
+type AuditItem struct {
+	Item
+	Expected int         `json:"expected"`
+	Entry    *AuditEntry `json:"entry"`
+}
+
+// A difference between the records and the box found by a finished audit
+type Discrepancy struct {
+	AuditEntry
+	BoxID      int       `json:"box_id"`
+	BoxName    string    `json:"box_name"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+// Quantity of an item that should be in its box, which is its quantity without what is lent out
+const expectedQuantity = `contents.quantity - (SELECT COALESCE(SUM(quantity), 0) FROM loans WHERE loans.content_id = contents.id AND loans.returned_at IS NULL)`
+
+// Starts an audit of a box, or returns the audit of the box that is still going on
+func (d *Database) StartAudit(db *sql.DB, boxID int) (Audit, error) {
+	if _, err := d.GetBox(db, boxID); err != nil {
+		return Audit{}, err
+	}
+	var id int64
+	err := db.QueryRow(`SELECT id FROM audits WHERE box_id = ? AND finished_at IS NULL`, boxID).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		result, insertErr := db.Exec(`INSERT INTO audits (box_id) VALUES (?)`, boxID)
+		if insertErr != nil {
+			return Audit{}, insertErr
+		}
+		id, err = result.LastInsertId()
+	}
+	if err != nil {
+		return Audit{}, err
+	}
+	return d.GetAudit(db, int(id))
+}
+
+// Get an audit with the items checked so far
+func (d *Database) GetAudit(db *sql.DB, id int) (Audit, error) {
+	query := `
+	SELECT audits.id, audits.box_id, boxes.name, audits.started_at, audits.finished_at
+	FROM audits
+	JOIN boxes ON boxes.id = audits.box_id
+	WHERE audits.id = ?`
+	var audit Audit
+	err := db.QueryRow(query, id).Scan(&audit.ID, &audit.BoxID, &audit.BoxName, &audit.StartedAt, &audit.FinishedAt.NullTime)
+	if err != nil {
+		return audit, err
+	}
+
+	query = `
+	SELECT audit_id, content_id, item_name, expected, counted, status, checked_at
+	FROM audit_entries
+	WHERE audit_id = ?
+	ORDER BY checked_at, content_id`
+	rows, err := db.Query(query, id)
+	if err != nil {
+		return audit, err
+	}
+	defer rows.Close()
+
+	audit.Entries = make([]AuditEntry, 0)
+	for rows.Next() {
+		var entry AuditEntry
+		if err := rows.Scan(&entry.AuditID, &entry.ItemID, &entry.ItemName, &entry.Expected, &entry.Counted, &entry.Status, &entry.CheckedAt); err != nil {
+			return audit, err
+		}
+		audit.Entries = append(audit.Entries, entry)
+	}
+	return audit, rows.Err()
+}
+
+// Get the items of the box of an audit with what is expected of them and their results so far
+func (d *Database) GetAuditItems(db *sql.DB, audit Audit) ([]AuditItem, error) {
+	query := `SELECT ` + itemColumns + `, ` + expectedQuantity + ` FROM contents WHERE box_id = ? ORDER BY name, id`
+	rows, err := db.Query(query, audit.BoxID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make(map[int]*AuditEntry)
+	for i := range audit.Entries {
+		entries[audit.Entries[i].ItemID] = &audit.Entries[i]
+	}
+	items := make([]AuditItem, 0)
+	for rows.Next() {
+		var expected int
+		item, err := scanItem(rows, &expected)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, AuditItem{Item: item, Expected: expected, Entry: entries[item.ID]})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// Records the counted quantity of an item of the box being audited, or that it is missing.
+// Checking an item again replaces its result.
+func (d *Database) CheckAuditItem(db *sql.DB, auditID int, itemID int, counted int, missing bool) (AuditEntry, error) {
+	audit, err := d.GetAudit(db, auditID)
+	if err != nil {
+		return AuditEntry{}, err
+	}
+	if audit.FinishedAt.Valid {
+		return AuditEntry{}, ErrAuditFinished
+	}
+	entry := AuditEntry{AuditID: auditID, ItemID: itemID, Counted: counted}
+	query := `SELECT name, ` + expectedQuantity + ` FROM contents WHERE id = ? AND box_id = ?`
+	if err := db.QueryRow(query, itemID, audit.BoxID).Scan(&entry.ItemName, &entry.Expected); err != nil {
+		return entry, err
+	}
+	switch {
+	case missing:
+		entry.Counted = 0
+		entry.Status = AuditMissing
+	case counted == entry.Expected:
+		entry.Status = AuditConfirmed
+	default:
+		entry.Status = AuditAdjusted
+	}
+	query = `
+	INSERT INTO audit_entries (audit_id, content_id, item_name, expected, counted, status, checked_at) VALUES (?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+	ON CONFLICT (audit_id, content_id) DO UPDATE
+	SET item_name = excluded.item_name, expected = excluded.expected, counted = excluded.counted, status = excluded.status, checked_at = excluded.checked_at
+	RETURNING checked_at`
+	err = db.QueryRow(query, auditID, itemID, entry.ItemName, entry.Expected, entry.Counted, entry.Status).Scan(&entry.CheckedAt)
+	return entry, err
+}
+
+// Finishes an audit once every item of the box was checked with an atomic transaction.
+// Counted quantities that differ from the expected ones are recorded as adjustments in the stock movements ledger,
+// and the box is marked as verified.
+func (d *Database) FinishAudit(db *sql.DB, id int) (Audit, error) {
+	audit, err := d.GetAudit(db, id)
+	if err != nil {
+		return audit, err
+	}
+	if audit.FinishedAt.Valid {
+		return audit, ErrAuditFinished
+	}
+	items, err := d.GetAuditItems(db, audit)
+	if err != nil {
+		return audit, err
+	}
+	for _, item := range items {
+		if item.Entry == nil {
+			return audit, ErrAuditIncomplete
+		}
+	}
+
+	// Start a transaction
+	tx, err := db.Begin()
+	if err != nil {
+		return audit, err
+	}
+	// Defer a rollback in case something fails.
+	defer tx.Rollback()
+
+	var events []Event
+	for _, item := range items {
+		// Compared with what is expected now, in case the item changed since it was counted
+		delta := item.Entry.Counted - item.Expected
+		if delta == 0 {
+			continue
+		}
+		query := `UPDATE contents SET quantity = MAX(quantity + ?, 0), version = version + 1, updated_at = CURRENT_TIMESTAMP WHERE id = ?`
+		if _, err := tx.Exec(query, delta, item.ID); err != nil {
+			return audit, err
+		}
+		note := "counted in audit"
+		if item.Entry.Status == AuditMissing {
+			note = "missing in audit"
+		}
+		if _, err := recordStockMovement(tx, item.ID, delta, MovementAdjust, note); err != nil {
+			return audit, err
+		}
+		event, err := d.recordEvent(tx, Event{Type: EventItemUpdated, BoxID: audit.BoxID, ItemID: item.ID})
+		if err != nil {
+			return audit, err
+		}
+		events = append(events, event)
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	if _, err := tx.Exec(`UPDATE audits SET finished_at = ? WHERE id = ?`, now, id); err != nil {
+		return audit, err
+	}
+	if _, err := tx.Exec(`UPDATE boxes SET verified_at = ? WHERE id = ?`, now, audit.BoxID); err != nil {
+		return audit, err
+	}
+	event, err := d.recordEvent(tx, Event{Type: EventBoxUpdated, BoxID: audit.BoxID})
+	if err != nil {
+		return audit, err
+	}
+	events = append(events, event)
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		return audit, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	for _, event := range events {
+		d.publish(event)
+	}
+	return d.GetAudit(db, id)
+}
+
+// Abandons an audit that is still going on without changing anything
+func (d *Database) CancelAudit(db *sql.DB, id int) error {
+	audit, err := d.GetAudit(db, id)
+	if err != nil {
+		return err
+	}
+	if audit.FinishedAt.Valid {
+		return ErrAuditFinished
+	}
+	// Start a transaction
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	// Defer a rollback in case something fails.
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM audit_entries WHERE audit_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM audits WHERE id = ?`, id); err != nil {
+		return err
+	}
+	// Commit the transaction
+	return tx.Commit()
+}
+
+// Get the audits that are still going on in an inventory (0 for all)
+func (d *Database) GetOpenAudits(db *sql.DB, inventoryID int) ([]Audit, error) {
+	query := `
+	SELECT audits.id, audits.box_id, boxes.name, audits.started_at
+	FROM audits
+	JOIN boxes ON boxes.id = audits.box_id
+	WHERE audits.finished_at IS NULL AND (? = 0 OR boxes.inventory_id = ?)
+	ORDER BY audits.started_at`
+	rows, err := db.Query(query, inventoryID, inventoryID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	audits := make([]Audit, 0)
+	for rows.Next() {
+		var audit Audit
+		if err := rows.Scan(&audit.ID, &audit.BoxID, &audit.BoxName, &audit.StartedAt); err != nil {
+			return nil, err
+		}
+		audits = append(audits, audit)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return audits, nil
+}
+
+// Get the boxes of an inventory (0 for all) that were not verified since the given time, never verified ones first
+func (d *Database) GetUnverifiedBoxes(db *sql.DB, inventoryID int, since time.Time) ([]Box, error) {
+	query := `
+	SELECT ` + boxColumns + `
+	FROM boxes
+	WHERE (? = 0 OR inventory_id = ?) AND (verified_at IS NULL OR verified_at < ?)
+	ORDER BY verified_at IS NOT NULL, verified_at, name`
+	rows, err := db.Query(query, inventoryID, inventoryID, since.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	boxes := make([]Box, 0)
+	for rows.Next() {
+		box, err := scanBox(rows)
+		if err != nil {
+			return nil, err
+		}
+		boxes = append(boxes, box)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return boxes, nil
+}
+
+// Get the latest differences found by finished audits in an inventory (0 for all), newest first
+func (d *Database) GetDiscrepancies(db *sql.DB, inventoryID int, limit int) ([]Discrepancy, error) {
+	query := `
+	SELECT audit_entries.audit_id, audit_entries.content_id, audit_entries.item_name, audit_entries.expected, audit_entries.counted,
+		audit_entries.status, audit_entries.checked_at, audits.box_id, boxes.name, audits.finished_at
+	FROM audit_entries
+	JOIN audits ON audits.id = audit_entries.audit_id
+	JOIN boxes ON boxes.id = audits.box_id
+	WHERE audits.finished_at IS NOT NULL AND audit_entries.status != ? AND (? = 0 OR boxes.inventory_id = ?)
+	ORDER BY audits.finished_at DESC, audit_entries.item_name
+	LIMIT ?`
+	rows, err := db.Query(query, AuditConfirmed, inventoryID, inventoryID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	discrepancies := make([]Discrepancy, 0)
+	for rows.Next() {
+		var d Discrepancy
+		err := rows.Scan(&d.AuditID, &d.ItemID, &d.ItemName, &d.Expected, &d.Counted, &d.Status, &d.CheckedAt, &d.BoxID, &d.BoxName, &d.FinishedAt)
+		if err != nil {
+			return nil, err
+		}
+		discrepancies = append(discrepancies, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return discrepancies, nil
+}
+
+// Returns the inventory of the box of an audit, sql.ErrNoRows if the audit does not exist
+func (d *Database) auditInventory(db *sql.DB, auditID int) (int, error) {
+	var inventoryID int
+	query := `SELECT boxes.inventory_id FROM audits JOIN boxes ON boxes.id = audits.box_id WHERE audits.id = ?`
+	err := db.QueryRow(query, auditID).Scan(&inventoryID)
+	return inventoryID, err
+}
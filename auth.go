@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Key of the identity of the user in the gin context
+const actorKey = "actor"
+
+// Proxies trusted if AUTH_TRUSTED_PROXIES is not set
+const defaultTrustedProxies = "127.0.0.1/32,::1/128"
+
+// Authentication by a reverse proxy such as Authelia or oauth2-proxy which passes the name
+// of the signed in user in a header. Only requests from the trusted proxies are accepted,
+// since anyone else could set the header themselves.
+type TrustedHeaderAuth struct {
+	// Headers holding the identity, the first one set wins
+	Headers []string
+	Proxies []*net.IPNet
+}
+
+// Reads the trusted header settings from the environment. Returns a disabled config if no header is set.
+func trustedHeaderAuthFromEnv() (TrustedHeaderAuth, error) {
+	var auth TrustedHeaderAuth
+	for _, header := range strings.Split(getEnv("AUTH_TRUSTED_HEADER", ""), ",") {
+		if header = strings.TrimSpace(header); header != "" {
+			auth.Headers = append(auth.Headers, http.CanonicalHeaderKey(header))
+		}
+	}
+	proxies, err := parseCIDRs(getEnv("AUTH_TRUSTED_PROXIES", defaultTrustedProxies))
+	if err != nil {
+		return auth, fmt.Errorf("invalid AUTH_TRUSTED_PROXIES: %w", err)
+	}
+	auth.Proxies = proxies
+	return auth, nil
+}
+
+// Parses a comma separated list of networks in CIDR notation. Single addresses are taken as networks of their own.
+func parseCIDRs(value string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("%q is neither an address nor a network", entry)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				bits = 32
+			}
+			entry = fmt.Sprintf("%s/%d", entry, bits)
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// Reports whether trusted header authentication is turned on
+func (a TrustedHeaderAuth) Enabled() bool {
+	return len(a.Headers) > 0
+}
+
+// Reports whether a request was sent directly by one of the trusted proxies
+func (a TrustedHeaderAuth) trusted(c *gin.Context) bool {
+	ip := net.ParseIP(c.RemoteIP())
+	if ip == nil {
+		return false
+	}
+	for _, network := range a.Proxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Rejects requests that did not come through a trusted proxy and stores the identity from the header.
+// Share links are meant for people without an account, so they only need to come through the proxy.
+func (a TrustedHeaderAuth) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !a.trusted(c) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"fail": "requests must come through the authenticating proxy"})
+			return
+		}
+		for _, header := range a.Headers {
+			if identity := strings.TrimSpace(c.GetHeader(header)); identity != "" {
+				c.Set(actorKey, identity)
+				break
+			}
+		}
+		if currentActor(c) == "" && !strings.HasPrefix(c.Request.URL.Path, "/shared/") {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"fail": "the proxy did not pass a user"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// Returns the identity of the user making the request, empty if it is unknown
+func currentActor(c *gin.Context) string {
+	return c.GetString(actorKey)
+}
+
+// Returns the database acting on behalf of the user making the request,
+// so every change it makes is recorded with the identity of the user
+func actorDatabase(c *gin.Context) *Database {
+	return database.As(currentActor(c))
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+)
+
+// Returned for anything which is not a valid EAN-8, UPC-A, EAN-13 or GTIN-14 barcode
+var ErrInvalidBarcode = errors.New("invalid barcode")
+
+// Validates a scanned or typed barcode and brings it into the form stored in the database.
+// UPC-A codes are stored as EAN-13 with a leading zero, just like scanners and Open Food Facts report them.
+func normalizeBarcode(code string) (string, error) {
+	code = strings.Map(func(r rune) rune {
+		if r == ' ' || r == '-' {
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(code))
+	for _, r := range code {
+		if r < '0' || r > '9' {
+			return "", ErrInvalidBarcode
+		}
+	}
+	switch len(code) {
+	case 12:
+		code = "0" + code
+	case 14:
+		code = strings.TrimPrefix(code, "0")
+	case 8, 13:
+	default:
+		return "", ErrInvalidBarcode
+	}
+	if !validCheckDigit(code) {
+		return "", ErrInvalidBarcode
+	}
+	return code, nil
+}
+
+// Verifies the GS1 check digit, the last digit of EAN and UPC codes.
+// Counting from the right, digits are alternately weighted 3 and 1 starting next to the check digit.
+func validCheckDigit(code string) bool {
+	sum := 0
+	for i := len(code) - 2; i >= 0; i-- {
+		digit := int(code[i] - '0')
+		if (len(code)-2-i)%2 == 0 {
+			digit *= 3
+		}
+		sum += digit
+	}
+	return (10-sum%10)%10 == int(code[len(code)-1]-'0')
+}
+
+// Parses an optional barcode from a form or JSON body. An empty string means no barcode.
+func parseOptionalBarcode(value string) (sql.NullString, error) {
+	if strings.TrimSpace(value) == "" {
+		return sql.NullString{}, nil
+	}
+	code, err := normalizeBarcode(value)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	return sql.NullString{String: code, Valid: true}, nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Pattern of new box codes if BOX_CODE_PATTERN is not set
+const defaultBoxCodePattern = "A-###"
+
+// Characters of random box codes: Crockford's base32 without I, L, O and U which are easily confused
+const boxCodeAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// Setting holding the last number used for box codes
+const settingBoxCodeSequence = "box_code_sequence"
+
+// Number of codes tried before giving up on finding an unused one
+const boxCodeAttempts = 100
+
+// Returned for box code patterns that can't produce unique codes or are unsafe in links
+var ErrInvalidBoxCodePattern = errors.New("box code pattern needs one run of # or at least one *, and may only contain letters, digits, - and _")
+
+// Checks a box code pattern.
+// A run of # is replaced by the next number of a sequence, padded with zeros to the length of the run,
+// every * by a random character. Everything else is kept as it is.
+func validateBoxCodePattern(pattern string) error {
+	if strings.Count(pattern, "#") == 0 && strings.Count(pattern, "*") == 0 {
+		return ErrInvalidBoxCodePattern
+	}
+	first, last := strings.Index(pattern, "#"), strings.LastIndex(pattern, "#")
+	if first >= 0 && strings.Count(pattern, "#") != last-first+1 {
+		return ErrInvalidBoxCodePattern
+	}
+	for _, r := range pattern {
+		if !(r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || strings.ContainsRune("-_#*", r)) {
+			return ErrInvalidBoxCodePattern
+		}
+	}
+	return nil
+}
+
+// Builds a box code from a validated pattern and a sequence number
+func formatBoxCode(pattern string, sequence int) (string, error) {
+	var code strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '#':
+			width := strings.Count(pattern, "#")
+			code.WriteString(fmt.Sprintf("%0*d", width, sequence))
+			i += width - 1
+		case '*':
+			n, err := rand.Int(rand.Reader, big.NewInt(int64(len(boxCodeAlphabet))))
+			if err != nil {
+				return "", err
+			}
+			code.WriteByte(boxCodeAlphabet[n.Int64()])
+		default:
+			code.WriteByte(pattern[i])
+		}
+	}
+	return normalizeBoxCode(code.String()), nil
+}
+
+// Box codes are compared without regard to case and surrounding spaces
+func normalizeBoxCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// Returns the pattern for new box codes
+func (d *Database) boxCodePattern() string {
+	if d.BoxCodePattern == "" {
+		return defaultBoxCodePattern
+	}
+	return d.BoxCodePattern
+}
+
+// Finds an unused code for a new box within a transaction
+func (d *Database) nextBoxCode(tx *sql.Tx) (string, error) {
+	pattern := d.boxCodePattern()
+	for attempt := 0; attempt < boxCodeAttempts; attempt++ {
+		sequence := 0
+		if strings.Contains(pattern, "#") {
+			query := `
+			INSERT INTO settings (key, value) VALUES (?, '1')
+			ON CONFLICT (key) DO UPDATE SET value = CAST(value AS INTEGER) + 1
+			RETURNING value`
+			var value string
+			if err := tx.QueryRow(query, settingBoxCodeSequence).Scan(&value); err != nil {
+				return "", err
+			}
+			var err error
+			if sequence, err = strconv.Atoi(value); err != nil {
+				return "", err
+			}
+		}
+		code, err := formatBoxCode(pattern, sequence)
+		if err != nil {
+			return "", err
+		}
+		var taken int
+		err = tx.QueryRow(`SELECT COUNT(*) FROM boxes WHERE code = ?`, code).Scan(&taken)
+		if err != nil {
+			return "", err
+		}
+		if taken == 0 {
+			return code, nil
+		}
+	}
+	return "", fmt.Errorf("no unused box code found for pattern %s", pattern)
+}
+
+// Gives every box without a code one, in the order the boxes were created
+func (d *Database) backfillBoxCodes(db *sql.DB) error {
+	// Start a transaction
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	// Defer a rollback in case something fails.
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	rows, err := tx.Query(`SELECT id FROM boxes WHERE code IS NULL ORDER BY id`)
+	if err != nil {
+		return err
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err = rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		var code string
+		if code, err = d.nextBoxCode(tx); err != nil {
+			return err
+		}
+		if _, err = tx.Exec(`UPDATE boxes SET code = ? WHERE id = ?`, code, id); err != nil {
+			return err
+		}
+	}
+
+	// Commit the transaction
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// Get a single box by its code
+func (d *Database) GetBoxByCode(db *sql.DB, code string) (Box, error) {
+	var id int
+	err := db.QueryRow(`SELECT id FROM boxes WHERE code = ?`, normalizeBoxCode(code)).Scan(&id)
+	if err != nil {
+		return Box{}, err
+	}
+	return d.GetBox(db, id)
+}
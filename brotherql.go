@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"strings"
+)
+
+// Resolution of Brother QL printers in dots per inch
+const brotherQLDPI = 300
+
+// Pixels of a raster line of the print head of the QL-500 to QL-820 series
+const brotherQLLineWidth = 720
+
+// Media types of the print information command
+const (
+	brotherQLContinuous = 0x0A
+	brotherQLDieCut     = 0x0B
+)
+
+// Feed before and after continuous labels in dots
+const brotherQLFeedMargin = 35
+
+// A tape of a Brother QL printer
+type BrotherQLTape struct {
+	Name     string
+	WidthMM  int
+	LengthMM int
+	// Printable dots across the tape
+	Dots int
+	// Printable dots along die-cut labels, 0 for continuous tape
+	Length int
+	// Unprinted dots at the right edge of the print head
+	Offset int
+}
+
+// Returns whether the tape is continuous rather than die-cut labels
+func (t BrotherQLTape) Continuous() bool {
+	return t.Length == 0
+}
+
+// Tapes and die-cut labels of the common widths
+var brotherQLTapes = []BrotherQLTape{
+	{Name: "12", WidthMM: 12, Dots: 106, Offset: 29},
+	{Name: "29", WidthMM: 29, Dots: 306, Offset: 6},
+	{Name: "38", WidthMM: 38, Dots: 413, Offset: 12},
+	{Name: "50", WidthMM: 50, Dots: 554, Offset: 12},
+	{Name: "54", WidthMM: 54, Dots: 590, Offset: 0},
+	{Name: "62", WidthMM: 62, Dots: 696, Offset: 12},
+	{Name: "29x90", WidthMM: 29, LengthMM: 90, Dots: 306, Length: 991, Offset: 6},
+	{Name: "62x29", WidthMM: 62, LengthMM: 29, Dots: 696, Length: 271, Offset: 12},
+	{Name: "62x100", WidthMM: 62, LengthMM: 100, Dots: 696, Length: 1109, Offset: 12},
+}
+
+// Finds a tape by its name such as "62" or "62x29"
+func brotherQLTapeByName(name string) (BrotherQLTape, error) {
+	for _, tape := range brotherQLTapes {
+		if strings.EqualFold(tape.Name, strings.TrimSpace(name)) {
+			return tape, nil
+		}
+	}
+	names := make([]string, len(brotherQLTapes))
+	for i, tape := range brotherQLTapes {
+		names[i] = tape.Name
+	}
+	return BrotherQLTape{}, fmt.Errorf("unknown tape %q, use one of %s", name, strings.Join(names, ", "))
+}
+
+// Renders labels as a raster command stream for Brother QL printers, one page per label.
+// Labels are drawn in landscape and turned if the tape is narrower than they are long,
+// so text runs along narrow tapes. Continuous tape is cut to the length of the template.
+func renderBrotherQL(template LabelTemplate, tape BrotherQLTape, labels []Label, baseURL string) ([]byte, error) {
+	length := tape.Length
+	if tape.Continuous() {
+		length = mmToDots(template.HeightMM, brotherQLDPI)
+	}
+	width, height := max(tape.Dots, length), min(tape.Dots, length)
+	rotate := tape.Dots < length
+
+	var stream bytes.Buffer
+	// Invalidate to clear a print job that was cut off, then initialize
+	stream.Write(make([]byte, 200))
+	stream.Write([]byte{0x1B, 0x40})
+	for page, label := range labels {
+		img, err := template.rasterize(label, baseURL, width, height)
+		if err != nil {
+			return nil, err
+		}
+		if rotate {
+			img = rotateClockwise(img)
+		}
+		writeBrotherQLPage(&stream, tape, img, page)
+		if page == len(labels)-1 {
+			// Print the last page and feed
+			stream.WriteByte(0x1A)
+		} else {
+			stream.WriteByte(0x0C)
+		}
+	}
+	return stream.Bytes(), nil
+}
+
+// Writes the commands of a single page whose image is as wide as the printable area of the tape
+func writeBrotherQLPage(stream *bytes.Buffer, tape BrotherQLTape, img *image.Gray, page int) {
+	lines := img.Bounds().Dy()
+	// Switch to raster mode
+	stream.Write([]byte{0x1B, 0x69, 0x61, 0x01})
+
+	// Print information: media type, width and length are valid, recover on errors
+	mediaType := byte(brotherQLDieCut)
+	margin := 0
+	if tape.Continuous() {
+		mediaType = brotherQLContinuous
+		margin = brotherQLFeedMargin
+	}
+	stream.Write([]byte{0x1B, 0x69, 0x7A, 0x80 | 0x02 | 0x04 | 0x08, mediaType, byte(tape.WidthMM), byte(tape.LengthMM)})
+	binary.Write(stream, binary.LittleEndian, uint32(lines))
+	startingPage := byte(0)
+	if page > 0 {
+		startingPage = 1
+	}
+	stream.Write([]byte{startingPage, 0x00})
+
+	// Cut after every label and at the end
+	stream.Write([]byte{0x1B, 0x69, 0x4D, 0x40})
+	stream.Write([]byte{0x1B, 0x69, 0x41, 0x01})
+	stream.Write([]byte{0x1B, 0x69, 0x4B, 0x08})
+	stream.Write([]byte{0x1B, 0x69, 0x64})
+	binary.Write(stream, binary.LittleEndian, uint16(margin))
+	// No compression
+	stream.Write([]byte{0x4D, 0x00})
+
+	// The print head sees the label mirrored, the first bit is the leftmost pixel of the head
+	line := make([]byte, brotherQLLineWidth/8)
+	for y := 0; y < lines; y++ {
+		clear(line)
+		for x := 0; x < tape.Dots; x++ {
+			if isDark(img, x, y) {
+				pixel := tape.Offset + tape.Dots - 1 - x
+				line[pixel/8] |= 0x80 >> (pixel % 8)
+			}
+		}
+		stream.Write([]byte{0x67, 0x00, byte(len(line))})
+		stream.Write(line)
+	}
+}
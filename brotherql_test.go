@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// Compares data with a golden file in testdata, or rewrites the file with -update
+func checkGolden(t *testing.T, name string, data []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *updateGolden {
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, want) {
+		t.Errorf("output differs from %s (%d bytes, want %d), run the tests with -update after checking the change", path, len(data), len(want))
+	}
+}
+
+// A page of a Brother QL command stream
+type brotherQLPage struct {
+	Info   []byte
+	Margin int
+	Lines  [][]byte
+	// The byte ending the page, 0x0C for more pages or 0x1A for the last one
+	End byte
+}
+
+// Splits a Brother QL command stream into its pages, checking every command on the way
+func parseBrotherQL(t *testing.T, stream []byte) []brotherQLPage {
+	t.Helper()
+	expect := func(want ...byte) {
+		t.Helper()
+		if !bytes.HasPrefix(stream, want) {
+			t.Fatalf("stream continues with % x, want % x", stream[:min(len(stream), len(want))], want)
+		}
+		stream = stream[len(want):]
+	}
+	expect(make([]byte, 200)...)
+	expect(0x1B, 0x40)
+
+	var pages []brotherQLPage
+	for len(stream) > 0 {
+		var page brotherQLPage
+		expect(0x1B, 0x69, 0x61, 0x01)
+		expect(0x1B, 0x69, 0x7A)
+		page.Info, stream = stream[:10], stream[10:]
+		expect(0x1B, 0x69, 0x4D, 0x40)
+		expect(0x1B, 0x69, 0x41, 0x01)
+		expect(0x1B, 0x69, 0x4B, 0x08)
+		expect(0x1B, 0x69, 0x64)
+		page.Margin, stream = int(binary.LittleEndian.Uint16(stream)), stream[2:]
+		expect(0x4D, 0x00)
+		for len(stream) > 0 && stream[0] == 0x67 {
+			expect(0x67, 0x00, brotherQLLineWidth/8)
+			page.Lines = append(page.Lines, stream[:brotherQLLineWidth/8])
+			stream = stream[brotherQLLineWidth/8:]
+		}
+		page.End, stream = stream[0], stream[1:]
+		if lines := binary.LittleEndian.Uint32(page.Info[4:8]); int(lines) != len(page.Lines) {
+			t.Errorf("page %d announces %d raster lines, has %d", len(pages), lines, len(page.Lines))
+		}
+		pages = append(pages, page)
+	}
+	return pages
+}
+
+// Returns whether a pixel of the print head is printed on a raster line
+func headPixel(line []byte, pixel int) bool {
+	return line[pixel/8]&(0x80>>(pixel%8)) != 0
+}
+
+func TestRenderBrotherQLDieCut(t *testing.T) {
+	tape, err := brotherQLTapeByName("62x29")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, err := renderBrotherQL(testLabelTemplate, tape, testLabels, "https://witb.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "brotherql_62x29.bin", stream)
+
+	pages := parseBrotherQL(t, stream)
+	if len(pages) != 2 {
+		t.Fatalf("got %d pages, want 2", len(pages))
+	}
+	for i, page := range pages {
+		// Valid media type, width and length, recovery on, die-cut 62 x 29 mm, 271 lines, starting page flag
+		want := []byte{0x8E, brotherQLDieCut, 62, 29, 0x0F, 0x01, 0x00, 0x00, byte(min(i, 1)), 0x00}
+		if !bytes.Equal(page.Info, want) {
+			t.Errorf("page %d print information % x, want % x", i, page.Info, want)
+		}
+		if page.Margin != 0 {
+			t.Errorf("page %d has a feed margin of %d dots on die-cut labels", i, page.Margin)
+		}
+	}
+	if pages[0].End != 0x0C || pages[1].End != 0x1A {
+		t.Errorf("pages end with %#x and %#x, want 0x0c and 0x1a", pages[0].End, pages[1].End)
+	}
+
+	// The label has a margin of 16 dots, the 29 modules of the QR code are scaled to 232 of its 239 dots and centered.
+	// The corner at 19,19 of the label is mirrored onto the print head, after the offset of the tape.
+	line := pages[0].Lines[19]
+	if !headPixel(line, tape.Offset+tape.Dots-1-19) {
+		t.Error("top left corner of the QR code is not printed")
+	}
+	if headPixel(pages[0].Lines[18], tape.Offset+tape.Dots-1-19) || headPixel(line, tape.Offset+tape.Dots-1-18) {
+		t.Error("margin next to the QR code is printed")
+	}
+	// Nothing is printed outside of the tape
+	for y, line := range pages[0].Lines {
+		for pixel := 0; pixel < brotherQLLineWidth; pixel++ {
+			if (pixel < tape.Offset || pixel >= tape.Offset+tape.Dots) && headPixel(line, pixel) {
+				t.Fatalf("pixel %d of line %d is printed outside of the tape", pixel, y)
+			}
+		}
+	}
+}
+
+func TestRenderBrotherQLContinuous(t *testing.T) {
+	tape, err := brotherQLTapeByName("29")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, err := renderBrotherQL(testLabelTemplate, tape, testLabels[:1], "https://witb.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "brotherql_29.bin", stream)
+
+	pages := parseBrotherQL(t, stream)
+	if len(pages) != 1 {
+		t.Fatalf("got %d pages, want 1", len(pages))
+	}
+	page := pages[0]
+	// Continuous tape is cut after the 29 mm of the template, 343 lines at 300 dpi
+	want := []byte{0x8E, brotherQLContinuous, 29, 0, 0x57, 0x01, 0x00, 0x00, 0x00, 0x00}
+	if !bytes.Equal(page.Info, want) {
+		t.Errorf("print information % x, want % x", page.Info, want)
+	}
+	if page.Margin != brotherQLFeedMargin || page.End != 0x1A {
+		t.Errorf("feed margin %d and end %#x, want %d and 0x1a", page.Margin, page.End, brotherQLFeedMargin)
+	}
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Returned when a scanned barcode is neither on an item of the box nor in the product catalog
+var ErrUnknownBarcode = errors.New("barcode is not in the product catalog")
+
+// Where a product in the catalog came from.
+// Products entered by hand are never overwritten by imports.
+const (
+	ProductSourceManual = "manual"
+	ProductSourceImport = "import"
+)
+
+// Number of products written per transaction while importing
+const catalogImportBatchSize = 1000
+
+// Define product struct with json marshalling config
+// Unit describes the package such as "500 g", DefaultQuantity is added per scan.
+type Product struct {
+	Barcode         string         `json:"barcode"`
+	Name            string         `json:"name"`
+	Brand           JSONNullString `json:"brand"`
+	Unit            JSONNullString `json:"unit"`
+	DefaultQuantity int            `json:"default_quantity"`
+	Source          string         `json:"source"`
+	UpdatedAt       time.Time      `json:"updated_at"`
+}
+
+// Counts of a catalog import
+type CatalogImportResult struct {
+	Imported int `json:"imported"`
+	Skipped  int `json:"skipped"`
+}
+
+// Outcome of scanning a barcode into a box
+type ScanResult struct {
+	// Either "incremented" or "created"
+	Action string `json:"action"`
+	Item   Item   `json:"item"`
+}
+
+// Get a product from the catalog by its normalized barcode
+func (d *Database) GetProduct(db *sql.DB, barcode string) (Product, error) {
+	query := `SELECT barcode, name, brand, unit, default_quantity, source, updated_at FROM products WHERE barcode = ?`
+	var product Product
+	err := db.QueryRow(query, barcode).Scan(&product.Barcode, &product.Name, &product.Brand.NullString, &product.Unit.NullString, &product.DefaultQuantity, &product.Source, &product.UpdatedAt)
+	return product, err
+}
+
+// Creates or replaces a product entered by hand
+func (d *Database) SaveProduct(db *sql.DB, product Product) error {
+	query := `
+	INSERT INTO products (barcode, name, brand, unit, default_quantity, source)
+	VALUES (?, ?, ?, ?, ?, ?)
+	ON CONFLICT (barcode) DO UPDATE SET
+		name = excluded.name,
+		brand = excluded.brand,
+		unit = excluded.unit,
+		default_quantity = excluded.default_quantity,
+		source = excluded.source,
+		updated_at = CURRENT_TIMESTAMP`
+	_, err := db.Exec(query, product.Barcode, product.Name, product.Brand.NullString, product.Unit.NullString, product.DefaultQuantity, ProductSourceManual)
+	return err
+}
+
+// Imports products from a CSV file into the catalog.
+// Both the tab separated Open Food Facts dump (optionally gzipped) and simple comma separated files
+// with the columns barcode, name, brand, unit and default_quantity are understood.
+// Rows without a valid barcode or a name are skipped.
+func (d *Database) ImportProducts(db *sql.DB, r io.Reader) (CatalogImportResult, error) {
+	var result CatalogImportResult
+	next, err := catalogRecords(r)
+	if err != nil {
+		return result, err
+	}
+	header, err := next()
+	if err != nil {
+		return result, fmt.Errorf("could not read header: %w", err)
+	}
+	columns := catalogColumns(header)
+	if columns.barcode < 0 || columns.name < 0 {
+		return result, errors.New("the file needs a code or barcode and a product_name or name column")
+	}
+
+	query := `
+	INSERT INTO products (barcode, name, brand, unit, default_quantity, source)
+	VALUES (?, ?, ?, ?, ?, ?)
+	ON CONFLICT (barcode) DO UPDATE SET
+		name = excluded.name,
+		brand = excluded.brand,
+		unit = excluded.unit,
+		source = excluded.source,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE products.source != ?`
+
+	var tx *sql.Tx
+	batch := 0
+	// Roll back the open batch in case something fails
+	defer func() {
+		if tx != nil {
+			tx.Rollback()
+		}
+	}()
+	for {
+		record, err := next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return result, err
+		}
+		product, ok := columns.product(record)
+		if !ok {
+			result.Skipped++
+			continue
+		}
+		if tx == nil {
+			if tx, err = db.Begin(); err != nil {
+				return result, err
+			}
+		}
+		_, err = tx.Exec(query, product.Barcode, product.Name, product.Brand.NullString, product.Unit.NullString, product.DefaultQuantity, ProductSourceImport, ProductSourceManual)
+		if err != nil {
+			return result, fmt.Errorf("failed to import product %s: %w", product.Barcode, err)
+		}
+		result.Imported++
+		batch++
+		if batch == catalogImportBatchSize {
+			if err := tx.Commit(); err != nil {
+				return result, fmt.Errorf("failed to commit transaction: %w", err)
+			}
+			tx = nil
+			batch = 0
+		}
+	}
+	if tx != nil {
+		if err := tx.Commit(); err != nil {
+			return result, fmt.Errorf("failed to commit transaction: %w", err)
+		}
+		tx = nil
+	}
+	return result, nil
+}
+
+// Positions of the known columns in a catalog file, -1 if missing
+type catalogColumnIndex struct {
+	barcode, name, genericName, brand, unit, defaultQuantity int
+}
+
+// Finds the known columns by their Open Food Facts or simple names
+func catalogColumns(header []string) catalogColumnIndex {
+	columns := catalogColumnIndex{-1, -1, -1, -1, -1, -1}
+	for i, name := range header {
+		switch strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))) {
+		case "code", "barcode":
+			columns.barcode = i
+		case "product_name", "name":
+			columns.name = i
+		case "generic_name":
+			columns.genericName = i
+		case "brands", "brand":
+			columns.brand = i
+		case "quantity", "unit":
+			columns.unit = i
+		case "default_quantity":
+			columns.defaultQuantity = i
+		}
+	}
+	return columns
+}
+
+// Builds a product from a record, reports false if the record can't be used
+func (columns catalogColumnIndex) product(record []string) (Product, bool) {
+	field := func(i int) string {
+		if i < 0 || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+	barcode, err := normalizeBarcode(field(columns.barcode))
+	if err != nil {
+		return Product{}, false
+	}
+	product := Product{Barcode: barcode, Name: field(columns.name), DefaultQuantity: 1}
+	if product.Name == "" {
+		product.Name = field(columns.genericName)
+	}
+	if product.Name == "" {
+		return Product{}, false
+	}
+	// Open Food Facts lists all brands of a product separated by commas
+	if brand, _, _ := strings.Cut(field(columns.brand), ","); brand != "" {
+		product.Brand.NullString = sql.NullString{String: strings.TrimSpace(brand), Valid: true}
+	}
+	if unit := field(columns.unit); unit != "" {
+		product.Unit.NullString = sql.NullString{String: unit, Valid: true}
+	}
+	if quantity, err := strconv.Atoi(field(columns.defaultQuantity)); err == nil && quantity > 0 {
+		product.DefaultQuantity = quantity
+	}
+	return product, true
+}
+
+// Returns a function reading one record after another from a catalog file.
+// Gzip compression and the separator (tab or comma) are detected from the first bytes.
+func catalogRecords(r io.Reader) (func() ([]string, error), error) {
+	br := bufio.NewReaderSize(r, 64*1024)
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		br = bufio.NewReaderSize(gz, 64*1024)
+	}
+	first, err := br.Peek(4096)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, bufio.ErrBufferFull) {
+		return nil, err
+	}
+	firstLine, _, _ := strings.Cut(string(first), "\n")
+
+	if strings.Contains(firstLine, "\t") {
+		// The Open Food Facts dump does not quote its fields, so it has to be split by hand
+		scanner := bufio.NewScanner(br)
+		scanner.Buffer(make([]byte, 1024*1024), 16*1024*1024)
+		return func() ([]string, error) {
+			if !scanner.Scan() {
+				if err := scanner.Err(); err != nil {
+					return nil, err
+				}
+				return nil, io.EOF
+			}
+			return strings.Split(strings.TrimSuffix(scanner.Text(), "\r"), "\t"), nil
+		}, nil
+	}
+	reader := csv.NewReader(br)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	return reader.Read, nil
+}
+
+// Adds a scanned product to a box.
+// If the box already holds an item with that barcode its quantity is increased,
+// otherwise a new item is created from the product catalog.
+// A quantity of zero adds the default quantity of the product.
+func (d *Database) ScanBarcode(db *sql.DB, boxID int, code string, quantity int) (ScanResult, error) {
+	var result ScanResult
+	barcode, err := normalizeBarcode(code)
+	if err != nil {
+		return result, err
+	}
+	if _, err := d.GetBox(db, boxID); err != nil {
+		return result, err
+	}
+	product, err := d.GetProduct(db, barcode)
+	known := err == nil
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return result, err
+	}
+	if quantity <= 0 {
+		quantity = 1
+		if known {
+			quantity = product.DefaultQuantity
+		}
+	}
+
+	var itemID int
+	err = db.QueryRow(`SELECT id FROM contents WHERE box_id = ? AND barcode = ? ORDER BY id LIMIT 1`, boxID, barcode).Scan(&itemID)
+	switch {
+	case err == nil:
+		if _, err := d.AdjustItemQuantity(db, itemID, quantity, MovementRestock, "scan"); err != nil {
+			return result, err
+		}
+		result.Action = "incremented"
+	case errors.Is(err, sql.ErrNoRows):
+		if !known {
+			return result, ErrUnknownBarcode
+		}
+		attrs := ItemAttributes{Barcode: sql.NullString{String: barcode, Valid: true}}
+		if itemID, err = d.CreateItem(db, boxID, product.Name, quantity, attrs); err != nil {
+			return result, err
+		}
+		result.Action = "created"
+	default:
+		return result, err
+	}
+	result.Item, err = d.GetItem(db, itemID)
+	return result, err
+}
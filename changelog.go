@@ -0,0 +1,87 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// A change to a box or item together with who made it
+type Change struct {
+	ID        int            `json:"id"`
+	EventType string         `json:"event"`
+	BoxID     int            `json:"box_id"`
+	ItemID    JSONNullInt64  `json:"item_id"`
+	FromBoxID JSONNullInt64  `json:"from_box_id"`
+	Actor     JSONNullString `json:"actor"`
+	ChangedAt time.Time      `json:"changed_at"`
+}
+
+// Returns a copy of the database that records the given identity on every change it makes
+func (d *Database) As(actor string) *Database {
+	copy := *d
+	copy.Actor = actor
+	return &copy
+}
+
+// Publishes an event on behalf of the identity of the database
+func (d *Database) publish(event Event) {
+	event.Actor = d.Actor
+	d.Events.Publish(event)
+}
+
+// Records an event in the change log and queues its webhooks within the transaction of the change that caused it.
+// Returns the event stamped with the identity of the database and the time, to be published once committed.
+func (d *Database) recordEvent(tx *sql.Tx, event Event) (Event, error) {
+	event.Actor = d.Actor
+	event.Time = time.Now().UTC()
+	if err := recordChange(tx, event); err != nil {
+		return event, err
+	}
+	if err := d.queueWebhooks(tx, event); err != nil {
+		return event, err
+	}
+	return event, nil
+}
+
+// Stores a single event in the change log within the transaction of the change
+func recordChange(tx *sql.Tx, event Event) error {
+	query := `INSERT INTO change_log (event_type, box_id, item_id, from_box_id, actor, changed_at) VALUES (?, ?, ?, ?, ?, ?)`
+	_, err := tx.Exec(query, event.Type, event.BoxID,
+		sql.NullInt64{Int64: int64(event.ItemID), Valid: event.ItemID != 0},
+		sql.NullInt64{Int64: int64(event.FromBoxID), Valid: event.FromBoxID != 0},
+		sql.NullString{String: event.Actor, Valid: event.Actor != ""},
+		event.Time)
+	if err != nil {
+		return fmt.Errorf("failed to record change: %w", err)
+	}
+	return nil
+}
+
+// Get the latest changes, newest first. A box id other than 0 only returns changes touching that box.
+func (d *Database) GetChanges(db *sql.DB, boxID int, limit int) ([]Change, error) {
+	query := `
+	SELECT id, event_type, box_id, item_id, from_box_id, actor, changed_at
+	FROM change_log
+	WHERE ? = 0 OR box_id = ? OR from_box_id = ?
+	ORDER BY changed_at DESC, id DESC
+	LIMIT ?`
+	rows, err := db.Query(query, boxID, boxID, boxID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := make([]Change, 0)
+	for rows.Next() {
+		var change Change
+		if err := rows.Scan(&change.ID, &change.EventType, &change.BoxID, &change.ItemID.NullInt64, &change.FromBoxID.NullInt64, &change.Actor.NullString, &change.ChangedAt); err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
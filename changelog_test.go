@@ -0,0 +1,68 @@
+package main
+
+import "testing"
+
+func TestChangesRecordedWithChange(t *testing.T) {
+	d, db := testDatabase(t)
+	alice := d.As("alice")
+	for _, name := range []string{"Tools", "Books"} {
+		if err := alice.CreateBox(db, 1, name, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	boxes, err := d.GetBoxes(db, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tools, books := boxes[0].ID, boxes[1].ID
+	itemID, err := alice.CreateItem(db, tools, "Hammer", 1, ItemAttributes{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.As("bob").MoveItem(db, tools, books, itemID); err != nil {
+		t.Fatal(err)
+	}
+	// Failed changes leave no trace
+	if err := d.UpdateBoxContent(db, 0, itemID, "Mallet", 1, ItemAttributes{}, 42); err != ErrVersionConflict {
+		t.Fatalf("update with stale version = %v, want %v", err, ErrVersionConflict)
+	}
+	if err := d.UpdateBox(db, books+1, "Nothing", "", 0); err == nil {
+		t.Fatal("updated a box that does not exist")
+	}
+
+	// Recorded right away and newest first, without anybody listening on the event bus
+	changes, err := d.GetChanges(db, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		event string
+		box   int
+		actor string
+	}{
+		{EventItemMoved, books, "bob"},
+		{EventItemCreated, tools, "alice"},
+		{EventBoxCreated, books, "alice"},
+		{EventBoxCreated, tools, "alice"},
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("recorded %d changes, want %d: %+v", len(changes), len(want), changes)
+	}
+	for i, change := range changes {
+		if change.EventType != want[i].event || change.BoxID != want[i].box || change.Actor.String != want[i].actor || change.ChangedAt.IsZero() {
+			t.Errorf("change %d = %+v, want %s of box %d by %s", i, change, want[i].event, want[i].box, want[i].actor)
+		}
+	}
+	if moved := changes[0]; moved.ItemID.Int64 != int64(itemID) || moved.FromBoxID.Int64 != int64(tools) {
+		t.Errorf("move recorded as %+v", moved)
+	}
+
+	// The move touches both boxes
+	changes, err = d.GetChanges(db, tools, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 3 || changes[0].EventType != EventItemMoved {
+		t.Errorf("changes of the box the item was taken out of = %+v", changes)
+	}
+}
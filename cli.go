@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+const usage = `Usage:
+  witb                                run the web interface
+  witb catalog import <file|->        import products from an Open Food Facts or CSV dump into the catalog
+  witb token create <name> [scopes] [inventories]
+                                      create an API token with comma separated scopes read, write or admin (default read),
+                                      limited to the comma separated inventory ids if given
+  witb token list                     list API tokens
+  witb token revoke <id>              revoke an API token
+  witb user add <name> <role> [inventories]
+                                      add a user with the role viewer, editor or admin, reading the password from stdin,
+                                      limited to the comma separated inventory ids if given
+  witb user list                      list users
+  witb inventory list                 list inventories with their ids
+`
+
+// Runs a maintenance command given on the command line instead of the web interface
+// Returns the exit code for the process.
+func runCommand(args []string) int {
+	switch args[0] {
+	case "catalog":
+		return runCatalogCommand(args[1:])
+	case "token":
+		return runTokenCommand(args[1:])
+	case "user":
+		return runUserCommand(args[1:])
+	case "inventory":
+		return runInventoryCommand(args[1:])
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", args[0], usage)
+		return 2
+	}
+}
+
+// Handles "witb catalog ..."
+func runCatalogCommand(args []string) int {
+	if len(args) != 2 || args[0] != "import" {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+	var r io.Reader = os.Stdin
+	if args[1] != "-" {
+		file, err := os.Open(args[1])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer file.Close()
+		r = file
+	}
+	result, err := database.ImportProducts(client, r)
+	fmt.Printf("imported %d products, skipped %d rows\n", result.Imported, result.Skipped)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// Handles "witb token ..."
+func runTokenCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+	switch {
+	case args[0] == "create" && len(args) >= 2 && len(args) <= 4:
+		scopes := []string{ScopeRead}
+		if len(args) >= 3 {
+			var err error
+			if scopes, err = parseScopes(args[2]); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 2
+			}
+		}
+		var inventories []int
+		if len(args) == 4 {
+			var err error
+			if inventories, err = parseInventoryIDs(args[3]); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 2
+			}
+		}
+		token, secret, err := database.CreateAPIToken(client, args[1], scopes, inventories)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Printf("created token %d %q with scopes %s\n", token.ID, token.Name, strings.Join(token.Scopes, ","))
+		fmt.Println("store it now, it is not shown again:")
+		fmt.Println(secret)
+		return 0
+	case args[0] == "list" && len(args) == 1:
+		tokens, err := database.GetAPITokens(client)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tNAME\tPREFIX\tSCOPES\tINVENTORIES\tCREATED\tLAST USED")
+		for _, token := range tokens {
+			lastUsed := "never"
+			if token.LastUsedAt.Valid {
+				lastUsed = token.LastUsedAt.Time.Format("2006-01-02 15:04")
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", token.ID, token.Name, token.Prefix, strings.Join(token.Scopes, ","), formatInventoryIDs(token.Inventories), token.CreatedAt.Format("2006-01-02"), lastUsed)
+		}
+		w.Flush()
+		return 0
+	case args[0] == "revoke" && len(args) == 2:
+		id, err := strconv.Atoi(args[1])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid token id %q\n", args[1])
+			return 2
+		}
+		if err := database.RevokeAPIToken(client, id); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Printf("revoked token %d\n", id)
+		return 0
+	}
+	fmt.Fprint(os.Stderr, usage)
+	return 2
+}
+
+// Handles "witb user ..."
+func runUserCommand(args []string) int {
+	switch {
+	case (len(args) == 3 || len(args) == 4) && args[0] == "add":
+		role, err := parseRole(args[2])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		var inventories []int
+		if len(args) == 4 {
+			if inventories, err = parseInventoryIDs(args[3]); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 2
+			}
+		}
+		// The password is read from stdin so it does not end up in the shell history
+		fmt.Fprint(os.Stderr, "Password: ")
+		password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && err != io.EOF {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		user, err := database.CreateUser(client, args[1], strings.TrimRight(password, "\r\n"), role)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if err := database.SetUserInventories(client, user.ID, inventories); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Printf("added user %d %q with role %s\n", user.ID, user.Username, user.Role)
+		return 0
+	case len(args) == 1 && args[0] == "list":
+		users, err := database.GetUsers(client)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tNAME\tROLE\tINVENTORIES\tCREATED")
+		for _, user := range users {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", user.ID, user.Username, user.Role, formatInventoryIDs(user.Inventories), user.CreatedAt.Format("2006-01-02"))
+		}
+		w.Flush()
+		return 0
+	}
+	fmt.Fprint(os.Stderr, usage)
+	return 2
+}
+
+// Handles "witb inventory ..."
+func runInventoryCommand(args []string) int {
+	if len(args) != 1 || args[0] != "list" {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+	inventories, err := database.GetInventories(client)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tBOXES")
+	for _, inventory := range inventories {
+		fmt.Fprintf(w, "%d\t%s\t%d\n", inventory.ID, inventory.Name, inventory.BoxCount)
+	}
+	w.Flush()
+	return 0
+}
+
+// Formats granted inventories for listings
+func formatInventoryIDs(ids []int) string {
+	if len(ids) == 0 {
+		return "all"
+	}
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = strconv.Itoa(id)
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Name of the cookie holding the CSRF token of a browser
+const csrfCookie = "witb_csrf"
+
+// Form field and header the CSRF token is sent back in
+const (
+	csrfField  = "csrf_token"
+	csrfHeader = "X-CSRF-Token"
+)
+
+// Key of the CSRF token of the request in the gin context
+const csrfKey = "csrf_token"
+
+// Reports whether the request reached the server over https, directly or through a proxy
+func secureRequest(c *gin.Context) bool {
+	return c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https"
+}
+
+// Returns the CSRF token of the browser making the request, creating it on first use.
+// The token is kept in a cookie and must be sent back with every form and fetch call that changes something.
+func csrfToken(c *gin.Context) string {
+	if token := c.GetString(csrfKey); token != "" {
+		return token
+	}
+	token, err := c.Cookie(csrfCookie)
+	if err != nil || len(token) != 64 {
+		random := make([]byte, 32)
+		// rand.Read never fails on supported platforms
+		rand.Read(random)
+		token = hex.EncodeToString(random)
+		c.SetSameSite(http.SameSiteLaxMode)
+		c.SetCookie(csrfCookie, token, 0, "/", "", secureRequest(c), true)
+	}
+	c.Set(csrfKey, token)
+	return token
+}
+
+// Renders an html template with the CSRF token of the browser, which templates put into their forms
+// and the csrf-token meta tag for fetch calls
+func renderHTML(c *gin.Context, status int, name string, values gin.H) {
+	values["csrfToken"] = csrfToken(c)
+	c.HTML(status, name, values)
+}
+
+// Protects against cross-site request forgery. Requests that change something must come from
+// the same origin, judged by the Origin or Referer header, and carry the CSRF token of the browser.
+// API requests with an API token are exempt, since browsers never add that header on their own,
+// and so are API requests from scripts, which send none of the headers browsers add.
+func csrfProtect(trustedOrigins []string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+			return
+		}
+		if strings.HasPrefix(c.Request.URL.Path, "/api/") {
+			if c.GetHeader("Authorization") != "" || !fromBrowser(c) {
+				c.Next()
+				return
+			}
+		}
+		if !sameOrigin(c, trustedOrigins) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"fail": "cross-site request rejected"})
+			return
+		}
+		sent := c.GetHeader(csrfHeader)
+		if sent == "" && !strings.HasPrefix(c.Request.URL.Path, "/api/") {
+			sent = csrfFormToken(c)
+		}
+		token, err := c.Cookie(csrfCookie)
+		if err != nil || token == "" || subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"fail": "missing or invalid CSRF token, reload the page and try again"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// Returns the CSRF token sent in the form field of an html form. Other bodies are left alone for the handler,
+// and forms are limited to the size of the largest upload before they are parsed.
+func csrfFormToken(c *gin.Context) string {
+	switch c.ContentType() {
+	case "application/x-www-form-urlencoded", "multipart/form-data":
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImageUploadSize)
+		return c.PostForm(csrfField)
+	}
+	return ""
+}
+
+// Reports whether a request carries any of the headers browsers add on their own
+func fromBrowser(c *gin.Context) bool {
+	return c.GetHeader("Origin") != "" || c.GetHeader("Referer") != "" || c.GetHeader("Sec-Fetch-Site") != "" || c.GetHeader("Cookie") != ""
+}
+
+// Checks the Origin header, or the Referer header if there is none, against the host of the request
+// and the trusted origins. Requests with neither header are left to the token check.
+func sameOrigin(c *gin.Context, trustedOrigins []string) bool {
+	source := c.GetHeader("Origin")
+	if source == "" {
+		source = c.GetHeader("Referer")
+	}
+	if source == "" {
+		return true
+	}
+	if source == "null" {
+		return false
+	}
+	parsed, err := url.Parse(source)
+	if err != nil || parsed.Host == "" {
+		return false
+	}
+	origin := parsed.Scheme + "://" + parsed.Host
+	for _, trusted := range trustedOrigins {
+		if strings.EqualFold(origin, trusted) {
+			return true
+		}
+	}
+	// Browsers can't set X-Forwarded-Host on cross-site requests, so a reverse proxy passing the original host is fine
+	for _, host := range []string{c.Request.Host, c.GetHeader("X-Forwarded-Host")} {
+		if host != "" && strings.EqualFold(parsed.Host, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reads the extra origins allowed to send requests, such as the public address behind a reverse proxy
+func csrfTrustedOriginsFromEnv() []string {
+	var origins []string
+	for _, origin := range strings.Split(getEnv("CSRF_TRUSTED_ORIGINS", ""), ",") {
+		if origin = strings.TrimRight(strings.TrimSpace(origin), "/"); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	return origins
+}
@@ -3,14 +3,21 @@ package main
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"time"
 )
 
 // Define Database struct with database file path as field
+// Every successful change to boxes and items is published on Events if it is set.
+// New boxes get a code following BoxCodePattern, see validateBoxCodePattern.
 type Database struct {
-	DBFilePath string
+	DBFilePath     string
+	Events         *EventBus
+	BoxCodePattern string
+	// Identity of the user changes are made for, set with As
+	Actor string
 }
 
 // Define a custom nullable string type for JSON marshaling
@@ -26,23 +33,453 @@ func (ns JSONNullString) MarshalJSON() ([]byte, error) {
 	return json.Marshal(nil)
 }
 
+// Define a custom nullable integer type for JSON marshaling
+type JSONNullInt64 struct {
+	sql.NullInt64
+}
+
+// MarshalJSON customizes JSON encoding for JSONNullInt64
+func (ni JSONNullInt64) MarshalJSON() ([]byte, error) {
+	if ni.Valid {
+		return json.Marshal(ni.Int64)
+	}
+	return json.Marshal(nil)
+}
+
+// Define a custom nullable time type for JSON marshaling
+type JSONNullTime struct {
+	sql.NullTime
+}
+
+// MarshalJSON customizes JSON encoding for JSONNullTime
+func (nt JSONNullTime) MarshalJSON() ([]byte, error) {
+	if nt.Valid {
+		return json.Marshal(nt.Time)
+	}
+	return json.Marshal(nil)
+}
+
+// Returned by updates when the row was changed since the caller last read it
+var ErrVersionConflict = errors.New("version conflict")
+
 // Define box struct with json marshalling config
 type Box struct {
-	ID        int            `json:"id"`
-	Name      string         `json:"name"`
-	Label     JSONNullString `json:"label"`
-	CreatedAt time.Time      `json:"created_at"`
+	ID          int            `json:"id"`
+	InventoryID int            `json:"inventory_id"`
+	Code        string         `json:"code"`
+	Name        string         `json:"name"`
+	Label       JSONNullString `json:"label"`
+	CreatedAt   time.Time      `json:"created_at"`
+	Version     int            `json:"version"`
+	// When an audit last confirmed the contents of the box
+	VerifiedAt JSONNullTime `json:"verified_at"`
+	// Where the box is in a house move and the room it goes to
+	Status      JSONNullString `json:"status"`
+	Destination JSONNullString `json:"destination"`
+	// Outer size in cm, weight of the empty box and the load it carries in grams
+	Length      JSONNullInt64 `json:"length"`
+	Width       JSONNullInt64 `json:"width"`
+	Height      JSONNullInt64 `json:"height"`
+	EmptyWeight JSONNullInt64 `json:"empty_weight"`
+	MaxLoad     JSONNullInt64 `json:"max_load"`
+	// Weight in grams of the items that have one, not counting what is lent out, and the number of items without one
+	ContentWeight  int64 `json:"content_weight"`
+	UnweighedItems int   `json:"unweighed_items"`
+	// Value of the items in the box in cents of the configured currency
+	Value int64 `json:"value"`
+	// Filled in by scanBox: the total weight in grams, the volume in liters and the content weight in percent of the max load
+	Weight int64         `json:"weight"`
+	Volume JSONNullInt64 `json:"volume"`
+	Fill   JSONNullInt64 `json:"fill"`
+}
+
+// Weight in grams of the items in a box that have one, without what is lent out
+const boxContentWeight = `(SELECT COALESCE(SUM(contents.weight * (contents.quantity - (SELECT COALESCE(SUM(loans.quantity), 0) FROM loans WHERE loans.content_id = contents.id AND loans.returned_at IS NULL))), 0) FROM contents WHERE contents.box_id = boxes.id)`
+
+// Columns selected for a Box, in the order expected by scanBox
+const boxColumns = `boxes.id, boxes.inventory_id, boxes.code, boxes.name, boxes.label, boxes.created_at, boxes.version, boxes.verified_at, boxes.status, boxes.destination,
+	boxes.length, boxes.width, boxes.height, boxes.empty_weight, boxes.max_load, ` + boxContentWeight + `,
+	(SELECT COUNT(*) FROM contents WHERE contents.box_id = boxes.id AND contents.weight IS NULL),
+	(SELECT COALESCE(SUM(COALESCE(contents.current_value, contents.purchase_price) * contents.quantity), 0) FROM contents WHERE contents.box_id = boxes.id)`
+
+// Scans a row selected with boxColumns into a Box
+// Additional columns selected after boxColumns are scanned into extra.
+func scanBox(row rowScanner, extra ...any) (Box, error) {
+	var box Box
+	dest := []any{&box.ID, &box.InventoryID, &box.Code, &box.Name, &box.Label, &box.CreatedAt, &box.Version, &box.VerifiedAt.NullTime, &box.Status.NullString, &box.Destination.NullString,
+		&box.Length.NullInt64, &box.Width.NullInt64, &box.Height.NullInt64, &box.EmptyWeight.NullInt64, &box.MaxLoad.NullInt64, &box.ContentWeight, &box.UnweighedItems, &box.Value}
+	err := row.Scan(append(dest, extra...)...)
+	box.Weight = box.EmptyWeight.Int64 + box.ContentWeight
+	if box.Length.Valid && box.Width.Valid && box.Height.Valid {
+		box.Volume = JSONNullInt64{sql.NullInt64{Int64: (box.Length.Int64*box.Width.Int64*box.Height.Int64 + 500) / 1000, Valid: true}}
+	}
+	if box.MaxLoad.Int64 > 0 {
+		box.Fill = JSONNullInt64{sql.NullInt64{Int64: box.ContentWeight * 100 / box.MaxLoad.Int64, Valid: true}}
+	}
+	return box, err
+}
+
+// Define item struct with json marshalling config
+type Item struct {
+	ID             int            `json:"id"`
+	BoxID          int            `json:"box_id"`
+	Name           string         `json:"name"`
+	Quantity       int            `json:"quantity"`
+	AddedAt        time.Time      `json:"added_at"`
+	Version        int            `json:"version"`
+	ExpiresAt      JSONNullTime   `json:"expires_at"`
+	MinQuantity    JSONNullInt64  `json:"min_quantity"`
+	TargetQuantity JSONNullInt64  `json:"target_quantity"`
+	Barcode        JSONNullString `json:"barcode"`
+	// Items with their own QR label belong to a home box they can be returned to
+	Labeled   bool          `json:"labeled"`
+	HomeBoxID JSONNullInt64 `json:"home_box_id"`
+	// Weight of one unit in grams
+	Weight JSONNullInt64 `json:"weight"`
+	// Price and value of one unit in cents of the configured currency
+	PurchasePrice JSONNullInt64  `json:"purchase_price"`
+	PurchasedAt   JSONNullTime   `json:"purchased_at"`
+	CurrentValue  JSONNullInt64  `json:"current_value"`
+	SerialNumber  JSONNullString `json:"serial_number"`
+	HasPhoto      bool           `json:"has_photo"`
+}
+
+// Returns the value of one unit in cents: the current value if known, else the purchase price
+func (i Item) UnitValue() sql.NullInt64 {
+	if i.CurrentValue.Valid {
+		return i.CurrentValue.NullInt64
+	}
+	return i.PurchasePrice.NullInt64
+}
+
+// Returns the value of all units in cents
+func (i Item) TotalValue() sql.NullInt64 {
+	value := i.UnitValue()
+	value.Int64 *= int64(i.Quantity)
+	return value
+}
+
+// Optional attributes of an item which are set together with its name and quantity
+type ItemAttributes struct {
+	ExpiresAt      sql.NullTime
+	MinQuantity    sql.NullInt64
+	TargetQuantity sql.NullInt64
+	Barcode        sql.NullString
+	// Gives the item its own QR label, with the box it is in as its home box unless it has one already
+	Labeled bool
+	// Weight of one unit in grams
+	Weight sql.NullInt64
+}
+
+// Columns selected for an Item, in the order expected by scanItem
+const itemColumns = `contents.id, contents.box_id, contents.name, contents.quantity, contents.added_at, contents.version, contents.expires_at, contents.min_quantity, contents.target_quantity, contents.barcode, contents.home_box_id, contents.weight,
+	contents.purchase_price, contents.purchased_at, contents.current_value, contents.serial_number,
+	EXISTS (SELECT 1 FROM item_photos WHERE item_photos.content_id = contents.id)`
+
+// Interface shared by sql.Row and sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// Interface shared by sql.DB and sql.Tx
+type queryer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// Scans a row selected with itemColumns into an Item
+// Additional columns selected after itemColumns are scanned into extra.
+func scanItem(row rowScanner, extra ...any) (Item, error) {
+	var item Item
+	dest := []any{&item.ID, &item.BoxID, &item.Name, &item.Quantity, &item.AddedAt, &item.Version, &item.ExpiresAt, &item.MinQuantity, &item.TargetQuantity, &item.Barcode, &item.HomeBoxID, &item.Weight.NullInt64,
+		&item.PurchasePrice.NullInt64, &item.PurchasedAt.NullTime, &item.CurrentValue.NullInt64, &item.SerialNumber.NullString, &item.HasPhoto}
+	err := row.Scan(append(dest, extra...)...)
+	item.Labeled = item.HomeBoxID.Valid
+	return item, err
+}
+
+// Define box summary struct with json marshalling config
+// Expiring counts the items expiring before the cut-off used for the query.
+type BoxSummary struct {
+	BoxID         int `json:"box_id"`
+	ItemCount     int `json:"item_count"`
+	TotalQuantity int `json:"total_quantity"`
+	ExpiringCount int `json:"expiring_count"`
 }
 
 // Define box content struct
 type BoxContent struct {
-	BoxID     int
-	BoxName   string
-	BoxLabel  sql.NullString
-	ContentID sql.NullInt64
-	Name      sql.NullString
-	Quantity  sql.NullInt64
-	AddedAt   sql.NullTime
+	BoxID          int
+	BoxCode        string
+	BoxName        string
+	BoxLabel       sql.NullString
+	BoxVersion     int
+	BoxVerifiedAt  sql.NullTime
+	ContentID      sql.NullInt64
+	Name           sql.NullString
+	Quantity       sql.NullInt64
+	AddedAt        sql.NullTime
+	Version        sql.NullInt64
+	ExpiresAt      sql.NullTime
+	MinQuantity    sql.NullInt64
+	TargetQuantity sql.NullInt64
+	Barcode        sql.NullString
+	HomeBoxID      sql.NullInt64
+	Weight         sql.NullInt64
+	// How much of the item is lent out and to whom
+	LentQuantity int64
+	Borrowers    sql.NullString
+}
+
+// Reports whether the item has less than its minimum quantity
+func (c BoxContent) IsLow() bool {
+	return c.MinQuantity.Valid && c.Quantity.Int64 < c.MinQuantity.Int64
+}
+
+// Reports whether some of the item is lent out
+func (c BoxContent) IsOut() bool {
+	return c.LentQuantity > 0
+}
+
+// Reports whether the item has a home box other than the box it is in
+func (c BoxContent) IsAway() bool {
+	return c.HomeBoxID.Valid && c.HomeBoxID.Int64 != int64(c.BoxID)
+}
+
+// Schema changes applied in order on top of the initial tables.
+// The number of applied migrations is tracked in the user_version pragma of the database.
+var migrations = []string{
+	// Versions for optimistic concurrency control
+	`ALTER TABLE boxes ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+	ALTER TABLE boxes ADD COLUMN updated_at TIMESTAMP;
+	UPDATE boxes SET updated_at = created_at;
+	ALTER TABLE contents ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+	ALTER TABLE contents ADD COLUMN updated_at TIMESTAMP;
+	UPDATE contents SET updated_at = added_at;`,
+	// Outgoing webhooks with a persistent outbox and delivery log
+	`CREATE TABLE webhooks (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		url TEXT NOT NULL,
+		events TEXT NOT NULL DEFAULT '*',
+		secret TEXT NOT NULL,
+		active INTEGER NOT NULL DEFAULT 1,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE webhook_outbox (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		webhook_id INTEGER NOT NULL,
+		event_type TEXT NOT NULL,
+		payload TEXT NOT NULL,
+		status TEXT NOT NULL DEFAULT 'pending',
+		attempts INTEGER NOT NULL DEFAULT 0,
+		next_attempt_at INTEGER NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (webhook_id) REFERENCES webhooks(id)
+	);
+	CREATE INDEX webhook_outbox_due ON webhook_outbox (status, next_attempt_at);
+	CREATE TABLE webhook_deliveries (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		outbox_id INTEGER NOT NULL,
+		webhook_id INTEGER NOT NULL,
+		event_type TEXT NOT NULL,
+		attempt INTEGER NOT NULL,
+		status_code INTEGER,
+		error TEXT,
+		duration_ms INTEGER,
+		attempted_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (webhook_id) REFERENCES webhooks(id)
+	);`,
+	// Expiration dates of items
+	`ALTER TABLE contents ADD COLUMN expires_at TIMESTAMP;`,
+	// Minimum quantities and a log of sent notifications so they are not repeated
+	`ALTER TABLE contents ADD COLUMN min_quantity INTEGER;
+	CREATE TABLE notifications (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		rule TEXT NOT NULL,
+		dedupe_key TEXT NOT NULL UNIQUE,
+		content_id INTEGER,
+		sent_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE settings (
+		key TEXT PRIMARY KEY,
+		value TEXT NOT NULL
+	);`,
+	// Target quantities for the shopping list
+	`ALTER TABLE contents ADD COLUMN target_quantity INTEGER;`,
+	// Ledger of all quantity changes
+	`CREATE TABLE stock_movements (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		content_id INTEGER NOT NULL,
+		delta INTEGER NOT NULL,
+		reason TEXT NOT NULL,
+		quantity_after INTEGER NOT NULL,
+		note TEXT,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX stock_movements_content ON stock_movements (content_id, created_at);`,
+	// Barcodes of items and a local product catalog to look them up offline
+	`ALTER TABLE contents ADD COLUMN barcode TEXT;
+	CREATE INDEX contents_barcode ON contents (barcode);
+	CREATE TABLE products (
+		barcode TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		brand TEXT,
+		unit TEXT,
+		default_quantity INTEGER NOT NULL DEFAULT 1,
+		source TEXT NOT NULL DEFAULT 'manual',
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);`,
+	// Active box of the scan workflow per browser
+	`CREATE TABLE scan_sessions (
+		id TEXT PRIMARY KEY,
+		box_id INTEGER,
+		message TEXT,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);`,
+	// Short codes of boxes, filled in by backfillBoxCodes
+	`ALTER TABLE boxes ADD COLUMN code TEXT;
+	CREATE UNIQUE INDEX boxes_code ON boxes (code);`,
+	// Home boxes of items with their own QR label and a log of item moves
+	`ALTER TABLE contents ADD COLUMN home_box_id INTEGER;
+	CREATE TABLE item_moves (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		content_id INTEGER NOT NULL,
+		from_box_id INTEGER NOT NULL,
+		to_box_id INTEGER NOT NULL,
+		moved_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX item_moves_content ON item_moves (content_id, moved_at);`,
+	// Signed read-only links to a box, revoked by setting revoked_at
+	`CREATE TABLE shares (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		box_id INTEGER NOT NULL,
+		note TEXT,
+		expires_at TIMESTAMP NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		revoked_at TIMESTAMP
+	);`,
+	// Every change to boxes and items with the user who made it
+	`CREATE TABLE change_log (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		event_type TEXT NOT NULL,
+		box_id INTEGER NOT NULL,
+		item_id INTEGER,
+		from_box_id INTEGER,
+		actor TEXT,
+		changed_at TIMESTAMP NOT NULL
+	);
+	CREATE INDEX change_log_changed ON change_log (changed_at);`,
+	// Hashed API tokens with their scopes
+	`CREATE TABLE api_tokens (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		prefix TEXT NOT NULL,
+		token_hash TEXT NOT NULL UNIQUE,
+		scopes TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		last_used_at TIMESTAMP,
+		revoked_at TIMESTAMP
+	);`,
+	// User accounts with bcrypt hashed passwords and their sessions
+	`CREATE TABLE users (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		username TEXT NOT NULL UNIQUE COLLATE NOCASE,
+		password_hash TEXT NOT NULL,
+		role TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE sessions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		token_hash TEXT NOT NULL UNIQUE,
+		expires_at TIMESTAMP NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);`,
+	// Inventories such as households or storage units, each box belonging to one,
+	// and the inventories users and API tokens are limited to
+	`CREATE TABLE inventories (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL UNIQUE COLLATE NOCASE,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+	INSERT INTO inventories (id, name) VALUES (1, 'Home');
+	ALTER TABLE boxes ADD COLUMN inventory_id INTEGER NOT NULL DEFAULT 1;
+	CREATE INDEX boxes_inventory ON boxes (inventory_id);
+	CREATE TABLE user_inventories (
+		user_id INTEGER NOT NULL,
+		inventory_id INTEGER NOT NULL,
+		PRIMARY KEY (user_id, inventory_id)
+	);
+	CREATE TABLE api_token_inventories (
+		token_id INTEGER NOT NULL,
+		inventory_id INTEGER NOT NULL,
+		PRIMARY KEY (token_id, inventory_id)
+	);`,
+	// Items lent to borrowers, checked in again by setting returned_at
+	`CREATE TABLE loans (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		content_id INTEGER NOT NULL,
+		box_id INTEGER NOT NULL,
+		borrower TEXT NOT NULL,
+		quantity INTEGER NOT NULL,
+		due_at TIMESTAMP,
+		note TEXT,
+		remind BOOLEAN NOT NULL DEFAULT 0,
+		lent_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		returned_at TIMESTAMP,
+		returned_to_box_id INTEGER
+	);
+	CREATE INDEX loans_content ON loans (content_id, returned_at);`,
+	// Stocktakes of boxes with the result for every item, and when each box was last verified
+	`ALTER TABLE boxes ADD COLUMN verified_at TIMESTAMP;
+	CREATE TABLE audits (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		box_id INTEGER NOT NULL,
+		started_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		finished_at TIMESTAMP
+	);
+	CREATE INDEX audits_box ON audits (box_id, finished_at);
+	CREATE TABLE audit_entries (
+		audit_id INTEGER NOT NULL,
+		content_id INTEGER NOT NULL,
+		item_name TEXT NOT NULL,
+		expected INTEGER NOT NULL,
+		counted INTEGER NOT NULL,
+		status TEXT NOT NULL,
+		checked_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (audit_id, content_id)
+	);`,
+	// Where boxes are in a house move, the rooms they go to and when they reached each status.
+	// Scan sessions can set a status on every box scanned.
+	`ALTER TABLE boxes ADD COLUMN status TEXT;
+	ALTER TABLE boxes ADD COLUMN destination TEXT;
+	CREATE TABLE box_status_changes (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		box_id INTEGER NOT NULL,
+		status TEXT NOT NULL,
+		destination TEXT,
+		changed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX box_status_changes_box ON box_status_changes (box_id);
+	ALTER TABLE scan_sessions ADD COLUMN status TEXT;`,
+	// Outer size of boxes in cm, their empty weight and the load they carry in grams, and the weight of one unit of an item in grams
+	`ALTER TABLE boxes ADD COLUMN length INTEGER;
+	ALTER TABLE boxes ADD COLUMN width INTEGER;
+	ALTER TABLE boxes ADD COLUMN height INTEGER;
+	ALTER TABLE boxes ADD COLUMN empty_weight INTEGER;
+	ALTER TABLE boxes ADD COLUMN max_load INTEGER;
+	ALTER TABLE contents ADD COLUMN weight INTEGER;`,
+	// What items cost and are worth in cents of the configured currency, their serial numbers and photos
+	`ALTER TABLE contents ADD COLUMN purchase_price INTEGER;
+	ALTER TABLE contents ADD COLUMN purchased_at TIMESTAMP;
+	ALTER TABLE contents ADD COLUMN current_value INTEGER;
+	ALTER TABLE contents ADD COLUMN serial_number TEXT;
+	CREATE TABLE item_photos (
+		content_id INTEGER PRIMARY KEY,
+		data BLOB NOT NULL,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);`,
 }
 
 // Initializes the database and creates the database with the provided field value for DBFilePath
@@ -71,38 +508,121 @@ func (d *Database) Init() *sql.DB {
 	if _, err := db.Exec(createTable); err != nil {
 		log.Fatalf("Could not create table: %v", err)
 	}
+	if err := d.Migrate(db); err != nil {
+		log.Fatalf("Could not migrate database: %v", err)
+	}
 	return db
 }
 
-// Queries all boxes from database
-func (d *Database) GetBoxesTotal(db *sql.DB) (int, error) {
-	query := `SELECT COUNT(*) AS box_count FROM boxes;`
+// Applies all migrations the database has not seen yet, each within its own transaction
+func (d *Database) Migrate(db *sql.DB) error {
+	var current int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&current); err != nil {
+		return err
+	}
+	for i := current; i < len(migrations); i++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d failed: %w", i+1, err)
+		}
+		// PRAGMA statements do not support placeholders
+		if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, i+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit migration %d: %w", i+1, err)
+		}
+		log.Printf("Applied database migration %d", i+1)
+	}
+	// Codes depend on the configured pattern, so boxes without one get it here instead of in the migration
+	if err := d.backfillBoxCodes(db); err != nil {
+		return fmt.Errorf("failed to fill in box codes: %w", err)
+	}
+	return nil
+}
+
+// Orders of boxes other than newest first
+const (
+	BoxSortHeaviest = "heaviest"
+	BoxSortLightest = "lightest"
+)
+
+// Narrows down the boxes listed on the home page, empty fields match every box
+type BoxFilter struct {
+	Status      string
+	Destination string
+	// Only boxes with a max load that have more than this many grams of it left
+	RoomLeft sql.NullInt64
+	// BoxSortHeaviest, BoxSortLightest or "" for the newest boxes first
+	Sort string
+}
+
+// Condition matching the boxes of a filter, used with BoxFilter.args
+const boxFilterWhere = `(? = '' OR status = ?) AND (? = '' OR destination = ?) AND (? IS NULL OR boxes.max_load - ` + boxContentWeight + ` > ?)`
+
+// Returns the arguments of boxFilterWhere
+func (f BoxFilter) args() []any {
+	return []any{f.Status, f.Status, f.Destination, f.Destination, f.RoomLeft, f.RoomLeft}
+}
+
+// Returns the ORDER BY clause for the sort order of the filter
+func (f BoxFilter) orderBy() string {
+	switch f.Sort {
+	case BoxSortHeaviest:
+		return `ORDER BY COALESCE(boxes.empty_weight, 0) + ` + boxContentWeight + ` DESC, created_at DESC`
+	case BoxSortLightest:
+		return `ORDER BY COALESCE(boxes.empty_weight, 0) + ` + boxContentWeight + `, created_at DESC`
+	default:
+		return `ORDER BY created_at DESC`
+	}
+}
+
+// Counts the boxes of an inventory matching a filter
+func (d *Database) GetBoxesTotal(db *sql.DB, inventoryID int, filter BoxFilter) (int, error) {
+	query := `SELECT COUNT(*) AS box_count FROM boxes WHERE inventory_id = ? AND ` + boxFilterWhere
 	var boxCount int
 
-	err := db.QueryRow(query).Scan(&boxCount)
+	err := db.QueryRow(query, append([]any{inventoryID}, filter.args()...)...).Scan(&boxCount)
 	if err != nil {
 		return 0, err
 	}
 	return boxCount, nil
 }
 
-// Get a certian amount of boxes using LIMIT and OFFSET
+// Get a certian amount of boxes of an inventory matching a filter using LIMIT and OFFSET
 // Used to paginate boxes
-func (d *Database) GetBoxesPaginated(db *sql.DB, page int, pageSize int) ([]Box, error) {
+func (d *Database) GetBoxesPaginated(db *sql.DB, inventoryID int, filter BoxFilter, page int, pageSize int) ([]Box, error) {
 	offset := (page * pageSize) / pageSize
 
-	query := `SELECT id, name, label, created_at FROM boxes ORDER BY created_at DESC LIMIT ? OFFSET ?`
+	query := `SELECT ` + boxColumns + ` FROM boxes WHERE inventory_id = ? AND ` + boxFilterWhere + ` ` + filter.orderBy() + ` LIMIT ? OFFSET ?`
+
+	args := append([]any{inventoryID}, filter.args()...)
+	return d.queryBoxes(db, query, append(args, pageSize, offset)...)
+}
+
+// Get all boxes of an inventory matching a filter
+func (d *Database) GetBoxesFiltered(db *sql.DB, inventoryID int, filter BoxFilter) ([]Box, error) {
+	query := `SELECT ` + boxColumns + ` FROM boxes WHERE inventory_id = ? AND ` + boxFilterWhere + ` ` + filter.orderBy()
+	return d.queryBoxes(db, query, append([]any{inventoryID}, filter.args()...)...)
+}
 
-	rows, err := db.Query(query, pageSize, offset)
+// Queries boxes selected with boxColumns
+func (d *Database) queryBoxes(db *sql.DB, query string, args ...any) ([]Box, error) {
+	rows, err := db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var boxes []Box
+	boxes := make([]Box, 0)
 	for rows.Next() {
-		var box Box
-		if err := rows.Scan(&box.ID, &box.Name, &box.Label, &box.CreatedAt); err != nil {
+		box, err := scanBox(rows)
+		if err != nil {
 			return nil, err
 		}
 		boxes = append(boxes, box)
@@ -114,15 +634,17 @@ func (d *Database) GetBoxesPaginated(db *sql.DB, page int, pageSize int) ([]Box,
 	return boxes, nil
 }
 
-// Database query used to get all boxes by name or label value
-func (d *Database) GetBoxesByTextV0(db *sql.DB, searchText string) ([]Box, error) {
+// Database query used to get all boxes of an inventory by name, label or code value
+func (d *Database) GetBoxesByTextV0(db *sql.DB, inventoryID int, searchText string) ([]Box, error) {
 	query := `
-	SELECT id, name, label, created_at
+	SELECT ` + boxColumns + `
 	FROM boxes 
-	WHERE name LIKE '%' || ? || '%' 
-	OR label LIKE '%' || ? || '%';`
+	WHERE inventory_id = ?
+	AND (name LIKE '%' || ? || '%' 
+	OR label LIKE '%' || ? || '%'
+	OR code LIKE '%' || ? || '%');`
 
-	rows, err := db.Query(query, searchText, searchText)
+	rows, err := db.Query(query, inventoryID, searchText, searchText, searchText)
 	if err != nil {
 		return nil, err
 	}
@@ -130,8 +652,8 @@ func (d *Database) GetBoxesByTextV0(db *sql.DB, searchText string) ([]Box, error
 
 	var boxes []Box
 	for rows.Next() {
-		var box Box
-		if err := rows.Scan(&box.ID, &box.Name, &box.Label, &box.CreatedAt); err != nil {
+		box, err := scanBox(rows)
+		if err != nil {
 			return nil, err
 		}
 		boxes = append(boxes, box)
@@ -143,10 +665,10 @@ func (d *Database) GetBoxesByTextV0(db *sql.DB, searchText string) ([]Box, error
 	return boxes, nil
 }
 
-// Returns ALL boxes from database (unused right now since there is no full REST API)
-func (d *Database) GetBoxes(db *sql.DB) ([]Box, error) {
-	query := `SELECT id, label, name, created_at FROM boxes`
-	rows, err := db.Query(query)
+// Returns the boxes of an inventory, or ALL boxes from database if the inventory is 0
+func (d *Database) GetBoxes(db *sql.DB, inventoryID int) ([]Box, error) {
+	query := `SELECT ` + boxColumns + ` FROM boxes WHERE ? = 0 OR inventory_id = ?`
+	rows, err := db.Query(query, inventoryID, inventoryID)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -154,8 +676,8 @@ func (d *Database) GetBoxes(db *sql.DB) ([]Box, error) {
 
 	var boxes []Box
 	for rows.Next() {
-		var box Box
-		if err := rows.Scan(&box.ID, &box.Label, &box.Name, &box.CreatedAt); err != nil {
+		box, err := scanBox(rows)
+		if err != nil {
 			return nil, err
 		}
 		if !box.Label.Valid {
@@ -174,12 +696,24 @@ func (d *Database) GetBoxContent(db *sql.DB, boxID int) ([]BoxContent, error) {
 	query := `
     SELECT 
         boxes.id AS box_id,
+        boxes.code AS box_code,
         boxes.name AS box_name, 
         boxes.label AS box_label, 
+        boxes.version AS box_version,
+        boxes.verified_at AS box_verified_at,
         contents.id AS content_id, 
         contents.name AS content_name, 
         contents.quantity AS content_quantity, 
-        contents.added_at AS content_added_at	
+        contents.added_at AS content_added_at,
+        contents.version AS content_version,
+        contents.expires_at AS content_expires_at,
+        contents.min_quantity AS content_min_quantity,
+        contents.target_quantity AS content_target_quantity,
+        contents.barcode AS content_barcode,
+        contents.home_box_id AS content_home_box_id,
+        contents.weight AS content_weight,
+        (SELECT COALESCE(SUM(quantity), 0) FROM loans WHERE loans.content_id = contents.id AND loans.returned_at IS NULL) AS lent_quantity,
+        (SELECT GROUP_CONCAT(borrower, ', ') FROM loans WHERE loans.content_id = contents.id AND loans.returned_at IS NULL) AS borrowers
     FROM 
         boxes
     LEFT JOIN 
@@ -197,7 +731,7 @@ func (d *Database) GetBoxContent(db *sql.DB, boxID int) ([]BoxContent, error) {
 	var boxContents []BoxContent
 	for rows.Next() {
 		var content BoxContent
-		if err := rows.Scan(&content.BoxID, &content.BoxName, &content.BoxLabel, &content.ContentID, &content.Name, &content.Quantity, &content.AddedAt); err != nil {
+		if err := rows.Scan(&content.BoxID, &content.BoxCode, &content.BoxName, &content.BoxLabel, &content.BoxVersion, &content.BoxVerifiedAt, &content.ContentID, &content.Name, &content.Quantity, &content.AddedAt, &content.Version, &content.ExpiresAt, &content.MinQuantity, &content.TargetQuantity, &content.Barcode, &content.HomeBoxID, &content.Weight, &content.LentQuantity, &content.Borrowers); err != nil {
 			return nil, err
 		}
 		if !content.BoxLabel.Valid {
@@ -211,10 +745,86 @@ func (d *Database) GetBoxContent(db *sql.DB, boxID int) ([]BoxContent, error) {
 	return boxContents, nil
 }
 
-// Updates an item with new values
-func (d *Database) UpdateBoxContent(db *sql.DB, contentID int, newName string, newQuantity int) error {
-	query := `UPDATE contents SET name = ?, quantity = ? WHERE id = ?`
-	result, err := db.Exec(query, newName, newQuantity, contentID)
+// Get a single box by its id
+func (d *Database) GetBox(db queryer, id int) (Box, error) {
+	query := `SELECT ` + boxColumns + ` FROM boxes WHERE id = ?`
+	return scanBox(db.QueryRow(query, id))
+}
+
+// Get a single item by its id
+func (d *Database) GetItem(db queryer, id int) (Item, error) {
+	query := `SELECT ` + itemColumns + ` FROM contents WHERE id = ?`
+	return scanItem(db.QueryRow(query, id))
+}
+
+// Get all items stored in a certain box
+func (d *Database) GetItemsByBox(db *sql.DB, boxID int) ([]Item, error) {
+	query := `SELECT ` + itemColumns + ` FROM contents WHERE box_id = ? ORDER BY added_at DESC`
+	rows, err := db.Query(query, boxID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]Item, 0)
+	for rows.Next() {
+		item, err := scanItem(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// Counts the items and their total quantity in a box including the items expiring before the given time
+func (d *Database) GetBoxSummary(db *sql.DB, boxID int, expiringBefore time.Time) (BoxSummary, error) {
+	query := `
+	SELECT
+		COUNT(id),
+		COALESCE(SUM(quantity), 0),
+		COALESCE(SUM(CASE WHEN expires_at IS NOT NULL AND expires_at <= ? THEN 1 ELSE 0 END), 0)
+	FROM contents
+	WHERE box_id = ?`
+	summary := BoxSummary{BoxID: boxID}
+	err := db.QueryRow(query, expiringBefore.UTC(), boxID).Scan(&summary.ItemCount, &summary.TotalQuantity, &summary.ExpiringCount)
+	return summary, err
+}
+
+// Updates an item with new values with an atomic transaction
+// A changed quantity is recorded as an adjustment in the stock movements ledger.
+// If expectedVersion is greater than zero the update only succeeds if the item still has that version.
+// A box id other than 0 only updates the item if it is in that box, sql.ErrNoRows is returned otherwise.
+func (d *Database) UpdateBoxContent(db *sql.DB, boxID int, contentID int, newName string, newQuantity int, attrs ItemAttributes, expectedVersion int) error {
+	// Start a transaction
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	// Defer a rollback in case something fails.
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var oldQuantity int
+	query := `SELECT box_id, quantity FROM contents WHERE id = ? AND (? = 0 OR box_id = ?)`
+	err = tx.QueryRow(query, contentID, boxID, boxID).Scan(&boxID, &oldQuantity)
+	if err != nil {
+		return err
+	}
+
+	query = `
+	UPDATE contents
+	SET name = ?, quantity = ?, expires_at = ?, min_quantity = ?, target_quantity = ?, barcode = ?, weight = ?,
+		home_box_id = CASE WHEN ? THEN COALESCE(home_box_id, box_id) END,
+		version = version + 1, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND box_id = ? AND (? = 0 OR version = ?)`
+	result, err := tx.Exec(query, newName, newQuantity, attrs.ExpiresAt, attrs.MinQuantity, attrs.TargetQuantity, attrs.Barcode, attrs.Weight, attrs.Labeled, contentID, boxID, expectedVersion, expectedVersion)
 	if err != nil {
 		return err
 	}
@@ -224,15 +834,48 @@ func (d *Database) UpdateBoxContent(db *sql.DB, contentID int, newName string, n
 		return err
 	}
 	if rowsAffected == 0 {
-		return fmt.Errorf("no content found with id %d", contentID)
+		err = ErrVersionConflict
+		return err
+	}
+	if delta := newQuantity - oldQuantity; delta != 0 {
+		if _, err = recordStockMovement(tx, contentID, delta, MovementAdjust, ""); err != nil {
+			return err
+		}
+	}
+	event, err := d.recordEvent(tx, Event{Type: EventItemUpdated, BoxID: boxID, ItemID: contentID})
+	if err != nil {
+		return err
+	}
+
+	// Commit the transaction
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
+	d.publish(event)
 	return nil
 }
 
-// Inserts a new box into the boxes table
-func (d *Database) CreateBox(db *sql.DB, name string, label string) error {
-	query := `INSERT INTO boxes (name, label) VALUES (?, ?)`
-	result, err := db.Exec(query, name, label)
+// Inserts a new box with the next free box code into the boxes table of an inventory
+func (d *Database) CreateBox(db *sql.DB, inventoryID int, name string, label string) error {
+	// Start a transaction
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	// Defer a rollback in case something fails.
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	code, err := d.nextBoxCode(tx)
+	if err != nil {
+		return err
+	}
+	query := `INSERT INTO boxes (inventory_id, code, name, label) VALUES (?, ?, ?, ?)`
+	result, err := tx.Exec(query, inventoryID, code, name, label)
 	if err != nil {
 		return err
 	}
@@ -240,7 +883,17 @@ func (d *Database) CreateBox(db *sql.DB, name string, label string) error {
 	if err != nil {
 		return err
 	}
+	event, err := d.recordEvent(tx, Event{Type: EventBoxCreated, BoxID: int(boxId)})
+	if err != nil {
+		return err
+	}
+	// Commit the transaction
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
 	log.Println(boxId)
+	d.publish(event)
 	return nil
 }
 
@@ -258,12 +911,55 @@ func (d *Database) DeleteBox(db *sql.DB, id int) error {
 		}
 	}()
 
-	// First, delete all contents associated with the box
+	// First, delete the history and all contents associated with the box
+	deleteMovementsQuery := `DELETE FROM stock_movements WHERE content_id IN (SELECT id FROM contents WHERE box_id = ?)`
+	_, err = tx.Exec(deleteMovementsQuery, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete stock movements: %w", err)
+	}
+	_, err = tx.Exec(`DELETE FROM item_moves WHERE content_id IN (SELECT id FROM contents WHERE box_id = ?)`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete item moves: %w", err)
+	}
+	_, err = tx.Exec(`DELETE FROM loans WHERE content_id IN (SELECT id FROM contents WHERE box_id = ?)`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete loans: %w", err)
+	}
+	_, err = tx.Exec(`DELETE FROM item_photos WHERE content_id IN (SELECT id FROM contents WHERE box_id = ?)`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete item photos: %w", err)
+	}
+	// Items away from the box make the box they are in their new home
+	_, err = tx.Exec(`UPDATE contents SET home_box_id = box_id WHERE home_box_id = ? AND box_id != ?`, id, id)
+	if err != nil {
+		return fmt.Errorf("failed to reset home boxes: %w", err)
+	}
 	deleteContentsQuery := `DELETE FROM contents WHERE box_id = ?`
 	_, err = tx.Exec(deleteContentsQuery, id)
 	if err != nil {
 		return fmt.Errorf("failed to delete contents: %w", err)
 	}
+	// Scan sessions putting items into the box continue without an active box
+	_, err = tx.Exec(`UPDATE scan_sessions SET box_id = NULL WHERE box_id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("failed to reset scan sessions: %w", err)
+	}
+	_, err = tx.Exec(`DELETE FROM shares WHERE box_id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete shares: %w", err)
+	}
+	_, err = tx.Exec(`DELETE FROM audit_entries WHERE audit_id IN (SELECT id FROM audits WHERE box_id = ?)`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete audit entries: %w", err)
+	}
+	_, err = tx.Exec(`DELETE FROM audits WHERE box_id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete audits: %w", err)
+	}
+	_, err = tx.Exec(`DELETE FROM box_status_changes WHERE box_id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete status changes: %w", err)
+	}
 
 	// Then, delete the box itself
 	deleteBoxQuery := `DELETE FROM boxes WHERE id = ?`
@@ -271,19 +967,37 @@ func (d *Database) DeleteBox(db *sql.DB, id int) error {
 	if err != nil {
 		return fmt.Errorf("failed to delete box: %w", err)
 	}
+	event, err := d.recordEvent(tx, Event{Type: EventBoxDeleted, BoxID: id})
+	if err != nil {
+		return err
+	}
 
 	// Commit the transaction
 	err = tx.Commit()
 	if err != nil {
 		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
+	d.publish(event)
 	return nil
 }
 
 // Update a box with new values to fields
-func (d *Database) UpdateBox(db *sql.DB, id int, newName string, newLabel string) error {
-	query := `UPDATE boxes SET name = ?, label = ? WHERE id = ?`
-	result, err := db.Exec(query, newName, newLabel, id)
+// If expectedVersion is greater than zero the update only succeeds if the box still has that version.
+func (d *Database) UpdateBox(db *sql.DB, id int, newName string, newLabel string, expectedVersion int) error {
+	query := `
+	UPDATE boxes
+	SET name = ?, label = ?, version = version + 1, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND (? = 0 OR version = ?)`
+
+	// Start a transaction
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	// Defer a rollback in case something fails.
+	defer tx.Rollback()
+
+	result, err := tx.Exec(query, newName, newLabel, id, expectedVersion, expectedVersion)
 	if err != nil {
 		return err
 	}
@@ -293,31 +1007,110 @@ func (d *Database) UpdateBox(db *sql.DB, id int, newName string, newLabel string
 		return err
 	}
 	if rowsAffected == 0 {
+		if _, err := d.GetBox(tx, id); err == nil {
+			return ErrVersionConflict
+		}
 		return fmt.Errorf("no box found with id %d", id)
 	}
+	event, err := d.recordEvent(tx, Event{Type: EventBoxUpdated, BoxID: id})
+	if err != nil {
+		return err
+	}
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	d.publish(event)
 	return nil
 }
 
-// Creates an item in a certain box
-func (d *Database) CreateItem(db *sql.DB, boxId int, name string, quantity int) error {
-	query := `INSERT INTO contents (name, quantity, box_id) VALUES (?, ?, ?)`
-	result, err := db.Exec(query, name, quantity, boxId)
+// Outer size in cm, empty weight and max load in grams of a box, each of them optional
+type BoxSize struct {
+	Length      sql.NullInt64
+	Width       sql.NullInt64
+	Height      sql.NullInt64
+	EmptyWeight sql.NullInt64
+	MaxLoad     sql.NullInt64
+}
+
+// Sets the size, empty weight and max load of a box
+func (d *Database) SetBoxSize(db *sql.DB, id int, size BoxSize) error {
+	query := `
+	UPDATE boxes
+	SET length = ?, width = ?, height = ?, empty_weight = ?, max_load = ?, version = version + 1, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?`
+
+	// Start a transaction
+	tx, err := db.Begin()
 	if err != nil {
 		return err
 	}
-	contentId, err := result.LastInsertId()
+	// Defer a rollback in case something fails.
+	defer tx.Rollback()
+
+	result, err := tx.Exec(query, size.Length, size.Width, size.Height, size.EmptyWeight, size.MaxLoad, id)
 	if err != nil {
 		return err
 	}
-	log.Println(contentId)
+	if updated, err := result.RowsAffected(); err != nil {
+		return err
+	} else if updated == 0 {
+		return sql.ErrNoRows
+	}
+	event, err := d.recordEvent(tx, Event{Type: EventBoxUpdated, BoxID: id})
+	if err != nil {
+		return err
+	}
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	d.publish(event)
 	return nil
 }
 
+// Creates an item in a certain box and returns its id
+func (d *Database) CreateItem(db *sql.DB, boxId int, name string, quantity int, attrs ItemAttributes) (int, error) {
+	var homeBoxID sql.NullInt64
+	if attrs.Labeled {
+		homeBoxID = sql.NullInt64{Int64: int64(boxId), Valid: true}
+	}
+	query := `INSERT INTO contents (name, quantity, expires_at, min_quantity, target_quantity, barcode, weight, home_box_id, box_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	// Start a transaction
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	// Defer a rollback in case something fails.
+	defer tx.Rollback()
+
+	result, err := tx.Exec(query, name, quantity, attrs.ExpiresAt, attrs.MinQuantity, attrs.TargetQuantity, attrs.Barcode, attrs.Weight, homeBoxID, boxId)
+	if err != nil {
+		return 0, err
+	}
+	contentId, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	event, err := d.recordEvent(tx, Event{Type: EventItemCreated, BoxID: boxId, ItemID: int(contentId)})
+	if err != nil {
+		return 0, err
+	}
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	log.Println(contentId)
+	d.publish(event)
+	return int(contentId), nil
+}
+
 // Moves one item to a new box with an atomic transaction
 func (d *Database) MoveItem(db *sql.DB, sourceBoxID, destBoxID, contentId int) error {
 	query := `
 	UPDATE contents
-	SET box_id = ?
+	SET box_id = ?, version = version + 1, updated_at = CURRENT_TIMESTAMP
 	WHERE box_id = ? AND id = ?`
 
 	// Start a transaction
@@ -332,15 +1125,35 @@ func (d *Database) MoveItem(db *sql.DB, sourceBoxID, destBoxID, contentId int) e
 		}
 	}()
 	// Execute the transaction
-	_, err = tx.Exec(query, destBoxID, sourceBoxID, contentId)
+	result, err := tx.Exec(query, destBoxID, sourceBoxID, contentId)
+	if err != nil {
+		return err
+	}
+	moved, err := result.RowsAffected()
 	if err != nil {
 		return err
 	}
+	if moved > 0 {
+		moveQuery := `INSERT INTO item_moves (content_id, from_box_id, to_box_id) VALUES (?, ?, ?)`
+		if _, err = tx.Exec(moveQuery, contentId, sourceBoxID, destBoxID); err != nil {
+			return err
+		}
+	}
+	var event Event
+	if moved > 0 {
+		event, err = d.recordEvent(tx, Event{Type: EventItemMoved, BoxID: destBoxID, ItemID: contentId, FromBoxID: sourceBoxID})
+		if err != nil {
+			return err
+		}
+	}
 	// Commit the transaction
 	err = tx.Commit()
 	if err != nil {
 		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
+	if moved > 0 {
+		d.publish(event)
+	}
 	return nil
 }
 
@@ -358,17 +1171,53 @@ func (d *Database) DeleteItem(db *sql.DB, id int) error {
 		}
 	}()
 
+	// Remember the box of the item to tell listeners which box changed
+	var boxID int
+	err = tx.QueryRow(`SELECT box_id FROM contents WHERE id = ?`, id).Scan(&boxID)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = nil
+		tx.Rollback()
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// Delete the history of the item
+	deleteMovementsQuery := `DELETE FROM stock_movements WHERE content_id = ?`
+	_, err = tx.Exec(deleteMovementsQuery, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete stock movements: %w", err)
+	}
+	_, err = tx.Exec(`DELETE FROM item_moves WHERE content_id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete item moves: %w", err)
+	}
+	_, err = tx.Exec(`DELETE FROM loans WHERE content_id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete loans: %w", err)
+	}
+	_, err = tx.Exec(`DELETE FROM item_photos WHERE content_id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete item photos: %w", err)
+	}
+
 	// Delete item from box
 	deleteContentsQuery := `DELETE FROM contents WHERE id = ?`
 	_, err = tx.Exec(deleteContentsQuery, id)
 	if err != nil {
 		return fmt.Errorf("failed to delete contents from box: %w", err)
 	}
+	event, err := d.recordEvent(tx, Event{Type: EventItemDeleted, BoxID: boxID, ItemID: id})
+	if err != nil {
+		return err
+	}
 
 	// Commit the transaction
 	err = tx.Commit()
 	if err != nil {
 		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
+	d.publish(event)
 	return nil
 }
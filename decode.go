@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+)
+
+// Returned when neither a QR code nor a barcode could be found in an image
+var ErrNoCodeFound = errors.New("no QR code or barcode found in the image")
+
+// Images are scaled down to this size before decoding, photos from phones are much bigger than needed
+const maxDecodeDimension = 2000
+
+// A QR code or barcode found in an image
+type DecodedCode struct {
+	// Either "qr", "ean13" or "ean8", or "text" for codes typed in or opened as a link.
+	// UPC-A codes are reported as EAN-13 with a leading zero.
+	Format string `json:"format"`
+	Text   string `json:"text"`
+}
+
+// A black and white version of an image
+type bitImage struct {
+	width, height int
+	bits          []bool
+}
+
+// Reports whether the pixel is black, everything outside the image is white
+func (b *bitImage) black(x, y int) bool {
+	if x < 0 || y < 0 || x >= b.width || y >= b.height {
+		return false
+	}
+	return b.bits[y*b.width+x]
+}
+
+// A grayscale version of an image
+type grayImage struct {
+	width, height int
+	pix           []uint8
+}
+
+// Decodes a JPEG, PNG or GIF image and returns all codes found in it.
+// QR codes are reported before barcodes.
+func decodeCodes(r io.Reader) ([]DecodedCode, error) {
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+	gray := toGray(img)
+	// Evenly lit, sharp images work best with a global threshold, photos with a local one
+	bitImages := []*bitImage{gray.globalThreshold(), gray.localThreshold()}
+	var codes []DecodedCode
+	for _, bits := range bitImages {
+		if text, err := decodeQR(bits); err == nil {
+			codes = append(codes, DecodedCode{Format: "qr", Text: text})
+			break
+		}
+	}
+	for _, bits := range bitImages {
+		if format, text, ok := decodeEAN(bits); ok {
+			codes = append(codes, DecodedCode{Format: format, Text: text})
+			break
+		}
+	}
+	if len(codes) == 0 {
+		return nil, ErrNoCodeFound
+	}
+	return codes, nil
+}
+
+// Converts an image to grayscale, scaling it down by an integer factor if it is too big
+func toGray(img image.Image) *grayImage {
+	bounds := img.Bounds()
+	factor := (max(bounds.Dx(), bounds.Dy()) + maxDecodeDimension - 1) / maxDecodeDimension
+	factor = max(factor, 1)
+	gray := &grayImage{width: bounds.Dx() / factor, height: bounds.Dy() / factor}
+	gray.pix = make([]uint8, gray.width*gray.height)
+
+	// Decoded JPEG and grayscale images already have a luminance plane which is much faster to read
+	luma := func(x, y int) uint32 {
+		r, g, b, _ := img.At(x, y).RGBA()
+		return (299*r + 587*g + 114*b) / 1000 >> 8
+	}
+	switch src := img.(type) {
+	case *image.YCbCr:
+		luma = func(x, y int) uint32 { return uint32(src.Y[src.YOffset(x, y)]) }
+	case *image.Gray:
+		luma = func(x, y int) uint32 { return uint32(src.Pix[src.PixOffset(x, y)]) }
+	}
+	for y := 0; y < gray.height; y++ {
+		for x := 0; x < gray.width; x++ {
+			var sum uint32
+			for dy := 0; dy < factor; dy++ {
+				for dx := 0; dx < factor; dx++ {
+					sum += luma(bounds.Min.X+x*factor+dx, bounds.Min.Y+y*factor+dy)
+				}
+			}
+			gray.pix[y*gray.width+x] = uint8(sum / uint32(factor*factor))
+		}
+	}
+	return gray
+}
+
+// Turns the image black and white with a single threshold chosen by Otsu's method
+func (g *grayImage) globalThreshold() *bitImage {
+	var histogram [256]int
+	for _, p := range g.pix {
+		histogram[p]++
+	}
+	total := len(g.pix)
+	var sumAll float64
+	for i, n := range histogram {
+		sumAll += float64(i * n)
+	}
+	var sumBackground float64
+	var weightBackground int
+	var best float64
+	threshold := 127
+	for i, n := range histogram {
+		weightBackground += n
+		if weightBackground == 0 {
+			continue
+		}
+		weightForeground := total - weightBackground
+		if weightForeground == 0 {
+			break
+		}
+		sumBackground += float64(i * n)
+		meanBackground := sumBackground / float64(weightBackground)
+		meanForeground := (sumAll - sumBackground) / float64(weightForeground)
+		between := float64(weightBackground) * float64(weightForeground) * (meanBackground - meanForeground) * (meanBackground - meanForeground)
+		if between > best {
+			best = between
+			threshold = i
+		}
+	}
+	bits := &bitImage{width: g.width, height: g.height, bits: make([]bool, len(g.pix))}
+	for i, p := range g.pix {
+		bits.bits[i] = int(p) <= threshold
+	}
+	return bits
+}
+
+// Turns the image black and white by comparing every pixel with the mean of its surroundings.
+// This copes with shadows and uneven light across a photo.
+func (g *grayImage) localThreshold() *bitImage {
+	w, h := g.width, g.height
+	// Summed area table with an extra row and column of zeros
+	integral := make([]uint32, (w+1)*(h+1))
+	for y := 0; y < h; y++ {
+		var row uint32
+		for x := 0; x < w; x++ {
+			row += uint32(g.pix[y*w+x])
+			integral[(y+1)*(w+1)+x+1] = integral[y*(w+1)+x+1] + row
+		}
+	}
+	radius := max(max(w, h)/16, 8)
+	bits := &bitImage{width: w, height: h, bits: make([]bool, len(g.pix))}
+	for y := 0; y < h; y++ {
+		y0, y1 := max(y-radius, 0), min(y+radius+1, h)
+		for x := 0; x < w; x++ {
+			x0, x1 := max(x-radius, 0), min(x+radius+1, w)
+			sum := integral[y1*(w+1)+x1] - integral[y0*(w+1)+x1] - integral[y1*(w+1)+x0] + integral[y0*(w+1)+x0]
+			count := uint32((y1 - y0) * (x1 - x0))
+			// Black if clearly darker than the surroundings
+			bits.bits[y*w+x] = uint32(g.pix[y*w+x])*count*100 <= sum*85
+		}
+	}
+	return bits
+}
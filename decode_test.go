@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"testing"
+
+	"github.com/skip2/go-qrcode"
+)
+
+// Draws a grid of modules with a quiet zone, scale pixels per module, rotated by angle degrees around the center
+func renderModules(modules [][]bool, quiet, scale int, angle float64) image.Image {
+	width := (len(modules[0]) + 2*quiet) * scale
+	height := (len(modules) + 2*quiet) * scale
+	// Leave room for the corners of rotated codes
+	side := int(math.Hypot(float64(width), float64(height))) + 2*scale
+	img := image.NewGray(image.Rect(0, 0, side, side))
+	sin, cos := math.Sincos(angle * math.Pi / 180)
+	center := float64(side) / 2
+	for y := 0; y < side; y++ {
+		for x := 0; x < side; x++ {
+			// Rotate every pixel back onto the unrotated code and sample the module under it
+			dx, dy := float64(x)+0.5-center, float64(y)+0.5-center
+			sx := dx*cos + dy*sin + float64(width)/2
+			sy := -dx*sin + dy*cos + float64(height)/2
+			mx := int(math.Floor(sx/float64(scale))) - quiet
+			my := int(math.Floor(sy/float64(scale))) - quiet
+			dark := my >= 0 && my < len(modules) && mx >= 0 && mx < len(modules[my]) && modules[my][mx]
+			if dark {
+				img.SetGray(x, y, color.Gray{Y: 0})
+			} else {
+				img.SetGray(x, y, color.Gray{Y: 255})
+			}
+		}
+	}
+	return img
+}
+
+// Decodes an image after a round trip through PNG, like an upload would
+func decodeImage(t *testing.T, img image.Image) ([]DecodedCode, error) {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return decodeCodes(&buf)
+}
+
+// Returns the modules of a QR code without its quiet zone
+func qrTestModules(t *testing.T, content string, version int, level qrcode.RecoveryLevel) [][]bool {
+	t.Helper()
+	qr, err := qrcode.NewWithForcedVersion(content, version, level)
+	if err != nil {
+		t.Fatal(err)
+	}
+	qr.DisableBorder = true
+	return qr.Bitmap()
+}
+
+func TestDecodeQRRoundTrip(t *testing.T) {
+	contents := []string{
+		"https://witb.example.com/s/AB12CD",
+		"0123456789012345",
+		"WITB BOX 42",
+		"Kiste mit Weihnachtsschmuck, Lichterketten und Kugeln",
+	}
+	for letter, level := range qrLevels {
+		for _, version := range []int{1, 2, 4, 7, 10, 15} {
+			for _, content := range contents {
+				qr, err := qrcode.NewWithForcedVersion(content, version, level)
+				if err != nil {
+					// The content does not fit this version at this level
+					continue
+				}
+				qr.DisableBorder = true
+				name := fmt.Sprintf("%s/v%d/%.10s", letter, version, content)
+				t.Run(name, func(t *testing.T) {
+					codes, err := decodeImage(t, renderModules(qr.Bitmap(), 4, 4, 0))
+					if err != nil {
+						t.Fatal(err)
+					}
+					if codes[0].Format != "qr" || codes[0].Text != content {
+						t.Errorf("decoded %+v, want qr %q", codes[0], content)
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestDecodeQRRotated(t *testing.T) {
+	content := "https://witb.example.com/s/ROT8ED"
+	for _, version := range []int{3, 7, 10} {
+		modules := qrTestModules(t, content, version, qrcode.Medium)
+		for _, angle := range []float64{90, 180, 270, 10, -25, 40, 45} {
+			t.Run(fmt.Sprintf("v%d/%g", version, angle), func(t *testing.T) {
+				codes, err := decodeImage(t, renderModules(modules, 4, 6, angle))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if codes[0].Text != content {
+					t.Errorf("decoded %q, want %q", codes[0].Text, content)
+				}
+			})
+		}
+	}
+}
+
+// Flips count data modules spread evenly over the code
+func corruptQR(modules [][]bool, count int) [][]bool {
+	function := qrFunctionModules((len(modules) - 17) / 4)
+	var data [][2]int
+	for y := range modules {
+		for x := range modules[y] {
+			if !function[y][x] {
+				data = append(data, [2]int{x, y})
+			}
+		}
+	}
+	corrupted := make([][]bool, len(modules))
+	for y := range modules {
+		corrupted[y] = append([]bool(nil), modules[y]...)
+	}
+	for i := 0; i < count; i++ {
+		p := data[i*len(data)/count+len(data)/(2*count)]
+		corrupted[p[1]][p[0]] = !corrupted[p[1]][p[0]]
+	}
+	return corrupted
+}
+
+func TestDecodeQRCorrupted(t *testing.T) {
+	content := "https://witb.example.com/s/BROKEN"
+	tests := []struct {
+		letter  string
+		version int
+		flipped int
+	}{
+		{"L", 4, 4},
+		{"M", 4, 8},
+		{"Q", 4, 10},
+		{"H", 4, 14},
+		{"H", 8, 20},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%s/v%d", test.letter, test.version), func(t *testing.T) {
+			modules := corruptQR(qrTestModules(t, content, test.version, qrLevels[test.letter]), test.flipped)
+			text, err := decodeQRModules(modules)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if text != content {
+				t.Errorf("decoded %q, want %q", text, content)
+			}
+			// The same code has to survive thresholding and sampling as well
+			codes, err := decodeImage(t, renderModules(modules, 4, 4, 0))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if codes[0].Text != content {
+				t.Errorf("decoded %q from the image, want %q", codes[0].Text, content)
+			}
+		})
+	}
+}
+
+func TestDecodeQRTooManyErrors(t *testing.T) {
+	content := "https://witb.example.com/s/BROKEN"
+	for letter, level := range qrLevels {
+		t.Run(letter, func(t *testing.T) {
+			modules := corruptQR(qrTestModules(t, content, 4, level), 200)
+			if text, err := decodeQRModules(modules); err == nil {
+				t.Errorf("decoded %q from a code with too many errors", text)
+			}
+			if codes, err := decodeImage(t, renderModules(modules, 4, 4, 0)); !errors.Is(err, ErrNoCodeFound) {
+				t.Errorf("decoded %+v, %v from a code with too many errors", codes, err)
+			}
+		})
+	}
+}
+
+// Returns the modules of an EAN-13 (13 digits) or EAN-8 (8 digits) barcode, true for dark bars
+func eanModules(digits string) []bool {
+	var modules []bool
+	// Appends runs of alternating color starting with the given one
+	runs := func(dark bool, widths ...int) {
+		for _, width := range widths {
+			for i := 0; i < width; i++ {
+				modules = append(modules, dark)
+			}
+			dark = !dark
+		}
+	}
+	parity := 0
+	if len(digits) == 13 {
+		parity = eanFirstDigitParity[digits[0]-'0']
+		digits = digits[1:]
+	}
+	half := len(digits) / 2
+	runs(true, 1, 1, 1)
+	for i := 0; i < half; i++ {
+		p := eanDigitPatterns[digits[i]-'0']
+		if parity&(1<<(half-1-i)) != 0 {
+			runs(false, p[3], p[2], p[1], p[0])
+		} else {
+			runs(false, p[0], p[1], p[2], p[3])
+		}
+	}
+	runs(false, 1, 1, 1, 1, 1)
+	for i := half; i < len(digits); i++ {
+		p := eanDigitPatterns[digits[i]-'0']
+		runs(true, p[0], p[1], p[2], p[3])
+	}
+	runs(true, 1, 1, 1)
+	return modules
+}
+
+// Draws a barcode with bars as high as the given number of modules
+func eanImage(digits string, height int, angle float64) image.Image {
+	bars := eanModules(digits)
+	modules := make([][]bool, height)
+	for y := range modules {
+		modules[y] = bars
+	}
+	return renderModules(modules, 10, 3, angle)
+}
+
+func TestDecodeEAN(t *testing.T) {
+	tests := []struct {
+		name   string
+		digits string
+		format string
+		text   string
+	}{
+		{"EAN-13", "4006381333931", "ean13", "4006381333931"},
+		{"EAN-13 all L-code", "0012345678905", "ean13", "0012345678905"},
+		{"EAN-13 with G-code", "5901234123457", "ean13", "5901234123457"},
+		{"UPC-A", "0036000291452", "ean13", "0036000291452"},
+		{"EAN-8", "96385074", "ean8", "96385074"},
+		{"EAN-8 with zeros", "40170725", "ean8", "40170725"},
+	}
+	for _, test := range tests {
+		for _, angle := range []float64{0, 90, 180, 270} {
+			t.Run(fmt.Sprintf("%s/%g", test.name, angle), func(t *testing.T) {
+				codes, err := decodeImage(t, eanImage(test.digits, 40, angle))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(codes) != 1 || codes[0].Format != test.format || codes[0].Text != test.text {
+					t.Errorf("decoded %+v, want %s %s", codes, test.format, test.text)
+				}
+			})
+		}
+	}
+}
+
+func TestDecodeEANRejectsInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		img  image.Image
+	}{
+		{"wrong EAN-13 check digit", eanImage("4006381333932", 40, 0)},
+		{"wrong EAN-8 check digit", eanImage("96385075", 40, 0)},
+		{"blank", renderModules([][]bool{{false}}, 40, 4, 0)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if codes, err := decodeImage(t, test.img); !errors.Is(err, ErrNoCodeFound) {
+				t.Errorf("decoded %+v, %v, want %v", codes, err, ErrNoCodeFound)
+			}
+		})
+	}
+}
+
+func TestDecodeCodesQRAndEAN(t *testing.T) {
+	content := "https://witb.example.com/s/BOTH42"
+	qr := renderModules(qrTestModules(t, content, 3, qrcode.Medium), 4, 4, 0)
+	ean := eanImage("4006381333931", 40, 0)
+	img := image.NewGray(image.Rect(0, 0, qr.Bounds().Dx()+ean.Bounds().Dx(), max(qr.Bounds().Dy(), ean.Bounds().Dy())))
+	for y := 0; y < img.Bounds().Dy(); y++ {
+		for x := 0; x < img.Bounds().Dx(); x++ {
+			img.SetGray(x, y, color.Gray{Y: 255})
+		}
+	}
+	for y := 0; y < qr.Bounds().Dy(); y++ {
+		for x := 0; x < qr.Bounds().Dx(); x++ {
+			img.Set(x, y, qr.At(x, y))
+		}
+	}
+	for y := 0; y < ean.Bounds().Dy(); y++ {
+		for x := 0; x < ean.Bounds().Dx(); x++ {
+			img.Set(qr.Bounds().Dx()+x, y, ean.At(x, y))
+		}
+	}
+	codes, err := decodeImage(t, img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []DecodedCode{{Format: "qr", Text: content}, {Format: "ean13", Text: "4006381333931"}}
+	if fmt.Sprint(codes) != fmt.Sprint(want) {
+		t.Errorf("decoded %+v, want %+v", codes, want)
+	}
+}
+
+// Appends the Reed-Solomon error correction codewords of the generator with roots a^0 ... a^(eccLen-1)
+func reedSolomonEncode(data []byte, eccLen int) []byte {
+	generator := []byte{1}
+	for i := 0; i < eccLen; i++ {
+		next := make([]byte, len(generator)+1)
+		for j, c := range generator {
+			next[j] ^= c
+			next[j+1] ^= gfMul(c, gfExp[i])
+		}
+		generator = next
+	}
+	remainder := make([]byte, len(data)+eccLen)
+	copy(remainder, data)
+	for i := range data {
+		factor := remainder[i]
+		for j, c := range generator {
+			remainder[i+j] ^= gfMul(c, factor)
+		}
+	}
+	return append(append([]byte(nil), data...), remainder[len(data):]...)
+}
+
+func TestReedSolomonCorrect(t *testing.T) {
+	data := []byte("what is in the box")
+	tests := []struct {
+		eccLen    int
+		positions []int
+		wantErr   error
+	}{
+		{10, nil, nil},
+		{10, []int{0}, nil},
+		{10, []int{27}, nil},
+		{10, []int{1, 5, 9, 20, 27}, nil},
+		{22, []int{0, 3, 6, 9, 12, 15, 18, 21, 24, 27, 39}, nil},
+		{10, []int{1, 5, 9, 13, 20, 27}, ErrTooManyErrors},
+		{22, []int{0, 2, 4, 6, 8, 10, 12, 14, 16, 18, 20, 22}, ErrTooManyErrors},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%d/%v", test.eccLen, test.positions), func(t *testing.T) {
+			block := reedSolomonEncode(data, test.eccLen)
+			want := append([]byte(nil), block...)
+			for i, p := range test.positions {
+				block[p] ^= byte(0x5A + i)
+			}
+			err := reedSolomonCorrect(block, test.eccLen)
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("got error %v, want %v", err, test.wantErr)
+			}
+			if err == nil && !bytes.Equal(block, want) {
+				t.Errorf("corrected to %q, want %q", block, want)
+			}
+		})
+	}
+}
+
+func TestReedSolomonEncodeMatchesQR(t *testing.T) {
+	// The encoder above has to produce the codewords of real codes, otherwise the tests of
+	// the corrector would prove nothing. This is the version 1-M "01234567" example of the standard.
+	want := []byte{0x10, 0x20, 0x0C, 0x56, 0x61, 0x80, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11,
+		0xA5, 0x24, 0xD4, 0xC1, 0xED, 0x36, 0xC7, 0x87, 0x2C, 0x55}
+	if got := reedSolomonEncode(want[:16], 10); !bytes.Equal(got, want) {
+		t.Errorf("encoded %X, want %X", got, want)
+	}
+}
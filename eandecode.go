@@ -0,0 +1,210 @@
+package main
+
+// Reading EAN-13, EAN-8 and UPC-A barcodes from black and white images.
+//
+// Rows and columns across the image are split into runs of dark and light pixels.
+// A barcode starts with a guard of three thin bars after a light quiet zone, every digit
+// is made of four runs seven modules wide, and the check digit confirms the result.
+
+// Widths of the light and dark runs of the L-code digits. G-code digits are mirrored,
+// R-code digits have the same widths as L-code digits with colors swapped.
+var eanDigitPatterns = [10][4]int{
+	{3, 2, 1, 1}, {2, 2, 2, 1}, {2, 1, 2, 2}, {1, 4, 1, 1}, {1, 1, 3, 2},
+	{1, 2, 3, 1}, {1, 1, 1, 4}, {1, 3, 1, 2}, {1, 2, 1, 3}, {3, 1, 1, 2},
+}
+
+// Parity of the six left digits of an EAN-13 (bit set for G-code) encoding the first digit
+var eanFirstDigitParity = [10]int{0x00, 0x0B, 0x0D, 0x0E, 0x13, 0x19, 0x1C, 0x15, 0x16, 0x1A}
+
+// Thresholds for matching run widths against a pattern, relative to the module width
+const (
+	eanMaxAverageVariance    = 0.48
+	eanMaxIndividualVariance = 0.7
+)
+
+// Scans lines across the image in both directions and returns a barcode read on at least two lines
+func decodeEAN(img *bitImage) (string, string, bool) {
+	votes := make(map[DecodedCode]int)
+	lines := 32
+	for i := 1; i < lines; i++ {
+		row := make([]bool, img.width)
+		y := img.height * i / lines
+		for x := range row {
+			row[x] = img.black(x, y)
+		}
+		column := make([]bool, img.height)
+		x := img.width * i / lines
+		for y := range column {
+			column[y] = img.black(x, y)
+		}
+		for _, line := range [][]bool{row, column} {
+			runs := eanRuns(line)
+			for _, r := range [][]int{runs, reversed(runs)} {
+				if code, ok := decodeEANRuns(r); ok {
+					votes[code]++
+				}
+			}
+		}
+	}
+	var best DecodedCode
+	for code, n := range votes {
+		if n >= 2 && n > votes[best] {
+			best = code
+		}
+	}
+	return best.Format, best.Text, best.Text != ""
+}
+
+// Splits a line into the lengths of its runs, starting with a light run (which may be empty)
+func eanRuns(line []bool) []int {
+	runs := []int{0}
+	black := false
+	for _, b := range line {
+		if b != black {
+			runs = append(runs, 0)
+			black = b
+		}
+		runs[len(runs)-1]++
+	}
+	// End with a light run as well so both ends look alike when reversed
+	if black {
+		runs = append(runs, 0)
+	}
+	return runs
+}
+
+func reversed(runs []int) []int {
+	r := make([]int, len(runs))
+	for i, v := range runs {
+		r[len(runs)-1-i] = v
+	}
+	return r
+}
+
+// How well run widths match a pattern, lower is better. Returns a value above 1 for no match at all.
+func eanVariance(runs []int, pattern []int) float64 {
+	total, patternLength := 0, 0
+	for i := range runs {
+		total += runs[i]
+		patternLength += pattern[i]
+	}
+	if total < patternLength {
+		return 2
+	}
+	unit := float64(total) / float64(patternLength)
+	maxIndividual := eanMaxIndividualVariance * unit
+	var variance float64
+	for i, run := range runs {
+		diff := float64(run) - float64(pattern[i])*unit
+		if diff < 0 {
+			diff = -diff
+		}
+		if diff > maxIndividual {
+			return 2
+		}
+		variance += diff
+	}
+	return variance / float64(total)
+}
+
+// Finds the best matching digit for four runs, reporting whether it is a G-code digit
+func eanDigit(runs []int, allowG bool) (int, bool, bool) {
+	best, bestG := -1, false
+	bestVariance := eanMaxAverageVariance
+	for digit, pattern := range eanDigitPatterns {
+		if v := eanVariance(runs, pattern[:]); v < bestVariance {
+			best, bestG, bestVariance = digit, false, v
+		}
+		if !allowG {
+			continue
+		}
+		mirrored := []int{pattern[3], pattern[2], pattern[1], pattern[0]}
+		if v := eanVariance(runs, mirrored); v < bestVariance {
+			best, bestG, bestVariance = digit, true, v
+		}
+	}
+	return best, bestG, best >= 0
+}
+
+// Tries to read an EAN-13 or EAN-8 starting at every dark run of a line.
+// Even indexes of runs are light, odd indexes dark.
+func decodeEANRuns(runs []int) (DecodedCode, bool) {
+	for start := 1; start < len(runs); start += 2 {
+		if code, ok := decodeEANAt(runs, start, 6); ok {
+			return code, true
+		}
+		if code, ok := decodeEANAt(runs, start, 4); ok {
+			return code, true
+		}
+	}
+	return DecodedCode{}, false
+}
+
+// Reads a barcode with the given number of digits per half starting with the guard at runs[start]
+func decodeEANAt(runs []int, start, half int) (DecodedCode, bool) {
+	length := 3 + 4*half + 5 + 4*half + 3
+	if start+length >= len(runs) {
+		return DecodedCode{}, false
+	}
+	guard := runs[start : start+3]
+	if eanVariance(guard, []int{1, 1, 1}) > eanMaxAverageVariance {
+		return DecodedCode{}, false
+	}
+	// The quiet zones have to be clearly wider than a bar
+	module := float64(guard[0]+guard[1]+guard[2]) / 3
+	if float64(runs[start-1]) < 3*module || float64(runs[start+length]) < 3*module {
+		return DecodedCode{}, false
+	}
+
+	digits := make([]byte, 0, 2*half+1)
+	parity := 0
+	pos := start + 3
+	for i := 0; i < half; i++ {
+		digit, g, ok := eanDigit(runs[pos:pos+4], half == 6)
+		if !ok {
+			return DecodedCode{}, false
+		}
+		parity <<= 1
+		if g {
+			parity |= 1
+		}
+		digits = append(digits, byte('0'+digit))
+		pos += 4
+	}
+	if eanVariance(runs[pos:pos+5], []int{1, 1, 1, 1, 1}) > eanMaxAverageVariance {
+		return DecodedCode{}, false
+	}
+	pos += 5
+	for i := 0; i < half; i++ {
+		digit, _, ok := eanDigit(runs[pos:pos+4], false)
+		if !ok {
+			return DecodedCode{}, false
+		}
+		digits = append(digits, byte('0'+digit))
+		pos += 4
+	}
+	if eanVariance(runs[pos:pos+3], []int{1, 1, 1}) > eanMaxAverageVariance {
+		return DecodedCode{}, false
+	}
+
+	format := "ean8"
+	if half == 6 {
+		// The first digit of an EAN-13 is not drawn, it is encoded in the parity of the left half
+		first := -1
+		for d, p := range eanFirstDigitParity {
+			if p == parity {
+				first = d
+			}
+		}
+		if first < 0 {
+			return DecodedCode{}, false
+		}
+		digits = append([]byte{byte('0' + first)}, digits...)
+		format = "ean13"
+	}
+	text := string(digits)
+	if !validCheckDigit(text) {
+		return DecodedCode{}, false
+	}
+	return DecodedCode{Format: format, Text: text}, true
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// A single field shown side by side on the conflict page
+type conflictField struct {
+	Label  string
+	Name   string
+	Mine   string
+	Theirs string
+}
+
+// Formats the version of a box or item as an ETag
+func versionETag(version int) string {
+	return fmt.Sprintf(`"%d"`, version)
+}
+
+// Builds a strong ETag from the bytes of a generated resource such as a QR code image
+func dataETag(data []byte) string {
+	sum := sha1.Sum(data)
+	return `"` + hex.EncodeToString(sum[:])[:16] + `"`
+}
+
+// Collects the status and body of a response instead of sending them, so the ETag of the body can be set first
+type bufferedWriter struct {
+	gin.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *bufferedWriter) WriteHeader(status int) {
+	w.status = status
+}
+
+func (w *bufferedWriter) WriteHeaderNow() {}
+
+func (w *bufferedWriter) Write(data []byte) (int, error) {
+	return w.body.Write(data)
+}
+
+func (w *bufferedWriter) WriteString(s string) (int, error) {
+	return w.body.WriteString(s)
+}
+
+// Renders an html template like renderHTML with the ETag of the page, so browsers revalidating it get
+// 304 Not Modified as long as nothing shown on it has changed
+func renderHTMLWithETag(c *gin.Context, status int, name string, values gin.H) {
+	writer := &bufferedWriter{ResponseWriter: c.Writer, status: status}
+	c.Writer = writer
+	renderHTML(c, status, name, values)
+	c.Writer = writer.ResponseWriter
+
+	etag := dataETag(writer.body.Bytes())
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", "no-cache")
+	if notModified(c, etag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.Writer.WriteHeader(writer.status)
+	c.Writer.Write(writer.body.Bytes())
+}
+
+// Returns whether the client already has the resource with the ETag, checking If-None-Match
+func notModified(c *gin.Context, etag string) bool {
+	for _, candidate := range strings.Split(c.GetHeader("If-None-Match"), ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// Parses the If-Match header into the version the client expects.
+// A missing header or "*" returns 0 which makes the update unconditional.
+func ifMatchVersion(c *gin.Context) (int, bool, error) {
+	header := strings.TrimSpace(c.GetHeader("If-Match"))
+	if header == "" || header == "*" {
+		return 0, false, nil
+	}
+	// Weak validators are accepted since versions are compared as plain numbers
+	header = strings.TrimPrefix(header, "W/")
+	version, err := strconv.Atoi(strings.Trim(header, `"`))
+	if err != nil || version < 1 {
+		return 0, true, fmt.Errorf("invalid If-Match header %q", c.GetHeader("If-Match"))
+	}
+	return version, true, nil
+}
+
+// Determines the expected version of an HTML form update.
+// The If-Match header wins over the hidden item_version form field.
+func expectedVersion(c *gin.Context) (int, bool, error) {
+	version, fromHeader, err := ifMatchVersion(c)
+	if err != nil || fromHeader {
+		return version, fromHeader, err
+	}
+	field := c.PostForm("item_version")
+	if field == "" {
+		return 0, false, nil
+	}
+	version, err = strconv.Atoi(field)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid version %q", field)
+	}
+	return version, false, nil
+}
+
+// Renders the page telling the user someone else changed the record in the meantime.
+// The form on the page resubmits the users values against the current version.
+func renderConflict(c *gin.Context, kind string, action string, backURL string, version int, fields []conflictField) {
+	c.Header("ETag", versionETag(version))
+	renderHTML(c, http.StatusConflict, "conflict.tmpl", gin.H{
+		"Kind":    kind,
+		"Action":  action,
+		"BackURL": backURL,
+		"Version": version,
+		"Fields":  fields,
+	})
+}
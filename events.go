@@ -0,0 +1,195 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Event types published on the event bus whenever the store changes
+const (
+	EventBoxCreated  = "box.created"
+	EventBoxUpdated  = "box.updated"
+	EventBoxDeleted  = "box.deleted"
+	EventItemCreated = "item.created"
+	EventItemUpdated = "item.updated"
+	EventItemMoved   = "item.moved"
+	EventItemDeleted = "item.deleted"
+)
+
+// All event types in the order they are documented
+var eventTypes = []string{
+	EventBoxCreated,
+	EventBoxUpdated,
+	EventBoxDeleted,
+	EventItemCreated,
+	EventItemUpdated,
+	EventItemMoved,
+	EventItemDeleted,
+}
+
+// Define event struct with json marshalling config
+// FromBoxID is only set for moved items and holds the box the item was taken out of.
+type Event struct {
+	ID        int64  `json:"id"`
+	Type      string `json:"type"`
+	BoxID     int    `json:"box_id"`
+	ItemID    int    `json:"item_id,omitempty"`
+	FromBoxID int    `json:"from_box_id,omitempty"`
+	// Identity of the user who made the change, empty if unknown
+	Actor string    `json:"actor,omitempty"`
+	Time  time.Time `json:"time"`
+}
+
+// Reports whether the event changes the contents or attributes of the given box
+func (e Event) Touches(boxID int) bool {
+	return e.BoxID == boxID || e.FromBoxID == boxID
+}
+
+// A single listener on the event bus
+// Subscriptions with a backlog queue every event for the subscriber instead of dropping any.
+type subscription struct {
+	events chan Event
+	filter func(Event) bool
+
+	backlog *eventBacklog
+}
+
+// Events waiting to be taken by a subscriber that must not lose any
+type eventBacklog struct {
+	mu      sync.Mutex
+	pending []Event
+	ready   chan struct{}
+	done    chan struct{}
+}
+
+// Queues an event and wakes up the goroutine passing events on
+func (q *eventBacklog) push(event Event) {
+	q.mu.Lock()
+	q.pending = append(q.pending, event)
+	q.mu.Unlock()
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// Passes queued events on to out in order until the subscription is removed
+func (q *eventBacklog) forward(out chan<- Event) {
+	defer close(out)
+	for {
+		select {
+		case <-q.ready:
+		case <-q.done:
+			return
+		}
+		q.mu.Lock()
+		pending := q.pending
+		q.pending = nil
+		q.mu.Unlock()
+		for _, event := range pending {
+			select {
+			case out <- event:
+			case <-q.done:
+				return
+			}
+		}
+	}
+}
+
+// In-process publish/subscribe hub for store changes.
+// Publishing never blocks: subscribers that do not keep up lose events instead of slowing down writers,
+// except for those registered with SubscribeAll whose events are queued in memory.
+type EventBus struct {
+	mu          sync.RWMutex
+	subscribers map[*subscription]struct{}
+	lastID      atomic.Int64
+	dropped     atomic.Int64
+}
+
+// Creates an event bus without any subscribers
+func NewEventBus() *EventBus {
+	return &EventBus{
+		subscribers: make(map[*subscription]struct{}),
+	}
+}
+
+// Registers a new subscriber receiving all events accepted by filter (or all events if filter is nil).
+// The returned function removes the subscription and closes the channel.
+func (b *EventBus) Subscribe(buffer int, filter func(Event) bool) (<-chan Event, func()) {
+	sub := &subscription{
+		events: make(chan Event, buffer),
+		filter: filter,
+	}
+	b.mu.Lock()
+	b.subscribers[sub] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subscribers, sub)
+			b.mu.Unlock()
+			close(sub.events)
+		})
+	}
+	return sub.events, unsubscribe
+}
+
+// Registers a subscriber receiving every event in order, however far it falls behind.
+// Events it has not taken yet are queued without limit, so it must keep draining the channel.
+// The returned function removes the subscription and closes the channel.
+func (b *EventBus) SubscribeAll() (<-chan Event, func()) {
+	out := make(chan Event)
+	sub := &subscription{
+		backlog: &eventBacklog{ready: make(chan struct{}, 1), done: make(chan struct{})},
+	}
+	b.mu.Lock()
+	b.subscribers[sub] = struct{}{}
+	b.mu.Unlock()
+	go sub.backlog.forward(out)
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subscribers, sub)
+			b.mu.Unlock()
+			close(sub.backlog.done)
+		})
+	}
+	return out, unsubscribe
+}
+
+// Assigns an id and timestamp to the event and fans it out to all matching subscribers
+func (b *EventBus) Publish(event Event) {
+	if b == nil {
+		return
+	}
+	event.ID = b.lastID.Add(1)
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for sub := range b.subscribers {
+		if sub.filter != nil && !sub.filter(event) {
+			continue
+		}
+		if sub.backlog != nil {
+			sub.backlog.push(event)
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			b.dropped.Add(1)
+		}
+	}
+}
+
+// Returns the number of events that were dropped because a subscriber was too slow
+func (b *EventBus) Dropped() int64 {
+	return b.dropped.Load()
+}
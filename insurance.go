@@ -0,0 +1,397 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/image/draw"
+)
+
+// Photos of items are scaled down to fit this size, they are shown small in reports
+const maxPhotoDimension = 1024
+
+// Location of boxes without a label in the insurance report
+const unlabeledLocation = "Unlabeled"
+
+// What an item cost and is worth, in cents of the configured currency, and its serial number
+type ItemValue struct {
+	PurchasePrice sql.NullInt64
+	PurchasedAt   sql.NullTime
+	CurrentValue  sql.NullInt64
+	SerialNumber  sql.NullString
+}
+
+// An item in the insurance report with the value of all its units
+type InsuranceItem struct {
+	Item
+	Total JSONNullInt64 `json:"total"`
+}
+
+// A box in the insurance report with the items worth insuring in it
+type InsuranceBox struct {
+	ID    int             `json:"id"`
+	Name  string          `json:"name"`
+	Items []InsuranceItem `json:"items"`
+	Total int64           `json:"total"`
+}
+
+// Boxes sharing a label, which says where they are
+type InsuranceLocation struct {
+	Name  string         `json:"name"`
+	Boxes []InsuranceBox `json:"boxes"`
+	Total int64          `json:"total"`
+}
+
+// Items of an inventory with a value, a serial number or a photo, grouped by location and box.
+// Totals are in cents of Currency and count items without a value as worth nothing.
+type InsuranceReport struct {
+	Inventory   string              `json:"inventory"`
+	Currency    string              `json:"currency"`
+	GeneratedAt time.Time           `json:"generated_at"`
+	Locations   []InsuranceLocation `json:"locations"`
+	Items       int                 `json:"items"`
+	Unvalued    int                 `json:"unvalued"`
+	Total       int64               `json:"total"`
+}
+
+// Sets what an item cost and is worth and its serial number
+func (d *Database) SetItemValue(db *sql.DB, itemID int, value ItemValue) error {
+	query := `
+	UPDATE contents
+	SET purchase_price = ?, purchased_at = ?, current_value = ?, serial_number = ?, version = version + 1, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	RETURNING box_id`
+
+	// Start a transaction
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	// Defer a rollback in case something fails.
+	defer tx.Rollback()
+
+	var boxID int
+	err = tx.QueryRow(query, value.PurchasePrice, value.PurchasedAt, value.CurrentValue, value.SerialNumber, itemID).Scan(&boxID)
+	if err != nil {
+		return err
+	}
+	event, err := d.recordEvent(tx, Event{Type: EventItemUpdated, BoxID: boxID, ItemID: itemID})
+	if err != nil {
+		return err
+	}
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	d.publish(event)
+	return nil
+}
+
+// Sets the photo of an item, replacing the one it had. The photo is JPEG data prepared with preparePhoto.
+func (d *Database) SetItemPhoto(db *sql.DB, itemID int, data []byte) error {
+	return d.changeItemPhoto(db, itemID, `
+	INSERT INTO item_photos (content_id, data) VALUES (?, ?)
+	ON CONFLICT (content_id) DO UPDATE SET data = excluded.data, updated_at = CURRENT_TIMESTAMP`, itemID, data)
+}
+
+// Removes the photo of an item
+func (d *Database) DeleteItemPhoto(db *sql.DB, itemID int) error {
+	return d.changeItemPhoto(db, itemID, `DELETE FROM item_photos WHERE content_id = ?`, itemID)
+}
+
+// Changes the photo of an item with a query and counts it as a change of the item
+func (d *Database) changeItemPhoto(db *sql.DB, itemID int, query string, args ...any) error {
+	// Start a transaction
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	// Defer a rollback in case something fails.
+	defer tx.Rollback()
+
+	var boxID int
+	err = tx.QueryRow(`UPDATE contents SET version = version + 1, updated_at = CURRENT_TIMESTAMP WHERE id = ? RETURNING box_id`, itemID).Scan(&boxID)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(query, args...); err != nil {
+		return err
+	}
+	event, err := d.recordEvent(tx, Event{Type: EventItemUpdated, BoxID: boxID, ItemID: itemID})
+	if err != nil {
+		return err
+	}
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	d.publish(event)
+	return nil
+}
+
+// Get the JPEG photo of an item
+func (d *Database) GetItemPhoto(db *sql.DB, itemID int) ([]byte, error) {
+	var data []byte
+	err := db.QueryRow(`SELECT data FROM item_photos WHERE content_id = ?`, itemID).Scan(&data)
+	return data, err
+}
+
+// Decodes an uploaded JPEG, PNG or GIF photo and stores it as a JPEG scaled down to maxPhotoDimension
+func preparePhoto(r io.Reader) ([]byte, error) {
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+	bounds := img.Bounds()
+	if longest := max(bounds.Dx(), bounds.Dy()); longest > maxPhotoDimension {
+		scaled := image.NewRGBA(image.Rect(0, 0, bounds.Dx()*maxPhotoDimension/longest, bounds.Dy()*maxPhotoDimension/longest))
+		draw.ApproxBiLinear.Scale(scaled, scaled.Bounds(), img, bounds, draw.Src, nil)
+		img = scaled
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Builds the insurance report of an inventory
+func (d *Database) GetInsuranceReport(db *sql.DB, inventory Inventory) (InsuranceReport, error) {
+	report := InsuranceReport{Inventory: inventory.Name, Currency: currency, GeneratedAt: time.Now(), Locations: make([]InsuranceLocation, 0)}
+	query := `
+	SELECT ` + itemColumns + `, boxes.name, boxes.label
+	FROM contents
+	JOIN boxes ON boxes.id = contents.box_id
+	WHERE boxes.inventory_id = ?
+	AND (contents.purchase_price IS NOT NULL OR contents.current_value IS NOT NULL OR contents.serial_number IS NOT NULL
+		OR EXISTS (SELECT 1 FROM item_photos WHERE item_photos.content_id = contents.id))
+	ORDER BY COALESCE(boxes.label, '') = '', boxes.label, LOWER(boxes.name), boxes.id, LOWER(contents.name), contents.id`
+	rows, err := db.Query(query, inventory.ID)
+	if err != nil {
+		return report, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var boxName string
+		var label sql.NullString
+		item, err := scanItem(rows, &boxName, &label)
+		if err != nil {
+			return report, err
+		}
+		location := unlabeledLocation
+		if label.Valid && label.String != "" {
+			location = label.String
+		}
+		entry := InsuranceItem{Item: item, Total: JSONNullInt64{item.TotalValue()}}
+		if !entry.Total.Valid {
+			report.Unvalued++
+		}
+
+		last := len(report.Locations) - 1
+		if last < 0 || report.Locations[last].Name != location {
+			report.Locations = append(report.Locations, InsuranceLocation{Name: location})
+			last++
+		}
+		loc := &report.Locations[last]
+		if len(loc.Boxes) == 0 || loc.Boxes[len(loc.Boxes)-1].ID != item.BoxID {
+			loc.Boxes = append(loc.Boxes, InsuranceBox{ID: item.BoxID, Name: boxName})
+		}
+		box := &loc.Boxes[len(loc.Boxes)-1]
+		box.Items = append(box.Items, entry)
+		box.Total += entry.Total.Int64
+		loc.Total += entry.Total.Int64
+		report.Total += entry.Total.Int64
+		report.Items++
+	}
+	if err := rows.Err(); err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+// Get the photos of the items in an insurance report by item ID
+func (d *Database) GetInsurancePhotos(db *sql.DB, report InsuranceReport) (map[int][]byte, error) {
+	photos := make(map[int][]byte)
+	for _, location := range report.Locations {
+		for _, box := range location.Boxes {
+			for _, item := range box.Items {
+				if !item.HasPhoto {
+					continue
+				}
+				data, err := d.GetItemPhoto(db, item.ID)
+				if err != nil {
+					return nil, err
+				}
+				photos[item.ID] = data
+			}
+		}
+	}
+	return photos, nil
+}
+
+// Formats cents as an amount with two decimals
+func formatCents(cents int64) string {
+	sign := ""
+	if cents < 0 {
+		sign, cents = "-", -cents
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, cents/100, cents%100)
+}
+
+// Formats optional cents as an amount, or nothing
+func formatOptionalCents(cents sql.NullInt64) string {
+	if !cents.Valid {
+		return ""
+	}
+	return formatCents(cents.Int64)
+}
+
+// Writes the insurance report as CSV, one row per item.
+// photoURL returns the link to the photo of an item.
+func writeInsuranceCSV(w io.Writer, report InsuranceReport, photoURL func(itemID int) string) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"Location", "Box", "Item", "Quantity", "Serial number", "Purchased",
+		"Purchase price (" + report.Currency + ")", "Current value (" + report.Currency + ")", "Total (" + report.Currency + ")", "Photo"})
+	for _, location := range report.Locations {
+		for _, box := range location.Boxes {
+			for _, item := range box.Items {
+				purchased := ""
+				if item.PurchasedAt.Valid {
+					purchased = item.PurchasedAt.Time.Format(time.DateOnly)
+				}
+				photo := ""
+				if item.HasPhoto {
+					photo = photoURL(item.ID)
+				}
+				out.Write([]string{csvText(location.Name), csvText(box.Name), csvText(item.Name), strconv.Itoa(item.Quantity), csvText(item.SerialNumber.String), purchased,
+					formatOptionalCents(item.PurchasePrice.NullInt64), formatOptionalCents(item.CurrentValue.NullInt64),
+					formatOptionalCents(item.Total.NullInt64), photo})
+			}
+		}
+	}
+	out.Write([]string{"Total", "", "", "", "", "", "", "", formatCents(report.Total), ""})
+	out.Flush()
+	return out.Error()
+}
+
+// Keeps text typed in by users from being taken as a formula when the CSV is opened in a spreadsheet
+// by prefixing an apostrophe to cells starting like one
+func csvText(text string) string {
+	if text != "" && strings.ContainsRune("=+-@\t\r", rune(text[0])) {
+		return "'" + text
+	}
+	return text
+}
+
+// Renders the insurance report as an A4 PDF with a table of the items per location and box and their photos
+func insurancePDF(report InsuranceReport, photos map[int][]byte) []byte {
+	const (
+		margin     = 40.0
+		rowHeight  = 14.0
+		photoSize  = 48.0
+		amountEdge = pdfPageWidth - margin
+	)
+	doc := &pdfDocument{}
+	var page *pdfPage
+	y := 0.0
+	newPage := func() {
+		page = doc.addPage()
+		page.text(margin, pdfPageHeight-margin+12, pdfHelvetica, 8, fmt.Sprintf("Insurance inventory of %s, %s", report.Inventory, report.GeneratedAt.Format(time.DateOnly)))
+		page.textRight(amountEdge, margin-20, 8, fmt.Sprintf("Page %d", len(doc.pages)))
+		y = pdfPageHeight - margin - 16
+	}
+	// Starts a new page unless there is room for the height left on this one
+	need := func(height float64) {
+		if y-height < margin {
+			newPage()
+		}
+	}
+
+	newPage()
+	page.text(margin, y, pdfHelveticaBold, 18, "Insurance inventory")
+	y -= 22
+	page.text(margin, y, pdfHelvetica, 11, report.Inventory)
+	y -= 16
+	page.text(margin, y, pdfHelvetica, 11, fmt.Sprintf("%d items worth %s %s", report.Items, formatCents(report.Total), report.Currency))
+	if report.Unvalued > 0 {
+		y -= 14
+		page.text(margin, y, pdfHelvetica, 9, fmt.Sprintf("Not counted in the total: %d without a value", report.Unvalued))
+	}
+	y -= 28
+
+	for _, location := range report.Locations {
+		need(3*rowHeight + photoSize)
+		page.text(margin, y, pdfHelveticaBold, 14, location.Name)
+		page.textRight(amountEdge, y, 11, formatCents(location.Total))
+		y -= 6
+		page.line(margin, y, amountEdge, y)
+		y -= 16
+		for _, box := range location.Boxes {
+			need(2*rowHeight + photoSize)
+			page.text(margin, y, pdfHelveticaBold, 11, box.Name)
+			page.textRight(amountEdge, y, 10, formatCents(box.Total))
+			y -= rowHeight + 2
+			for _, item := range box.Items {
+				height := 2 * rowHeight
+				photo, photoWidth, photoHeight := -1, 0.0, 0.0
+				if data, ok := photos[item.ID]; ok {
+					// Photos are fitted into a square next to the item
+					if index, w, h, err := doc.addJPEG(data); err == nil && w > 0 && h > 0 {
+						scale := photoSize / float64(max(w, h))
+						photo, photoWidth, photoHeight = index, float64(w)*scale, float64(h)*scale
+						height = photoSize
+					}
+				}
+				need(height + 4)
+				x := margin + 10
+				if photo >= 0 {
+					page.image(photo, x, y+10-photoHeight, photoWidth, photoHeight)
+					x += photoSize + 8
+				}
+				page.text(x, y, pdfHelvetica, 10, fmt.Sprintf("%d x %s", item.Quantity, item.Name))
+				if item.Total.Valid {
+					page.textRight(amountEdge, y, 10, formatCents(item.Total.Int64))
+				} else {
+					page.textRight(amountEdge, y, 10, "-")
+				}
+				details := ""
+				if item.SerialNumber.Valid {
+					details += "S/N " + item.SerialNumber.String + "   "
+				}
+				if item.PurchasePrice.Valid {
+					details += "Bought for " + formatCents(item.PurchasePrice.Int64)
+					if item.PurchasedAt.Valid {
+						details += " on " + item.PurchasedAt.Time.Format(time.DateOnly)
+					}
+					details += "   "
+				} else if item.PurchasedAt.Valid {
+					details += "Bought on " + item.PurchasedAt.Time.Format(time.DateOnly) + "   "
+				}
+				if item.CurrentValue.Valid {
+					details += "Now worth " + formatCents(item.CurrentValue.Int64)
+				}
+				if details != "" {
+					page.text(x, y-rowHeight+2, pdfHelvetica, 8, details)
+				}
+				y -= height + 4
+			}
+			y -= 6
+		}
+		y -= 10
+	}
+
+	need(rowHeight + 8)
+	page.line(margin, y+rowHeight-2, amountEdge, y+rowHeight-2)
+	page.text(margin, y, pdfHelveticaBold, 12, "Total ("+report.Currency+")")
+	page.textRight(amountEdge, y, 12, formatCents(report.Total))
+	return doc.bytes()
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCSVText(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"", ""},
+		{"Laptop", "Laptop"},
+		{"=HYPERLINK(\"https://example.com\")", "'=HYPERLINK(\"https://example.com\")"},
+		{"+49 30 1234", "'+49 30 1234"},
+		{"-5", "'-5"},
+		{"@SUM(A1:A9)", "'@SUM(A1:A9)"},
+		{"\t=1+1", "'\t=1+1"},
+		{"\r=1+1", "'\r=1+1"},
+		{"A=B", "A=B"},
+		{"Serial 12-34", "Serial 12-34"},
+	}
+	for _, test := range tests {
+		if got := csvText(test.text); got != test.want {
+			t.Errorf("csvText(%q) = %q, want %q", test.text, got, test.want)
+		}
+	}
+}
+
+func TestWriteInsuranceCSV(t *testing.T) {
+	item := InsuranceItem{Item: Item{ID: 7, Name: "=cmd|' /C calc'!A0", Quantity: 2, HasPhoto: true}}
+	item.SerialNumber.String, item.SerialNumber.Valid = "-SN-42", true
+	item.PurchasePrice.Int64, item.PurchasePrice.Valid = 129900, true
+	item.PurchasedAt.Time, item.PurchasedAt.Valid = time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), true
+	item.Total.Int64, item.Total.Valid = 259800, true
+	unvalued := InsuranceItem{Item: Item{ID: 8, Name: "Drill", Quantity: 1}}
+	report := InsuranceReport{
+		Currency: "EUR",
+		Locations: []InsuranceLocation{{
+			Name:  "@Garage",
+			Boxes: []InsuranceBox{{ID: 3, Name: "+Tools", Items: []InsuranceItem{item, unvalued}, Total: 259800}},
+			Total: 259800,
+		}},
+		Items: 2,
+		Total: 259800,
+	}
+	var buf bytes.Buffer
+	err := writeInsuranceCSV(&buf, report, func(id int) string { return fmt.Sprintf("https://witb.example.com/item/%d/photo", id) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"Location", "Box", "Item", "Quantity", "Serial number", "Purchased", "Purchase price (EUR)", "Current value (EUR)", "Total (EUR)", "Photo"},
+		{"'@Garage", "'+Tools", "'=cmd|' /C calc'!A0", "2", "'-SN-42", "2024-03-01", "1299.00", "", "2598.00", "https://witb.example.com/item/7/photo"},
+		{"'@Garage", "'+Tools", "Drill", "1", "", "", "", "", "", ""},
+		{"Total", "", "", "", "", "", "", "", "2598.00", ""},
+	}
+	if fmt.Sprintf("%q", records) != fmt.Sprintf("%q", want) {
+		t.Errorf("wrote\n%q\nwant\n%q", records, want)
+	}
+}
@@ -0,0 +1,518 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Name of the cookie holding the inventory selected in the browser
+const inventoryCookie = "witb_inventory"
+
+// Returned when deleting an inventory that still has boxes
+var ErrInventoryNotEmpty = errors.New("inventory still has boxes")
+
+// Returned for inventories that don't exist
+var ErrUnknownInventory = errors.New("unknown inventory")
+
+// Returned when the user or token may not use an inventory
+var ErrInventoryForbidden = errors.New("no access to this inventory")
+
+// A separate set of boxes, such as a household or a storage unit
+type Inventory struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	BoxCount  int       `json:"box_count"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Returns the path that selects the inventory in the browser
+func (i Inventory) Path() string {
+	return "/i/" + strconv.Itoa(i.ID)
+}
+
+// Reports whether a list of granted inventories allows the given one. No grants allow every inventory.
+func inventoryGranted(grants []int, id int) bool {
+	if len(grants) == 0 {
+		return true
+	}
+	for _, grant := range grants {
+		if grant == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Parses a comma separated list of inventory ids such as "1,3". An empty list grants every inventory.
+func parseInventoryIDs(value string) ([]int, error) {
+	ids := make([]int, 0)
+	for _, part := range strings.Split(value, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		id, err := strconv.Atoi(part)
+		if err != nil || id < 1 {
+			return nil, errors.New("inventories must be a comma separated list of inventory ids")
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// Parses the ids of granted inventories from a GROUP_CONCAT column
+func scanInventoryGrants(value sql.NullString) []int {
+	ids, _ := parseInventoryIDs(value.String)
+	return ids
+}
+
+// Creates an inventory
+func (d *Database) CreateInventory(db *sql.DB, name string) (Inventory, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return Inventory{}, errors.New("name is required")
+	}
+	result, err := db.Exec(`INSERT INTO inventories (name) VALUES (?)`, name)
+	if err != nil {
+		return Inventory{}, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return Inventory{}, err
+	}
+	return d.GetInventory(db, int(id))
+}
+
+// Get all inventories with the number of boxes in them, ordered by name
+func (d *Database) GetInventories(db *sql.DB) ([]Inventory, error) {
+	return d.queryInventories(db, `ORDER BY inventories.name`)
+}
+
+// Get a single inventory
+func (d *Database) GetInventory(db *sql.DB, id int) (Inventory, error) {
+	inventories, err := d.queryInventories(db, `WHERE inventories.id = ?`, id)
+	if err != nil {
+		return Inventory{}, err
+	}
+	if len(inventories) == 0 {
+		return Inventory{}, sql.ErrNoRows
+	}
+	return inventories[0], nil
+}
+
+// Queries inventories with the number of boxes in them
+func (d *Database) queryInventories(db *sql.DB, where string, args ...any) ([]Inventory, error) {
+	query := `
+	SELECT inventories.id, inventories.name, inventories.created_at,
+		(SELECT COUNT(*) FROM boxes WHERE boxes.inventory_id = inventories.id)
+	FROM inventories ` + where
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	inventories := make([]Inventory, 0)
+	for rows.Next() {
+		var inventory Inventory
+		if err := rows.Scan(&inventory.ID, &inventory.Name, &inventory.CreatedAt, &inventory.BoxCount); err != nil {
+			return nil, err
+		}
+		inventories = append(inventories, inventory)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return inventories, nil
+}
+
+// Renames an inventory
+func (d *Database) RenameInventory(db *sql.DB, id int, name string) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return errors.New("name is required")
+	}
+	result, err := db.Exec(`UPDATE inventories SET name = ? WHERE id = ?`, name, id)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// Deletes an inventory without boxes together with the grants for it
+func (d *Database) DeleteInventory(db *sql.DB, id int) error {
+	// Start a transaction
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	// Defer a rollback in case something fails.
+	defer tx.Rollback()
+
+	var boxes int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM boxes WHERE inventory_id = ?`, id).Scan(&boxes); err != nil {
+		return err
+	}
+	if boxes > 0 {
+		return ErrInventoryNotEmpty
+	}
+	for _, query := range []string{
+		`DELETE FROM user_inventories WHERE inventory_id = ?`,
+		`DELETE FROM api_token_inventories WHERE inventory_id = ?`,
+		`DELETE FROM inventories WHERE id = ?`,
+	} {
+		if _, err := tx.Exec(query, id); err != nil {
+			return err
+		}
+	}
+	// Commit the transaction
+	return tx.Commit()
+}
+
+// Moves a box with everything in it to another inventory.
+// Items keep their home box only if it is in the same inventory as the box they are in afterwards.
+func (d *Database) MoveBoxToInventory(db *sql.DB, boxID int, inventoryID int) error {
+	if _, err := d.GetInventory(db, inventoryID); err != nil {
+		return err
+	}
+	// Start a transaction
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	// Defer a rollback in case something fails.
+	defer tx.Rollback()
+
+	query := `UPDATE boxes SET inventory_id = ?, version = version + 1, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND inventory_id != ?`
+	result, err := tx.Exec(query, inventoryID, boxID, inventoryID)
+	if err != nil {
+		return err
+	}
+	moved, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if moved == 0 {
+		if _, err := d.GetBox(db, boxID); err != nil {
+			return err
+		}
+		return nil
+	}
+	// Items in the box whose home stayed behind and items left behind whose home is the box
+	// make the box they are in their new home
+	query = `
+	UPDATE contents SET home_box_id = box_id
+	WHERE home_box_id IS NOT NULL AND (box_id = ? OR home_box_id = ?)
+	AND (SELECT inventory_id FROM boxes WHERE id = contents.box_id) != (SELECT inventory_id FROM boxes WHERE id = contents.home_box_id)`
+	if _, err := tx.Exec(query, boxID, boxID); err != nil {
+		return err
+	}
+	event, err := d.recordEvent(tx, Event{Type: EventBoxUpdated, BoxID: boxID})
+	if err != nil {
+		return err
+	}
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	d.publish(event)
+	return nil
+}
+
+// Replaces the inventories granted to a user. No inventories grant all of them.
+func (d *Database) SetUserInventories(db *sql.DB, userID int, inventoryIDs []int) error {
+	// Start a transaction
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	// Defer a rollback in case something fails.
+	defer tx.Rollback()
+
+	if err := setGrants(tx, `user_inventories`, `user_id`, userID, inventoryIDs); err != nil {
+		return err
+	}
+	// Commit the transaction
+	return tx.Commit()
+}
+
+// Replaces the rows of a grant table for a user or token.
+// Returns ErrUnknownInventory if one of the inventories does not exist.
+func setGrants(tx *sql.Tx, table string, column string, id int, inventoryIDs []int) error {
+	if _, err := tx.Exec(`DELETE FROM `+table+` WHERE `+column+` = ?`, id); err != nil {
+		return err
+	}
+	for _, inventoryID := range inventoryIDs {
+		query := `INSERT OR IGNORE INTO ` + table + ` (` + column + `, inventory_id) SELECT ?, id FROM inventories WHERE id = ?`
+		result, err := tx.Exec(query, id, inventoryID)
+		if err != nil {
+			return err
+		}
+		added, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if added == 0 {
+			var exists bool
+			if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM inventories WHERE id = ?)`, inventoryID).Scan(&exists); err != nil {
+				return err
+			}
+			if !exists {
+				return ErrUnknownInventory
+			}
+		}
+	}
+	return nil
+}
+
+// Returns the inventories the requester may use, nil for all of them.
+// API tokens limited to inventories win over the signed in user.
+func inventoryGrants(c *gin.Context) []int {
+	if value, ok := c.Get(apiTokenKey); ok && len(value.(APIToken).Inventories) > 0 {
+		return value.(APIToken).Inventories
+	}
+	if user, ok := currentUser(c); ok {
+		return user.Inventories
+	}
+	return nil
+}
+
+// Reports whether the requester may use the inventory
+func canUseInventory(c *gin.Context, inventoryID int) bool {
+	return inventoryGranted(inventoryGrants(c), inventoryID)
+}
+
+// Returns the inventories the requester may use
+func accessibleInventories(c *gin.Context) ([]Inventory, error) {
+	inventories, err := database.GetInventories(client)
+	if err != nil {
+		return nil, err
+	}
+	accessible := make([]Inventory, 0, len(inventories))
+	for _, inventory := range inventories {
+		if canUseInventory(c, inventory.ID) {
+			accessible = append(accessible, inventory)
+		}
+	}
+	return accessible, nil
+}
+
+// Returns the inventory the request works in: the one given in the inventory query parameter,
+// else the one selected in the browser, else the first one the requester may use
+func currentInventory(c *gin.Context) (Inventory, error) {
+	inventories, err := accessibleInventories(c)
+	if err != nil {
+		return Inventory{}, err
+	}
+	if len(inventories) == 0 {
+		return Inventory{}, ErrInventoryForbidden
+	}
+	// An inventory asked for explicitly must be usable, a remembered one may have been taken away since
+	asked := c.Query("inventory")
+	selected := asked
+	if selected == "" {
+		selected, _ = c.Cookie(inventoryCookie)
+	}
+	for _, inventory := range inventories {
+		if strconv.Itoa(inventory.ID) == selected {
+			return inventory, nil
+		}
+	}
+	if asked != "" {
+		return Inventory{}, ErrInventoryForbidden
+	}
+	return inventories[0], nil
+}
+
+// Remembers the selected inventory in the browser
+func selectInventory(c *gin.Context, inventoryID int) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(inventoryCookie, strconv.Itoa(inventoryID), 365*24*3600, "/", "", secureRequest(c), true)
+}
+
+// Returns the inventory of a box, sql.ErrNoRows if the box does not exist
+func (d *Database) boxInventory(db *sql.DB, boxID int) (int, error) {
+	var inventoryID int
+	err := db.QueryRow(`SELECT inventory_id FROM boxes WHERE id = ?`, boxID).Scan(&inventoryID)
+	return inventoryID, err
+}
+
+// Returns the inventory of the box an item is in, sql.ErrNoRows if the item does not exist
+func (d *Database) itemInventory(db *sql.DB, itemID int) (int, error) {
+	var inventoryID int
+	err := db.QueryRow(`SELECT boxes.inventory_id FROM contents JOIN boxes ON boxes.id = contents.box_id WHERE contents.id = ?`, itemID).Scan(&inventoryID)
+	return inventoryID, err
+}
+
+// Rejects requests for boxes and items in inventories the requester may not use.
+// The box or item is taken from the route, so routes passing it in the body check it themselves with canUseBox.
+func inventoryAccess() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if len(inventoryGrants(c)) == 0 {
+			c.Next()
+			return
+		}
+		route := c.FullPath()
+		var inventoryID int
+		var err error
+		switch {
+		case strings.HasPrefix(route, "/box/:boxid"):
+			inventoryID, err = routeInventory(c, "boxid", database.boxInventory)
+		case strings.HasPrefix(route, "/box/:id"), strings.HasPrefix(route, "/api/v1/boxes/:id"):
+			inventoryID, err = routeInventory(c, "id", database.boxInventory)
+		case strings.HasPrefix(route, "/item/:id"), strings.HasPrefix(route, "/api/v1/items/:id"):
+			inventoryID, err = routeInventory(c, "id", database.itemInventory)
+		case strings.HasPrefix(route, "/loans/:id"), strings.HasPrefix(route, "/api/v1/loans/:id"):
+			inventoryID, err = routeInventory(c, "id", database.loanInventory)
+		case strings.HasPrefix(route, "/audits/:id"), strings.HasPrefix(route, "/api/v1/audits/:id"):
+			inventoryID, err = routeInventory(c, "id", database.auditInventory)
+		case strings.HasPrefix(route, "/shares/:id"), strings.HasPrefix(route, "/api/v1/shares/:id"):
+			inventoryID, err = routeInventory(c, "id", database.shareInventory)
+		default:
+			c.Next()
+			return
+		}
+		// Unknown boxes and items are left to the handlers
+		if errors.Is(err, sql.ErrNoRows) {
+			c.Next()
+			return
+		}
+		if err != nil {
+			log.Println(err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"fail": "could not check inventory"})
+			return
+		}
+		if !canUseInventory(c, inventoryID) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"fail": ErrInventoryForbidden.Error()})
+			return
+		}
+		c.Next()
+	}
+}
+
+// Looks up the inventory of the box or item named by a route parameter
+func routeInventory(c *gin.Context, param string, lookup func(*sql.DB, int) (int, error)) (int, error) {
+	id, err := strconv.Atoi(c.Param(param))
+	if err != nil {
+		// Invalid ids are left to the handlers like unknown ones
+		return 0, sql.ErrNoRows
+	}
+	return lookup(client, id)
+}
+
+// Reports whether the requester may use the box. Boxes that don't exist are left to the caller.
+func canUseBox(c *gin.Context, boxID int) bool {
+	inventoryID, err := database.boxInventory(client, boxID)
+	return usableInventory(c, inventoryID, err)
+}
+
+// Reports whether the requester may use the box an item is in. Items that don't exist are left to the caller.
+func canUseItem(c *gin.Context, itemID int) bool {
+	inventoryID, err := database.itemInventory(client, itemID)
+	return usableInventory(c, inventoryID, err)
+}
+
+// Reports whether the requester may use the inventory a lookup returned.
+// Nothing found is allowed for the caller to handle, failed lookups are denied.
+func usableInventory(c *gin.Context, inventoryID int, err error) bool {
+	if errors.Is(err, sql.ErrNoRows) {
+		return true
+	}
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	return canUseInventory(c, inventoryID)
+}
+
+// Leaves out the share links of boxes in inventories the requester may not use
+func usableShares(c *gin.Context, shares []Share) []Share {
+	if len(inventoryGrants(c)) == 0 {
+		return shares
+	}
+	usable := make([]Share, 0, len(shares))
+	for _, share := range shares {
+		if canUseBox(c, share.BoxID) {
+			usable = append(usable, share)
+		}
+	}
+	return usable
+}
+
+// Leaves out the boxes and items of a resolved code that are in inventories the requester may not use,
+// as if the code pointed to nothing there. A barcode only carried by such items resolves to its catalog product.
+func usableResolution(c *gin.Context, resolution CodeResolution) (CodeResolution, error) {
+	if len(inventoryGrants(c)) == 0 {
+		return resolution, nil
+	}
+	switch resolution.Kind {
+	case ResolvedBox, ResolvedItem:
+		if !canUseBox(c, resolution.BoxID) {
+			return CodeResolution{Code: resolution.Code, Kind: ResolvedNone}, nil
+		}
+	case ResolvedItems:
+		items := make([]LocatedItem, 0, len(resolution.Items))
+		for _, item := range resolution.Items {
+			if canUseBox(c, item.BoxID) {
+				items = append(items, item)
+			}
+		}
+		if len(items) > 0 {
+			resolution.Items = items
+			return resolution, nil
+		}
+		resolution.Kind = ResolvedNone
+		resolution.Items = nil
+		product, err := database.GetProduct(client, resolution.Barcode)
+		if errors.Is(err, sql.ErrNoRows) {
+			return resolution, nil
+		}
+		if err != nil {
+			return resolution, err
+		}
+		resolution.Kind = ResolvedProduct
+		resolution.Product = &product
+	}
+	return resolution, nil
+}
+
+// Reports whether the requester may see an event, which must touch a box in an inventory they may use.
+// Unlike canUseBox boxes that are gone don't count, so deleted boxes of other inventories stay hidden.
+func canSeeEvent(c *gin.Context, event Event) bool {
+	for _, boxID := range []int{event.BoxID, event.FromBoxID} {
+		if boxID == 0 {
+			continue
+		}
+		if inventoryID, err := database.boxInventory(client, boxID); err == nil && canUseInventory(c, inventoryID) {
+			return true
+		}
+	}
+	return false
+}
+
+// Leaves out the changes to boxes in inventories the requester may not use, like canSeeEvent does for live updates
+func usableChanges(c *gin.Context, changes []Change) []Change {
+	if len(inventoryGrants(c)) == 0 {
+		return changes
+	}
+	usable := make([]Change, 0, len(changes))
+	for _, change := range changes {
+		if canSeeEvent(c, Event{BoxID: change.BoxID, FromBoxID: int(change.FromBoxID.Int64)}) {
+			usable = append(usable, change)
+		}
+	}
+	return usable
+}
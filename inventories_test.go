@@ -0,0 +1,175 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Replaces the database the handlers use with a test database
+func useTestDatabase(t *testing.T) (*Database, *sql.DB) {
+	t.Helper()
+	d, db := testDatabase(t)
+	previous, previousClient := database, client
+	database, client = *d, db
+	t.Cleanup(func() { database, client = previous, previousClient })
+	return &database, db
+}
+
+// Creates a box with an item in an inventory and returns their ids
+func testBoxWithItem(t *testing.T, d *Database, db *sql.DB, inventoryID int, name string) (int, int) {
+	t.Helper()
+	if err := d.CreateBox(db, inventoryID, name, ""); err != nil {
+		t.Fatal(err)
+	}
+	boxes, err := d.GetBoxes(db, inventoryID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	boxID := boxes[len(boxes)-1].ID
+	itemID, err := d.CreateItem(db, boxID, name+" item", 1, ItemAttributes{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return boxID, itemID
+}
+
+// Router with the inventory checks for requests made with a token limited to the given inventories
+func testInventoryRouter(inventories []int) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set(apiTokenKey, APIToken{Name: "test", Inventories: inventories})
+	}, inventoryAccess())
+	router.POST("/box/:boxid/edit/:id", updateBoxContent)
+	router.POST("/shares/:id/revoke", revokeShare)
+	router.DELETE("/api/v1/shares/:id", apiRevokeShare)
+	return router
+}
+
+// Sends a request to the router and returns the status code
+func serve(router *gin.Engine, method, target string, form url.Values) int {
+	req := httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestUpdateItemOfOtherBox(t *testing.T) {
+	d, db := useTestDatabase(t)
+	garage, err := d.CreateInventory(db, "Garage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	homeBox, homeItem := testBoxWithItem(t, d, db, 1, "Kitchen")
+	garageBox, garageItem := testBoxWithItem(t, d, db, garage.ID, "Tools")
+	router := testInventoryRouter([]int{garage.ID})
+	form := url.Values{"item_name": {"Renamed"}, "item_amount": {"5"}}
+
+	for _, test := range []struct {
+		name   string
+		box    int
+		item   int
+		status int
+	}{
+		{"item of a box of another inventory through a granted box", garageBox, homeItem, http.StatusNotFound},
+		{"item of a box of another inventory", homeBox, homeItem, http.StatusForbidden},
+		{"item of the box", garageBox, garageItem, http.StatusFound},
+	} {
+		if status := serve(router, http.MethodPost, fmt.Sprintf("/box/%d/edit/%d", test.box, test.item), form); status != test.status {
+			t.Errorf("%s: status %d, want %d", test.name, status, test.status)
+		}
+	}
+	item, err := d.GetItem(db, homeItem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Name != "Kitchen item" || item.Quantity != 1 || item.BoxID != homeBox {
+		t.Errorf("item of the other inventory was changed to %+v", item)
+	}
+	if item, _ := d.GetItem(db, garageItem); item.Name != "Renamed" || item.Quantity != 5 {
+		t.Errorf("item of the granted box is %+v, want it renamed", item)
+	}
+}
+
+func TestRevokeShareOfOtherInventory(t *testing.T) {
+	d, db := useTestDatabase(t)
+	garage, err := d.CreateInventory(db, "Garage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	homeBox, _ := testBoxWithItem(t, d, db, 1, "Kitchen")
+	garageBox, _ := testBoxWithItem(t, d, db, garage.ID, "Tools")
+	homeShare, err := d.CreateShare(db, homeBox, time.Now().Add(time.Hour), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	garageShare, err := d.CreateShare(db, garageBox, time.Now().Add(time.Hour), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := testInventoryRouter([]int{garage.ID})
+
+	if status := serve(router, http.MethodPost, fmt.Sprintf("/shares/%d/revoke", homeShare.ID), nil); status != http.StatusForbidden {
+		t.Errorf("revoking a share of another inventory: status %d, want %d", status, http.StatusForbidden)
+	}
+	if status := serve(router, http.MethodDelete, fmt.Sprintf("/api/v1/shares/%d", homeShare.ID), nil); status != http.StatusForbidden {
+		t.Errorf("revoking a share of another inventory through the API: status %d, want %d", status, http.StatusForbidden)
+	}
+	if _, err := d.getShare(db, homeShare.ID); err != nil {
+		t.Errorf("share of the other inventory was revoked: %v", err)
+	}
+
+	if status := serve(router, http.MethodDelete, fmt.Sprintf("/api/v1/shares/%d", garageShare.ID), nil); status != http.StatusOK {
+		t.Errorf("revoking a share of a granted inventory: status %d, want %d", status, http.StatusOK)
+	}
+	if status := serve(router, http.MethodDelete, fmt.Sprintf("/api/v1/shares/%d", garageShare.ID+1), nil); status != http.StatusNotFound {
+		t.Errorf("revoking a share that does not exist: status %d, want %d", status, http.StatusNotFound)
+	}
+}
+
+func TestUsableChanges(t *testing.T) {
+	d, db := useTestDatabase(t)
+	garage, err := d.CreateInventory(db, "Garage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	homeBox, _ := testBoxWithItem(t, d, db, 1, "Kitchen")
+	gone, _ := testBoxWithItem(t, d, db, 1, "Attic")
+	garageBox, _ := testBoxWithItem(t, d, db, garage.ID, "Tools")
+	if err := d.DeleteBox(db, gone); err != nil {
+		t.Fatal(err)
+	}
+	changes, err := d.GetChanges(db, 0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Set(apiTokenKey, APIToken{Name: "test", Inventories: []int{garage.ID}})
+	usable := usableChanges(c, changes)
+	// The box and item created in the garage, nothing of the deleted box of the other inventory
+	if len(usable) != 2 {
+		t.Fatalf("got %d changes, want 2: %+v", len(usable), usable)
+	}
+	for _, change := range usable {
+		if change.BoxID != garageBox {
+			t.Errorf("change %+v of box %d is visible", change, change.BoxID)
+		}
+	}
+
+	c.Set(apiTokenKey, APIToken{Name: "test", Inventories: []int{1}})
+	for _, change := range usableChanges(c, changes) {
+		if change.BoxID != homeBox {
+			t.Errorf("change %+v of box %d is visible", change, change.BoxID)
+		}
+	}
+}
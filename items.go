@@ -0,0 +1,143 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Returned when an item without a QR label is returned to its home box
+var ErrNoHomeBox = errors.New("item has no home box")
+
+// Define item move struct with json marshalling config
+// The box names are null if the box was deleted since.
+type ItemMove struct {
+	ID          int            `json:"id"`
+	ItemID      int            `json:"item_id"`
+	FromBoxID   int            `json:"from_box_id"`
+	FromBoxName JSONNullString `json:"from_box_name"`
+	ToBoxID     int            `json:"to_box_id"`
+	ToBoxName   JSONNullString `json:"to_box_name"`
+	MovedAt     time.Time      `json:"moved_at"`
+}
+
+// Get the latest moves of an item, newest first
+func (d *Database) GetItemMoves(db *sql.DB, itemID int, limit int) ([]ItemMove, error) {
+	query := `
+	SELECT item_moves.id, item_moves.content_id, item_moves.from_box_id, from_box.name, item_moves.to_box_id, to_box.name, item_moves.moved_at
+	FROM item_moves
+	LEFT JOIN boxes AS from_box ON from_box.id = item_moves.from_box_id
+	LEFT JOIN boxes AS to_box ON to_box.id = item_moves.to_box_id
+	WHERE item_moves.content_id = ?
+	ORDER BY item_moves.moved_at DESC, item_moves.id DESC
+	LIMIT ?`
+	rows, err := db.Query(query, itemID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	moves := make([]ItemMove, 0)
+	for rows.Next() {
+		var move ItemMove
+		if err := rows.Scan(&move.ID, &move.ItemID, &move.FromBoxID, &move.FromBoxName.NullString, &move.ToBoxID, &move.ToBoxName.NullString, &move.MovedAt); err != nil {
+			return nil, err
+		}
+		moves = append(moves, move)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return moves, nil
+}
+
+// Gives an item its own QR label with the box it is in as its home box, or removes the label
+func (d *Database) SetItemLabeled(db *sql.DB, itemID int, labeled bool) error {
+	query := `
+	UPDATE contents
+	SET home_box_id = CASE WHEN ? THEN COALESCE(home_box_id, box_id) END, version = version + 1, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	RETURNING box_id`
+
+	// Start a transaction
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	// Defer a rollback in case something fails.
+	defer tx.Rollback()
+
+	var boxID int
+	if err := tx.QueryRow(query, labeled, itemID).Scan(&boxID); err != nil {
+		return err
+	}
+	event, err := d.recordEvent(tx, Event{Type: EventItemUpdated, BoxID: boxID, ItemID: itemID})
+	if err != nil {
+		return err
+	}
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	d.publish(event)
+	return nil
+}
+
+// Makes the box an item is in its home box
+func (d *Database) SetItemHome(db *sql.DB, itemID int) error {
+	query := `
+	UPDATE contents
+	SET home_box_id = box_id, version = version + 1, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND home_box_id IS NOT NULL
+	RETURNING box_id`
+
+	// Start a transaction
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	// Defer a rollback in case something fails.
+	defer tx.Rollback()
+
+	var boxID int
+	err = tx.QueryRow(query, itemID).Scan(&boxID)
+	if errors.Is(err, sql.ErrNoRows) {
+		if _, err := d.GetItem(tx, itemID); err != nil {
+			return err
+		}
+		return ErrNoHomeBox
+	}
+	if err != nil {
+		return err
+	}
+	event, err := d.recordEvent(tx, Event{Type: EventItemUpdated, BoxID: boxID, ItemID: itemID})
+	if err != nil {
+		return err
+	}
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	d.publish(event)
+	return nil
+}
+
+// Moves an item back into its home box
+func (d *Database) ReturnItem(db *sql.DB, itemID int) (Item, error) {
+	item, err := d.GetItem(db, itemID)
+	if err != nil {
+		return item, err
+	}
+	if !item.HomeBoxID.Valid {
+		return item, ErrNoHomeBox
+	}
+	home := int(item.HomeBoxID.Int64)
+	if item.BoxID == home {
+		return item, nil
+	}
+	if err := d.MoveItem(db, item.BoxID, home, itemID); err != nil {
+		return item, err
+	}
+	return d.GetItem(db, itemID)
+}
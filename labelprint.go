@@ -0,0 +1,383 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"log"
+	"math"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/skip2/go-qrcode"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// Fields a label template can show
+const (
+	labelFieldQR    = "qr"
+	labelFieldName  = "name"
+	labelFieldLabel = "label"
+	labelFieldCode  = "code"
+)
+
+// Template used if LABEL_TEMPLATE is not set
+const defaultLabelTemplate = "qr,name,label,code"
+
+// Formats labels can be rendered in
+const (
+	labelFormatZPL = "zpl"
+	labelFormatQL  = "ql"
+)
+
+// Port of raw TCP printing (also called JetDirect or AppSocket) used if a printer address has none
+const rawPrinterPort = "9100"
+
+// Time allowed for connecting to a printer and for sending a print job
+var (
+	printerDialTimeout = 10 * time.Second
+	printerSendTimeout = 30 * time.Second
+)
+
+// Returned for unknown label formats
+var ErrUnknownLabelFormat = errors.New("unknown label format, use zpl or ql")
+
+// Returned when printing in a format without a configured printer
+var ErrNoPrinter = errors.New("no printer configured for this label format")
+
+// Layout of printed labels: the fields shown, in order, and the size of a label
+type LabelTemplate struct {
+	Fields   []string
+	WidthMM  float64
+	HeightMM float64
+}
+
+// Parses a comma separated list of label fields such as "qr,name,code"
+func parseLabelFields(value string) ([]string, error) {
+	var fields []string
+	for _, field := range strings.Split(value, ",") {
+		field = strings.ToLower(strings.TrimSpace(field))
+		switch field {
+		case labelFieldQR, labelFieldName, labelFieldLabel, labelFieldCode:
+			fields = append(fields, field)
+		case "":
+		default:
+			return nil, fmt.Errorf("unknown label field %q, use qr, name, label or code", field)
+		}
+	}
+	if len(fields) == 0 {
+		return nil, errors.New("label template has no fields")
+	}
+	return fields, nil
+}
+
+// Returns whether the template shows the field
+func (t LabelTemplate) has(field string) bool {
+	for _, f := range t.Fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// Printers for printed labels and the template of the labels
+type LabelPrintConfig struct {
+	Template LabelTemplate
+	// Resolution of the Zebra printer in dots per inch
+	ZPLDPI     int
+	ZPLPrinter string
+	// Name of the tape in the Brother QL printer such as "62" or "62x29"
+	QLTape    string
+	QLPrinter string
+}
+
+// Reads the label template and the printers from the environment
+func labelPrintConfigFromEnv() LabelPrintConfig {
+	fields, err := parseLabelFields(getEnv("LABEL_TEMPLATE", defaultLabelTemplate))
+	if err != nil {
+		log.Printf("invalid LABEL_TEMPLATE: %v", err)
+		fields, _ = parseLabelFields(defaultLabelTemplate)
+	}
+	width, err := parseIntEnv("LABEL_WIDTH_MM", 62)
+	if err != nil || width < 10 {
+		log.Printf("Ignoring LABEL_WIDTH_MM %q", getEnv("LABEL_WIDTH_MM", ""))
+		width = 62
+	}
+	height, err := parseIntEnv("LABEL_HEIGHT_MM", 29)
+	if err != nil || height < 10 {
+		log.Printf("Ignoring LABEL_HEIGHT_MM %q", getEnv("LABEL_HEIGHT_MM", ""))
+		height = 29
+	}
+	dpi, err := parseIntEnv("LABEL_ZPL_DPI", 203)
+	if err != nil || dpi < 100 {
+		log.Printf("Ignoring LABEL_ZPL_DPI %q", getEnv("LABEL_ZPL_DPI", ""))
+		dpi = 203
+	}
+	tape := getEnv("LABEL_QL_TAPE", "62")
+	if _, err := brotherQLTapeByName(tape); err != nil {
+		log.Printf("invalid LABEL_QL_TAPE: %v", err)
+		tape = "62"
+	}
+	return LabelPrintConfig{
+		Template:   LabelTemplate{Fields: fields, WidthMM: float64(width), HeightMM: float64(height)},
+		ZPLDPI:     dpi,
+		ZPLPrinter: getEnv("LABEL_ZPL_PRINTER", ""),
+		QLTape:     tape,
+		QLPrinter:  getEnv("LABEL_QL_PRINTER", ""),
+	}
+}
+
+// Renders labels in the given format. QR codes link to baseURL followed by the path of the label.
+// Returns the command stream together with its content type and file extension.
+func (config LabelPrintConfig) Render(format string, labels []Label, baseURL string) ([]byte, string, string, error) {
+	switch format {
+	case labelFormatZPL:
+		data, err := renderZPL(config.Template, config.ZPLDPI, labels, baseURL)
+		return data, "text/plain; charset=utf-8", "zpl", err
+	case labelFormatQL:
+		tape, err := brotherQLTapeByName(config.QLTape)
+		if err != nil {
+			return nil, "", "", err
+		}
+		data, err := renderBrotherQL(config.Template, tape, labels, baseURL)
+		return data, "application/octet-stream", "bin", err
+	}
+	return nil, "", "", ErrUnknownLabelFormat
+}
+
+// Returns the address of the printer for a label format
+func (config LabelPrintConfig) Printer(format string) (string, error) {
+	var addr string
+	switch format {
+	case labelFormatZPL:
+		addr = config.ZPLPrinter
+	case labelFormatQL:
+		addr = config.QLPrinter
+	default:
+		return "", ErrUnknownLabelFormat
+	}
+	if addr == "" {
+		return "", ErrNoPrinter
+	}
+	return addr, nil
+}
+
+// Renders labels and sends them to the printer configured for the format
+func (config LabelPrintConfig) Print(format string, labels []Label, baseURL string) error {
+	addr, err := config.Printer(format)
+	if err != nil {
+		return err
+	}
+	data, _, _, err := config.Render(format, labels, baseURL)
+	if err != nil {
+		return err
+	}
+	return sendToPrinter(addr, data)
+}
+
+// Sends a print job to a network printer over a raw TCP connection.
+// The address is host:port, the port defaults to 9100.
+func sendToPrinter(addr string, data []byte) error {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, rawPrinterPort)
+	}
+	conn, err := net.DialTimeout("tcp", addr, printerDialTimeout)
+	if err != nil {
+		return fmt.Errorf("could not connect to printer %s: %w", addr, err)
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(printerSendTimeout)); err != nil {
+		return err
+	}
+	if _, err := conn.Write(data); err != nil {
+		return fmt.Errorf("could not send to printer %s: %w", addr, err)
+	}
+	return nil
+}
+
+// Converts millimeters to printer dots
+func mmToDots(mm float64, dpi int) int {
+	return int(math.Round(mm * float64(dpi) / 25.4))
+}
+
+// A field of a label placed on it, in dots
+type labelBlock struct {
+	Field string
+	Text  string
+	Rect  image.Rectangle
+}
+
+// Share of the text area each text field gets
+var labelFieldWeights = map[string]int{
+	labelFieldName:  3,
+	labelFieldLabel: 2,
+	labelFieldCode:  3,
+}
+
+// Places the fields of the template on a label of the given size in dots.
+// The QR code is a square at the left with the text fields next to it, one per line.
+// Labels too narrow for that get the QR code above the text. Empty fields are left out.
+func (t LabelTemplate) layout(label Label, baseURL string, width, height int) []labelBlock {
+	margin := max(4, min(width, height)/16)
+	text := image.Rect(margin, margin, width-margin, height-margin)
+
+	var blocks []labelBlock
+	if t.has(labelFieldQR) {
+		side := min(text.Dx(), text.Dy())
+		qr := image.Rect(margin, margin, margin+side, margin+side)
+		if text.Dx()-side-margin >= text.Dx()/3 {
+			text.Min.X = qr.Max.X + margin
+			// Center the QR code vertically
+			qr = qr.Add(image.Pt(0, (text.Dy()-side)/2))
+		} else {
+			text.Min.Y = qr.Max.Y + margin
+			qr = qr.Add(image.Pt((text.Dx()-side)/2, 0))
+		}
+		blocks = append(blocks, labelBlock{Field: labelFieldQR, Text: baseURL + label.Path(), Rect: qr})
+	}
+
+	var lines []labelBlock
+	total := 0
+	for _, field := range t.Fields {
+		var value string
+		switch field {
+		case labelFieldName:
+			value = label.Name
+		case labelFieldLabel:
+			value = label.Text
+		case labelFieldCode:
+			value = label.Code
+		}
+		if value = strings.TrimSpace(value); value == "" {
+			continue
+		}
+		lines = append(lines, labelBlock{Field: field, Text: value})
+		total += labelFieldWeights[field]
+	}
+	if text.Dx() <= 0 || text.Dy() <= 0 {
+		return blocks
+	}
+	y := text.Min.Y
+	for _, line := range lines {
+		lineHeight := text.Dy() * labelFieldWeights[line.Field] / total
+		line.Rect = image.Rect(text.Min.X, y, text.Max.X, y+lineHeight)
+		blocks = append(blocks, line)
+		y += lineHeight
+	}
+	return blocks
+}
+
+// Returns the modules of the QR code of a link without the quiet zone, true is dark
+func qrModules(link string) ([][]bool, error) {
+	qr, err := qrcode.New(link, qrcode.Medium)
+	if err != nil {
+		return nil, err
+	}
+	qr.DisableBorder = true
+	return qr.Bitmap(), nil
+}
+
+// Size of the glyphs of the bitmap font used for raster labels
+const (
+	rasterGlyphWidth  = 7
+	rasterGlyphHeight = 13
+)
+
+// Draws a label black on white for printers that take raster images
+func (t LabelTemplate) rasterize(label Label, baseURL string, width, height int) (*image.Gray, error) {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+	for _, block := range t.layout(label, baseURL, width, height) {
+		if block.Field == labelFieldQR {
+			modules, err := qrModules(block.Text)
+			if err != nil {
+				return nil, err
+			}
+			drawQR(img, modules, block.Rect)
+			continue
+		}
+		drawText(img, block.Text, block.Rect, false)
+	}
+	return img, nil
+}
+
+// Draws QR modules as large as they fit into a square, centered
+func drawQR(img *image.Gray, modules [][]bool, rect image.Rectangle) {
+	size := len(modules)
+	scale := rect.Dx() / size
+	if scale < 1 {
+		scale = 1
+	}
+	offset := rect.Min.Add(image.Pt((rect.Dx()-size*scale)/2, (rect.Dy()-size*scale)/2))
+	for y, row := range modules {
+		for x, dark := range row {
+			if dark {
+				module := image.Rect(x*scale, y*scale, (x+1)*scale, (y+1)*scale).Add(offset)
+				draw.Draw(img, module, image.Black, image.Point{}, draw.Src)
+			}
+		}
+	}
+}
+
+// Draws a single line of text as large as it fits into a rectangle, vertically centered
+// and either left aligned or centered. Text too long even at the smallest size is cut off.
+func drawText(img *image.Gray, text string, rect image.Rectangle, center bool) {
+	runes := []rune(text)
+	scale := min(rect.Dy()/rasterGlyphHeight, rect.Dx()/(rasterGlyphWidth*len(runes)))
+	if scale < 1 {
+		scale = 1
+		if fit := rect.Dx() / rasterGlyphWidth; len(runes) > fit {
+			runes = runes[:fit]
+		}
+	}
+	if len(runes) == 0 {
+		return
+	}
+
+	// Draw the text in the size of the font and scale it up afterwards
+	glyphs := image.NewGray(image.Rect(0, 0, rasterGlyphWidth*len(runes), rasterGlyphHeight))
+	draw.Draw(glyphs, glyphs.Bounds(), image.White, image.Point{}, draw.Src)
+	drawer := font.Drawer{
+		Dst:  glyphs,
+		Src:  image.Black,
+		Face: basicfont.Face7x13,
+		Dot:  fixed.P(0, basicfont.Face7x13.Ascent),
+	}
+	drawer.DrawString(string(runes))
+
+	offset := image.Pt(rect.Min.X, rect.Min.Y+(rect.Dy()-rasterGlyphHeight*scale)/2)
+	if center {
+		offset.X += (rect.Dx() - glyphs.Bounds().Dx()*scale) / 2
+	}
+	for y := 0; y < rasterGlyphHeight; y++ {
+		for x := 0; x < glyphs.Bounds().Dx(); x++ {
+			if glyphs.GrayAt(x, y).Y < 128 {
+				pixel := image.Rect(x*scale, y*scale, (x+1)*scale, (y+1)*scale).Add(offset)
+				draw.Draw(img, pixel.Intersect(rect), image.Black, image.Point{}, draw.Src)
+			}
+		}
+	}
+}
+
+// Returns whether a pixel of a rasterized label is printed
+func isDark(img *image.Gray, x, y int) bool {
+	return img.GrayAt(x, y).Y < 128
+}
+
+// Rotates an image by 90 degrees clockwise
+func rotateClockwise(img *image.Gray) *image.Gray {
+	bounds := img.Bounds()
+	rotated := image.NewGray(image.Rect(0, 0, bounds.Dy(), bounds.Dx()))
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			rotated.SetGray(bounds.Dy()-1-y, x, color.Gray{Y: img.GrayAt(x, y).Y})
+		}
+	}
+	return rotated
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Printer listening on a free local port which collects everything sent to it
+func testPrinter(t *testing.T) (addr string, received <-chan []byte) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	jobs := make(chan []byte, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		data, _ := io.ReadAll(conn)
+		jobs <- data
+	}()
+	return listener.Addr().String(), jobs
+}
+
+// Waits for the job a test printer received
+func receivedJob(t *testing.T, received <-chan []byte) []byte {
+	t.Helper()
+	select {
+	case data := <-received:
+		return data
+	case <-time.After(5 * time.Second):
+		t.Fatal("printer received nothing")
+		return nil
+	}
+}
+
+func TestSendToPrinter(t *testing.T) {
+	addr, received := testPrinter(t)
+	job := bytes.Repeat([]byte("^XA^FDlabel^FS^XZ\n"), 10000)
+	if err := sendToPrinter(addr, job); err != nil {
+		t.Fatal(err)
+	}
+	if data := receivedJob(t, received); !bytes.Equal(data, job) {
+		t.Errorf("printer received %d bytes, want the %d bytes of the job", len(data), len(job))
+	}
+}
+
+func TestPrintLabels(t *testing.T) {
+	addr, received := testPrinter(t)
+	config := LabelPrintConfig{Template: testLabelTemplate, ZPLDPI: 203, ZPLPrinter: addr}
+	if err := config.Print(labelFormatZPL, testLabels, "https://witb.example"); err != nil {
+		t.Fatal(err)
+	}
+	want, err := renderZPL(testLabelTemplate, 203, testLabels, "https://witb.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data := receivedJob(t, received); !bytes.Equal(data, want) {
+		t.Errorf("printer received\n%s\nwant\n%s", data, want)
+	}
+
+	if err := config.Print(labelFormatQL, testLabels, "https://witb.example"); !errors.Is(err, ErrNoPrinter) {
+		t.Errorf("printing without a printer = %v, want %v", err, ErrNoPrinter)
+	}
+	if err := config.Print("pdf", testLabels, "https://witb.example"); !errors.Is(err, ErrUnknownLabelFormat) {
+		t.Errorf("printing in an unknown format = %v, want %v", err, ErrUnknownLabelFormat)
+	}
+}
+
+func TestSendToPrinterUnreachable(t *testing.T) {
+	// A port nobody listens on any more
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	err = sendToPrinter(addr, []byte("^XA^XZ"))
+	if err == nil || !strings.Contains(err.Error(), "could not connect to printer "+addr) {
+		t.Errorf("sending to a closed port = %v", err)
+	}
+}
+
+func TestSendToPrinterTimeout(t *testing.T) {
+	defer func(timeout time.Duration) { printerSendTimeout = timeout }(printerSendTimeout)
+	printerSendTimeout = 200 * time.Millisecond
+
+	// A printer that accepts the connection but never reads the job
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		<-done
+		conn.Close()
+	}()
+
+	start := time.Now()
+	// Far more than fits into the socket buffers
+	err = sendToPrinter(listener.Addr().String(), make([]byte, 64<<20))
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("sending to a stuck printer = %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("gave up after %v, want about %v", elapsed, printerSendTimeout)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+)
+
+// A printable label with a QR code linking to a box or an item
+type Label struct {
+	// Either "box" or "item"
+	Kind        string `json:"kind"`
+	ID          int    `json:"id"`
+	InventoryID int    `json:"inventory_id"`
+	Name        string `json:"name"`
+	// The label of a box or the name of the home box of an item
+	Text string `json:"text"`
+	Code string `json:"code"`
+}
+
+// Returns the path the QR code of the label links to, which opens the inventory of the box
+func (l Label) Path() string {
+	return fmt.Sprintf("/i/%d/s/%s", l.InventoryID, url.PathEscape(l.Code))
+}
+
+// Returns the path of the QR code image of the label
+func (l Label) QRPath() string {
+	return fmt.Sprintf("/%s/%d/qr", l.Kind, l.ID)
+}
+
+// Returns the label of a box
+func boxLabel(box Box) Label {
+	return Label{Kind: "box", ID: box.ID, InventoryID: box.InventoryID, Name: box.Name, Text: box.Label.String, Code: box.Code}
+}
+
+// Get the labels of the given boxes, each followed by the labels of the labeled items in it,
+// and of the given items. Without any boxes and items the labels of everything in the inventory are returned.
+func (d *Database) GetLabels(db *sql.DB, inventoryID int, boxIDs []int, itemIDs []int) ([]Label, error) {
+	labels := make([]Label, 0)
+	if len(boxIDs) == 0 && len(itemIDs) == 0 {
+		boxes, err := d.GetBoxes(db, inventoryID)
+		if err != nil {
+			return nil, err
+		}
+		for _, box := range boxes {
+			boxIDs = append(boxIDs, box.ID)
+		}
+	}
+	for _, boxID := range boxIDs {
+		box, err := d.GetBox(db, boxID)
+		if err != nil {
+			return nil, err
+		}
+		labels = append(labels, boxLabel(box))
+		items, err := d.GetItemsByBox(db, boxID)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			if item.Labeled {
+				labels = append(labels, d.itemLabel(db, item))
+			}
+		}
+	}
+	for _, itemID := range itemIDs {
+		item, err := d.GetItem(db, itemID)
+		if err != nil {
+			return nil, err
+		}
+		labels = append(labels, d.itemLabel(db, item))
+	}
+	return labels, nil
+}
+
+// Returns the label of an item, naming its home box or else the box it is in
+func (d *Database) itemLabel(db *sql.DB, item Item) Label {
+	label := Label{Kind: "item", ID: item.ID, Name: item.Name, Code: item.Code()}
+	boxID := item.BoxID
+	if item.HomeBoxID.Valid {
+		boxID = int(item.HomeBoxID.Int64)
+	}
+	if box, err := d.GetBox(db, boxID); err == nil {
+		label.Text = box.Name
+		label.InventoryID = box.InventoryID
+	}
+	return label
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Returned when lending more of an item than is left in its box
+var ErrNotAvailable = errors.New("not enough left to lend")
+
+// Returned when checking in a loan that was already returned
+var ErrLoanReturned = errors.New("loan was already returned")
+
+// Part or all of an item lent to someone. The item keeps its quantity while it is out.
+// BoxID is the box the item was in when it was lent.
+type Loan struct {
+	ID              int            `json:"id"`
+	ItemID          int            `json:"item_id"`
+	ItemName        string         `json:"item_name"`
+	BoxID           int            `json:"box_id"`
+	BoxName         JSONNullString `json:"box_name"`
+	Borrower        string         `json:"borrower"`
+	Quantity        int            `json:"quantity"`
+	DueAt           JSONNullTime   `json:"due_at"`
+	Note            JSONNullString `json:"note"`
+	Remind          bool           `json:"remind"`
+	LentAt          time.Time      `json:"lent_at"`
+	ReturnedAt      JSONNullTime   `json:"returned_at"`
+	ReturnedToBoxID JSONNullInt64  `json:"returned_to_box_id"`
+}
+
+// Reports whether the loan is still out after its due date
+func (l Loan) IsOverdue() bool {
+	return !l.ReturnedAt.Valid && l.DueAt.Valid && l.DueAt.Time.Before(overdueCutoff(time.Now()))
+}
+
+// Returns the time before which loans are overdue. Due dates are days, so a loan is only overdue the day after.
+func overdueCutoff(now time.Time) time.Time {
+	return now.UTC().Truncate(24 * time.Hour)
+}
+
+// Columns selected for a Loan, in the order expected by queryLoans
+const loanColumns = `loans.id, loans.content_id, contents.name, loans.box_id, boxes.name, loans.borrower, loans.quantity, loans.due_at, loans.note, loans.remind, loans.lent_at, loans.returned_at, loans.returned_to_box_id`
+
+// Joins needed by loanColumns. item_box is the box the item is in now.
+const loanJoins = `
+	FROM loans
+	JOIN contents ON contents.id = loans.content_id
+	JOIN boxes AS item_box ON item_box.id = contents.box_id
+	LEFT JOIN boxes ON boxes.id = loans.box_id`
+
+// Lends part of an item to a borrower. A quantity of 0 lends everything that is not out already.
+func (d *Database) LendItem(db *sql.DB, itemID int, borrower string, quantity int, dueAt sql.NullTime, note string, remind bool) (Loan, error) {
+	// Start a transaction
+	tx, err := db.Begin()
+	if err != nil {
+		return Loan{}, err
+	}
+	// Defer a rollback in case something fails.
+	defer tx.Rollback()
+
+	var boxID, available int
+	query := `
+	SELECT box_id, quantity - (SELECT COALESCE(SUM(quantity), 0) FROM loans WHERE content_id = contents.id AND returned_at IS NULL)
+	FROM contents
+	WHERE id = ?`
+	if err := tx.QueryRow(query, itemID).Scan(&boxID, &available); err != nil {
+		return Loan{}, err
+	}
+	if quantity == 0 {
+		quantity = available
+	}
+	if quantity < 1 || quantity > available {
+		return Loan{}, ErrNotAvailable
+	}
+	query = `INSERT INTO loans (content_id, box_id, borrower, quantity, due_at, note, remind) VALUES (?, ?, ?, ?, ?, ?, ?)`
+	result, err := tx.Exec(query, itemID, boxID, borrower, quantity, dueAt, sql.NullString{String: note, Valid: note != ""}, remind)
+	if err != nil {
+		return Loan{}, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return Loan{}, err
+	}
+	event, err := d.recordEvent(tx, Event{Type: EventItemUpdated, BoxID: boxID, ItemID: itemID})
+	if err != nil {
+		return Loan{}, err
+	}
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		return Loan{}, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	d.publish(event)
+	return d.GetLoan(db, int(id))
+}
+
+// Checks a loan back in to the given box, or to the home box of the item or else the box it is in if boxID is 0.
+// Checking in to another box moves the item there, or only the returned part if the rest of it stayed.
+func (d *Database) ReturnLoan(db *sql.DB, loanID int, boxID int) (Loan, error) {
+	loan, err := d.GetLoan(db, loanID)
+	if err != nil {
+		return loan, err
+	}
+	if loan.ReturnedAt.Valid {
+		return loan, ErrLoanReturned
+	}
+	item, err := d.GetItem(db, loan.ItemID)
+	if err != nil {
+		return loan, err
+	}
+	if boxID == 0 {
+		boxID = item.BoxID
+		if item.HomeBoxID.Valid {
+			boxID = int(item.HomeBoxID.Int64)
+		}
+	}
+	target, err := d.GetBox(db, boxID)
+	if err != nil {
+		return loan, err
+	}
+
+	// Start a transaction
+	tx, err := db.Begin()
+	if err != nil {
+		return loan, err
+	}
+	// Defer a rollback in case something fails.
+	defer tx.Rollback()
+
+	var events []Event
+	switch {
+	case target.ID == item.BoxID:
+		events = append(events, Event{Type: EventItemUpdated, BoxID: item.BoxID, ItemID: item.ID})
+	case loan.Quantity >= item.Quantity:
+		query := `UPDATE contents SET box_id = ?, version = version + 1, updated_at = CURRENT_TIMESTAMP WHERE id = ?`
+		if _, err := tx.Exec(query, target.ID, item.ID); err != nil {
+			return loan, err
+		}
+		moveQuery := `INSERT INTO item_moves (content_id, from_box_id, to_box_id) VALUES (?, ?, ?)`
+		if _, err := tx.Exec(moveQuery, item.ID, item.BoxID, target.ID); err != nil {
+			return loan, err
+		}
+		events = append(events, Event{Type: EventItemMoved, BoxID: target.ID, ItemID: item.ID, FromBoxID: item.BoxID})
+	default:
+		// The returned part becomes an item of its own in the other box
+		query := `UPDATE contents SET quantity = quantity - ?, version = version + 1, updated_at = CURRENT_TIMESTAMP WHERE id = ?`
+		if _, err := tx.Exec(query, loan.Quantity, item.ID); err != nil {
+			return loan, err
+		}
+		note := fmt.Sprintf("returned by %s to %s", loan.Borrower, target.Name)
+		if _, err := recordStockMovement(tx, item.ID, -loan.Quantity, MovementAdjust, note); err != nil {
+			return loan, err
+		}
+		query = `
+		INSERT INTO contents (name, quantity, expires_at, barcode, weight, purchase_price, purchased_at, current_value, box_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+		result, err := tx.Exec(query, item.Name, loan.Quantity, item.ExpiresAt.NullTime, item.Barcode.NullString, item.Weight.NullInt64,
+			item.PurchasePrice.NullInt64, item.PurchasedAt.NullTime, item.CurrentValue.NullInt64, target.ID)
+		if err != nil {
+			return loan, err
+		}
+		splitID, err := result.LastInsertId()
+		if err != nil {
+			return loan, err
+		}
+		events = append(events,
+			Event{Type: EventItemUpdated, BoxID: item.BoxID, ItemID: item.ID},
+			Event{Type: EventItemCreated, BoxID: target.ID, ItemID: int(splitID)})
+	}
+	query := `UPDATE loans SET returned_at = ?, returned_to_box_id = ? WHERE id = ? AND returned_at IS NULL`
+	result, err := tx.Exec(query, time.Now().UTC(), target.ID, loan.ID)
+	if err != nil {
+		return loan, err
+	}
+	// Someone else may have checked it in meanwhile
+	if returned, err := result.RowsAffected(); err != nil {
+		return loan, err
+	} else if returned == 0 {
+		return loan, ErrLoanReturned
+	}
+	for i := range events {
+		if events[i], err = d.recordEvent(tx, events[i]); err != nil {
+			return loan, err
+		}
+	}
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		return loan, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	for _, event := range events {
+		d.publish(event)
+	}
+	return d.GetLoan(db, loan.ID)
+}
+
+// Get a single loan by its id
+func (d *Database) GetLoan(db *sql.DB, id int) (Loan, error) {
+	loans, err := d.queryLoans(db, `WHERE loans.id = ?`, id)
+	if err != nil {
+		return Loan{}, err
+	}
+	if len(loans) == 0 {
+		return Loan{}, sql.ErrNoRows
+	}
+	return loans[0], nil
+}
+
+// Get the loans of an item, newest first
+func (d *Database) GetItemLoans(db *sql.DB, itemID int, limit int) ([]Loan, error) {
+	return d.queryLoans(db, `WHERE loans.content_id = ? ORDER BY loans.lent_at DESC, loans.id DESC LIMIT ?`, itemID, limit)
+}
+
+// Get all loans that are still out in an inventory (0 for all), the ones due first at the top.
+// With overdueOnly only the loans past their due date are returned.
+func (d *Database) GetOpenLoans(db *sql.DB, inventoryID int, overdueOnly bool) ([]Loan, error) {
+	where := `WHERE loans.returned_at IS NULL AND (? = 0 OR item_box.inventory_id = ?)`
+	args := []any{inventoryID, inventoryID}
+	if overdueOnly {
+		where += ` AND loans.due_at < ?`
+		args = append(args, overdueCutoff(time.Now()))
+	}
+	return d.queryLoans(db, where+` ORDER BY loans.due_at IS NULL, loans.due_at, loans.lent_at`, args...)
+}
+
+// Get the loans with reminders that are overdue at the given time
+func (d *Database) GetOverdueLoans(db *sql.DB, now time.Time) ([]Loan, error) {
+	return d.queryLoans(db, `WHERE loans.returned_at IS NULL AND loans.remind AND loans.due_at < ? ORDER BY loans.due_at`, overdueCutoff(now))
+}
+
+// Queries loans with the names of their items and boxes
+func (d *Database) queryLoans(db *sql.DB, where string, args ...any) ([]Loan, error) {
+	rows, err := db.Query(`SELECT `+loanColumns+loanJoins+` `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	loans := make([]Loan, 0)
+	for rows.Next() {
+		var loan Loan
+		err := rows.Scan(&loan.ID, &loan.ItemID, &loan.ItemName, &loan.BoxID, &loan.BoxName.NullString, &loan.Borrower, &loan.Quantity,
+			&loan.DueAt.NullTime, &loan.Note.NullString, &loan.Remind, &loan.LentAt, &loan.ReturnedAt.NullTime, &loan.ReturnedToBoxID.NullInt64)
+		if err != nil {
+			return nil, err
+		}
+		loans = append(loans, loan)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return loans, nil
+}
+
+// Returns the inventory of the item of a loan, sql.ErrNoRows if the loan does not exist
+func (d *Database) loanInventory(db *sql.DB, loanID int) (int, error) {
+	var inventoryID int
+	query := `SELECT item_box.inventory_id` + loanJoins + ` WHERE loans.id = ?`
+	err := db.QueryRow(query, loanID).Scan(&inventoryID)
+	return inventoryID, err
+}
@@ -2,39 +2,57 @@ package main
 
 import (
 	"database/sql"
-	"encoding/base64"
+	"errors"
 	"fmt"
 	"html/template"
+	"io"
 	"log"
 	"math"
 	"net/http"
+	"net/url"
 	"os"
 	"strconv"
-
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	_ "github.com/mattn/go-sqlite3"
-	"github.com/skip2/go-qrcode"
 )
 
 var (
 	database Database
 	client   *sql.DB
 	secure   bool
+	// Number of delivery attempts before a webhook delivery is marked as failed
+	webhookMaxAttempts int
+	// Template and printers of labels for thermal label printers
+	labelPrinting LabelPrintConfig
+	// Currency of item values
+	currency string
 )
 
 const (
 	itemsPerPage = 5
 	version      = "v0.5.2"
-	qrCodeSize   = 156
+	// Events buffered per live update subscriber before further events are dropped for it
+	eventStreamBuffer = 64
+	// Interval for comments sent on idle event streams to keep proxies from closing them
+	eventStreamKeepAlive = 25 * time.Second
+	// Largest photo accepted for decoding QR codes and barcodes and largest form accepted at all
+	maxImageUploadSize = 20 << 20
 )
 
 // Initialize Database and return sql connection to client
 // Additionally also determine whether QR codes should use http:// or https:// as the schema via ENV
 func init() {
 	database = Database{
-		DBFilePath: getEnv("DB", "/tmp/boxes.db"),
+		DBFilePath:     getEnv("DB", "/tmp/boxes.db"),
+		Events:         NewEventBus(),
+		BoxCodePattern: getEnv("BOX_CODE_PATTERN", defaultBoxCodePattern),
+	}
+	if err := validateBoxCodePattern(database.BoxCodePattern); err != nil {
+		log.Printf("Ignoring BOX_CODE_PATTERN %q: %v", database.BoxCodePattern, err)
+		database.BoxCodePattern = defaultBoxCodePattern
 	}
 	client = database.Init()
 	var err error
@@ -42,6 +60,12 @@ func init() {
 	if err != nil {
 		secure = false
 	}
+	webhookMaxAttempts, err = strconv.Atoi(getEnv("WEBHOOK_MAX_ATTEMPTS", "8"))
+	if err != nil || webhookMaxAttempts < 1 {
+		webhookMaxAttempts = 8
+	}
+	labelPrinting = labelPrintConfigFromEnv()
+	currency = getEnv("CURRENCY", "EUR")
 }
 
 // Template function to pretty print time data types as string
@@ -50,6 +74,130 @@ func formatAsDate(t time.Time) string {
 	return fmt.Sprintf("%d/%02d/%02d", year, month, day)
 }
 
+// Template function to format a time in the time zone of the server
+func formatAsTime(t time.Time) string {
+	return t.Local().Format("2006/01/02 15:04")
+}
+
+// Template function to format a nullable date as the value of a date input
+func formatAsInputDate(t sql.NullTime) string {
+	if !t.Valid {
+		return ""
+	}
+	return t.Time.Format(time.DateOnly)
+}
+
+// Parses an optional date (YYYY-MM-DD) from a form or JSON body. An empty string means no date.
+func parseOptionalDate(value string) (sql.NullTime, error) {
+	if value == "" {
+		return sql.NullTime{}, nil
+	}
+	t, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return sql.NullTime{}, err
+	}
+	return sql.NullTime{Time: t, Valid: true}, nil
+}
+
+// Parses an optional non-negative number from a form. An empty string means no value.
+func parseOptionalInt(value string) (sql.NullInt64, error) {
+	if value == "" {
+		return sql.NullInt64{}, nil
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed < 0 {
+		return sql.NullInt64{}, fmt.Errorf("invalid number %q", value)
+	}
+	return sql.NullInt64{Int64: int64(parsed), Valid: true}, nil
+}
+
+// Formats an optional number for a form value
+func formatOptionalInt(value sql.NullInt64) string {
+	if !value.Valid {
+		return ""
+	}
+	return strconv.FormatInt(value.Int64, 10)
+}
+
+// Parses an optional weight in kg with decimals into grams
+func parseOptionalKilograms(value string) (sql.NullInt64, error) {
+	if value == "" {
+		return sql.NullInt64{}, nil
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil || parsed < 0 || math.IsInf(parsed, 0) {
+		return sql.NullInt64{}, fmt.Errorf("invalid weight %q", value)
+	}
+	return sql.NullInt64{Int64: int64(math.Round(parsed * 1000)), Valid: true}, nil
+}
+
+// Template function to format grams as kg without trailing zeros
+func formatKilograms(grams int64) string {
+	return strconv.FormatFloat(float64(grams)/1000, 'f', -1, 64)
+}
+
+// Formats an optional weight in grams as kg for a form value
+func formatOptionalKilograms(grams sql.NullInt64) string {
+	if !grams.Valid {
+		return ""
+	}
+	return formatKilograms(grams.Int64)
+}
+
+// Parses an optional amount of money with up to two decimals into cents
+func parseOptionalMoney(value string) (sql.NullInt64, error) {
+	value = strings.TrimSpace(strings.ReplaceAll(value, ",", "."))
+	if value == "" {
+		return sql.NullInt64{}, nil
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil || parsed < 0 || math.IsInf(parsed, 0) || parsed > math.MaxInt64/100 {
+		return sql.NullInt64{}, fmt.Errorf("invalid amount %q", value)
+	}
+	return sql.NullInt64{Int64: int64(math.Round(parsed * 100)), Valid: true}, nil
+}
+
+// Template function to format cents as an amount in the configured currency
+func formatMoney(cents int64) string {
+	return formatCents(cents) + " " + currency
+}
+
+// Parses the optional item attributes from the item form
+func parseItemAttributes(c *gin.Context) (ItemAttributes, error) {
+	var attrs ItemAttributes
+	var err error
+	attrs.ExpiresAt, err = parseOptionalDate(c.PostForm("item_expires"))
+	if err != nil {
+		return attrs, errors.New("Invalid Expiration Date")
+	}
+	attrs.MinQuantity, err = parseOptionalInt(c.PostForm("item_min"))
+	if err != nil {
+		return attrs, errors.New("Invalid Minimum Quantity")
+	}
+	attrs.TargetQuantity, err = parseOptionalInt(c.PostForm("item_target"))
+	if err != nil {
+		return attrs, errors.New("Invalid Target Quantity")
+	}
+	attrs.Barcode, err = parseOptionalBarcode(c.PostForm("item_barcode"))
+	if err != nil {
+		return attrs, err
+	}
+	attrs.Weight, err = parseOptionalInt(c.PostForm("item_weight"))
+	if err != nil {
+		return attrs, errors.New("Invalid Weight")
+	}
+	attrs.Labeled = c.PostForm("item_labeled") == "yes"
+	return attrs, nil
+}
+
+// Formats whether an item has its own QR label like the checkbox of the item form
+func formatLabeled(labeled bool) string {
+	if labeled {
+		return "yes"
+	}
+	return ""
+}
+
 // Handle setting of variables of env var is not set
 func getEnv(key, defaultValue string) string {
 	value := os.Getenv(key)
@@ -59,7 +207,34 @@ func getEnv(key, defaultValue string) string {
 	return value
 }
 
+// Parses a boolean env variable and returns the default value if it is not set or invalid
+func parseBoolEnv(key string, defaultValue bool) (bool, error) {
+	value := os.Getenv(key)
+	if len(value) == 0 {
+		return defaultValue, nil
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return defaultValue, err
+	}
+	return parsed, nil
+}
+
+// Parses an integer env variable and returns the default value if it is not set or invalid
+func parseIntEnv(key string, defaultValue int) (int, error) {
+	value := os.Getenv(key)
+	if len(value) == 0 {
+		return defaultValue, nil
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return defaultValue, err
+	}
+	return parsed, nil
+}
+
 // Get all boxes and return html page and paginate them to only show a certain amount per page
+// Query Params: status, destination, room and sort (optional), see parseBoxFilter
 func getBox(c *gin.Context) {
 	// Page has always a default value if not provided by the request
 	pageStr := c.DefaultQuery("page", "1")
@@ -72,16 +247,32 @@ func getBox(c *gin.Context) {
 	// This means that the offset of the underlying SQL query will be 0 and the limit will be 'itemsPerPage'
 	// Each page will only show 'itemsPerPage' with a certain offset to make pagination work
 	offset := (page - 1) * itemsPerPage
+	filter, err := parseBoxFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// Only the boxes of the selected inventory are shown
+	inventory, ok := requestInventory(c)
+	if !ok {
+		return
+	}
+	inventories, err := accessibleInventories(c)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get inventories"})
+		return
+	}
 	// We query the database for the total amount of boxes.
 	// Will be used to calculate the amount of total pages displayed in the frontend.
-	totalItems, err := database.GetBoxesTotal(client)
+	totalItems, err := database.GetBoxesTotal(client, inventory.ID, filter)
 	if err != nil {
 		log.Println(err)
 		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get total boxes"})
 		return
 	}
 	// Get boxes considering offsets and limits
-	boxes, err := database.GetBoxesPaginated(client, offset, itemsPerPage)
+	boxes, err := database.GetBoxesPaginated(client, inventory.ID, filter, offset, itemsPerPage)
 	// Calculate the total amount of pages to display for the user in the frontend.
 	// Right now this will be able to indefinitely "grow" in the user interface since we don't do any kind of "1,2,3,...,45" display in the frontend
 	totalPages := int(math.Ceil(float64(totalItems) / float64(itemsPerPage)))
@@ -90,15 +281,131 @@ func getBox(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get boxes"})
 		return
 	}
+	// Destination rooms to filter by
+	destinations, err := database.GetBoxDestinations(client, inventory.ID)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get destinations"})
+		return
+	}
+	// The signed in user, if user accounts are turned on
+	user, _ := currentUser(c)
 	// Render the HTML page providing all values to it
-	c.HTML(http.StatusOK, "boxes.tmpl", gin.H{
-		"boxes":       boxes,
-		"version":     version,
-		"CurrentPage": page,
-		"TotalPages":  totalPages,
+	renderHTML(c, http.StatusOK, "boxes.tmpl", gin.H{
+		"boxes":        boxes,
+		"version":      version,
+		"CurrentPage":  page,
+		"TotalPages":   totalPages,
+		"user":         user,
+		"inventory":    inventory,
+		"inventories":  inventories,
+		"filter":       filter,
+		"statuses":     boxStatuses,
+		"destinations": destinations,
+		"roomChoices":  []int64{0, 5000, 10000, 20000},
 	})
 }
 
+// Parses the filter of box lists from the query parameters status and destination of boxes in a move,
+// room for boxes with more than that many grams of their max load left and sort (heaviest or lightest)
+func parseBoxFilter(c *gin.Context) (BoxFilter, error) {
+	filter := BoxFilter{Status: c.Query("status"), Destination: c.Query("destination"), Sort: c.Query("sort")}
+	if filter.Status != "" && !validBoxStatus(filter.Status) {
+		return filter, ErrInvalidStatus
+	}
+	if filter.Sort != "" && filter.Sort != BoxSortHeaviest && filter.Sort != BoxSortLightest {
+		return filter, errors.New("Invalid Sort Order")
+	}
+	room, err := parseOptionalInt(c.Query("room"))
+	if err != nil {
+		return filter, errors.New("Invalid Room Left")
+	}
+	filter.RoomLeft = room
+	return filter, nil
+}
+
+// Returns the URL of a path on this server as it is opened from a QR code
+func absoluteURL(c *gin.Context, path string) string {
+	// Set schema to http(s) according to the environment variable HTTP_SECURE_SCHEMA
+	schema := "http://"
+	if secure {
+		schema = "https://"
+	}
+	return schema + c.Request.Host + path
+}
+
+// Serves the QR code image of a box
+// Query Params: format (svg or png, default svg), size (width in pixels, default 256),
+// level (error correction L, M, Q or H, default M), quiet (quiet zone in modules, default 4)
+// and caption (name, code or both as "name,code" to show below the code)
+// Example: curl "http://localhost/box/3/qr?format=png&size=1024&level=Q&caption=name,code"
+func getBoxQR(c *gin.Context) {
+	id, err := strconv.Atoi(c.Params.ByName("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Box ID"})
+		return
+	}
+	box, err := database.GetBox(client, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"fail": "box does not exist"})
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get box"})
+		return
+	}
+	serveQR(c, boxLabel(box))
+}
+
+// Serves the QR code image of an item, taking the same query parameters as the QR code of a box
+func getItemQR(c *gin.Context) {
+	id, err := strconv.Atoi(c.Params.ByName("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Item ID"})
+		return
+	}
+	item, err := database.GetItem(client, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"fail": "item does not exist"})
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get item"})
+		return
+	}
+	serveQR(c, database.itemLabel(client, item))
+}
+
+// Renders the QR code of a label with the options from the query and serves it with an ETag for revalidation.
+// The QR code links to the short route of the code, whose shorter URL gives a smaller QR code
+// with bigger modules that is easier to scan.
+func serveQR(c *gin.Context, label Label) {
+	options, err := parseQROptions(c.Request.URL.Query())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	captions := map[string]string{qrCaptionName: label.Name, qrCaptionCode: label.Code}
+	data, contentType, err := renderQRImage(absoluteURL(c, label.Path()), options, captions)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate QR code"})
+		return
+	}
+	etag := dataETag(data)
+	c.Header("ETag", etag)
+	// The link changes with the code and the inventory of a box and captions follow renames,
+	// so caches have to revalidate with the ETag of the image
+	c.Header("Cache-Control", "no-cache")
+	if notModified(c, etag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.Data(http.StatusOK, contentType, data)
+}
+
 // Get all box contents for a certain box and return html page
 func getBoxContent(c *gin.Context) {
 	// Get the ID for the request and parse it to int
@@ -120,29 +427,44 @@ func getBoxContent(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"fail": "box does not exist"})
 		return
 	}
-	// Define png byte slice to store qr code in
-	var png []byte
-	// Gets hostname and path from the request.
-	currentURL := c.Request.Host + c.Request.RequestURI
-	// Set schema to http(s) according to the environment variable HTTP_SECURE_SCHEMA
-	schema := "http://"
-	if secure {
-		schema = "https://"
+	// The box can be moved to any other inventory the user may use
+	box, err := database.GetBox(client, id)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get box"})
+		return
 	}
-	fullURL := schema + currentURL
-	// Generate QR code with a defined size
-	png, err = qrcode.Encode(fullURL, qrcode.Medium, qrCodeSize)
+	inventories, err := accessibleInventories(c)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate QR code"})
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get inventories"})
 		return
 	}
-	// Encode the qr code data as base64 and enclose it in a html image tag
-	qrCodeBase64 := "data:image/png;base64," + base64.StdEncoding.EncodeToString(png)
-	qrCodeSafeURL := template.HTML(`<img src="` + qrCodeBase64 + `" alt="QR Code" />`)
-	// Render the html page with the provided variables
-	c.HTML(http.StatusOK, "content.tmpl", gin.H{
-		"QRCode":   qrCodeSafeURL,
-		"contents": contents,
+	// Where the box went in a move and the rooms to choose from
+	statusHistory, err := database.GetBoxStatusHistory(client, id)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get status changes"})
+		return
+	}
+	destinations, err := database.GetBoxDestinations(client, box.InventoryID)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get destinations"})
+		return
+	}
+	// Opening a box works in its inventory from then on
+	if c.Query("inventory") == "" {
+		selectInventory(c, box.InventoryID)
+	}
+	// Render the html page with the provided variables, the ETag changes with everything shown on it
+	renderHTMLWithETag(c, http.StatusOK, "content.tmpl", gin.H{
+		"contents":      contents,
+		"box":           box,
+		"inventories":   inventories,
+		"statuses":      boxStatuses,
+		"statusHistory": statusHistory,
+		"destinations":  destinations,
 	})
 }
 
@@ -175,8 +497,47 @@ func updateBoxContent(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Quantity"})
 		return
 	}
+	attrs, err := parseItemAttributes(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// The version the user saw when opening the form, either from If-Match or the hidden form field
+	version, fromHeader, err := expectedVersion(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	// Update the box content with the provided values
-	err = database.UpdateBoxContent(client, id, name, quantity)
+	err = actorDatabase(c).UpdateBoxContent(client, boxid, id, name, quantity, attrs, version)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"fail": "item is not in this box"})
+		return
+	}
+	if errors.Is(err, ErrVersionConflict) {
+		if fromHeader {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"fail": "item was changed by someone else"})
+			return
+		}
+		// Someone else saved the item in the meantime, let the user decide which values to keep
+		current, err := database.GetItem(client, id)
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get item"})
+			return
+		}
+		renderConflict(c, "item", c.Request.URL.Path, fmt.Sprintf("/box/%d", boxid), current.Version, []conflictField{
+			{Label: "Name", Name: "item_name", Mine: name, Theirs: current.Name},
+			{Label: "Amount", Name: "item_amount", Mine: quantityString, Theirs: strconv.Itoa(current.Quantity)},
+			{Label: "Expires", Name: "item_expires", Mine: c.PostForm("item_expires"), Theirs: formatAsInputDate(current.ExpiresAt.NullTime)},
+			{Label: "Minimum", Name: "item_min", Mine: c.PostForm("item_min"), Theirs: formatOptionalInt(current.MinQuantity.NullInt64)},
+			{Label: "Target", Name: "item_target", Mine: c.PostForm("item_target"), Theirs: formatOptionalInt(current.TargetQuantity.NullInt64)},
+			{Label: "Barcode", Name: "item_barcode", Mine: c.PostForm("item_barcode"), Theirs: current.Barcode.String},
+			{Label: "Weight (g)", Name: "item_weight", Mine: c.PostForm("item_weight"), Theirs: formatOptionalInt(current.Weight.NullInt64)},
+			{Label: "Own QR label", Name: "item_labeled", Mine: c.PostForm("item_labeled"), Theirs: formatLabeled(current.Labeled)},
+		})
+		return
+	}
 	if err != nil {
 		log.Println(err)
 		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get boxes contents"})
@@ -192,7 +553,20 @@ func createBox(c *gin.Context) {
 	c.Request.ParseForm()
 	name := c.PostForm("item_name")
 	label := c.PostForm("item_label")
-	err := database.CreateBox(client, name, label)
+	// The form names the inventory it was shown for, in case another one was selected in the meantime
+	inventoryID, err := strconv.Atoi(c.PostForm("inventory_id"))
+	if err != nil {
+		inventory, ok := requestInventory(c)
+		if !ok {
+			return
+		}
+		inventoryID = inventory.ID
+	}
+	if !canUseInventory(c, inventoryID) {
+		c.JSON(http.StatusForbidden, gin.H{"fail": ErrInventoryForbidden.Error()})
+		return
+	}
+	err = actorDatabase(c).CreateBox(client, inventoryID, name, label)
 	if err != nil {
 		log.Println(err)
 		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not create new box"})
@@ -223,8 +597,12 @@ func deleteBox(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Box ID"})
 		return
 	}
+	if !canUseBox(c, boxid) {
+		c.JSON(http.StatusForbidden, gin.H{"fail": ErrInventoryForbidden.Error()})
+		return
+	}
 	// Deletes the box and all associated contents
-	err = database.DeleteBox(client, boxid)
+	err = actorDatabase(c).DeleteBox(client, boxid)
 	if err != nil {
 		log.Println(err)
 		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not delete box"})
@@ -251,8 +629,30 @@ func updateBox(c *gin.Context) {
 	c.Request.ParseForm()
 	name := c.PostForm("item_name")
 	label := c.PostForm("item_label")
+	version, fromHeader, err := expectedVersion(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	err = database.UpdateBox(client, id, name, label)
+	err = actorDatabase(c).UpdateBox(client, id, name, label, version)
+	if errors.Is(err, ErrVersionConflict) {
+		if fromHeader {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"fail": "box was changed by someone else"})
+			return
+		}
+		current, err := database.GetBox(client, id)
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get box"})
+			return
+		}
+		renderConflict(c, "box", c.Request.URL.Path, "/", current.Version, []conflictField{
+			{Label: "Name", Name: "item_name", Mine: name, Theirs: current.Name},
+			{Label: "Label", Name: "item_label", Mine: label, Theirs: current.Label.String},
+		})
+		return
+	}
 	if err != nil {
 		log.Println(err)
 		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not edit box"})
@@ -261,6 +661,55 @@ func updateBox(c *gin.Context) {
 	c.Redirect(http.StatusFound, "/")
 }
 
+// Sets the size, empty weight and max load of a box from the box page
+// Takes the form values box_length, box_width and box_height in cm, box_empty_weight and box_max_load in kg
+func setBoxSize(c *gin.Context) {
+	boxID, err := strconv.Atoi(c.Params.ByName("boxid"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Box ID"})
+		return
+	}
+	var size BoxSize
+	size.Length, err = parseOptionalInt(c.PostForm("box_length"))
+	if err == nil {
+		size.Width, err = parseOptionalInt(c.PostForm("box_width"))
+	}
+	if err == nil {
+		size.Height, err = parseOptionalInt(c.PostForm("box_height"))
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Size"})
+		return
+	}
+	if size.EmptyWeight, err = parseOptionalKilograms(c.PostForm("box_empty_weight")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Empty Weight"})
+		return
+	}
+	if size.MaxLoad, err = parseOptionalKilograms(c.PostForm("box_max_load")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Max Load"})
+		return
+	}
+	if !setBoxSizeOrAbort(c, boxID, size) {
+		return
+	}
+	c.Redirect(http.StatusFound, fmt.Sprintf("/box/%d", boxID))
+}
+
+// Sets the size of a box and responds with an error if that fails
+func setBoxSizeOrAbort(c *gin.Context, boxID int, size BoxSize) bool {
+	err := actorDatabase(c).SetBoxSize(client, boxID, size)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"fail": "box does not exist"})
+		return false
+	}
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not update box size"})
+		return false
+	}
+	return true
+}
+
 // Creates a new item in the specified box
 // Uses the boxid to place the item into the correct box
 // Takes the form data to set the attributes for the item and creates it
@@ -281,7 +730,12 @@ func createItem(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Quantity for item"})
 		return
 	}
-	err = database.CreateItem(client, boxid, name, quantity)
+	attrs, err := parseItemAttributes(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	_, err = actorDatabase(c).CreateItem(client, boxid, name, quantity, attrs)
 	if err != nil {
 		log.Println(err)
 		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not create new item in box"})
@@ -307,7 +761,11 @@ func deleteItem(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Item ID"})
 		return
 	}
-	err = database.DeleteItem(client, itemId)
+	if !canUseItem(c, itemId) {
+		c.JSON(http.StatusForbidden, gin.H{"fail": ErrInventoryForbidden.Error()})
+		return
+	}
+	err = actorDatabase(c).DeleteItem(client, itemId)
 	if err != nil {
 		log.Println(err)
 		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not delete item"})
@@ -319,70 +777,3234 @@ func deleteItem(c *gin.Context) {
 	})
 }
 
-// API endpoint to search boxes based on name or label name
-// Method: GET
-// URL: /api/v0/box
-// Query Param: search
-// Example: curl http://localhost/api/v0/box?search=box
-func apiGetBox(c *gin.Context) {
-	query := c.DefaultQuery("search", "")
-	boxes, err := database.GetBoxesByTextV0(client, query)
+// Shows an item with its box, its QR label and its history
+func getItem(c *gin.Context) {
+	id, err := strconv.Atoi(c.Params.ByName("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Item ID"})
+		return
+	}
+	item, err := database.GetLocatedItem(client, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"fail": "item does not exist"})
+		return
+	}
 	if err != nil {
 		log.Println(err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"message": "could not query boxes. Internal Server Error.",
-		})
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get item"})
 		return
 	}
-	if len(boxes) == 0 {
-		boxes = make([]Box, 0)
+	var home Box
+	if item.HomeBoxID.Valid {
+		if home, err = database.GetBox(client, int(item.HomeBoxID.Int64)); err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get home box"})
+			return
+		}
 	}
-	c.JSON(http.StatusOK, gin.H{
-		"message": "success",
-		"count":   len(boxes),
-		"result":  boxes,
-	})
-
-}
-
-// API endpoint to move one item to another box
-// Method: PATCH
-// URL: /api/v0/item/move
-// Body: { "targetBox": 1, "sourceBox": 2, "sourceItem": 10 }
-// Example: curl -XPATCH http://localhost/api/v0/item/move -d '{ "targetBox": 1, "sourceBox": 2, "sourceItem": 10 }'
-func apiMoveItem(c *gin.Context) {
-	type MoveRequest struct {
-		TargetBox  int `json:"targetBox" binding:"required"`
-		SourceBox  int `json:"sourceBox" binding:"required"`
-		SourceItem int `json:"sourceItem" binding:"required"`
+	moves, err := database.GetItemMoves(client, id, 50)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get item moves"})
+		return
 	}
-	var req MoveRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	movements, err := database.GetStockMovements(client, id, 50)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get stock movements"})
 		return
 	}
-	err := database.MoveItem(client, req.SourceBox, req.TargetBox, req.SourceItem)
+	loans, err := database.GetItemLoans(client, id, 50)
 	if err != nil {
 		log.Println(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not move item"})
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get loans"})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{
-		"message":  "item moved",
-		"id":       req.SourceItem,
-		"oldBoxId": req.SourceBox,
-		"newBoxId": req.TargetBox,
-	})
+	// Lent items can be checked in to any box of the inventory of the item
+	available := item.Quantity
+	for _, loan := range loans {
+		if !loan.ReturnedAt.Valid {
+			available -= loan.Quantity
+		}
+	}
+	inventoryID, err := database.boxInventory(client, item.BoxID)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get box"})
+		return
+	}
+	boxes, err := database.GetBoxes(client, inventoryID)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get boxes"})
+		return
+	}
+	renderHTML(c, http.StatusOK, "item.tmpl", gin.H{
+		"item":      item,
+		"home":      home,
+		"moves":     moves,
+		"movements": movements,
+		"loans":     loans,
+		"available": available,
+		"boxes":     boxes,
+		"currency":  currency,
+	})
+}
+
+// Moves an item back into its home box from the item page
+func returnItem(c *gin.Context) {
+	id, err := strconv.Atoi(c.Params.ByName("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Item ID"})
+		return
+	}
+	if _, err := actorDatabase(c).ReturnItem(client, id); err != nil {
+		itemError(c, err)
+		return
+	}
+	c.Redirect(http.StatusFound, fmt.Sprintf("/item/%d", id))
+}
+
+// Makes the box the item is in its home box
+func setItemHome(c *gin.Context) {
+	id, err := strconv.Atoi(c.Params.ByName("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Item ID"})
+		return
+	}
+	if err := actorDatabase(c).SetItemHome(client, id); err != nil {
+		itemError(c, err)
+		return
+	}
+	c.Redirect(http.StatusFound, fmt.Sprintf("/item/%d", id))
+}
+
+// Adds or removes the QR label of an item from the item page
+// Takes the form value labeled ("yes" to add the label)
+func labelItem(c *gin.Context) {
+	id, err := strconv.Atoi(c.Params.ByName("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Item ID"})
+		return
+	}
+	if err := actorDatabase(c).SetItemLabeled(client, id, c.PostForm("labeled") == "yes"); err != nil {
+		itemError(c, err)
+		return
+	}
+	c.Redirect(http.StatusFound, fmt.Sprintf("/item/%d", id))
+}
+
+// Sets what an item cost and is worth and its serial number from the item page
+// Takes the form values purchase_price and current_value (amounts with up to two decimals),
+// purchased_at (YYYY-MM-DD) and serial_number, all optional
+func setItemValue(c *gin.Context) {
+	id, err := strconv.Atoi(c.Params.ByName("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Item ID"})
+		return
+	}
+	var value ItemValue
+	if value.PurchasePrice, err = parseOptionalMoney(c.PostForm("purchase_price")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Purchase Price"})
+		return
+	}
+	if value.CurrentValue, err = parseOptionalMoney(c.PostForm("current_value")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Current Value"})
+		return
+	}
+	if value.PurchasedAt, err = parseOptionalDate(c.PostForm("purchased_at")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Purchase Date"})
+		return
+	}
+	serial := strings.TrimSpace(c.PostForm("serial_number"))
+	value.SerialNumber = sql.NullString{String: serial, Valid: serial != ""}
+	if err := actorDatabase(c).SetItemValue(client, id, value); err != nil {
+		itemError(c, err)
+		return
+	}
+	c.Redirect(http.StatusFound, fmt.Sprintf("/item/%d", id))
+}
+
+// Sets the photo of an item from the item page
+// Takes the image as the form field "photo"
+func uploadItemPhoto(c *gin.Context) {
+	id, err := strconv.Atoi(c.Params.ByName("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Item ID"})
+		return
+	}
+	if !setItemPhotoOrAbort(c, id, "photo") {
+		return
+	}
+	c.Redirect(http.StatusFound, fmt.Sprintf("/item/%d", id))
+}
+
+// Removes the photo of an item from the item page
+func deleteItemPhoto(c *gin.Context) {
+	id, err := strconv.Atoi(c.Params.ByName("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Item ID"})
+		return
+	}
+	if err := actorDatabase(c).DeleteItemPhoto(client, id); err != nil {
+		itemError(c, err)
+		return
+	}
+	c.Redirect(http.StatusFound, fmt.Sprintf("/item/%d", id))
+}
+
+// Stores the image uploaded as a form field or the request body as the photo of an item
+// and responds with an error if that fails
+func setItemPhotoOrAbort(c *gin.Context, itemID int, field string) bool {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImageUploadSize)
+	r, err := uploadedFile(c, field)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+	defer r.Close()
+	data, err := preparePhoto(r)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("could not read image: %v", err)})
+		return false
+	}
+	if err := actorDatabase(c).SetItemPhoto(client, itemID, data); err != nil {
+		itemError(c, err)
+		return false
+	}
+	return true
+}
+
+// Serves the photo of an item as a JPEG image
+func getItemPhoto(c *gin.Context) {
+	id, err := strconv.Atoi(c.Params.ByName("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Item ID"})
+		return
+	}
+	data, err := database.GetItemPhoto(client, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"fail": "item has no photo"})
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get photo"})
+		return
+	}
+	etag := dataETag(data)
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", "no-cache")
+	if notModified(c, etag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.Data(http.StatusOK, "image/jpeg", data)
+}
+
+// Responds with the status matching an error of the item actions
+func itemError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		c.JSON(http.StatusNotFound, gin.H{"fail": "item does not exist"})
+	case errors.Is(err, ErrNoHomeBox):
+		c.JSON(http.StatusConflict, gin.H{"fail": err.Error()})
+	default:
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not update item"})
+	}
+}
+
+// Lends an item to someone from the item page
+// Takes the form values borrower, quantity (optional, everything not lent out yet), due (optional, YYYY-MM-DD),
+// note (optional) and remind ("yes" to be reminded once the loan is overdue)
+func lendItem(c *gin.Context) {
+	id, err := strconv.Atoi(c.Params.ByName("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Item ID"})
+		return
+	}
+	quantity := 0
+	if value := c.PostForm("quantity"); value != "" {
+		if quantity, err = strconv.Atoi(value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Quantity"})
+			return
+		}
+	}
+	due, err := parseOptionalDate(c.PostForm("due"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Due Date"})
+		return
+	}
+	borrower := strings.TrimSpace(c.PostForm("borrower"))
+	if borrower == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "borrower is required"})
+		return
+	}
+	_, err = actorDatabase(c).LendItem(client, id, borrower, quantity, due, strings.TrimSpace(c.PostForm("note")), c.PostForm("remind") == "yes")
+	if err != nil {
+		loanError(c, err)
+		return
+	}
+	c.Redirect(http.StatusFound, fmt.Sprintf("/item/%d", id))
+}
+
+// Shows the loans that are still out in the selected inventory
+// Query Param: overdue (optional) to only show the loans past their due date
+func getLoans(c *gin.Context) {
+	inventory, ok := requestInventory(c)
+	if !ok {
+		return
+	}
+	overdue := c.Query("overdue") != ""
+	loans, err := database.GetOpenLoans(client, inventory.ID, overdue)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get loans"})
+		return
+	}
+	renderHTML(c, http.StatusOK, "loans.tmpl", gin.H{
+		"loans":     loans,
+		"inventory": inventory,
+		"overdue":   overdue,
+	})
+}
+
+// Checks a loan back in from the item page or the loans page
+// Takes the form values box_id (optional, the home box of the item or else the box it is in)
+// and from ("loans" to go back to the loans page)
+func returnLoan(c *gin.Context) {
+	id, err := strconv.Atoi(c.Params.ByName("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Loan ID"})
+		return
+	}
+	boxID := 0
+	if value := c.PostForm("box_id"); value != "" {
+		if boxID, err = strconv.Atoi(value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Box ID"})
+			return
+		}
+	}
+	loan, ok := returnLoanOrAbort(c, id, boxID)
+	if !ok {
+		return
+	}
+	if c.PostForm("from") == "loans" {
+		c.Redirect(http.StatusFound, "/loans")
+		return
+	}
+	c.Redirect(http.StatusFound, fmt.Sprintf("/item/%d", loan.ItemID))
+}
+
+// Checks a loan in to a box the user may use and responds with an error if that fails
+func returnLoanOrAbort(c *gin.Context, loanID int, boxID int) (Loan, bool) {
+	if boxID != 0 && !canUseBox(c, boxID) {
+		c.JSON(http.StatusForbidden, gin.H{"fail": ErrInventoryForbidden.Error()})
+		return Loan{}, false
+	}
+	loan, err := actorDatabase(c).ReturnLoan(client, loanID, boxID)
+	if err != nil {
+		loanError(c, err)
+		return Loan{}, false
+	}
+	return loan, true
+}
+
+// Responds with the status matching an error of lending and checking in items
+func loanError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		c.JSON(http.StatusNotFound, gin.H{"fail": "item, loan or box does not exist"})
+	case errors.Is(err, ErrNotAvailable), errors.Is(err, ErrLoanReturned):
+		c.JSON(http.StatusConflict, gin.H{"fail": err.Error()})
+	default:
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not update loan"})
+	}
+}
+
+// Shows printable labels with QR codes
+// Query Params: box and item (optional, repeatable) to only print the labels of these boxes and items.
+// A box comes with the labels of the labeled items in it. Without any, all labels are shown.
+func getLabels(c *gin.Context) {
+	labels, ok := requestedLabels(c, c.QueryArray("box"), c.QueryArray("item"))
+	if !ok {
+		return
+	}
+	download := func(format string) string {
+		query := url.Values{"format": {format}, "box": c.QueryArray("box"), "item": c.QueryArray("item")}
+		return "/api/v1/labels?" + query.Encode()
+	}
+	renderHTML(c, http.StatusOK, "labels.tmpl", gin.H{
+		"labels":     labels,
+		"zplURL":     download(labelFormatZPL),
+		"qlURL":      download(labelFormatQL),
+		"boxIDs":     c.QueryArray("box"),
+		"itemIDs":    c.QueryArray("item"),
+		"zplPrinter": labelPrinting.ZPLPrinter != "",
+		"qlPrinter":  labelPrinting.QLPrinter != "",
+		"printed":    c.Query("printed"),
+	})
+}
+
+// Sends labels to the thermal label printer of a format from the labels page
+// Takes the form values format ("zpl" or "ql") and box and item (optional, repeatable) like the labels page
+func printLabels(c *gin.Context) {
+	format := c.PostForm("format")
+	boxIDs, itemIDs := c.PostFormArray("box"), c.PostFormArray("item")
+	labels, ok := requestedLabels(c, boxIDs, itemIDs)
+	if !ok {
+		return
+	}
+	if err := labelPrinting.Print(format, labels, absoluteURL(c, "")); err != nil {
+		printError(c, err)
+		return
+	}
+	c.Redirect(http.StatusFound, "/labels?"+url.Values{"box": boxIDs, "item": itemIDs, "printed": {strconv.Itoa(len(labels))}}.Encode())
+}
+
+// Gets the labels of the given box and item ids and responds with an error if that fails
+func requestedLabels(c *gin.Context, boxValues []string, itemValues []string) ([]Label, bool) {
+	boxIDs, err := parseIDs(boxValues)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Box ID"})
+		return nil, false
+	}
+	itemIDs, err := parseIDs(itemValues)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Item ID"})
+		return nil, false
+	}
+	inventory, ok := requestInventory(c)
+	if !ok {
+		return nil, false
+	}
+	labels, err := database.GetLabels(client, inventory.ID, boxIDs, itemIDs)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"fail": "box or item does not exist"})
+		return nil, false
+	}
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get labels"})
+		return nil, false
+	}
+	for _, label := range labels {
+		if !canUseInventory(c, label.InventoryID) {
+			c.JSON(http.StatusForbidden, gin.H{"fail": ErrInventoryForbidden.Error()})
+			return nil, false
+		}
+	}
+	return labels, true
+}
+
+// Responds with the status matching an error of printing labels
+func printError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrUnknownLabelFormat):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrNoPrinter):
+		c.JSON(http.StatusConflict, gin.H{"fail": err.Error()})
+	default:
+		log.Println(err)
+		c.JSON(http.StatusBadGateway, gin.H{"fail": "could not print labels"})
+	}
+}
+
+// Parses all values of a repeatable parameter as ids
+func parseIDs(values []string) ([]int, error) {
+	var ids []int
+	for _, value := range values {
+		id, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// Parses the lifetime of a share link in days. An empty string means the default.
+func parseShareDays(value string) (int, error) {
+	if value == "" {
+		return defaultShareDays, nil
+	}
+	days, err := strconv.Atoi(value)
+	if err != nil || days < 1 || days > maxShareDays {
+		return 0, fmt.Errorf("days must be between 1 and %d", maxShareDays)
+	}
+	return days, nil
+}
+
+// Creates a read-only share link for a box
+// Takes the form values days (lifetime of the link, default 7) and note (optional, who it is for)
+func createShare(c *gin.Context) {
+	boxid, err := strconv.Atoi(c.Params.ByName("boxid"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Box ID"})
+		return
+	}
+	days, err := parseShareDays(c.PostForm("days"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	share, err := database.CreateShare(client, boxid, time.Now().AddDate(0, 0, days), c.PostForm("note"))
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"fail": "box does not exist"})
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not create share link"})
+		return
+	}
+	c.Redirect(http.StatusFound, fmt.Sprintf("/shares?created=%d", share.ID))
+}
+
+// Starts an audit of a box, or continues the one going on, and opens it
+func startAudit(c *gin.Context) {
+	boxID, err := strconv.Atoi(c.Params.ByName("boxid"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Box ID"})
+		return
+	}
+	audit, err := database.StartAudit(client, boxID)
+	if err != nil {
+		auditError(c, err)
+		return
+	}
+	c.Redirect(http.StatusFound, fmt.Sprintf("/audits/%d", audit.ID))
+}
+
+// Shows the boxes of the selected inventory not verified in a number of months, the audits going on
+// and the differences found by the latest audits
+// Query Param: months (optional, default 6)
+func getAudits(c *gin.Context) {
+	report, ok := auditReport(c)
+	if !ok {
+		return
+	}
+	renderHTML(c, http.StatusOK, "audits.tmpl", report)
+}
+
+// Collects the audit report of the selected inventory and responds with an error if that fails
+func auditReport(c *gin.Context) (gin.H, bool) {
+	months, err := strconv.Atoi(c.DefaultQuery("months", strconv.Itoa(defaultAuditMonths)))
+	if err != nil || months < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Number of Months"})
+		return nil, false
+	}
+	inventory, ok := requestInventory(c)
+	if !ok {
+		return nil, false
+	}
+	unverified, err := database.GetUnverifiedBoxes(client, inventory.ID, time.Now().AddDate(0, -months, 0))
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get unverified boxes"})
+		return nil, false
+	}
+	open, err := database.GetOpenAudits(client, inventory.ID)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get audits"})
+		return nil, false
+	}
+	discrepancies, err := database.GetDiscrepancies(client, inventory.ID, 100)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get discrepancies"})
+		return nil, false
+	}
+	return gin.H{
+		"inventory":     inventory,
+		"months":        months,
+		"unverified":    unverified,
+		"open":          open,
+		"discrepancies": discrepancies,
+	}, true
+}
+
+// Shows an audit with a form for every item of the box
+func getAudit(c *gin.Context) {
+	audit, items, ok := auditWithItems(c)
+	if !ok {
+		return
+	}
+	renderHTML(c, http.StatusOK, "audit.tmpl", gin.H{
+		"audit": audit,
+		"items": items,
+		"error": c.Query("error"),
+	})
+}
+
+// Gets the audit of the route with the items of its box and responds with an error if that fails
+func auditWithItems(c *gin.Context) (Audit, []AuditItem, bool) {
+	id, err := strconv.Atoi(c.Params.ByName("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Audit ID"})
+		return Audit{}, nil, false
+	}
+	audit, err := database.GetAudit(client, id)
+	if err != nil {
+		auditError(c, err)
+		return Audit{}, nil, false
+	}
+	items, err := database.GetAuditItems(client, audit)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get items"})
+		return Audit{}, nil, false
+	}
+	return audit, items, true
+}
+
+// Records the result of checking an item from the audit page
+// Takes the form values counted, or missing ("yes" if the item was not found)
+func checkAuditItem(c *gin.Context) {
+	id, err := strconv.Atoi(c.Params.ByName("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Audit ID"})
+		return
+	}
+	itemID, err := strconv.Atoi(c.Params.ByName("item"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Item ID"})
+		return
+	}
+	missing := c.PostForm("missing") == "yes"
+	counted := 0
+	if !missing {
+		if counted, err = strconv.Atoi(c.PostForm("counted")); err != nil || counted < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Quantity"})
+			return
+		}
+	}
+	if _, err := database.CheckAuditItem(client, id, itemID, counted, missing); err != nil {
+		auditError(c, err)
+		return
+	}
+	c.Redirect(http.StatusFound, fmt.Sprintf("/audits/%d#item-%d", id, itemID))
+}
+
+// Finishes an audit from the audit page and shows the box
+func finishAudit(c *gin.Context) {
+	id, err := strconv.Atoi(c.Params.ByName("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Audit ID"})
+		return
+	}
+	audit, err := actorDatabase(c).FinishAudit(client, id)
+	if errors.Is(err, ErrAuditIncomplete) {
+		c.Redirect(http.StatusFound, fmt.Sprintf("/audits/%d?%s", id, url.Values{"error": {err.Error()}}.Encode()))
+		return
+	}
+	if err != nil {
+		auditError(c, err)
+		return
+	}
+	c.Redirect(http.StatusFound, fmt.Sprintf("/box/%d", audit.BoxID))
+}
+
+// Abandons an audit from the audit page without changing anything
+func cancelAudit(c *gin.Context) {
+	audit, _, ok := auditWithItems(c)
+	if !ok {
+		return
+	}
+	if err := database.CancelAudit(client, audit.ID); err != nil {
+		auditError(c, err)
+		return
+	}
+	c.Redirect(http.StatusFound, fmt.Sprintf("/box/%d", audit.BoxID))
+}
+
+// Responds with the status matching an error of the audit actions
+func auditError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		c.JSON(http.StatusNotFound, gin.H{"fail": "audit, box or item does not exist"})
+	case errors.Is(err, ErrAuditFinished), errors.Is(err, ErrAuditIncomplete):
+		c.JSON(http.StatusConflict, gin.H{"fail": err.Error()})
+	default:
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not update audit"})
+	}
+}
+
+// Sets the move status and destination room of a box from the box page
+// Takes the form values status (empty to take the box out of the move) and destination
+func setBoxStatus(c *gin.Context) {
+	boxID, err := strconv.Atoi(c.Params.ByName("boxid"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Box ID"})
+		return
+	}
+	destination := strings.TrimSpace(c.PostForm("destination"))
+	if !setBoxStatusOrAbort(c, []int{boxID}, c.PostForm("status"), &destination) {
+		return
+	}
+	c.Redirect(http.StatusFound, fmt.Sprintf("/box/%d", boxID))
+}
+
+// Sets the destination room, unless it is nil, and then the status of boxes and responds with an error if that fails.
+// The destination goes first so the status change records it.
+func setBoxStatusOrAbort(c *gin.Context, boxIDs []int, status string, destination *string) bool {
+	if status != "" && !validBoxStatus(status) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": ErrInvalidStatus.Error()})
+		return false
+	}
+	db := actorDatabase(c)
+	if destination != nil {
+		if err := db.SetBoxDestination(client, boxIDs, *destination); err != nil {
+			moveError(c, err)
+			return false
+		}
+	}
+	if _, err := db.SetBoxStatus(client, boxIDs, status); err != nil {
+		moveError(c, err)
+		return false
+	}
+	return true
+}
+
+// Shows how many boxes of the selected inventory are in each status per destination room,
+// and the latest status changes
+func getMove(c *gin.Context) {
+	report, ok := moveReport(c)
+	if !ok {
+		return
+	}
+	renderHTML(c, http.StatusOK, "move.tmpl", report)
+}
+
+// Collects the move dashboard of the selected inventory and responds with an error if that fails
+func moveReport(c *gin.Context) (gin.H, bool) {
+	inventory, ok := requestInventory(c)
+	if !ok {
+		return nil, false
+	}
+	dashboard, err := database.GetMoveDashboard(client, inventory.ID)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not count boxes"})
+		return nil, false
+	}
+	changes, err := database.GetBoxStatusChanges(client, inventory.ID, 100)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get status changes"})
+		return nil, false
+	}
+	return gin.H{
+		"inventory": inventory,
+		"dashboard": dashboard,
+		"changes":   changes,
+	}, true
+}
+
+// Responds to an error changing the status or destination of boxes
+func moveError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		c.JSON(http.StatusNotFound, gin.H{"fail": "box does not exist"})
+	case errors.Is(err, ErrInvalidStatus):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not update box status"})
+	}
+}
+
+// Lists all active share links with buttons to revoke them
+func getShares(c *gin.Context) {
+	shares, err := database.GetActiveShares(client)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get share links"})
+		return
+	}
+	shares = usableShares(c, shares)
+	created, _ := strconv.Atoi(c.Query("created"))
+	renderHTML(c, http.StatusOK, "shares.tmpl", gin.H{
+		"shares":  shares,
+		"BaseURL": absoluteURL(c, ""),
+		"created": created,
+	})
+}
+
+// Revokes a share link from the list of share links
+func revokeShare(c *gin.Context) {
+	id, err := strconv.Atoi(c.Params.ByName("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Share ID"})
+		return
+	}
+	if err := database.RevokeShare(client, id); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not revoke share link"})
+		return
+	}
+	c.Redirect(http.StatusFound, "/shares")
+}
+
+// Shows the contents of a shared box read-only to anyone with a valid share link.
+// The page only shows that box and has no links into the rest of the inventory.
+func getSharedBox(c *gin.Context) {
+	// Keep the token out of caches, search engines and the referrer of links on the page
+	c.Header("Cache-Control", "private, no-store")
+	c.Header("Referrer-Policy", "no-referrer")
+	c.Header("X-Robots-Tag", "noindex")
+	share, err := database.ResolveShareToken(client, c.Params.ByName("token"))
+	if errors.Is(err, ErrInvalidShareToken) {
+		renderHTML(c, http.StatusNotFound, "shared.tmpl", gin.H{"invalid": true})
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not check share link"})
+		return
+	}
+	contents, err := database.GetBoxContent(client, share.BoxID)
+	if err != nil || len(contents) == 0 {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get box contents"})
+		return
+	}
+	renderHTML(c, http.StatusOK, "shared.tmpl", gin.H{
+		"share":    share,
+		"contents": contents,
+	})
+}
+
+// Shows the API tokens with forms to create and revoke them
+func getAPITokens(c *gin.Context) {
+	renderAPITokens(c, http.StatusOK, gin.H{})
+}
+
+// Renders the API token page with the current tokens and the given values
+func renderAPITokens(c *gin.Context, status int, values gin.H) {
+	tokens, err := database.GetAPITokens(client)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get API tokens"})
+		return
+	}
+	values["tokens"] = tokens
+	if values["inventories"], err = database.GetInventories(client); err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get inventories"})
+		return
+	}
+	renderHTML(c, status, "tokens.tmpl", values)
+}
+
+// Creates an API token and shows it once
+// Takes the form values name, scope (read, write or admin) and inventory (optional, repeatable) to limit the token to
+func createAPIToken(c *gin.Context) {
+	name := strings.TrimSpace(c.PostForm("name"))
+	if name == "" {
+		renderAPITokens(c, http.StatusBadRequest, gin.H{"error": "Name is required"})
+		return
+	}
+	scopes, err := parseScopes(c.PostForm("scope"))
+	if err != nil {
+		renderAPITokens(c, http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	inventoryIDs, err := parseIDs(c.PostFormArray("inventory"))
+	if err != nil {
+		renderAPITokens(c, http.StatusBadRequest, gin.H{"error": "Invalid Inventory ID"})
+		return
+	}
+	token, secret, err := database.CreateAPIToken(client, name, scopes, inventoryIDs)
+	if errors.Is(err, ErrUnknownInventory) {
+		renderAPITokens(c, http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not create API token"})
+		return
+	}
+	renderAPITokens(c, http.StatusCreated, gin.H{"created": token, "secret": secret})
+}
+
+// Revokes an API token from the API token page
+func revokeAPIToken(c *gin.Context) {
+	id, err := strconv.Atoi(c.Params.ByName("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Token ID"})
+		return
+	}
+	if err := database.RevokeAPIToken(client, id); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not revoke API token"})
+		return
+	}
+	c.Redirect(http.StatusFound, "/admin/tokens")
+}
+
+// Shows the sign in form
+func getLogin(c *gin.Context) {
+	renderHTML(c, http.StatusOK, "login.tmpl", gin.H{"next": loginTarget(c.Query("next"))})
+}
+
+// Signs a user in and sends them to the page they came from
+// Takes the form values username, password and next
+func postLogin(c *gin.Context) {
+	next := loginTarget(c.PostForm("next"))
+	user, err := database.Authenticate(client, c.PostForm("username"), c.PostForm("password"))
+	if errors.Is(err, ErrInvalidLogin) {
+		renderHTML(c, http.StatusUnauthorized, "login.tmpl", gin.H{
+			"next":     next,
+			"username": c.PostForm("username"),
+			"error":    "Invalid username or password",
+		})
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not sign in"})
+		return
+	}
+	token, _, err := database.CreateSession(client, user.ID)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not sign in"})
+		return
+	}
+	setSessionCookie(c, token, int(sessionLifetime.Seconds()))
+	c.Redirect(http.StatusFound, next)
+}
+
+// Ends the session of the user
+func logout(c *gin.Context) {
+	if token, err := c.Cookie(sessionCookie); err == nil && token != "" {
+		if err := database.DeleteSession(client, token); err != nil {
+			log.Println(err)
+		}
+	}
+	setSessionCookie(c, "", -1)
+	c.Redirect(http.StatusFound, "/login")
+}
+
+// Shows the user accounts with forms to create, change and delete them
+func getUsers(c *gin.Context) {
+	renderUsers(c, http.StatusOK, gin.H{})
+}
+
+// Renders the user page with the current users and the given values
+func renderUsers(c *gin.Context, status int, values gin.H) {
+	users, err := database.GetUsers(client)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get users"})
+		return
+	}
+	values["users"] = users
+	values["roles"] = userRoles
+	if values["inventories"], err = database.GetInventories(client); err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get inventories"})
+		return
+	}
+	renderHTML(c, status, "users.tmpl", values)
+}
+
+// Creates a user account
+// Takes the form values username, password and role
+func createUser(c *gin.Context) {
+	_, err := database.CreateUser(client, c.PostForm("username"), c.PostForm("password"), c.PostForm("role"))
+	if errors.Is(err, ErrInvalidRole) || errors.Is(err, ErrWeakPassword) {
+		renderUsers(c, http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		renderUsers(c, http.StatusBadRequest, gin.H{"error": "could not create user, the name may be taken"})
+		return
+	}
+	c.Redirect(http.StatusFound, "/admin/users")
+}
+
+// Changes the role of a user
+// Takes the form value role
+func setUserRole(c *gin.Context) {
+	id, err := strconv.Atoi(c.Params.ByName("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid User ID"})
+		return
+	}
+	err = database.SetUserRole(client, id, c.PostForm("role"))
+	if errors.Is(err, ErrInvalidRole) || errors.Is(err, ErrLastAdmin) {
+		renderUsers(c, http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not change role"})
+		return
+	}
+	c.Redirect(http.StatusFound, "/admin/users")
+}
+
+// Deletes a user account
+func deleteUser(c *gin.Context) {
+	id, err := strconv.Atoi(c.Params.ByName("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid User ID"})
+		return
+	}
+	err = database.DeleteUser(client, id)
+	if errors.Is(err, ErrLastAdmin) {
+		renderUsers(c, http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not delete user"})
+		return
+	}
+	c.Redirect(http.StatusFound, "/admin/users")
+}
+
+// Returns the inventory the request works in and responds with an error if there is none
+func requestInventory(c *gin.Context) (Inventory, bool) {
+	inventory, err := currentInventory(c)
+	if errors.Is(err, ErrInventoryForbidden) {
+		c.JSON(http.StatusForbidden, gin.H{"fail": err.Error()})
+		return Inventory{}, false
+	}
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get inventory"})
+		return Inventory{}, false
+	}
+	return inventory, true
+}
+
+// Shows the inventories with forms to create, rename and delete them
+func getInventories(c *gin.Context) {
+	renderInventories(c, http.StatusOK, gin.H{})
+}
+
+// Renders the inventory page with the inventories the user may use and the given values
+func renderInventories(c *gin.Context, status int, values gin.H) {
+	inventories, err := accessibleInventories(c)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get inventories"})
+		return
+	}
+	values["inventories"] = inventories
+	if current, err := currentInventory(c); err == nil {
+		values["current"] = current
+	}
+	renderHTML(c, status, "inventories.tmpl", values)
+}
+
+// Selects the inventory the browser works in and shows its boxes
+func selectInventoryPage(c *gin.Context) {
+	id, ok := usableInventoryParam(c)
+	if !ok {
+		return
+	}
+	selectInventory(c, id)
+	c.Redirect(http.StatusFound, "/")
+}
+
+// Selects the inventory of a scanned QR code before jumping to what its short code points to
+func resolveInventoryShortCode(c *gin.Context) {
+	id, ok := usableInventoryParam(c)
+	if !ok {
+		return
+	}
+	selectInventory(c, id)
+	resolveShortCode(c)
+}
+
+// Parses the inventory route parameter and checks the user may use it
+func usableInventoryParam(c *gin.Context) (int, bool) {
+	id, err := strconv.Atoi(c.Params.ByName("inventory"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Inventory ID"})
+		return 0, false
+	}
+	if _, err := database.GetInventory(client, id); errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"fail": "inventory does not exist"})
+		return 0, false
+	} else if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get inventory"})
+		return 0, false
+	}
+	if !canUseInventory(c, id) {
+		c.JSON(http.StatusForbidden, gin.H{"fail": ErrInventoryForbidden.Error()})
+		return 0, false
+	}
+	return id, true
+}
+
+// Creates an inventory and selects it
+// Takes the form value name
+func createInventory(c *gin.Context) {
+	inventory, err := database.CreateInventory(client, c.PostForm("name"))
+	if err != nil {
+		log.Println(err)
+		renderInventories(c, http.StatusBadRequest, gin.H{"error": "could not create inventory, the name may be taken"})
+		return
+	}
+	selectInventory(c, inventory.ID)
+	c.Redirect(http.StatusFound, "/inventories")
+}
+
+// Renames an inventory
+// Takes the form value name
+func renameInventory(c *gin.Context) {
+	id, err := strconv.Atoi(c.Params.ByName("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Inventory ID"})
+		return
+	}
+	err = database.RenameInventory(client, id, c.PostForm("name"))
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"fail": "inventory does not exist"})
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		renderInventories(c, http.StatusBadRequest, gin.H{"error": "could not rename inventory, the name may be taken"})
+		return
+	}
+	c.Redirect(http.StatusFound, "/inventories")
+}
+
+// Deletes an inventory without boxes
+func deleteInventory(c *gin.Context) {
+	id, err := strconv.Atoi(c.Params.ByName("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Inventory ID"})
+		return
+	}
+	err = database.DeleteInventory(client, id)
+	if errors.Is(err, ErrInventoryNotEmpty) {
+		renderInventories(c, http.StatusBadRequest, gin.H{"error": "Move or delete the boxes of the inventory first"})
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not delete inventory"})
+		return
+	}
+	c.Redirect(http.StatusFound, "/inventories")
+}
+
+// Moves a box with its contents to another inventory from the box page
+// Takes the form value inventory_id
+func moveBoxToInventory(c *gin.Context) {
+	boxID, err := strconv.Atoi(c.Params.ByName("boxid"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Box ID"})
+		return
+	}
+	inventoryID, err := strconv.Atoi(c.PostForm("inventory_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Inventory ID"})
+		return
+	}
+	if !moveBoxOrAbort(c, boxID, inventoryID) {
+		return
+	}
+	c.Redirect(http.StatusFound, fmt.Sprintf("/box/%d", boxID))
+}
+
+// Moves a box to another inventory the user may use and responds with an error if that fails
+func moveBoxOrAbort(c *gin.Context, boxID int, inventoryID int) bool {
+	if !canUseInventory(c, inventoryID) {
+		c.JSON(http.StatusForbidden, gin.H{"fail": ErrInventoryForbidden.Error()})
+		return false
+	}
+	err := actorDatabase(c).MoveBoxToInventory(client, boxID, inventoryID)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"fail": "box or inventory does not exist"})
+		return false
+	}
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not move box"})
+		return false
+	}
+	return true
+}
+
+// Limits a user to some inventories from the user page
+// Takes the form values inventory (repeatable), none for all inventories
+func setUserInventories(c *gin.Context) {
+	id, err := strconv.Atoi(c.Params.ByName("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid User ID"})
+		return
+	}
+	inventoryIDs, err := parseIDs(c.PostFormArray("inventory"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Inventory ID"})
+		return
+	}
+	err = database.SetUserInventories(client, id, inventoryIDs)
+	if errors.Is(err, ErrUnknownInventory) {
+		renderUsers(c, http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not change inventories"})
+		return
+	}
+	c.Redirect(http.StatusFound, "/admin/users")
+}
+
+// API endpoint to search boxes based on name or label name
+// Method: GET
+// URL: /api/v0/box
+// Query Param: search
+// Example: curl http://localhost/api/v0/box?search=box
+func apiGetBox(c *gin.Context) {
+	query := c.DefaultQuery("search", "")
+	inventory, ok := requestInventory(c)
+	if !ok {
+		return
+	}
+	boxes, err := database.GetBoxesByTextV0(client, inventory.ID, query)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "could not query boxes. Internal Server Error.",
+		})
+		return
+	}
+	if len(boxes) == 0 {
+		boxes = make([]Box, 0)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "success",
+		"count":   len(boxes),
+		"result":  boxes,
+	})
+
+}
+
+// API endpoint to move one item to another box
+// Method: PATCH
+// URL: /api/v0/item/move
+// Body: { "targetBox": 1, "sourceBox": 2, "sourceItem": 10 }
+// Example: curl -XPATCH http://localhost/api/v0/item/move -d '{ "targetBox": 1, "sourceBox": 2, "sourceItem": 10 }'
+func apiMoveItem(c *gin.Context) {
+	type MoveRequest struct {
+		TargetBox  int `json:"targetBox" binding:"required"`
+		SourceBox  int `json:"sourceBox" binding:"required"`
+		SourceItem int `json:"sourceItem" binding:"required"`
+	}
+	var req MoveRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !canUseBox(c, req.SourceBox) || !canUseBox(c, req.TargetBox) {
+		c.JSON(http.StatusForbidden, gin.H{"fail": ErrInventoryForbidden.Error()})
+		return
+	}
+	err := actorDatabase(c).MoveItem(client, req.SourceBox, req.TargetBox, req.SourceItem)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not move item"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "item moved",
+		"id":       req.SourceItem,
+		"oldBoxId": req.SourceBox,
+		"newBoxId": req.TargetBox,
+	})
+}
+
+// API endpoint to get the inventories the user or token may use
+// Method: GET
+// URL: /api/v1/inventories
+// Example: curl http://localhost/api/v1/inventories
+func apiGetInventories(c *gin.Context) {
+	inventories, err := accessibleInventories(c)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get inventories"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "success",
+		"count":   len(inventories),
+		"result":  inventories,
+	})
+}
+
+// API endpoint to move a box with all its contents to another inventory
+// Method: PUT
+// URL: /api/v1/boxes/:id/inventory
+// Body: { "inventory_id": 2 }
+// Example: curl -XPUT http://localhost/api/v1/boxes/1/inventory -d '{ "inventory_id": 2 }'
+func apiMoveBoxToInventory(c *gin.Context) {
+	type MoveRequest struct {
+		InventoryID int `json:"inventory_id" binding:"required"`
+	}
+	id, err := strconv.Atoi(c.Params.ByName("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Box ID"})
+		return
+	}
+	var req MoveRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !moveBoxOrAbort(c, id, req.InventoryID) {
+		return
+	}
+	box, err := database.GetBox(client, id)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get box"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "box moved",
+		"result":  box,
+	})
+}
+
+// API endpoint to get a single box including its items
+// Method: GET
+// URL: /api/v1/boxes/:id
+// The ETag header holds the version of the box which can be sent back with If-Match on updates
+// Example: curl -i http://localhost/api/v1/boxes/1
+func apiGetBoxV1(c *gin.Context) {
+	id, err := strconv.Atoi(c.Params.ByName("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Box ID"})
+		return
+	}
+	box, err := database.GetBox(client, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"fail": "box does not exist"})
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get box"})
+		return
+	}
+	items, err := database.GetItemsByBox(client, id)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get box contents"})
+		return
+	}
+	c.Header("ETag", versionETag(box.Version))
+	c.JSON(http.StatusOK, gin.H{
+		"message": "success",
+		"result":  box,
+		"items":   items,
+	})
+}
+
+// API endpoint to update the name and label of a box
+// Method: PUT
+// URL: /api/v1/boxes/:id
+// Header: If-Match (optional) with the ETag of the box. Responds with 412 if the box has been changed since.
+// Body: { "name": "Tools", "label": "Garage" }
+// Example: curl -XPUT http://localhost/api/v1/boxes/1 -H 'If-Match: "3"' -d '{ "name": "Tools", "label": "Garage" }'
+func apiUpdateBoxV1(c *gin.Context) {
+	type UpdateRequest struct {
+		Name  string `json:"name" binding:"required"`
+		Label string `json:"label"`
+	}
+	id, err := strconv.Atoi(c.Params.ByName("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Box ID"})
+		return
+	}
+	version, _, err := ifMatchVersion(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var req UpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	err = actorDatabase(c).UpdateBox(client, id, req.Name, req.Label, version)
+	if errors.Is(err, ErrVersionConflict) {
+		c.JSON(http.StatusPreconditionFailed, gin.H{"fail": "box was changed by someone else"})
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusNotFound, gin.H{"fail": "could not update box"})
+		return
+	}
+	box, err := database.GetBox(client, id)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get box"})
+		return
+	}
+	c.Header("ETag", versionETag(box.Version))
+	c.JSON(http.StatusOK, gin.H{
+		"message": "box updated",
+		"result":  box,
+	})
+}
+
+// API endpoint to list the boxes of an inventory, newest first unless sorted by weight
+// Query Params: inventory, status, destination, room (boxes with more than that many grams of their max load left)
+// and sort (heaviest or lightest), all optional
+// Method: GET
+// URL: /api/v1/boxes
+// Example: curl http://localhost/api/v1/boxes?room=5000&sort=lightest
+func apiGetBoxesV1(c *gin.Context) {
+	filter, err := parseBoxFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	inventory, ok := requestInventory(c)
+	if !ok {
+		return
+	}
+	boxes, err := database.GetBoxesFiltered(client, inventory.ID, filter)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get boxes"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "success",
+		"count":   len(boxes),
+		"result":  boxes,
+	})
+}
+
+// API endpoint to set the outer size of a box in cm, its empty weight and the load it carries in grams
+// Fields left out are removed.
+// Method: PUT
+// URL: /api/v1/boxes/:id/size
+// Body: { "length": 60, "width": 40, "height": 40, "empty_weight": 800, "max_load": 20000 }
+// Example: curl -XPUT http://localhost/api/v1/boxes/1/size -d '{ "length": 60, "width": 40, "height": 40, "max_load": 20000 }'
+func apiSetBoxSize(c *gin.Context) {
+	type SizeRequest struct {
+		Length      *int `json:"length" binding:"omitempty,min=0"`
+		Width       *int `json:"width" binding:"omitempty,min=0"`
+		Height      *int `json:"height" binding:"omitempty,min=0"`
+		EmptyWeight *int `json:"empty_weight" binding:"omitempty,min=0"`
+		MaxLoad     *int `json:"max_load" binding:"omitempty,min=0"`
+	}
+	id, err := strconv.Atoi(c.Params.ByName("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Box ID"})
+		return
+	}
+	var req SizeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	optional := func(value *int) sql.NullInt64 {
+		if value == nil {
+			return sql.NullInt64{}
+		}
+		return sql.NullInt64{Int64: int64(*value), Valid: true}
+	}
+	size := BoxSize{
+		Length:      optional(req.Length),
+		Width:       optional(req.Width),
+		Height:      optional(req.Height),
+		EmptyWeight: optional(req.EmptyWeight),
+		MaxLoad:     optional(req.MaxLoad),
+	}
+	if !setBoxSizeOrAbort(c, id, size) {
+		return
+	}
+	box, err := database.GetBox(client, id)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get box"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "success",
+		"result":  box,
+	})
+}
+
+// API endpoint to get a single item
+// Method: GET
+// URL: /api/v1/items/:id
+// The ETag header holds the version of the item which can be sent back with If-Match on updates
+// Example: curl -i http://localhost/api/v1/items/10
+func apiGetItemV1(c *gin.Context) {
+	id, err := strconv.Atoi(c.Params.ByName("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Item ID"})
+		return
+	}
+	item, err := database.GetItem(client, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"fail": "item does not exist"})
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get item"})
+		return
+	}
+	c.Header("ETag", versionETag(item.Version))
+	c.JSON(http.StatusOK, gin.H{
+		"message": "success",
+		"result":  item,
+	})
+}
+
+// API endpoint to update the name and quantity of an item
+// Method: PUT
+// URL: /api/v1/items/:id
+// Header: If-Match (optional) with the ETag of the item. Responds with 412 if the item has been changed since.
+// Body: { "name": "Screws", "quantity": 20, "expires_at": "2025-12-31", "min_quantity": 5, "target_quantity": 30, "barcode": "4006381333931", "labeled": false, "weight": 3 }
+// The weight of one unit is in grams.
+// Example: curl -XPUT http://localhost/api/v1/items/10 -H 'If-Match: "2"' -d '{ "name": "Screws", "quantity": 20 }'
+func apiUpdateItemV1(c *gin.Context) {
+	type UpdateRequest struct {
+		Name           string `json:"name" binding:"required"`
+		Quantity       int    `json:"quantity" binding:"min=0"`
+		ExpiresAt      string `json:"expires_at"`
+		MinQuantity    *int   `json:"min_quantity" binding:"omitempty,min=0"`
+		TargetQuantity *int   `json:"target_quantity" binding:"omitempty,min=0"`
+		Barcode        string `json:"barcode"`
+		Labeled        bool   `json:"labeled"`
+		Weight         *int   `json:"weight" binding:"omitempty,min=0"`
+	}
+	id, err := strconv.Atoi(c.Params.ByName("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Item ID"})
+		return
+	}
+	version, _, err := ifMatchVersion(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var req UpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var attrs ItemAttributes
+	attrs.ExpiresAt, err = parseOptionalDate(req.ExpiresAt)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Expiration Date"})
+		return
+	}
+	if req.MinQuantity != nil {
+		attrs.MinQuantity = sql.NullInt64{Int64: int64(*req.MinQuantity), Valid: true}
+	}
+	if req.TargetQuantity != nil {
+		attrs.TargetQuantity = sql.NullInt64{Int64: int64(*req.TargetQuantity), Valid: true}
+	}
+	attrs.Barcode, err = parseOptionalBarcode(req.Barcode)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Weight != nil {
+		attrs.Weight = sql.NullInt64{Int64: int64(*req.Weight), Valid: true}
+	}
+	attrs.Labeled = req.Labeled
+	err = actorDatabase(c).UpdateBoxContent(client, 0, id, req.Name, req.Quantity, attrs, version)
+	if errors.Is(err, ErrVersionConflict) {
+		c.JSON(http.StatusPreconditionFailed, gin.H{"fail": "item was changed by someone else"})
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusNotFound, gin.H{"fail": "could not update item"})
+		return
+	}
+	item, err := database.GetItem(client, id)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get item"})
+		return
+	}
+	c.Header("ETag", versionETag(item.Version))
+	c.JSON(http.StatusOK, gin.H{
+		"message": "item updated",
+		"result":  item,
+	})
+}
+
+// API endpoint to take units of an item out of stock
+// Method: POST
+// URL: /api/v1/items/:id/consume
+// Body (optional): { "delta": 1, "note": "dinner" }
+// Example: curl -XPOST http://localhost/api/v1/items/10/consume -d '{ "delta": 2 }'
+func apiConsumeItem(c *gin.Context) {
+	adjustItemQuantity(c, MovementConsume, -1)
+}
+
+// API endpoint to put units of an item back into stock
+// Method: POST
+// URL: /api/v1/items/:id/restock
+// Body (optional): { "delta": 1, "note": "bought at the market" }
+// Example: curl -XPOST http://localhost/api/v1/items/10/restock -d '{ "delta": 6 }'
+func apiRestockItem(c *gin.Context) {
+	adjustItemQuantity(c, MovementRestock, 1)
+}
+
+// Changes the quantity of an item by the delta of the request in the given direction
+func adjustItemQuantity(c *gin.Context, reason string, sign int) {
+	type AdjustRequest struct {
+		Delta int    `json:"delta" binding:"omitempty,min=1"`
+		Note  string `json:"note"`
+	}
+	id, err := strconv.Atoi(c.Params.ByName("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Item ID"})
+		return
+	}
+	var req AdjustRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if req.Delta == 0 {
+		req.Delta = 1
+	}
+	movement, err := actorDatabase(c).AdjustItemQuantity(client, id, sign*req.Delta, reason, req.Note)
+	if errors.Is(err, ErrInsufficientStock) {
+		c.JSON(http.StatusConflict, gin.H{"fail": err.Error()})
+		return
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"fail": "item does not exist"})
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not change quantity"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "quantity changed",
+		"quantity": movement.QuantityAfter,
+		"result":   movement,
+	})
+}
+
+// API endpoint to show the latest quantity changes of an item
+// Method: GET
+// URL: /api/v1/items/:id/movements
+// Query Param: limit (optional, default 100)
+// Example: curl http://localhost/api/v1/items/10/movements?limit=20
+func apiGetStockMovements(c *gin.Context) {
+	id, err := strconv.Atoi(c.Params.ByName("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Item ID"})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Limit"})
+		return
+	}
+	if _, err := database.GetItem(client, id); errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"fail": "item does not exist"})
+		return
+	}
+	movements, err := database.GetStockMovements(client, id, limit)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get stock movements"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "success",
+		"count":   len(movements),
+		"result":  movements,
+	})
+}
+
+// API endpoint to show the latest moves of an item between boxes
+// Method: GET
+// URL: /api/v1/items/:id/moves
+// Query Param: limit (optional, default 100)
+// Example: curl http://localhost/api/v1/items/10/moves
+func apiGetItemMoves(c *gin.Context) {
+	id, err := strconv.Atoi(c.Params.ByName("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Item ID"})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Limit"})
+		return
+	}
+	if _, err := database.GetItem(client, id); errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"fail": "item does not exist"})
+		return
+	}
+	moves, err := database.GetItemMoves(client, id, limit)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get item moves"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "success",
+		"count":   len(moves),
+		"result":  moves,
+	})
+}
+
+// API endpoint to move an item with a QR label back into its home box
+// Method: POST
+// URL: /api/v1/items/:id/return
+// Example: curl -XPOST http://localhost/api/v1/items/10/return
+func apiReturnItem(c *gin.Context) {
+	id, err := strconv.Atoi(c.Params.ByName("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Item ID"})
+		return
+	}
+	item, err := actorDatabase(c).ReturnItem(client, id)
+	if err != nil {
+		itemError(c, err)
+		return
+	}
+	c.Header("ETag", versionETag(item.Version))
+	c.JSON(http.StatusOK, gin.H{
+		"message": "item returned",
+		"result":  item,
+	})
+}
+
+// API endpoint to show the loans of an item, newest first
+// Method: GET
+// URL: /api/v1/items/:id/loans
+// Query Param: limit (optional, default 100)
+// Example: curl http://localhost/api/v1/items/10/loans
+func apiGetItemLoans(c *gin.Context) {
+	id, err := strconv.Atoi(c.Params.ByName("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Item ID"})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Limit"})
+		return
+	}
+	if _, err := database.GetItem(client, id); errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"fail": "item does not exist"})
+		return
+	}
+	loans, err := database.GetItemLoans(client, id, limit)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get loans"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "success",
+		"count":   len(loans),
+		"result":  loans,
+	})
+}
+
+// API endpoint to lend an item to someone. Without a quantity everything not lent out yet is lent.
+// Method: POST
+// URL: /api/v1/items/:id/loans
+// Body: {"borrower": "Alex", "quantity": 1, "due": "2024-06-30", "note": "for the camping trip", "remind": true}
+// Example: curl -XPOST -H "Content-Type: application/json" -d '{"borrower":"Alex","due":"2024-06-30","remind":true}' http://localhost/api/v1/items/10/loans
+func apiLendItem(c *gin.Context) {
+	id, err := strconv.Atoi(c.Params.ByName("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Item ID"})
+		return
+	}
+	var body struct {
+		Borrower string `json:"borrower" binding:"required"`
+		Quantity int    `json:"quantity" binding:"min=0"`
+		Due      string `json:"due"`
+		Note     string `json:"note"`
+		Remind   bool   `json:"remind"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	due, err := parseOptionalDate(body.Due)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Due Date"})
+		return
+	}
+	loan, err := actorDatabase(c).LendItem(client, id, strings.TrimSpace(body.Borrower), body.Quantity, due, strings.TrimSpace(body.Note), body.Remind)
+	if err != nil {
+		loanError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "success",
+		"result":  loan,
+	})
+}
+
+// API endpoint to set what an item cost and is worth in cents of the configured currency and its serial number
+// Fields left out are removed.
+// Method: PUT
+// URL: /api/v1/items/:id/value
+// Body: { "purchase_price": 129900, "purchased_at": "2024-03-01", "current_value": 80000, "serial_number": "C02XL0GYJGH5" }
+// Example: curl -XPUT http://localhost/api/v1/items/10/value -d '{ "purchase_price": 129900, "serial_number": "C02XL0GYJGH5" }'
+func apiSetItemValue(c *gin.Context) {
+	id, err := strconv.Atoi(c.Params.ByName("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Item ID"})
+		return
+	}
+	var body struct {
+		PurchasePrice *int64 `json:"purchase_price" binding:"omitempty,min=0"`
+		PurchasedAt   string `json:"purchased_at"`
+		CurrentValue  *int64 `json:"current_value" binding:"omitempty,min=0"`
+		SerialNumber  string `json:"serial_number"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	optional := func(value *int64) sql.NullInt64 {
+		if value == nil {
+			return sql.NullInt64{}
+		}
+		return sql.NullInt64{Int64: *value, Valid: true}
+	}
+	value := ItemValue{PurchasePrice: optional(body.PurchasePrice), CurrentValue: optional(body.CurrentValue)}
+	if value.PurchasedAt, err = parseOptionalDate(body.PurchasedAt); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Purchase Date"})
+		return
+	}
+	serial := strings.TrimSpace(body.SerialNumber)
+	value.SerialNumber = sql.NullString{String: serial, Valid: serial != ""}
+	if err := actorDatabase(c).SetItemValue(client, id, value); err != nil {
+		itemError(c, err)
+		return
+	}
+	item, err := database.GetItem(client, id)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get item"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "success",
+		"result":  item,
+	})
+}
+
+// API endpoint to set the photo of an item, which is stored as a JPEG of at most 1024 pixels
+// Method: PUT
+// URL: /api/v1/items/:id/photo
+// Body: JPEG, PNG or GIF image, either raw or as the form field "photo"
+// Example: curl -XPUT http://localhost/api/v1/items/10/photo --data-binary @laptop.jpg
+func apiSetItemPhoto(c *gin.Context) {
+	id, err := strconv.Atoi(c.Params.ByName("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Item ID"})
+		return
+	}
+	if !setItemPhotoOrAbort(c, id, "photo") {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "photo saved",
+		"id":      id,
+	})
+}
+
+// API endpoint to remove the photo of an item
+// Method: DELETE
+// URL: /api/v1/items/:id/photo
+// Example: curl -XDELETE http://localhost/api/v1/items/10/photo
+func apiDeleteItemPhoto(c *gin.Context) {
+	id, err := strconv.Atoi(c.Params.ByName("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Item ID"})
+		return
+	}
+	if err := actorDatabase(c).DeleteItemPhoto(client, id); err != nil {
+		itemError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "photo deleted",
+		"id":      id,
+	})
+}
+
+// API endpoint to get the loans that are still out
+// Method: GET
+// URL: /api/v1/loans
+// Query Params: overdue (optional, true to only get the loans past their due date) and inventory (optional)
+// Example: curl http://localhost/api/v1/loans?overdue=true
+func apiGetLoans(c *gin.Context) {
+	overdue, err := strconv.ParseBool(c.DefaultQuery("overdue", "false"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Overdue Flag"})
+		return
+	}
+	inventory, ok := requestInventory(c)
+	if !ok {
+		return
+	}
+	loans, err := database.GetOpenLoans(client, inventory.ID, overdue)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get loans"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "success",
+		"count":   len(loans),
+		"result":  loans,
+	})
+}
+
+// API endpoint to check a loan back in, to the given box or to the home box of the item or else the box it is in.
+// Checking in to another box moves the item there, or only the returned part if the rest of it stayed.
+// Method: POST
+// URL: /api/v1/loans/:id/return
+// Body (optional): {"box_id": 3}
+// Example: curl -XPOST -H "Content-Type: application/json" -d '{"box_id":3}' http://localhost/api/v1/loans/5/return
+func apiReturnLoan(c *gin.Context) {
+	id, err := strconv.Atoi(c.Params.ByName("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Loan ID"})
+		return
+	}
+	var body struct {
+		BoxID int `json:"box_id"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Body"})
+			return
+		}
+	}
+	loan, ok := returnLoanOrAbort(c, id, body.BoxID)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "loan returned",
+		"result":  loan,
+	})
+}
+
+// API endpoint to get the consumption rate of an item and when it is expected to run out
+// Method: GET
+// URL: /api/v1/items/:id/stats
+// Query Param: days (optional, default 28) to look back
+// Example: curl http://localhost/api/v1/items/10/stats?days=14
+func apiGetConsumptionStats(c *gin.Context) {
+	id, err := strconv.Atoi(c.Params.ByName("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Item ID"})
+		return
+	}
+	days, err := strconv.Atoi(c.DefaultQuery("days", "28"))
+	if err != nil || days < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Number of Days"})
+		return
+	}
+	stats, err := database.GetConsumptionStats(client, id, days)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"fail": "item does not exist"})
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get consumption stats"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "success",
+		"result":  stats,
+	})
+}
+
+// API endpoint to add a scanned product to a box
+// Increases the quantity of the item with that barcode in the box or creates it from the product catalog.
+// Method: POST
+// URL: /api/v1/boxes/:id/scan
+// Body: { "barcode": "4006381333931", "quantity": 1 }
+// Example: curl -XPOST http://localhost/api/v1/boxes/12/scan -d '{ "barcode": "4006381333931" }'
+func apiScanIntoBox(c *gin.Context) {
+	type ScanRequest struct {
+		Barcode  string `json:"barcode" binding:"required"`
+		Quantity int    `json:"quantity" binding:"min=0"`
+	}
+	id, err := strconv.Atoi(c.Params.ByName("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Box ID"})
+		return
+	}
+	var req ScanRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	result, err := actorDatabase(c).ScanBarcode(client, id, req.Barcode, req.Quantity)
+	if err != nil {
+		scanError(c, err)
+		return
+	}
+	status := http.StatusOK
+	if result.Action == "created" {
+		status = http.StatusCreated
+	}
+	c.JSON(status, gin.H{
+		"message": "item " + result.Action,
+		"result":  result,
+	})
+}
+
+// Adds a scanned product to a box from the barcode field on the box page
+// Redirects the user back to the box
+func scanIntoBox(c *gin.Context) {
+	boxid, err := strconv.Atoi(c.Params.ByName("boxid"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Box ID"})
+		return
+	}
+	_, err = actorDatabase(c).ScanBarcode(client, boxid, c.PostForm("barcode"), 0)
+	if err != nil {
+		scanError(c, err)
+		return
+	}
+	c.Redirect(http.StatusFound, fmt.Sprintf("/box/%d", boxid))
+}
+
+// Responds with the status matching an error of ScanBarcode
+func scanError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrInvalidBarcode):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, sql.ErrNoRows):
+		c.JSON(http.StatusNotFound, gin.H{"fail": "box does not exist"})
+	case errors.Is(err, ErrUnknownBarcode):
+		c.JSON(http.StatusNotFound, gin.H{"fail": err.Error()})
+	default:
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not add scanned item"})
+	}
+}
+
+// API endpoint to look up a product in the local catalog
+// Method: GET
+// URL: /api/v1/catalog/:barcode
+// Example: curl http://localhost/api/v1/catalog/4006381333931
+func apiGetProduct(c *gin.Context) {
+	barcode, err := normalizeBarcode(c.Params.ByName("barcode"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	product, err := database.GetProduct(client, barcode)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"fail": "product does not exist"})
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get product"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "success",
+		"result":  product,
+	})
+}
+
+// API endpoint to add a product to the local catalog or correct it by hand
+// Products saved this way are never overwritten by imports.
+// Method: PUT
+// URL: /api/v1/catalog/:barcode
+// Body: { "name": "Pencil", "brand": "Staedtler", "unit": "1 pc", "default_quantity": 1 }
+// Example: curl -XPUT http://localhost/api/v1/catalog/4006381333931 -d '{ "name": "Pencil" }'
+func apiSaveProduct(c *gin.Context) {
+	type SaveRequest struct {
+		Name            string `json:"name" binding:"required"`
+		Brand           string `json:"brand"`
+		Unit            string `json:"unit"`
+		DefaultQuantity int    `json:"default_quantity" binding:"min=0"`
+	}
+	barcode, err := normalizeBarcode(c.Params.ByName("barcode"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var req SaveRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.DefaultQuantity == 0 {
+		req.DefaultQuantity = 1
+	}
+	product := Product{Barcode: barcode, Name: req.Name, DefaultQuantity: req.DefaultQuantity}
+	product.Brand.NullString = sql.NullString{String: req.Brand, Valid: req.Brand != ""}
+	product.Unit.NullString = sql.NullString{String: req.Unit, Valid: req.Unit != ""}
+	if err := database.SaveProduct(client, product); err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not save product"})
+		return
+	}
+	product, err = database.GetProduct(client, barcode)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get product"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "product saved",
+		"result":  product,
+	})
+}
+
+// API endpoint to import products from an Open Food Facts dump or a CSV file into the catalog
+// The file can be uploaded as the form field "file" or sent as the request body.
+// Method: POST
+// URL: /api/v1/catalog/import
+// Example: curl -XPOST http://localhost/api/v1/catalog/import --data-binary @en.openfoodfacts.org.products.csv.gz
+func apiImportCatalog(c *gin.Context) {
+	r, err := uploadedFile(c, "file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	defer r.Close()
+	result, err := database.ImportProducts(client, r)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":  err.Error(),
+			"result": result,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "catalog imported",
+		"result":  result,
+	})
+}
+
+// Returns the file uploaded as the given multipart form field, or the request body for any other content type
+func uploadedFile(c *gin.Context, field string) (io.ReadCloser, error) {
+	if c.ContentType() != "multipart/form-data" {
+		return c.Request.Body, nil
+	}
+	file, _, err := c.Request.FormFile(field)
+	return file, err
+}
+
+// Looks up what a code points to among the boxes and items the requester may use
+func resolveCode(c *gin.Context, code DecodedCode) (CodeResolution, error) {
+	resolution, err := database.ResolveCode(client, code)
+	if err != nil {
+		return resolution, err
+	}
+	return usableResolution(c, resolution)
+}
+
+// Decodes the uploaded image of a request and resolves every code found in it
+// The image can be uploaded as the form field "image" or sent as the request body.
+func decodeUpload(c *gin.Context) ([]CodeResolution, int, error) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImageUploadSize)
+	r, err := uploadedFile(c, "image")
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	defer r.Close()
+	codes, err := decodeCodes(r)
+	if errors.Is(err, ErrNoCodeFound) {
+		return nil, http.StatusUnprocessableEntity, err
+	}
+	if err != nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("could not read image: %w", err)
+	}
+	resolutions := make([]CodeResolution, 0, len(codes))
+	for _, code := range codes {
+		resolution, err := resolveCode(c, code)
+		if err != nil {
+			log.Println(err)
+			return nil, http.StatusInternalServerError, errors.New("could not resolve code")
+		}
+		resolutions = append(resolutions, resolution)
+	}
+	return resolutions, http.StatusOK, nil
+}
+
+// API endpoint to decode QR codes and barcodes in an image and resolve them to boxes and items
+// Method: POST
+// URL: /api/v1/decode
+// Body: JPEG, PNG or GIF image, either raw or as the form field "image"
+// Example: curl -XPOST http://localhost/api/v1/decode -F image=@photo.jpg
+func apiDecodeImage(c *gin.Context) {
+	resolutions, status, err := decodeUpload(c)
+	if err != nil {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "success",
+		"count":   len(resolutions),
+		"result":  resolutions,
+	})
+}
+
+// Shows the page to upload a photo of a QR code or barcode
+func getScan(c *gin.Context) {
+	renderHTML(c, http.StatusOK, "scan.tmpl", gin.H{})
+}
+
+// Decodes an uploaded photo and jumps to what it shows
+// While a scan session is running the codes are applied to it instead.
+func postScan(c *gin.Context) {
+	resolutions, status, err := decodeUpload(c)
+	if err != nil {
+		renderHTML(c, status, "scan.tmpl", gin.H{"error": err.Error()})
+		return
+	}
+	if session, ok := currentScanSession(c); ok {
+		for _, resolution := range resolutions {
+			if err := actorDatabase(c).ApplyScan(client, &session, resolution); err != nil {
+				log.Println(err)
+				c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not apply scan"})
+				return
+			}
+		}
+		c.Redirect(http.StatusFound, "/scan/session")
+		return
+	}
+	showResolutions(c, resolutions)
+}
+
+// Jumps to what a code opened as /s/:code points to.
+// While a scan session is running the session page asks to apply the code instead, GET requests never change anything.
+func resolveShortCode(c *gin.Context) {
+	code := c.Params.ByName("code")
+	if _, ok := currentScanSession(c); ok {
+		c.Redirect(http.StatusFound, "/scan/session?code="+url.QueryEscape(code))
+		return
+	}
+	resolution, err := resolveCode(c, DecodedCode{Format: "text", Text: code})
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not resolve code"})
+		return
+	}
+	showResolutions(c, []CodeResolution{resolution})
+}
+
+// A box code redirects to the box, an item code to the item page and barcodes of items in a single box
+// to that box. Everything else is listed on the scan page.
+func showResolutions(c *gin.Context, resolutions []CodeResolution) {
+	boxes := make(map[int]bool)
+	for _, resolution := range resolutions {
+		if resolution.Kind == ResolvedBox {
+			c.Redirect(http.StatusFound, fmt.Sprintf("/box/%d", resolution.BoxID))
+			return
+		}
+		if resolution.Kind == ResolvedItem {
+			c.Redirect(http.StatusFound, fmt.Sprintf("/item/%d", resolution.Items[0].ID))
+			return
+		}
+		for _, item := range resolution.Items {
+			boxes[item.BoxID] = true
+		}
+	}
+	if len(boxes) == 1 {
+		for boxID := range boxes {
+			c.Redirect(http.StatusFound, fmt.Sprintf("/box/%d", boxID))
+			return
+		}
+	}
+	renderHTML(c, http.StatusOK, "scan.tmpl", gin.H{"resolutions": resolutions})
+}
+
+// Returns the scan session of the browser if one is running
+func currentScanSession(c *gin.Context) (ScanSession, bool) {
+	id, err := c.Cookie(scanSessionCookie)
+	if err != nil {
+		return ScanSession{}, false
+	}
+	session, err := database.GetScanSession(client, id)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Println(err)
+		}
+		return ScanSession{}, false
+	}
+	// The active box may have been scanned by someone allowed into more inventories
+	if session.BoxID.Valid && !canUseBox(c, int(session.BoxID.Int64)) {
+		session.BoxID = sql.NullInt64{}
+		session.BoxName = sql.NullString{}
+	}
+	return session, true
+}
+
+// Shows the running scan session with the contents of the active box,
+// or the latest status changes if the session marks boxes with a status.
+// Takes the optional query parameter code to fill in a scanned code waiting to be applied.
+func getScanSession(c *gin.Context) {
+	session, ok := currentScanSession(c)
+	if !ok {
+		renderHTML(c, http.StatusOK, "scansession.tmpl", gin.H{"statuses": boxStatuses})
+		return
+	}
+	if session.Status.Valid {
+		inventory, ok := requestInventory(c)
+		if !ok {
+			return
+		}
+		changes, err := database.GetBoxStatusChanges(client, inventory.ID, 20)
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get status changes"})
+			return
+		}
+		renderHTML(c, http.StatusOK, "scansession.tmpl", gin.H{
+			"session": session,
+			"changes": changes,
+			"code":    c.Query("code"),
+		})
+		return
+	}
+	items := make([]Item, 0)
+	if session.BoxID.Valid {
+		var err error
+		items, err = database.GetItemsByBox(client, int(session.BoxID.Int64))
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get box contents"})
+			return
+		}
+	}
+	renderHTML(c, http.StatusOK, "scansession.tmpl", gin.H{
+		"session": session,
+		"items":   items,
+		"code":    c.Query("code"),
+	})
+}
+
+// Starts a scan session for the browser
+// Takes the optional form value status to mark every box scanned with it
+func startScanSession(c *gin.Context) {
+	session, err := database.CreateScanSession(client, c.PostForm("status"))
+	if errors.Is(err, ErrInvalidStatus) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not start scan session"})
+		return
+	}
+	// Other sites must not be able to apply codes to the session
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie(scanSessionCookie, session.ID, int(scanSessionIdleTimeout.Seconds()), "/", "", secureRequest(c), true)
+	c.Redirect(http.StatusFound, "/scan/session")
+}
+
+// Applies a code typed or scanned into the code field of the scan session page
+func postScanSessionCode(c *gin.Context) {
+	session, ok := currentScanSession(c)
+	if !ok {
+		c.Redirect(http.StatusFound, "/scan/session")
+		return
+	}
+	resolution, err := resolveCode(c, DecodedCode{Format: "text", Text: c.PostForm("code")})
+	if err == nil {
+		err = actorDatabase(c).ApplyScan(client, &session, resolution)
+	}
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not apply scan"})
+		return
+	}
+	c.Redirect(http.StatusFound, "/scan/session")
+}
+
+// Ends the scan session of the browser
+func endScanSession(c *gin.Context) {
+	if session, ok := currentScanSession(c); ok {
+		if err := database.EndScanSession(client, session.ID); err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not end scan session"})
+			return
+		}
+	}
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie(scanSessionCookie, "", -1, "/", "", secureRequest(c), true)
+	c.Redirect(http.StatusFound, "/scan")
+}
+
+// API endpoint to look up what a code points to
+// Codes are box codes (A-012), item codes (I45), barcodes or links to boxes.
+// Method: GET
+// URL: /api/v1/resolve/:code
+// Example: curl http://localhost/api/v1/resolve/4006381333931
+func apiResolveCode(c *gin.Context) {
+	resolution, err := resolveCode(c, DecodedCode{Format: "text", Text: c.Params.ByName("code")})
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not resolve code"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "success",
+		"result":  resolution,
+	})
+}
+
+// API endpoint rendering labels for thermal label printers
+// Formats are zpl for Zebra printers and ql for the raster commands of Brother QL printers.
+// Method: GET
+// URL: /api/v1/labels
+// Query Params: format, box and item (optional, repeatable) like the labels page
+// Example: curl -o labels.zpl "http://localhost/api/v1/labels?format=zpl&box=3"
+func apiRenderLabels(c *gin.Context) {
+	labels, ok := requestedLabels(c, c.QueryArray("box"), c.QueryArray("item"))
+	if !ok {
+		return
+	}
+	format := c.Query("format")
+	data, contentType, extension, err := labelPrinting.Render(format, labels, absoluteURL(c, ""))
+	if err != nil {
+		printError(c, err)
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=labels-%s.%s", format, extension))
+	c.Data(http.StatusOK, contentType, data)
+}
+
+// API endpoint sending labels to the thermal label printer of a format over raw TCP
+// Method: POST
+// URL: /api/v1/labels/print
+// Query Params: format, box and item (optional, repeatable) like the labels page
+// Example: curl -X POST "http://localhost/api/v1/labels/print?format=ql&item=45"
+func apiPrintLabels(c *gin.Context) {
+	labels, ok := requestedLabels(c, c.QueryArray("box"), c.QueryArray("item"))
+	if !ok {
+		return
+	}
+	if err := labelPrinting.Print(c.Query("format"), labels, absoluteURL(c, "")); err != nil {
+		printError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "success",
+		"count":   len(labels),
+	})
+}
+
+// API endpoint to list all share links that are neither expired nor revoked
+// Method: GET
+// URL: /api/v1/shares
+// Example: curl http://localhost/api/v1/shares
+func apiGetShares(c *gin.Context) {
+	shares, err := database.GetActiveShares(client)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get share links"})
+		return
+	}
+	shares = usableShares(c, shares)
+	c.JSON(http.StatusOK, gin.H{
+		"message": "success",
+		"count":   len(shares),
+		"result":  shares,
+	})
+}
+
+// API endpoint to create a read-only share link for a box
+// The link is the path /shared/<token> of the result.
+// Method: POST
+// URL: /api/v1/boxes/:id/shares
+// Body: {"days": 7, "note": "Neighbor"} (both optional)
+// Example: curl -X POST -H "Content-Type: application/json" -d '{"days":3}' http://localhost/api/v1/boxes/12/shares
+func apiCreateShare(c *gin.Context) {
+	id, err := strconv.Atoi(c.Params.ByName("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Box ID"})
+		return
+	}
+	var body struct {
+		Days int    `json:"days"`
+		Note string `json:"note"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Body"})
+			return
+		}
+	}
+	if body.Days == 0 {
+		body.Days = defaultShareDays
+	}
+	if body.Days < 1 || body.Days > maxShareDays {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("days must be between 1 and %d", maxShareDays)})
+		return
+	}
+	share, err := database.CreateShare(client, id, time.Now().AddDate(0, 0, body.Days), body.Note)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"fail": "box does not exist"})
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not create share link"})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "success",
+		"result":  share,
+		"url":     absoluteURL(c, share.Path()),
+	})
+}
+
+// API endpoint to revoke a share link
+// Method: DELETE
+// URL: /api/v1/shares/:id
+// Example: curl -X DELETE http://localhost/api/v1/shares/3
+func apiRevokeShare(c *gin.Context) {
+	id, err := strconv.Atoi(c.Params.ByName("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Share ID"})
+		return
+	}
+	err = database.RevokeShare(client, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"fail": "share link does not exist"})
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not revoke share link"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "success",
+	})
+}
+
+// API endpoint to start an audit of a box, or to get the one going on, with the items to check
+// Method: POST
+// URL: /api/v1/boxes/:id/audits
+// Example: curl -XPOST http://localhost/api/v1/boxes/12/audits
+func apiStartAudit(c *gin.Context) {
+	boxID, err := strconv.Atoi(c.Params.ByName("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Box ID"})
+		return
+	}
+	audit, err := database.StartAudit(client, boxID)
+	if err != nil {
+		auditError(c, err)
+		return
+	}
+	items, err := database.GetAuditItems(client, audit)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get items"})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "success",
+		"result":  audit,
+		"items":   items,
+	})
+}
+
+// API endpoint to get an audit with the items of its box and their results so far
+// Method: GET
+// URL: /api/v1/audits/:id
+// Example: curl http://localhost/api/v1/audits/4
+func apiGetAudit(c *gin.Context) {
+	audit, items, ok := auditWithItems(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "success",
+		"result":  audit,
+		"items":   items,
+	})
+}
+
+// API endpoint to record the counted quantity of an item during an audit, or that it is missing
+// Method: PUT
+// URL: /api/v1/audits/:id/items/:item
+// Body: {"counted": 3} or {"missing": true}
+// Example: curl -XPUT -H "Content-Type: application/json" -d '{"counted":3}' http://localhost/api/v1/audits/4/items/10
+func apiCheckAuditItem(c *gin.Context) {
+	id, err := strconv.Atoi(c.Params.ByName("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Audit ID"})
+		return
+	}
+	itemID, err := strconv.Atoi(c.Params.ByName("item"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Item ID"})
+		return
+	}
+	var body struct {
+		Counted *int `json:"counted" binding:"omitempty,min=0"`
+		Missing bool `json:"missing"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if body.Counted == nil && !body.Missing {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "counted or missing is required"})
+		return
+	}
+	counted := 0
+	if body.Counted != nil {
+		counted = *body.Counted
+	}
+	entry, err := database.CheckAuditItem(client, id, itemID, counted, body.Missing)
+	if err != nil {
+		auditError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "success",
+		"result":  entry,
+	})
+}
+
+// API endpoint to finish an audit once every item was checked. Differences are applied to the items
+// and the box is marked as verified.
+// Method: POST
+// URL: /api/v1/audits/:id/finish
+// Example: curl -XPOST http://localhost/api/v1/audits/4/finish
+func apiFinishAudit(c *gin.Context) {
+	id, err := strconv.Atoi(c.Params.ByName("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Audit ID"})
+		return
+	}
+	audit, err := actorDatabase(c).FinishAudit(client, id)
+	if err != nil {
+		auditError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "audit finished",
+		"result":  audit,
+	})
+}
+
+// API endpoint to abandon an audit without changing anything
+// Method: DELETE
+// URL: /api/v1/audits/:id
+// Example: curl -XDELETE http://localhost/api/v1/audits/4
+func apiCancelAudit(c *gin.Context) {
+	id, err := strconv.Atoi(c.Params.ByName("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Audit ID"})
+		return
+	}
+	if err := database.CancelAudit(client, id); err != nil {
+		auditError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "audit cancelled"})
+}
+
+// API endpoint to set the move status of a box and the room it goes to
+// Statuses are packing, sealed, in_transit, delivered and unpacked, an empty status takes the box out of the move.
+// The destination is kept if it is left out and removed if it is empty.
+// Method: PUT
+// URL: /api/v1/boxes/:id/status
+// Body: { "status": "sealed", "destination": "Kitchen" }
+// Example: curl -XPUT http://localhost/api/v1/boxes/1/status -d '{ "status": "sealed", "destination": "Kitchen" }'
+func apiSetBoxStatus(c *gin.Context) {
+	type StatusRequest struct {
+		Status      string  `json:"status"`
+		Destination *string `json:"destination"`
+	}
+	id, err := strconv.Atoi(c.Params.ByName("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Box ID"})
+		return
+	}
+	var req StatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !setBoxStatusOrAbort(c, []int{id}, req.Status, req.Destination) {
+		return
+	}
+	box, err := database.GetBox(client, id)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get box"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "success",
+		"result":  box,
+	})
+}
+
+// API endpoint to set the move status of many boxes at once from their scanned codes
+// Codes are box codes (A-012) or links to boxes. The destination is only changed if it is given.
+// Method: POST
+// URL: /api/v1/move/scan
+// Body: { "status": "delivered", "codes": ["A-012", "A-013"], "destination": "Kitchen" }
+// Example: curl -XPOST http://localhost/api/v1/move/scan -d '{ "status": "delivered", "codes": ["A-012", "A-013"] }'
+func apiScanBoxStatus(c *gin.Context) {
+	type ScanRequest struct {
+		Status      string   `json:"status" binding:"required"`
+		Codes       []string `json:"codes" binding:"required"`
+		Destination *string  `json:"destination"`
+	}
+	var req ScanRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	boxIDs := make([]int, 0, len(req.Codes))
+	for _, code := range req.Codes {
+		resolution, err := database.ResolveCode(client, DecodedCode{Format: "text", Text: code})
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not resolve code"})
+			return
+		}
+		if resolution.Kind != ResolvedBox {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s is not a box", code)})
+			return
+		}
+		if !canUseBox(c, resolution.BoxID) {
+			c.JSON(http.StatusForbidden, gin.H{"fail": ErrInventoryForbidden.Error()})
+			return
+		}
+		boxIDs = append(boxIDs, resolution.BoxID)
+	}
+	if !setBoxStatusOrAbort(c, boxIDs, req.Status, req.Destination) {
+		return
+	}
+	boxes := make([]Box, 0, len(boxIDs))
+	for _, id := range boxIDs {
+		box, err := database.GetBox(client, id)
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get box"})
+			return
+		}
+		boxes = append(boxes, box)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "success",
+		"count":   len(boxes),
+		"result":  boxes,
+	})
+}
+
+// API endpoint to count the boxes of a move per status and destination room, with the latest status changes
+// Method: GET
+// URL: /api/v1/move
+// Query Param: inventory (optional)
+// Example: curl http://localhost/api/v1/move
+func apiGetMove(c *gin.Context) {
+	report, ok := moveReport(c)
+	if !ok {
+		return
+	}
+	delete(report, "inventory")
+	report["message"] = "success"
+	c.JSON(http.StatusOK, report)
+}
+
+// API endpoint to get the boxes not verified in a number of months, the audits going on and
+// the differences found by the latest audits
+// Method: GET
+// URL: /api/v1/audits
+// Query Params: months (optional, default 6) and inventory (optional)
+// Example: curl http://localhost/api/v1/audits?months=12
+func apiGetAuditReport(c *gin.Context) {
+	report, ok := auditReport(c)
+	if !ok {
+		return
+	}
+	delete(report, "inventory")
+	report["message"] = "success"
+	c.JSON(http.StatusOK, report)
+}
+
+// API endpoint to get the latest changes to boxes and items with the users who made them, newest first
+// Method: GET
+// URL: /api/v1/changes
+// Query Params: box (optional) to only get changes touching this box, limit (optional, default 100)
+// Example: curl http://localhost/api/v1/changes?box=12
+func apiGetChanges(c *gin.Context) {
+	boxid := 0
+	if boxParam := c.Query("box"); boxParam != "" {
+		var err error
+		if boxid, err = strconv.Atoi(boxParam); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Box ID"})
+			return
+		}
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit < 1 || limit > 1000 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Limit"})
+		return
+	}
+	changes, err := database.GetChanges(client, boxid, limit)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get changes"})
+		return
+	}
+	changes = usableChanges(c, changes)
+	c.JSON(http.StatusOK, gin.H{
+		"message": "success",
+		"count":   len(changes),
+		"result":  changes,
+	})
+}
+
+// API endpoint streaming box and item changes as Server-Sent Events
+// Method: GET
+// URL: /api/v1/events/stream
+// Query Param: box (optional) to only receive events touching this box
+// Example: curl -N http://localhost/api/v1/events/stream?box=12
+func apiEventStream(c *gin.Context) {
+	var filter func(Event) bool
+	if boxParam := c.Query("box"); boxParam != "" {
+		boxid, err := strconv.Atoi(boxParam)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Box ID"})
+			return
+		}
+		filter = func(e Event) bool { return e.Touches(boxid) }
+	}
+	events, unsubscribe := database.Events.Subscribe(eventStreamBuffer, filter)
+	defer unsubscribe()
+	// Users and tokens limited to some inventories only see changes there
+	limited := len(inventoryGrants(c)) > 0
+
+	// Disable response buffering of reverse proxies such as nginx
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	keepAlive := time.NewTicker(eventStreamKeepAlive)
+	defer keepAlive.Stop()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case event := <-events:
+			if !limited || canSeeEvent(c, event) {
+				c.SSEvent(event.Type, event)
+			}
+			return true
+		case <-keepAlive.C:
+			io.WriteString(w, ": keep-alive\n\n")
+			return true
+		}
+	})
+}
+
+// API endpoint to list all webhook subscriptions
+// Method: GET
+// URL: /api/v1/webhooks
+// Example: curl http://localhost/api/v1/webhooks
+func apiGetWebhooks(c *gin.Context) {
+	webhooks, err := database.GetWebhooks(client)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get webhooks"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "success",
+		"count":   len(webhooks),
+		"result":  webhooks,
+	})
+}
+
+// API endpoint to subscribe a url to box and item events
+// Method: POST
+// URL: /api/v1/webhooks
+// Body: { "url": "https://example.com/hook", "events": ["item.created", "item.moved"], "secret": "optional" }
+// Deliveries are signed with HMAC-SHA256 of the body in the X-WITB-Signature header. A secret is generated if none is provided.
+// Example: curl -XPOST http://localhost/api/v1/webhooks -d '{ "url": "https://example.com/hook", "events": ["*"] }'
+func apiCreateWebhook(c *gin.Context) {
+	type CreateRequest struct {
+		URL    string   `json:"url" binding:"required"`
+		Events []string `json:"events"`
+		Secret string   `json:"secret"`
+	}
+	var req CreateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Events == nil {
+		req.Events = []string{"*"}
+	}
+	if err := validateWebhook(req.URL, req.Events); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Secret == "" {
+		secret, err := newWebhookSecret()
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not generate webhook secret"})
+			return
+		}
+		req.Secret = secret
+	}
+	webhook, err := database.CreateWebhook(client, req.URL, req.Events, req.Secret)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not create webhook"})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "webhook created",
+		"result":  webhook,
+	})
+}
+
+// API endpoint to remove a webhook subscription including its pending deliveries
+// Method: DELETE
+// URL: /api/v1/webhooks/:id
+// Example: curl -XDELETE http://localhost/api/v1/webhooks/1
+func apiDeleteWebhook(c *gin.Context) {
+	id, err := strconv.Atoi(c.Params.ByName("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Webhook ID"})
+		return
+	}
+	err = database.DeleteWebhook(client, id)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not delete webhook"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "webhook deleted",
+		"id":      id,
+	})
+}
+
+// API endpoint to show the latest delivery attempts of a webhook
+// Method: GET
+// URL: /api/v1/webhooks/:id/deliveries
+// Example: curl http://localhost/api/v1/webhooks/1/deliveries
+func apiGetWebhookDeliveries(c *gin.Context) {
+	id, err := strconv.Atoi(c.Params.ByName("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Webhook ID"})
+		return
+	}
+	if _, err := database.GetWebhook(client, id); errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"fail": "webhook does not exist"})
+		return
+	}
+	deliveries, err := database.GetWebhookDeliveries(client, id, 100)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get webhook deliveries"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "success",
+		"count":   len(deliveries),
+		"result":  deliveries,
+	})
+}
+
+// Shows all items below their minimum quantity grouped by name
+func getShoppingList(c *gin.Context) {
+	inventory, ok := requestInventory(c)
+	if !ok {
+		return
+	}
+	entries, err := database.GetShoppingList(client, inventory.ID)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get shopping list"})
+		return
+	}
+	renderHTML(c, http.StatusOK, "shopping.tmpl", gin.H{
+		"entries":   entries,
+		"inventory": inventory,
+	})
+}
+
+// Shows the insurance report of the selected inventory
+// Query Param: format (html, csv or pdf, default html)
+func getInsurance(c *gin.Context) {
+	inventory, ok := requestInventory(c)
+	if !ok {
+		return
+	}
+	report, err := database.GetInsuranceReport(client, inventory)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get insurance report"})
+		return
+	}
+	if format := c.DefaultQuery("format", "html"); format != "html" {
+		serveInsuranceReport(c, report, format)
+		return
+	}
+	renderHTML(c, http.StatusOK, "insurance.tmpl", gin.H{
+		"report":    report,
+		"inventory": inventory,
+	})
+}
+
+// API endpoint to get the insurance report: the items with a value, a serial number or a photo grouped by location and box
+// Amounts are in cents of the configured currency, the location of a box is its label.
+// Method: GET
+// URL: /api/v1/insurance?format=json|csv|pdf&inventory=1
+// Example: curl -o insurance.pdf "http://localhost/api/v1/insurance?format=pdf"
+func apiGetInsurance(c *gin.Context) {
+	inventory, ok := requestInventory(c)
+	if !ok {
+		return
+	}
+	report, err := database.GetInsuranceReport(client, inventory)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get insurance report"})
+		return
+	}
+	serveInsuranceReport(c, report, c.DefaultQuery("format", "json"))
+}
+
+// Serves the insurance report as JSON, CSV or PDF
+func serveInsuranceReport(c *gin.Context, report InsuranceReport, format string) {
+	switch format {
+	case "json":
+		c.JSON(http.StatusOK, gin.H{
+			"message": "success",
+			"count":   report.Items,
+			"result":  report,
+		})
+	case "csv":
+		photoURL := func(itemID int) string {
+			return absoluteURL(c, fmt.Sprintf("/item/%d/photo", itemID))
+		}
+		c.Header("Content-Disposition", `attachment; filename="insurance.csv"`)
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		if err := writeInsuranceCSV(c.Writer, report, photoURL); err != nil {
+			log.Println(err)
+		}
+	case "pdf":
+		photos, err := database.GetInsurancePhotos(client, report)
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get photos"})
+			return
+		}
+		c.Header("Content-Disposition", `inline; filename="insurance.pdf"`)
+		c.Data(http.StatusOK, "application/pdf", insurancePDF(report, photos))
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be one of json, csv or pdf"})
+	}
+}
+
+// Checks off an entry of the shopping list by adding the bought quantity to its items
+// Takes the item name and the bought quantity as form values
+func checkShoppingListEntry(c *gin.Context) {
+	quantity, err := strconv.Atoi(c.PostForm("quantity"))
+	if err != nil || quantity < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Quantity"})
+		return
+	}
+	inventory, ok := requestInventory(c)
+	if !ok {
+		return
+	}
+	_, err = actorDatabase(c).CheckOffShoppingListEntry(client, inventory.ID, c.PostForm("name"), quantity)
+	if err != nil && !errors.Is(err, ErrNotOnShoppingList) {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not check off item"})
+		return
+	}
+	c.Redirect(http.StatusFound, "/shopping-list")
+}
+
+// API endpoint to get the shortfalls of all items of an inventory aggregated by name across all boxes
+// Method: GET
+// URL: /api/v1/shopping-list?format=json|text|markdown&inventory=1
+// Example: curl http://localhost/api/v1/shopping-list?format=markdown
+func apiGetShoppingList(c *gin.Context) {
+	inventory, ok := requestInventory(c)
+	if !ok {
+		return
+	}
+	entries, err := database.GetShoppingList(client, inventory.ID)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not get shopping list"})
+		return
+	}
+	switch c.DefaultQuery("format", "json") {
+	case "json":
+		c.JSON(http.StatusOK, gin.H{
+			"message": "success",
+			"count":   len(entries),
+			"result":  entries,
+		})
+	case "text":
+		c.Header("Content-Disposition", `inline; filename="shopping-list.txt"`)
+		c.String(http.StatusOK, shoppingListText(entries))
+	case "markdown":
+		c.Header("Content-Disposition", `inline; filename="shopping-list.md"`)
+		c.Data(http.StatusOK, "text/markdown; charset=utf-8", []byte(shoppingListMarkdown(entries)))
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be one of json, text or markdown"})
+	}
+}
+
+// API endpoint to check off a shopping list entry once it was bought
+// The quantity is added to the items with the biggest shortfall first
+// Method: POST
+// URL: /api/v1/shopping-list/check?inventory=1
+// Body: { "name": "AA batteries", "quantity": 4 }
+// Example: curl -XPOST http://localhost/api/v1/shopping-list/check -d '{ "name": "AA batteries", "quantity": 4 }'
+func apiCheckShoppingListEntry(c *gin.Context) {
+	type CheckRequest struct {
+		Name     string `json:"name" binding:"required"`
+		Quantity int    `json:"quantity" binding:"required,min=1"`
+	}
+	var req CheckRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	inventory, ok := requestInventory(c)
+	if !ok {
+		return
+	}
+	restocked, err := actorDatabase(c).CheckOffShoppingListEntry(client, inventory.ID, req.Name, req.Quantity)
+	if errors.Is(err, ErrNotOnShoppingList) {
+		c.JSON(http.StatusNotFound, gin.H{"fail": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"fail": "could not check off item"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "item checked off",
+		"count":   len(restocked),
+		"result":  restocked,
+	})
 }
 
 func main() {
+	// Run maintenance commands such as catalog imports instead of the web interface
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+	// Deliver webhooks for store changes in the background
+	NewWebhookDispatcher(client, webhookMaxAttempts).Start(database.Events)
+	// Publish store changes to MQTT if a broker is configured
+	if config := mqttConfigFromEnv(); config.Broker != "" {
+		publisher, err := NewMQTTPublisher(config, client)
+		if err != nil {
+			log.Fatal(err)
+		}
+		publisher.Start(database.Events)
+	}
+	// Send expiry and low stock notifications if at least one notifier is configured
+	if notifiers := notifiersFromEnv(); len(notifiers) > 0 {
+		scheduler, err := NewNotificationSchedulerFromEnv(client, notifiers)
+		if err != nil {
+			log.Fatal(err)
+		}
+		scheduler.Start()
+	}
+
 	// Initialize gin
 	router := gin.Default()
+	// Only accept requests from the authenticating proxy if trusted header authentication is configured
+	auth, err := trustedHeaderAuthFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if auth.Enabled() {
+		router.Use(auth.Middleware())
+	}
+	// Require signing in with a user account if the built-in user management is turned on
+	usersEnabled, err := parseBoolEnv("AUTH_USERS", false)
+	if err != nil {
+		log.Printf("invalid AUTH_USERS: %v", err)
+	}
+	if usersEnabled {
+		if users, err := database.GetUsers(client); err == nil && len(users) == 0 {
+			log.Println("AUTH_USERS is set but there are no users yet, create an admin with: witb user add <name> admin")
+		}
+		router.Use(userAuth())
+	}
+	// Only let users limited to some inventories at the boxes and items in them
+	router.Use(inventoryAccess())
+	// Reject forged cross-site requests to every route that changes something
+	router.Use(csrfProtect(csrfTrustedOriginsFromEnv()))
 	// Register helper functions for template rendering
 	router.SetFuncMap(template.FuncMap{
 		"formatAsDate": formatAsDate,
-		"add":          func(a, b int) int { return a + b },
-		"sub":          func(a, b int) int { return a - b },
+		"formatAsTime": formatAsTime,
+		"inputDate":    formatAsInputDate,
+		"inputInt":     formatOptionalInt,
+		// Reports whether a user or token may use an inventory
+		"inventoryGranted": inventoryGranted,
+		"statusLabel":      boxStatusLabel,
+		"kg":               formatKilograms,
+		"inputKg":          formatOptionalKilograms,
+		"money":            formatMoney,
+		"inputMoney":       formatOptionalCents,
+		"add":              func(a, b int) int { return a + b },
+		"sub":              func(a, b int) int { return a - b },
 		"seq": func(start int, end int) []int {
 			s := make([]int, end-start+1)
 			for i := range s {
@@ -403,15 +4025,141 @@ func main() {
 	box.POST("/:boxid/edit/:id", updateBoxContent)
 	box.POST("/:boxid/edit", updateBox)
 	box.POST("/:boxid/create", createItem)
+	box.POST("/:boxid/scan", scanIntoBox)
+	box.POST("/:boxid/share", createShare)
+	box.POST("/:boxid/inventory", moveBoxToInventory)
+	box.POST("/:boxid/audit", startAudit)
+	box.POST("/:boxid/status", setBoxStatus)
+	box.POST("/:boxid/size", setBoxSize)
 	box.GET("/:id", getBoxContent)
+	box.GET("/:id/qr", getBoxQR)
 
 	router.DELETE("/item", deleteItem)
+	router.GET("/item/:id", getItem)
+	router.GET("/item/:id/qr", getItemQR)
+	router.POST("/item/:id/return", returnItem)
+	router.POST("/item/:id/home", setItemHome)
+	router.POST("/item/:id/label", labelItem)
+	router.POST("/item/:id/lend", lendItem)
+	router.POST("/item/:id/value", setItemValue)
+	router.GET("/item/:id/photo", getItemPhoto)
+	router.POST("/item/:id/photo", uploadItemPhoto)
+	router.POST("/item/:id/photo/delete", deleteItemPhoto)
+	router.GET("/loans", getLoans)
+	router.POST("/loans/:id/return", returnLoan)
+	router.GET("/labels", getLabels)
+	router.POST("/labels/print", printLabels)
 
+	router.GET("/shopping-list", getShoppingList)
+	router.POST("/shopping-list/check", checkShoppingListEntry)
+	router.GET("/insurance", getInsurance)
+	router.GET("/scan", getScan)
+	router.POST("/scan", postScan)
+	router.GET("/scan/session", getScanSession)
+	router.POST("/scan/session", postScanSessionCode)
+	router.POST("/scan/session/start", startScanSession)
+	router.POST("/scan/session/end", endScanSession)
+	router.GET("/s/:code", resolveShortCode)
+	router.GET("/inventories", getInventories)
+	router.POST("/inventories", requireAdmin, createInventory)
+	router.POST("/inventories/:id/edit", requireAdmin, renameInventory)
+	router.POST("/inventories/:id/delete", requireAdmin, deleteInventory)
+	router.GET("/i/:inventory", selectInventoryPage)
+	router.GET("/i/:inventory/s/:code", resolveInventoryShortCode)
+	router.GET("/move", getMove)
+	router.GET("/audits", getAudits)
+	router.GET("/audits/:id", getAudit)
+	router.POST("/audits/:id/items/:item", checkAuditItem)
+	router.POST("/audits/:id/finish", finishAudit)
+	router.POST("/audits/:id/cancel", cancelAudit)
+	router.GET("/shares", getShares)
+	router.POST("/shares/:id/revoke", revokeShare)
+	router.GET("/shared/:token", getSharedBox)
+
+	if usersEnabled {
+		router.GET("/login", getLogin)
+		router.POST("/login", postLogin)
+		router.POST("/logout", logout)
+	}
+
+	// Group all admin pages together
+	admin := router.Group("/admin", requireAdmin)
+	admin.GET("/tokens", getAPITokens)
+	admin.POST("/tokens", createAPIToken)
+	admin.POST("/tokens/:id/revoke", revokeAPIToken)
+	admin.GET("/users", getUsers)
+	admin.POST("/users", createUser)
+	admin.POST("/users/:id/role", setUserRole)
+	admin.POST("/users/:id/delete", deleteUser)
+	admin.POST("/users/:id/inventories", setUserInventories)
+
+	// API tokens are checked on all API endpoints and can be made mandatory
+	requireToken, err := parseBoolEnv("API_REQUIRE_TOKEN", false)
+	if err != nil {
+		log.Printf("invalid API_REQUIRE_TOKEN: %v", err)
+	}
+	// Without anyone signing in everybody is an admin and could create a token for themselves
+	if requireToken && !usersEnabled && !auth.Enabled() {
+		log.Fatal("API_REQUIRE_TOKEN needs AUTH_USERS or AUTH_TRUSTED_HEADER, otherwise anyone could create API tokens at /admin/tokens")
+	}
 	// Group all API endpoints together
-	apiV0 := router.Group("/api/v0")
+	apiV0 := router.Group("/api/v0", apiTokenAuth(requireToken), inventoryAccess())
 	apiV0.GET("/box", apiGetBox)
 	apiV0.PATCH("/item/move", apiMoveItem)
 
+	apiV1 := router.Group("/api/v1", apiTokenAuth(requireToken), inventoryAccess())
+	apiV1.GET("/boxes", apiGetBoxesV1)
+	apiV1.GET("/boxes/:id", apiGetBoxV1)
+	apiV1.PUT("/boxes/:id", apiUpdateBoxV1)
+	apiV1.PUT("/boxes/:id/inventory", apiMoveBoxToInventory)
+	apiV1.GET("/inventories", apiGetInventories)
+	apiV1.POST("/boxes/:id/scan", apiScanIntoBox)
+	apiV1.GET("/items/:id", apiGetItemV1)
+	apiV1.PUT("/items/:id", apiUpdateItemV1)
+	apiV1.POST("/items/:id/consume", apiConsumeItem)
+	apiV1.POST("/items/:id/restock", apiRestockItem)
+	apiV1.GET("/items/:id/movements", apiGetStockMovements)
+	apiV1.GET("/items/:id/stats", apiGetConsumptionStats)
+	apiV1.GET("/items/:id/moves", apiGetItemMoves)
+	apiV1.POST("/items/:id/return", apiReturnItem)
+	apiV1.GET("/items/:id/loans", apiGetItemLoans)
+	apiV1.POST("/items/:id/loans", apiLendItem)
+	apiV1.PUT("/items/:id/value", apiSetItemValue)
+	apiV1.GET("/items/:id/photo", getItemPhoto)
+	apiV1.PUT("/items/:id/photo", apiSetItemPhoto)
+	apiV1.DELETE("/items/:id/photo", apiDeleteItemPhoto)
+	apiV1.GET("/loans", apiGetLoans)
+	apiV1.POST("/loans/:id/return", apiReturnLoan)
+	apiV1.GET("/events/stream", apiEventStream)
+	apiV1.GET("/webhooks", requireAdmin, apiGetWebhooks)
+	apiV1.POST("/webhooks", requireAdmin, apiCreateWebhook)
+	apiV1.DELETE("/webhooks/:id", requireAdmin, apiDeleteWebhook)
+	apiV1.GET("/webhooks/:id/deliveries", requireAdmin, apiGetWebhookDeliveries)
+	apiV1.GET("/shopping-list", apiGetShoppingList)
+	apiV1.POST("/shopping-list/check", apiCheckShoppingListEntry)
+	apiV1.GET("/insurance", apiGetInsurance)
+	apiV1.POST("/catalog/import", apiImportCatalog)
+	apiV1.GET("/catalog/:barcode", apiGetProduct)
+	apiV1.PUT("/catalog/:barcode", apiSaveProduct)
+	apiV1.POST("/decode", apiDecodeImage)
+	apiV1.GET("/resolve/:code", apiResolveCode)
+	apiV1.GET("/labels", apiRenderLabels)
+	apiV1.POST("/labels/print", apiPrintLabels)
+	apiV1.GET("/shares", apiGetShares)
+	apiV1.POST("/boxes/:id/shares", apiCreateShare)
+	apiV1.DELETE("/shares/:id", apiRevokeShare)
+	apiV1.GET("/changes", apiGetChanges)
+	apiV1.POST("/boxes/:id/audits", apiStartAudit)
+	apiV1.GET("/audits", apiGetAuditReport)
+	apiV1.GET("/audits/:id", apiGetAudit)
+	apiV1.PUT("/audits/:id/items/:item", apiCheckAuditItem)
+	apiV1.POST("/audits/:id/finish", apiFinishAudit)
+	apiV1.DELETE("/audits/:id", apiCancelAudit)
+	apiV1.PUT("/boxes/:id/status", apiSetBoxStatus)
+	apiV1.PUT("/boxes/:id/size", apiSetBoxSize)
+	apiV1.GET("/move", apiGetMove)
+	apiV1.POST("/move/scan", apiScanBoxStatus)
+
 	// Run the website and bind to port provided from env variable PORT with default 8088
 	router.Run(fmt.Sprintf("0.0.0.0:%s", getEnv("PORT", "8088")))
 }
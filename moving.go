@@ -0,0 +1,259 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Stages of a box in a house move, in the order they happen
+const (
+	BoxPacking   = "packing"
+	BoxSealed    = "sealed"
+	BoxInTransit = "in_transit"
+	BoxDelivered = "delivered"
+	BoxUnpacked  = "unpacked"
+)
+
+// All box statuses in the order they happen
+var boxStatuses = []string{BoxPacking, BoxSealed, BoxInTransit, BoxDelivered, BoxUnpacked}
+
+// Returned for a status that is not one of boxStatuses
+var ErrInvalidStatus = errors.New("unknown box status")
+
+// Reports whether status is one of boxStatuses
+func validBoxStatus(status string) bool {
+	for _, s := range boxStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the name of a box status shown to users
+func boxStatusLabel(status string) string {
+	if status == BoxInTransit {
+		return "In transit"
+	}
+	if status == "" {
+		return "No status"
+	}
+	return strings.ToUpper(status[:1]) + status[1:]
+}
+
+// A box reaching a status, with the room it was going to at that time
+type BoxStatusChange struct {
+	ID          int            `json:"id"`
+	BoxID       int            `json:"box_id"`
+	BoxName     string         `json:"box_name"`
+	Status      string         `json:"status"`
+	Destination JSONNullString `json:"destination"`
+	ChangedAt   time.Time      `json:"changed_at"`
+}
+
+// Number of boxes per status going to one destination room
+type MoveRow struct {
+	Destination JSONNullString `json:"destination"`
+	Counts      map[string]int `json:"counts"`
+	Total       int            `json:"total"`
+}
+
+// Boxes of a move counted per status and destination room
+type MoveDashboard struct {
+	Statuses []string       `json:"statuses"`
+	Rows     []MoveRow      `json:"rows"`
+	Totals   map[string]int `json:"totals"`
+	Total    int            `json:"total"`
+}
+
+// Sets the status of boxes and records the change for every box that did not have it yet.
+// An empty status takes the boxes out of the move without recording a change.
+// Returns the number of boxes whose status changed.
+func (d *Database) SetBoxStatus(db *sql.DB, boxIDs []int, status string) (int, error) {
+	if status != "" && !validBoxStatus(status) {
+		return 0, ErrInvalidStatus
+	}
+	// Start a transaction
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	// Defer a rollback in case something fails.
+	defer tx.Rollback()
+
+	var events []Event
+	for _, boxID := range boxIDs {
+		var current sql.NullString
+		if err := tx.QueryRow(`SELECT status FROM boxes WHERE id = ?`, boxID).Scan(&current); err != nil {
+			return 0, err
+		}
+		if current.String == status {
+			continue
+		}
+		query := `UPDATE boxes SET status = ?, version = version + 1, updated_at = CURRENT_TIMESTAMP WHERE id = ?`
+		if _, err := tx.Exec(query, sql.NullString{String: status, Valid: status != ""}, boxID); err != nil {
+			return 0, err
+		}
+		if status != "" {
+			query = `INSERT INTO box_status_changes (box_id, status, destination) SELECT id, status, destination FROM boxes WHERE id = ?`
+			if _, err := tx.Exec(query, boxID); err != nil {
+				return 0, err
+			}
+		}
+		event, err := d.recordEvent(tx, Event{Type: EventBoxUpdated, BoxID: boxID})
+		if err != nil {
+			return 0, err
+		}
+		events = append(events, event)
+	}
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	for _, event := range events {
+		d.publish(event)
+	}
+	return len(events), nil
+}
+
+// Sets the room boxes go to in a move, an empty destination removes it
+func (d *Database) SetBoxDestination(db *sql.DB, boxIDs []int, destination string) error {
+	// Start a transaction
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	// Defer a rollback in case something fails.
+	defer tx.Rollback()
+
+	query := `UPDATE boxes SET destination = ?, version = version + 1, updated_at = CURRENT_TIMESTAMP WHERE id = ?`
+	var events []Event
+	for _, boxID := range boxIDs {
+		result, err := tx.Exec(query, sql.NullString{String: destination, Valid: destination != ""}, boxID)
+		if err != nil {
+			return err
+		}
+		if updated, err := result.RowsAffected(); err != nil {
+			return err
+		} else if updated == 0 {
+			return sql.ErrNoRows
+		}
+		event, err := d.recordEvent(tx, Event{Type: EventBoxUpdated, BoxID: boxID})
+		if err != nil {
+			return err
+		}
+		events = append(events, event)
+	}
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	for _, event := range events {
+		d.publish(event)
+	}
+	return nil
+}
+
+// Counts the boxes of an inventory (0 for all) that are part of a move per destination and status
+func (d *Database) GetMoveDashboard(db *sql.DB, inventoryID int) (MoveDashboard, error) {
+	dashboard := MoveDashboard{Statuses: boxStatuses, Rows: make([]MoveRow, 0), Totals: make(map[string]int)}
+	query := `
+	SELECT destination, status, COUNT(*)
+	FROM boxes
+	WHERE status IS NOT NULL AND (? = 0 OR inventory_id = ?)
+	GROUP BY destination, status
+	ORDER BY destination IS NULL, destination`
+	rows, err := db.Query(query, inventoryID, inventoryID)
+	if err != nil {
+		return dashboard, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var destination JSONNullString
+		var status string
+		var count int
+		if err := rows.Scan(&destination.NullString, &status, &count); err != nil {
+			return dashboard, err
+		}
+		last := len(dashboard.Rows) - 1
+		if last < 0 || dashboard.Rows[last].Destination != destination {
+			dashboard.Rows = append(dashboard.Rows, MoveRow{Destination: destination, Counts: make(map[string]int)})
+			last++
+		}
+		dashboard.Rows[last].Counts[status] += count
+		dashboard.Rows[last].Total += count
+		dashboard.Totals[status] += count
+		dashboard.Total += count
+	}
+	if err := rows.Err(); err != nil {
+		return dashboard, err
+	}
+	return dashboard, nil
+}
+
+// Get the latest status changes of the boxes of an inventory (0 for all), newest first
+func (d *Database) GetBoxStatusChanges(db *sql.DB, inventoryID int, limit int) ([]BoxStatusChange, error) {
+	where := `WHERE ? = 0 OR boxes.inventory_id = ? ORDER BY box_status_changes.changed_at DESC, box_status_changes.id DESC LIMIT ?`
+	return d.queryBoxStatusChanges(db, where, inventoryID, inventoryID, limit)
+}
+
+// Get the status changes of a box in the order they happened
+func (d *Database) GetBoxStatusHistory(db *sql.DB, boxID int) ([]BoxStatusChange, error) {
+	where := `WHERE box_status_changes.box_id = ? ORDER BY box_status_changes.changed_at, box_status_changes.id`
+	return d.queryBoxStatusChanges(db, where, boxID)
+}
+
+// Queries status changes with the names of their boxes
+func (d *Database) queryBoxStatusChanges(db *sql.DB, where string, args ...any) ([]BoxStatusChange, error) {
+	query := `
+	SELECT box_status_changes.id, box_status_changes.box_id, boxes.name, box_status_changes.status,
+	box_status_changes.destination, box_status_changes.changed_at
+	FROM box_status_changes
+	JOIN boxes ON boxes.id = box_status_changes.box_id
+	` + where
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := make([]BoxStatusChange, 0)
+	for rows.Next() {
+		var change BoxStatusChange
+		if err := rows.Scan(&change.ID, &change.BoxID, &change.BoxName, &change.Status, &change.Destination.NullString, &change.ChangedAt); err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// Get the destination rooms used by the boxes of an inventory (0 for all)
+func (d *Database) GetBoxDestinations(db *sql.DB, inventoryID int) ([]string, error) {
+	query := `SELECT DISTINCT destination FROM boxes WHERE destination IS NOT NULL AND (? = 0 OR inventory_id = ?) ORDER BY destination`
+	rows, err := db.Query(query, inventoryID, inventoryID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	destinations := make([]string, 0)
+	for rows.Next() {
+		var destination string
+		if err := rows.Scan(&destination); err != nil {
+			return nil, err
+		}
+		destinations = append(destinations, destination)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return destinations, nil
+}
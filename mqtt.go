@@ -0,0 +1,250 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Most events kept for publishing while the broker is unreachable, the oldest are dropped first
+const mqttMaxPendingEvents = 10000
+
+// A message to publish with QoS 1
+type mqttMessage struct {
+	Topic    string
+	Retained bool
+	Payload  []byte
+}
+
+// Settings for the optional MQTT publisher, read from the environment
+type MQTTConfig struct {
+	// Broker url such as tcp://localhost:1883 or ssl://broker:8883. Publishing is disabled if empty.
+	Broker       string
+	ClientID     string
+	Username     string
+	Password     string
+	TopicPrefix  string
+	CAFile       string
+	Insecure     bool
+	ExpiringDays int
+}
+
+// Publishes store events to MQTT and keeps a retained summary topic per box up to date.
+//
+// Topics (relative to the configured prefix):
+//
+//	<prefix>/status                 online/offline (retained, offline is the last will)
+//	<prefix>/events/<event type>    every box and item event as JSON
+//	<prefix>/boxes/<id>/summary     item count, total quantity and expiring items (retained)
+type MQTTPublisher struct {
+	config MQTTConfig
+	db     *sql.DB
+	client mqtt.Client
+	// Tells the publishing loop whenever the client connects or loses the connection
+	connection chan bool
+}
+
+// Reads the MQTT settings from the environment
+func mqttConfigFromEnv() MQTTConfig {
+	insecure, err := parseBoolEnv("MQTT_TLS_INSECURE", false)
+	if err != nil {
+		log.Printf("invalid MQTT_TLS_INSECURE: %v", err)
+	}
+	expiringDays, err := parseIntEnv("MQTT_EXPIRING_DAYS", 7)
+	if err != nil {
+		log.Printf("invalid MQTT_EXPIRING_DAYS: %v", err)
+	}
+	return MQTTConfig{
+		Broker:       getEnv("MQTT_BROKER", ""),
+		ClientID:     getEnv("MQTT_CLIENT_ID", "witb"),
+		Username:     getEnv("MQTT_USERNAME", ""),
+		Password:     getEnv("MQTT_PASSWORD", ""),
+		TopicPrefix:  strings.TrimSuffix(getEnv("MQTT_TOPIC_PREFIX", "witb"), "/"),
+		CAFile:       getEnv("MQTT_TLS_CA", ""),
+		Insecure:     insecure,
+		ExpiringDays: expiringDays,
+	}
+}
+
+// Creates a publisher with a client that reconnects on its own whenever the connection to the broker is lost
+func NewMQTTPublisher(config MQTTConfig, db *sql.DB) (*MQTTPublisher, error) {
+	p := &MQTTPublisher{config: config, db: db, connection: make(chan bool, 8)}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(config.Broker).
+		SetClientID(config.ClientID).
+		SetUsername(config.Username).
+		SetPassword(config.Password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(5*time.Second).
+		SetMaxReconnectInterval(time.Minute).
+		SetWill(p.topic("status"), "offline", 1, true).
+		SetOnConnectHandler(p.onConnect).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			log.Printf("lost connection to MQTT broker: %v", err)
+			p.connection <- false
+		})
+
+	if config.CAFile != "" || config.Insecure {
+		tlsConfig := &tls.Config{InsecureSkipVerify: config.Insecure}
+		if config.CAFile != "" {
+			pem, err := os.ReadFile(config.CAFile)
+			if err != nil {
+				return nil, fmt.Errorf("could not read MQTT CA file: %w", err)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in %s", config.CAFile)
+			}
+			tlsConfig.RootCAs = pool
+		}
+		opts.SetTLSConfig(tlsConfig)
+	}
+	p.client = mqtt.NewClient(opts)
+	return p, nil
+}
+
+// Connects to the broker and publishes all events from the bus.
+// Connecting is retried in the background so a broker that is down does not keep the app from starting.
+func (p *MQTTPublisher) Start(bus *EventBus) {
+	p.client.Connect()
+	events, _ := bus.SubscribeAll()
+	go p.run(events)
+}
+
+// Publishes events one at a time until the channel is closed.
+// Events happening while the broker is unreachable are kept and published in order after the next connect.
+func (p *MQTTPublisher) run(events <-chan Event) {
+	// Expiring counts change with time even if nobody touches the boxes
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	var pending []Event
+	online := false
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if online && p.client.IsConnectionOpen() {
+				p.publishEvent(event)
+				continue
+			}
+			if len(pending) == mqttMaxPendingEvents {
+				pending = pending[1:]
+			}
+			pending = append(pending, event)
+		case online = <-p.connection:
+			if !online {
+				continue
+			}
+			// The summaries of the boxes are republished below, the events only once
+			for _, event := range pending {
+				p.publish(p.eventMessage(event))
+			}
+			pending = nil
+			p.publishAllSummaries()
+		case <-ticker.C:
+			p.publishAllSummaries()
+		}
+	}
+}
+
+// Builds a topic below the configured prefix
+func (p *MQTTPublisher) topic(parts ...string) string {
+	return p.config.TopicPrefix + "/" + strings.Join(parts, "/")
+}
+
+// Announces availability and has the retained state republished after every (re)connect
+func (p *MQTTPublisher) onConnect(client mqtt.Client) {
+	log.Printf("connected to MQTT broker %s", p.config.Broker)
+	client.Publish(p.topic("status"), 1, true, "online")
+	p.connection <- true
+}
+
+// Publishes the event and refreshes the summaries of all boxes it touched
+func (p *MQTTPublisher) publishEvent(event Event) {
+	for _, message := range p.eventMessages(event) {
+		p.publish(message)
+	}
+}
+
+// Builds the messages for an event: the event itself and the summaries of all boxes it touched
+func (p *MQTTPublisher) eventMessages(event Event) []mqttMessage {
+	messages := []mqttMessage{p.eventMessage(event)}
+	if event.Type == EventBoxDeleted {
+		// An empty retained message removes the summary from the broker
+		return append(messages, mqttMessage{Topic: p.topic("boxes", fmt.Sprint(event.BoxID), "summary"), Retained: true, Payload: []byte{}})
+	}
+	for _, boxID := range []int{event.BoxID, event.FromBoxID} {
+		if boxID == 0 {
+			continue
+		}
+		message, err := p.summaryMessage(boxID)
+		if err != nil {
+			log.Printf("could not summarize box %d for MQTT: %v", boxID, err)
+			continue
+		}
+		messages = append(messages, message)
+	}
+	return messages
+}
+
+// Builds the message announcing an event
+func (p *MQTTPublisher) eventMessage(event Event) mqttMessage {
+	body, _ := json.Marshal(event)
+	return mqttMessage{Topic: p.topic("events", event.Type), Payload: body}
+}
+
+// Builds the retained summary message of a single box
+func (p *MQTTPublisher) summaryMessage(boxID int) (mqttMessage, error) {
+	expiringBefore := time.Now().AddDate(0, 0, p.config.ExpiringDays)
+	summary, err := database.GetBoxSummary(p.db, boxID, expiringBefore)
+	if err != nil {
+		return mqttMessage{}, err
+	}
+	body, err := json.Marshal(summary)
+	if err != nil {
+		return mqttMessage{}, err
+	}
+	return mqttMessage{Topic: p.topic("boxes", fmt.Sprint(boxID), "summary"), Retained: true, Payload: body}, nil
+}
+
+// Publishes the retained summaries of all boxes
+func (p *MQTTPublisher) publishAllSummaries() {
+	if !p.client.IsConnectionOpen() {
+		return
+	}
+	boxes, err := database.GetBoxes(p.db, 0)
+	if err != nil {
+		log.Printf("could not get boxes for MQTT: %v", err)
+		return
+	}
+	for _, box := range boxes {
+		message, err := p.summaryMessage(box.ID)
+		if err != nil {
+			log.Printf("could not summarize box %d for MQTT: %v", box.ID, err)
+			continue
+		}
+		p.publish(message)
+	}
+}
+
+// Publishes a message with QoS 1
+func (p *MQTTPublisher) publish(message mqttMessage) {
+	token := p.client.Publish(message.Topic, 1, message.Retained, message.Payload)
+	go func() {
+		if token.WaitTimeout(10*time.Second) && token.Error() != nil {
+			log.Printf("could not publish to %s: %v", message.Topic, token.Error())
+		}
+	}()
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"sync"
+	"testing"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// MQTT client which records published messages instead of sending them to a broker
+type fakeMQTTClient struct {
+	mqtt.Client
+	mu        sync.Mutex
+	open      bool
+	published []mqttMessage
+}
+
+func (c *fakeMQTTClient) IsConnectionOpen() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.open
+}
+
+func (c *fakeMQTTClient) Publish(topic string, qos byte, retained bool, payload any) mqtt.Token {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var body []byte
+	switch payload := payload.(type) {
+	case []byte:
+		body = payload
+	case string:
+		body = []byte(payload)
+	}
+	c.published = append(c.published, mqttMessage{Topic: topic, Retained: retained, Payload: body})
+	return &mqtt.DummyToken{}
+}
+
+// Returns the topics published so far
+func (c *fakeMQTTClient) topics() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var topics []string
+	for _, message := range c.published {
+		topics = append(topics, message.Topic)
+	}
+	return topics
+}
+
+// Creates a publisher on a test database with two boxes, the first with two items
+func testMQTTPublisher(t *testing.T) (*MQTTPublisher, *fakeMQTTClient, []Box) {
+	t.Helper()
+	d, db := testDatabase(t)
+	for _, name := range []string{"Tools", "Books"} {
+		if err := d.CreateBox(db, 1, name, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	boxes, err := d.GetBoxes(db, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"Hammer", "Saw"} {
+		if _, err := d.CreateItem(db, boxes[0].ID, name, 2, ItemAttributes{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	client := &fakeMQTTClient{open: true}
+	p := &MQTTPublisher{
+		config:     MQTTConfig{TopicPrefix: "home/witb", ExpiringDays: 7},
+		db:         db,
+		client:     client,
+		connection: make(chan bool),
+	}
+	return p, client, boxes
+}
+
+func TestMQTTEventMessages(t *testing.T) {
+	p, _, boxes := testMQTTPublisher(t)
+	tools, books := boxes[0], boxes[1]
+
+	messages := p.eventMessages(Event{ID: 3, Type: EventItemMoved, BoxID: books.ID, ItemID: 1, FromBoxID: tools.ID, Actor: "alice"})
+	if len(messages) != 3 {
+		t.Fatalf("got %d messages, want 3: %+v", len(messages), messages)
+	}
+	event := messages[0]
+	if event.Topic != "home/witb/events/item.moved" || event.Retained {
+		t.Errorf("event message %s retained %v", event.Topic, event.Retained)
+	}
+	var published Event
+	if err := json.Unmarshal(event.Payload, &published); err != nil {
+		t.Fatal(err)
+	}
+	if published.ID != 3 || published.BoxID != books.ID || published.FromBoxID != tools.ID || published.Actor != "alice" {
+		t.Errorf("event payload = %s", event.Payload)
+	}
+
+	// Both boxes get a retained summary
+	for i, box := range []Box{books, tools} {
+		message := messages[i+1]
+		if want := "home/witb/boxes/" + strconv.Itoa(box.ID) + "/summary"; message.Topic != want || !message.Retained {
+			t.Errorf("summary message %s retained %v, want %s retained", message.Topic, message.Retained, want)
+		}
+		var summary BoxSummary
+		if err := json.Unmarshal(message.Payload, &summary); err != nil {
+			t.Fatal(err)
+		}
+		wantCount := 0
+		if box.ID == tools.ID {
+			wantCount = 2
+		}
+		if summary.BoxID != box.ID || summary.ItemCount != wantCount || summary.TotalQuantity != 2*wantCount {
+			t.Errorf("summary of %s = %s", box.Name, message.Payload)
+		}
+	}
+}
+
+func TestMQTTDeletedBoxMessages(t *testing.T) {
+	p, _, boxes := testMQTTPublisher(t)
+	messages := p.eventMessages(Event{Type: EventBoxDeleted, BoxID: boxes[1].ID})
+	if len(messages) != 2 {
+		t.Fatalf("got %d messages, want 2: %+v", len(messages), messages)
+	}
+	if messages[0].Topic != "home/witb/events/box.deleted" {
+		t.Errorf("event topic = %s", messages[0].Topic)
+	}
+	// An empty retained message removes the summary
+	summary := messages[1]
+	if summary.Topic != "home/witb/boxes/"+strconv.Itoa(boxes[1].ID)+"/summary" || !summary.Retained || len(summary.Payload) != 0 {
+		t.Errorf("summary message = %+v", summary)
+	}
+}
+
+func TestMQTTPublishesEventsAfterReconnect(t *testing.T) {
+	p, client, boxes := testMQTTPublisher(t)
+	events := make(chan Event)
+	done := make(chan struct{})
+	go func() {
+		p.run(events)
+		close(done)
+	}()
+
+	// Nothing is published before the client connected
+	events <- Event{Type: EventItemUpdated, BoxID: boxes[0].ID, ItemID: 1}
+	events <- Event{Type: EventBoxUpdated, BoxID: boxes[1].ID}
+	p.connection <- false
+	if topics := client.topics(); len(topics) != 0 {
+		t.Fatalf("published %v while disconnected", topics)
+	}
+
+	p.connection <- true
+	events <- Event{Type: EventBoxCreated, BoxID: boxes[1].ID}
+	close(events)
+	<-done
+
+	// The queued events in order, the summaries of all boxes and then the new event as usual
+	tools := "home/witb/boxes/" + strconv.Itoa(boxes[0].ID) + "/summary"
+	books := "home/witb/boxes/" + strconv.Itoa(boxes[1].ID) + "/summary"
+	want := []string{
+		"home/witb/events/item.updated",
+		"home/witb/events/box.updated",
+		tools,
+		books,
+		"home/witb/events/box.created",
+		books,
+	}
+	got := client.topics()
+	if len(got) != len(want) {
+		t.Fatalf("published %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("message %d published to %s, want %s", i, got[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,452 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Rules evaluated by the notification scheduler
+const (
+	ruleExpiring = "expiring"
+	ruleLowStock = "low_stock"
+	ruleOverdue  = "overdue_loan"
+)
+
+// Setting holding the date of the last daily digest
+const settingLastDigest = "notify_last_digest"
+
+// A message sent through a notifier
+type Notification struct {
+	Title   string
+	Message string
+}
+
+// Delivers notifications to a single channel such as e-mail or a push service
+type Notifier interface {
+	Name() string
+	Notify(n Notification) error
+}
+
+// Sends notifications as plain text e-mails
+type SMTPNotifier struct {
+	Addr     string
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+func (s SMTPNotifier) Name() string { return "smtp" }
+
+func (s SMTPNotifier) Notify(n Notification) error {
+	var auth smtp.Auth
+	if s.Username != "" {
+		host := strings.Split(s.Addr, ":")[0]
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s\r\n",
+		s.From, strings.Join(s.To, ", "), n.Title, strings.ReplaceAll(n.Message, "\n", "\r\n"))
+	return smtp.SendMail(s.Addr, auth, s.From, s.To, []byte(msg))
+}
+
+// Publishes notifications to a ntfy topic url such as https://ntfy.sh/my-boxes
+type NtfyNotifier struct {
+	URL   string
+	Token string
+}
+
+func (n NtfyNotifier) Name() string { return "ntfy" }
+
+func (n NtfyNotifier) Notify(notification Notification) error {
+	req, err := http.NewRequest(http.MethodPost, n.URL, strings.NewReader(notification.Message))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Title", notification.Title)
+	req.Header.Set("Tags", "package")
+	if n.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.Token)
+	}
+	return sendNotificationRequest(req)
+}
+
+// Sends notifications to a Gotify server (or any server implementing its message API)
+type GotifyNotifier struct {
+	URL      string
+	Token    string
+	Priority int
+}
+
+func (g GotifyNotifier) Name() string { return "gotify" }
+
+func (g GotifyNotifier) Notify(n Notification) error {
+	body, err := json.Marshal(map[string]any{
+		"title":    n.Title,
+		"message":  n.Message,
+		"priority": g.Priority,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(g.URL, "/")+"/message", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Gotify-Key", g.Token)
+	return sendNotificationRequest(req)
+}
+
+// Sends the request of an HTTP based notifier and treats any non 2xx response as an error
+func sendNotificationRequest(req *http.Request) error {
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s responded with %s", req.URL.Host, resp.Status)
+	}
+	return nil
+}
+
+// Creates all notifiers configured in the environment
+func notifiersFromEnv() []Notifier {
+	var notifiers []Notifier
+	if addr := getEnv("NOTIFY_SMTP_ADDR", ""); addr != "" {
+		notifiers = append(notifiers, SMTPNotifier{
+			Addr:     addr,
+			Username: getEnv("NOTIFY_SMTP_USERNAME", ""),
+			Password: getEnv("NOTIFY_SMTP_PASSWORD", ""),
+			From:     getEnv("NOTIFY_SMTP_FROM", "witb@localhost"),
+			To:       strings.Split(getEnv("NOTIFY_SMTP_TO", ""), ","),
+		})
+	}
+	if url := getEnv("NOTIFY_NTFY_URL", ""); url != "" {
+		notifiers = append(notifiers, NtfyNotifier{
+			URL:   url,
+			Token: getEnv("NOTIFY_NTFY_TOKEN", ""),
+		})
+	}
+	if url := getEnv("NOTIFY_GOTIFY_URL", ""); url != "" {
+		priority, err := parseIntEnv("NOTIFY_GOTIFY_PRIORITY", 5)
+		if err != nil {
+			log.Printf("invalid NOTIFY_GOTIFY_PRIORITY: %v", err)
+		}
+		notifiers = append(notifiers, GotifyNotifier{
+			URL:      url,
+			Token:    getEnv("NOTIFY_GOTIFY_TOKEN", ""),
+			Priority: priority,
+		})
+	}
+	return notifiers
+}
+
+// A condition found by one of the rules. Key identifies it for deduplication.
+type Finding struct {
+	Rule   string
+	Key    string
+	ItemID int
+	Text   string
+}
+
+// Define struct for an item together with the box it is stored in
+type LocatedItem struct {
+	Item
+	BoxName string `json:"box_name"`
+}
+
+// Get all items expiring before the given time
+func (d *Database) GetExpiringItems(db *sql.DB, before time.Time) ([]LocatedItem, error) {
+	query := `
+	SELECT ` + itemColumns + `, boxes.name
+	FROM contents
+	JOIN boxes ON boxes.id = contents.box_id
+	WHERE contents.expires_at IS NOT NULL AND contents.expires_at <= ?
+	ORDER BY contents.expires_at`
+	return d.queryLocatedItems(db, query, before.UTC())
+}
+
+// Get all items with less than their minimum quantity
+func (d *Database) GetLowStockItems(db *sql.DB) ([]LocatedItem, error) {
+	query := `
+	SELECT ` + itemColumns + `, boxes.name
+	FROM contents
+	JOIN boxes ON boxes.id = contents.box_id
+	WHERE contents.min_quantity IS NOT NULL AND contents.quantity < contents.min_quantity
+	ORDER BY contents.name`
+	return d.queryLocatedItems(db, query)
+}
+
+// Runs a query selecting itemColumns followed by the box name
+func (d *Database) queryLocatedItems(db *sql.DB, query string, args ...any) ([]LocatedItem, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]LocatedItem, 0)
+	for rows.Next() {
+		var boxName string
+		item, err := scanItem(rows, &boxName)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, LocatedItem{Item: item, BoxName: boxName})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// Reports whether a notification with the given key has already been sent
+func (d *Database) IsNotified(db *sql.DB, key string) (bool, error) {
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM notifications WHERE dedupe_key = ?`, key).Scan(&count)
+	return count > 0, err
+}
+
+// Remembers that a notification has been sent
+func (d *Database) RecordNotification(db *sql.DB, finding Finding) error {
+	query := `INSERT OR IGNORE INTO notifications (rule, dedupe_key, content_id) VALUES (?, ?, ?)`
+	_, err := db.Exec(query, finding.Rule, finding.Key, finding.ItemID)
+	return err
+}
+
+// Forgets sent notifications of a rule whose condition no longer applies, so they are sent again if it reoccurs
+func (d *Database) PruneNotifications(db *sql.DB, rule string, activeKeys []string) error {
+	query := `DELETE FROM notifications WHERE rule = ?`
+	args := []any{rule}
+	if len(activeKeys) > 0 {
+		query += ` AND dedupe_key NOT IN (?` + strings.Repeat(`, ?`, len(activeKeys)-1) + `)`
+		for _, key := range activeKeys {
+			args = append(args, key)
+		}
+	}
+	_, err := db.Exec(query, args...)
+	return err
+}
+
+// Get a value from the settings table. Returns an empty string if it is not set.
+func (d *Database) GetSetting(db *sql.DB, key string) (string, error) {
+	var value string
+	err := db.QueryRow(`SELECT value FROM settings WHERE key = ?`, key).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return value, err
+}
+
+// Stores a value in the settings table
+func (d *Database) SetSetting(db *sql.DB, key string, value string) error {
+	query := `INSERT INTO settings (key, value) VALUES (?, ?) ON CONFLICT (key) DO UPDATE SET value = excluded.value`
+	_, err := db.Exec(query, key, value)
+	return err
+}
+
+// Periodically evaluates the notification rules and sends every finding once.
+// In digest mode all new findings of a day are combined into a single notification sent at a fixed time.
+type NotificationScheduler struct {
+	db         *sql.DB
+	notifiers  []Notifier
+	expiryDays int
+	interval   time.Duration
+	digest     bool
+	digestAt   time.Duration
+}
+
+// Reads the scheduler settings from the environment
+func NewNotificationSchedulerFromEnv(db *sql.DB, notifiers []Notifier) (*NotificationScheduler, error) {
+	s := &NotificationScheduler{db: db, notifiers: notifiers}
+	var err error
+	if s.expiryDays, err = parseIntEnv("NOTIFY_EXPIRY_DAYS", 3); err != nil {
+		return nil, fmt.Errorf("invalid NOTIFY_EXPIRY_DAYS: %w", err)
+	}
+	if s.interval, err = time.ParseDuration(getEnv("NOTIFY_INTERVAL", "1h")); err != nil {
+		return nil, fmt.Errorf("invalid NOTIFY_INTERVAL: %w", err)
+	}
+	switch mode := getEnv("NOTIFY_MODE", "immediate"); mode {
+	case "immediate":
+	case "digest":
+		s.digest = true
+		at, err := time.Parse("15:04", getEnv("NOTIFY_DIGEST_TIME", "08:00"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid NOTIFY_DIGEST_TIME: %w", err)
+		}
+		s.digestAt = time.Duration(at.Hour())*time.Hour + time.Duration(at.Minute())*time.Minute
+	default:
+		return nil, fmt.Errorf("unknown NOTIFY_MODE %q", mode)
+	}
+	return s, nil
+}
+
+// Runs the scheduler in the background
+func (s *NotificationScheduler) Start() {
+	go func() {
+		interval := s.interval
+		if s.digest {
+			interval = time.Minute
+		}
+		for {
+			if err := s.tick(time.Now()); err != nil {
+				log.Printf("could not send notifications: %v", err)
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// Evaluates the rules once. Digests are only sent once a day after the configured time.
+func (s *NotificationScheduler) tick(now time.Time) error {
+	if !s.digest {
+		findings, err := s.evaluate(now)
+		if err != nil {
+			return err
+		}
+		for _, finding := range findings {
+			s.send(Notification{Title: "What's in the Box", Message: finding.Text}, finding)
+		}
+		return nil
+	}
+
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	today := now.Format(time.DateOnly)
+	if now.Before(midnight.Add(s.digestAt)) {
+		return nil
+	}
+	last, err := database.GetSetting(s.db, settingLastDigest)
+	if err != nil || last == today {
+		return err
+	}
+	findings, err := s.evaluate(now)
+	if err != nil {
+		return err
+	}
+	if len(findings) > 0 {
+		lines := make([]string, len(findings))
+		for i, finding := range findings {
+			lines[i] = "- " + finding.Text
+		}
+		digest := Notification{
+			Title:   fmt.Sprintf("What's in the Box: %d items need attention", len(findings)),
+			Message: strings.Join(lines, "\n"),
+		}
+		if !s.send(digest, findings...) {
+			// Try again on the next tick
+			return nil
+		}
+	}
+	return database.SetSetting(s.db, settingLastDigest, today)
+}
+
+// Returns all findings of all rules which have not been notified yet
+func (s *NotificationScheduler) evaluate(now time.Time) ([]Finding, error) {
+	expiring, err := database.GetExpiringItems(s.db, now.AddDate(0, 0, s.expiryDays))
+	if err != nil {
+		return nil, err
+	}
+	low, err := database.GetLowStockItems(s.db)
+	if err != nil {
+		return nil, err
+	}
+	overdue, err := database.GetOverdueLoans(s.db, now)
+	if err != nil {
+		return nil, err
+	}
+
+	var findings []Finding
+	var expiringKeys, lowKeys, overdueKeys []string
+	for _, item := range expiring {
+		verb := "expires"
+		if item.ExpiresAt.Time.Before(now) {
+			verb = "expired"
+		}
+		// The date is part of the key so a changed expiration date is notified again
+		date := item.ExpiresAt.Time.Format(time.DateOnly)
+		finding := Finding{
+			Rule:   ruleExpiring,
+			Key:    fmt.Sprintf("%s:%d:%s", ruleExpiring, item.ID, date),
+			ItemID: item.ID,
+			Text:   fmt.Sprintf("%s in %s %s on %s", item.Name, item.BoxName, verb, formatAsDate(item.ExpiresAt.Time)),
+		}
+		expiringKeys = append(expiringKeys, finding.Key)
+		findings = append(findings, finding)
+	}
+	for _, item := range low {
+		finding := Finding{
+			Rule:   ruleLowStock,
+			Key:    fmt.Sprintf("%s:%d", ruleLowStock, item.ID),
+			ItemID: item.ID,
+			Text:   fmt.Sprintf("%s in %s is running low: %d left (minimum %d)", item.Name, item.BoxName, item.Quantity, item.MinQuantity.Int64),
+		}
+		lowKeys = append(lowKeys, finding.Key)
+		findings = append(findings, finding)
+	}
+	for _, loan := range overdue {
+		// The due date is part of the key, as for expiring items
+		date := loan.DueAt.Time.Format(time.DateOnly)
+		finding := Finding{
+			Rule:   ruleOverdue,
+			Key:    fmt.Sprintf("%s:%d:%s", ruleOverdue, loan.ID, date),
+			ItemID: loan.ItemID,
+			Text:   fmt.Sprintf("%s has not returned %d × %s, due on %s", loan.Borrower, loan.Quantity, loan.ItemName, formatAsDate(loan.DueAt.Time)),
+		}
+		overdueKeys = append(overdueKeys, finding.Key)
+		findings = append(findings, finding)
+	}
+
+	// Items that are fine again may be notified again the next time they need attention
+	if err := database.PruneNotifications(s.db, ruleExpiring, expiringKeys); err != nil {
+		return nil, err
+	}
+	if err := database.PruneNotifications(s.db, ruleLowStock, lowKeys); err != nil {
+		return nil, err
+	}
+	if err := database.PruneNotifications(s.db, ruleOverdue, overdueKeys); err != nil {
+		return nil, err
+	}
+
+	var unsent []Finding
+	for _, finding := range findings {
+		notified, err := database.IsNotified(s.db, finding.Key)
+		if err != nil {
+			return nil, err
+		}
+		if !notified {
+			unsent = append(unsent, finding)
+		}
+	}
+	return unsent, nil
+}
+
+// Sends the notification through all notifiers and records the findings if at least one of them succeeded
+func (s *NotificationScheduler) send(n Notification, findings ...Finding) bool {
+	delivered := false
+	for _, notifier := range s.notifiers {
+		if err := notifier.Notify(n); err != nil {
+			log.Printf("%s notification failed: %v", notifier.Name(), err)
+			continue
+		}
+		delivered = true
+	}
+	if !delivered {
+		return false
+	}
+	for _, finding := range findings {
+		if err := database.RecordNotification(s.db, finding); err != nil {
+			log.Println(err)
+		}
+	}
+	return true
+}
@@ -0,0 +1,190 @@
+package main
+
+// Writing simple PDF documents: text in the standard fonts every PDF reader has and JPEG images.
+// The standard fonts need no embedding, JPEG data is embedded as it is.
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/jpeg"
+	"strings"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+)
+
+// Size of an A4 page in points
+const (
+	pdfPageWidth  = 595.0
+	pdfPageHeight = 842.0
+)
+
+// Standard fonts used on pages, by their resource names
+const (
+	pdfHelvetica     = "F1"
+	pdfHelveticaBold = "F2"
+	pdfCourier       = "F3"
+)
+
+// Base fonts of the resource names in the order of their objects
+var pdfFonts = []struct{ name, base string }{
+	{pdfHelvetica, "Helvetica"},
+	{pdfHelveticaBold, "Helvetica-Bold"},
+	{pdfCourier, "Courier"},
+}
+
+// Text is encoded in WinAnsiEncoding, characters it lacks become question marks
+var pdfTextEncoder = encoding.ReplaceUnsupported(charmap.Windows1252.NewEncoder())
+
+// A JPEG image placed on pages
+type pdfImage struct {
+	data   []byte
+	width  int
+	height int
+	gray   bool
+}
+
+// A page of a document. Coordinates are in points from the bottom left corner.
+type pdfPage struct {
+	content bytes.Buffer
+}
+
+// A PDF document built page by page
+type pdfDocument struct {
+	pages  []*pdfPage
+	images []pdfImage
+}
+
+// Adds an empty page to the document
+func (d *pdfDocument) addPage() *pdfPage {
+	page := &pdfPage{}
+	d.pages = append(d.pages, page)
+	return page
+}
+
+// Adds a JPEG image to the document and returns its index for pdfPage.image with its size in pixels
+func (d *pdfDocument) addJPEG(data []byte) (int, int, int, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	if format != "jpeg" {
+		return 0, 0, 0, fmt.Errorf("image is %s, not jpeg", format)
+	}
+	d.images = append(d.images, pdfImage{data: data, width: config.Width, height: config.Height, gray: config.ColorModel == color.GrayModel})
+	return len(d.images) - 1, config.Width, config.Height, nil
+}
+
+// Writes text with its baseline starting at x, y
+func (p *pdfPage) text(x, y float64, font string, size float64, text string) {
+	fmt.Fprintf(&p.content, "BT /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, y, pdfString(text))
+}
+
+// Writes text in Courier ending at x. Courier is the only standard font whose widths are simple: 0.6 of the size.
+func (p *pdfPage) textRight(x, y float64, size float64, text string) {
+	p.text(x-0.6*size*float64(len([]rune(text))), y, pdfCourier, size, text)
+}
+
+// Draws a gray line
+func (p *pdfPage) line(x1, y1, x2, y2 float64) {
+	fmt.Fprintf(&p.content, "0.7 G 0.5 w %.2f %.2f m %.2f %.2f l S 0 G\n", x1, y1, x2, y2)
+}
+
+// Draws an image added with addJPEG into the rectangle with the bottom left corner x, y
+func (p *pdfPage) image(index int, x, y, width, height float64) {
+	fmt.Fprintf(&p.content, "q %.2f 0 0 %.2f %.2f %.2f cm /Im%d Do Q\n", width, height, x, y, index)
+}
+
+// Encodes text as the contents of a PDF string literal
+func pdfString(text string) string {
+	encoded, err := pdfTextEncoder.String(text)
+	if err != nil {
+		encoded = strings.Map(func(r rune) rune {
+			if r > 126 {
+				return '?'
+			}
+			return r
+		}, text)
+	}
+	var b strings.Builder
+	for _, c := range []byte(encoded) {
+		switch {
+		case c == '\\' || c == '(' || c == ')':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == 0x1A:
+			// The encoder substitutes characters missing from WinAnsiEncoding with SUB
+			b.WriteByte('?')
+		case c < 32:
+			b.WriteByte(' ')
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// Serializes the document.
+// Objects are numbered: 1 catalog, 2 page tree, then the fonts, the images and a content stream and page per page.
+func (d *pdfDocument) bytes() []byte {
+	var objects [][]byte
+	add := func(object []byte) int {
+		objects = append(objects, object)
+		return len(objects)
+	}
+	add([]byte("<< /Type /Catalog /Pages 2 0 R >>"))
+	add(nil) // The page tree follows once the pages are numbered
+
+	var fonts strings.Builder
+	for _, font := range pdfFonts {
+		id := add([]byte(fmt.Sprintf("<< /Type /Font /Subtype /Type1 /BaseFont /%s /Encoding /WinAnsiEncoding >>", font.base)))
+		fmt.Fprintf(&fonts, "/%s %d 0 R ", font.name, id)
+	}
+	var images strings.Builder
+	for i, img := range d.images {
+		colorSpace := "/DeviceRGB"
+		if img.gray {
+			colorSpace = "/DeviceGray"
+		}
+		var object bytes.Buffer
+		fmt.Fprintf(&object, "<< /Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace %s /BitsPerComponent 8 /Filter /DCTDecode /Length %d >>\nstream\n",
+			img.width, img.height, colorSpace, len(img.data))
+		object.Write(img.data)
+		object.WriteString("\nendstream")
+		fmt.Fprintf(&images, "/Im%d %d 0 R ", i, add(object.Bytes()))
+	}
+	resources := fmt.Sprintf("<< /Font << %s>> /XObject << %s>> >>", fonts.String(), images.String())
+
+	var kids strings.Builder
+	for _, page := range d.pages {
+		var stream bytes.Buffer
+		fmt.Fprintf(&stream, "<< /Length %d >>\nstream\n", page.content.Len())
+		stream.Write(page.content.Bytes())
+		stream.WriteString("endstream")
+		content := add(stream.Bytes())
+		id := add([]byte(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] /Resources %s /Contents %d 0 R >>",
+			pdfPageWidth, pdfPageHeight, resources, content)))
+		fmt.Fprintf(&kids, "%d 0 R ", id)
+	}
+	objects[1] = []byte(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", kids.String(), len(d.pages)))
+
+	var out bytes.Buffer
+	// The comment with bytes above 127 tells tools the file is binary
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = out.Len()
+		fmt.Fprintf(&out, "%d 0 obj\n", i+1)
+		out.Write(object)
+		out.WriteString("\nendobj\n")
+	}
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return out.Bytes()
+}
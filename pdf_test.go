@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Checks the structure a PDF reader relies on: the cross-reference table pointing at every object,
+// the trailer and the lengths of the streams. Returns the objects by number.
+func parsePDF(t *testing.T, data []byte) map[int][]byte {
+	t.Helper()
+	if !bytes.HasPrefix(data, []byte("%PDF-1.4\n")) {
+		t.Fatalf("missing header: %q", data[:min(len(data), 16)])
+	}
+	match := regexp.MustCompile(`startxref\n(\d+)\n%%EOF\n$`).FindSubmatch(data)
+	if match == nil {
+		t.Fatal("missing startxref at the end")
+	}
+	xref, _ := strconv.Atoi(string(match[1]))
+	if xref >= len(data) || !bytes.HasPrefix(data[xref:], []byte("xref\n")) {
+		t.Fatalf("startxref %d does not point at the xref table", xref)
+	}
+	lines := strings.Split(string(data[xref:]), "\n")
+	var first, count int
+	if _, err := fmt.Sscanf(lines[1], "%d %d", &first, &count); err != nil || first != 0 {
+		t.Fatalf("invalid xref subsection %q", lines[1])
+	}
+	if lines[2] != "0000000000 65535 f " {
+		t.Errorf("invalid free entry %q", lines[2])
+	}
+	objects := make(map[int][]byte)
+	for n := 1; n < count; n++ {
+		entry := lines[2+n]
+		if len(entry) != 19 || !strings.HasSuffix(entry, " 00000 n ") {
+			t.Fatalf("invalid xref entry %q", entry)
+		}
+		offset, _ := strconv.Atoi(entry[:10])
+		header := fmt.Sprintf("%d 0 obj\n", n)
+		if !bytes.HasPrefix(data[offset:], []byte(header)) {
+			t.Fatalf("xref entry of object %d points at %q", n, data[offset:min(len(data), offset+16)])
+		}
+		body := data[offset+len(header):]
+		end := bytes.Index(body, []byte("\nendobj\n"))
+		if end < 0 {
+			t.Fatalf("object %d has no endobj", n)
+		}
+		objects[n] = body[:end]
+	}
+	trailer := lines[2+count]
+	if trailer != "trailer" || lines[3+count] != fmt.Sprintf("<< /Size %d /Root 1 0 R >>", count) {
+		t.Errorf("trailer %q %q does not match the %d xref entries", trailer, lines[3+count], count)
+	}
+
+	for n, object := range objects {
+		start := bytes.Index(object, []byte(">>\nstream\n"))
+		if start < 0 {
+			continue
+		}
+		match := regexp.MustCompile(`/Length (\d+)`).FindSubmatch(object[:start])
+		if match == nil {
+			t.Fatalf("stream of object %d has no /Length", n)
+		}
+		length, _ := strconv.Atoi(string(match[1]))
+		stream := object[start+len(">>\nstream\n"):]
+		// The stream data may be followed by an end of line before endstream
+		if rest := stream[min(length, len(stream)):]; string(rest) != "endstream" && string(rest) != "\nendstream" {
+			t.Errorf("stream of object %d is not %d bytes long, it is followed by %q", n, length, rest[:min(len(rest), 20)])
+		}
+	}
+	return objects
+}
+
+// Counts the page objects and checks the count of the page tree
+func pdfPageCount(t *testing.T, objects map[int][]byte) int {
+	t.Helper()
+	pages := 0
+	for _, object := range objects {
+		if bytes.HasPrefix(object, []byte("<< /Type /Page ")) {
+			pages++
+		}
+	}
+	match := regexp.MustCompile(`/Count (\d+)`).FindSubmatch(objects[2])
+	if match == nil || string(match[1]) != strconv.Itoa(pages) {
+		t.Errorf("page tree %q does not count the %d pages", objects[2], pages)
+	}
+	return pages
+}
+
+// Returns a small JPEG photo
+func testJPEG(t *testing.T, model color.Model) []byte {
+	t.Helper()
+	var img image.Image
+	if model == color.GrayModel {
+		img = image.NewGray(image.Rect(0, 0, 40, 30))
+	} else {
+		rgba := image.NewRGBA(image.Rect(0, 0, 40, 30))
+		for i := range rgba.Pix {
+			rgba.Pix[i] = byte(i)
+		}
+		img = rgba
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestPDFDocumentStructure(t *testing.T) {
+	tests := []struct {
+		name   string
+		pages  int
+		photos []color.Model
+	}{
+		{"empty page", 1, nil},
+		{"text", 2, nil},
+		{"photos", 3, []color.Model{color.RGBAModel, color.GrayModel}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			doc := &pdfDocument{}
+			var images []int
+			for _, model := range test.photos {
+				index, width, height, err := doc.addJPEG(testJPEG(t, model))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if width != 40 || height != 30 {
+					t.Errorf("photo is %dx%d, want 40x30", width, height)
+				}
+				images = append(images, index)
+			}
+			for i := 0; i < test.pages; i++ {
+				page := doc.addPage()
+				if test.name == "empty page" {
+					continue
+				}
+				page.text(40, 800, pdfHelveticaBold, 12, fmt.Sprintf("Page (%d) \\ Kiste für Geschirr", i+1))
+				page.textRight(555, 780, 10, "1234.56")
+				page.line(40, 770, 555, 770)
+				for j, index := range images {
+					page.image(index, 40+float64(j)*60, 600, 48, 36)
+				}
+			}
+			objects := parsePDF(t, doc.bytes())
+			if pages := pdfPageCount(t, objects); pages != test.pages {
+				t.Errorf("document has %d pages, want %d", pages, test.pages)
+			}
+			// Catalog, page tree, fonts, images and a content stream and page object per page
+			if want := 2 + len(pdfFonts) + len(test.photos) + 2*test.pages; len(objects) != want {
+				t.Errorf("document has %d objects, want %d", len(objects), want)
+			}
+		})
+	}
+}
+
+func TestPDFString(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"Box 1", "Box 1"},
+		{"Tools (garage)", `Tools \(garage\)`},
+		{`C:\backup`, `C:\\backup`},
+		{"Line\nbreak", "Line break"},
+		{"Kiste für Geschirr", "Kiste f\xfcr Geschirr"},
+		{"5 €", "5 \x80"},
+		{"Box 箱", "Box ?"},
+	}
+	for _, test := range tests {
+		if got := pdfString(test.text); got != test.want {
+			t.Errorf("pdfString(%q) = %q, want %q", test.text, got, test.want)
+		}
+	}
+}
+
+func TestInsurancePDF(t *testing.T) {
+	report := InsuranceReport{
+		Inventory:   "Home",
+		Currency:    "EUR",
+		GeneratedAt: time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC),
+	}
+	// Enough items to fill more than one page
+	box := InsuranceBox{ID: 1, Name: "Electronics (office)"}
+	for i := 1; i <= 80; i++ {
+		item := InsuranceItem{Item: Item{ID: i, Name: fmt.Sprintf("Item %d", i), Quantity: 1, HasPhoto: i%20 == 0}}
+		item.CurrentValue.Int64, item.CurrentValue.Valid = int64(i*1000), true
+		item.Total = item.CurrentValue
+		box.Items = append(box.Items, item)
+		box.Total += int64(i * 1000)
+	}
+	report.Locations = []InsuranceLocation{{Name: "Attic", Boxes: []InsuranceBox{box}, Total: box.Total}}
+	report.Items, report.Total = len(box.Items), box.Total
+	photos := map[int][]byte{20: testJPEG(t, color.RGBAModel), 40: testJPEG(t, color.GrayModel), 60: []byte("not a jpeg")}
+
+	objects := parsePDF(t, insurancePDF(report, photos))
+	if pages := pdfPageCount(t, objects); pages < 2 {
+		t.Errorf("80 items fit on %d page", pages)
+	}
+	images := 0
+	for _, object := range objects {
+		if bytes.Contains(object, []byte("/Subtype /Image")) {
+			images++
+		}
+	}
+	if images != 2 {
+		t.Errorf("document has %d images, want the 2 valid photos", images)
+	}
+}
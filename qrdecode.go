@@ -0,0 +1,943 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/japanese"
+)
+
+// Returned when no readable QR code could be located in an image
+var ErrNoQRCode = errors.New("no QR code found")
+
+// Reading QR codes (ISO/IEC 18004) from black and white images.
+//
+// The three finder patterns in the corners are located by their 1:1:3:1:1 ratio of dark and light modules,
+// the grid between them is sampled through a perspective transform, and the modules are then
+// unmasked, read in the zigzag order, error corrected with Reed-Solomon and decoded.
+
+// Error correction codewords per block and number of blocks, indexed by error correction level (L, M, Q, H) and version
+var (
+	qrECCodewordsPerBlock = [4][41]int{
+		{-1, 7, 10, 15, 20, 26, 18, 20, 24, 30, 18, 20, 24, 26, 30, 22, 24, 28, 30, 28, 28, 28, 28, 30, 30, 26, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+		{-1, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26, 30, 22, 22, 24, 24, 28, 28, 26, 26, 26, 26, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28},
+		{-1, 13, 22, 18, 26, 18, 24, 18, 22, 20, 24, 28, 26, 24, 20, 30, 24, 28, 28, 26, 30, 28, 30, 30, 30, 30, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+		{-1, 17, 28, 22, 16, 22, 28, 26, 26, 24, 28, 24, 28, 22, 24, 24, 30, 28, 28, 26, 28, 30, 24, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	}
+	qrNumBlocks = [4][41]int{
+		{-1, 1, 1, 1, 1, 1, 2, 2, 2, 2, 4, 4, 4, 4, 4, 6, 6, 6, 6, 7, 8, 8, 9, 9, 10, 12, 12, 12, 13, 14, 15, 16, 17, 18, 19, 19, 20, 21, 22, 24, 25},
+		{-1, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5, 5, 8, 9, 9, 10, 10, 11, 13, 14, 16, 17, 17, 18, 20, 21, 23, 25, 26, 28, 29, 31, 33, 35, 37, 38, 40, 43, 45, 47, 49},
+		{-1, 1, 1, 2, 2, 4, 4, 6, 6, 8, 8, 8, 10, 12, 16, 12, 17, 16, 18, 21, 20, 23, 23, 25, 27, 29, 34, 34, 35, 38, 40, 43, 45, 48, 51, 53, 56, 59, 62, 65, 68},
+		{-1, 1, 1, 2, 4, 4, 4, 5, 6, 8, 8, 11, 11, 16, 16, 18, 16, 19, 21, 25, 25, 25, 34, 30, 32, 35, 37, 40, 42, 45, 48, 51, 54, 57, 60, 63, 66, 70, 74, 77, 81},
+	}
+)
+
+// Error correction levels in the order of qrECCodewordsPerBlock, indexed by the two bits stored in the format information
+var qrFormatLevels = [4]int{1, 0, 3, 2}
+
+// A point in image coordinates
+type qrPoint struct {
+	x, y float64
+}
+
+// A candidate finder pattern together with how often it was seen
+type qrFinder struct {
+	qrPoint
+	moduleSize float64
+	count      int
+}
+
+func qrDistance(a, b qrPoint) float64 {
+	return math.Hypot(a.x-b.x, a.y-b.y)
+}
+
+// Locates and decodes a single QR code
+func decodeQR(img *bitImage) (string, error) {
+	finders := findQRFinders(img)
+	for _, triple := range qrFinderTriples(finders) {
+		bottomLeft, topLeft, topRight := orderQRFinders(triple)
+		moduleSize := qrModuleSize(img, topLeft, topRight, bottomLeft)
+		estimate := (qrDistance(topLeft.qrPoint, topRight.qrPoint)+qrDistance(topLeft.qrPoint, bottomLeft.qrPoint))/(2*moduleSize) + 7
+		dimension := int(math.Round(estimate))
+		// Valid sizes are 21, 25, ..., 177; the estimate can be off a little for skewed codes
+		switch dimension % 4 {
+		case 0:
+			dimension++
+		case 2:
+			dimension--
+		case 3:
+			dimension -= 2
+		}
+		for _, size := range []int{dimension, dimension + 4, dimension - 4} {
+			if size < 21 || size > 177 {
+				continue
+			}
+			text, err := decodeQRGrid(img, topLeft.qrPoint, topRight.qrPoint, bottomLeft.qrPoint, moduleSize, size)
+			if err == nil {
+				return text, nil
+			}
+		}
+	}
+	return "", ErrNoQRCode
+}
+
+// Measures the module size along the edges of the code. The runs through the finder patterns found while
+// scanning are too long for rotated codes, the lines between the finder centers run along the modules.
+func qrModuleSize(img *bitImage, topLeft, topRight, bottomLeft qrFinder) float64 {
+	var sum float64
+	n := 0
+	for _, pair := range [][2]qrFinder{{topLeft, topRight}, {topRight, topLeft}, {topLeft, bottomLeft}, {bottomLeft, topLeft}} {
+		if size, ok := qrModuleSizeAlong(img, pair[0].qrPoint, pair[1].qrPoint); ok {
+			sum += size
+			n++
+		}
+	}
+	if n == 0 {
+		return (bottomLeft.moduleSize + topLeft.moduleSize + topRight.moduleSize) / 3
+	}
+	return sum / float64(n)
+}
+
+// Walks from the center of a finder pattern towards another point and returns the module size
+// from the distance to the outer edge of the pattern, which is 3.5 modules away
+func qrModuleSizeAlong(img *bitImage, from, to qrPoint) (float64, bool) {
+	length := qrDistance(from, to)
+	dx, dy := (to.x-from.x)/length, (to.y-from.y)/length
+	// Dark center, light ring, dark ring
+	changes := 0
+	black := true
+	for d := 0.0; d < length/2; d++ {
+		if img.black(int(from.x+d*dx), int(from.y+d*dy)) == black {
+			continue
+		}
+		black = !black
+		if changes++; changes == 3 {
+			return d / 3.5, true
+		}
+	}
+	return 0, false
+}
+
+// Scans the image line by line for the 1:1:3:1:1 pattern of finder patterns and confirms them vertically and horizontally
+func findQRFinders(img *bitImage) []qrFinder {
+	var finders []qrFinder
+	step := max(img.height/400, 1)
+	for y := 0; y < img.height; y += step {
+		var counts [5]int
+		state := 0
+		for x := 0; x <= img.width; x++ {
+			black := x < img.width && img.black(x, y)
+			if black {
+				if state%2 == 1 {
+					state++
+				}
+				counts[state]++
+				continue
+			}
+			if state%2 == 1 {
+				counts[state]++
+				continue
+			}
+			if state < 4 {
+				if counts[state] > 0 {
+					state++
+					counts[state]++
+				}
+				continue
+			}
+			if qrFinderRatio(counts) {
+				if finder, ok := confirmQRFinder(img, counts, x, y); ok {
+					finders = addQRFinder(finders, finder)
+				}
+			}
+			// Keep the last black-white-black as the possible start of the next pattern
+			counts = [5]int{counts[2], counts[3], counts[4], 1, 0}
+			state = 3
+		}
+	}
+	return finders
+}
+
+// Reports whether the run lengths look like a 1:1:3:1:1 finder pattern
+func qrFinderRatio(counts [5]int) bool {
+	total := 0
+	for _, c := range counts {
+		if c == 0 {
+			return false
+		}
+		total += c
+	}
+	if total < 7 {
+		return false
+	}
+	module := float64(total) / 7
+	variance := module / 2
+	return math.Abs(module-float64(counts[0])) < variance &&
+		math.Abs(module-float64(counts[1])) < variance &&
+		math.Abs(3*module-float64(counts[2])) < 3*variance &&
+		math.Abs(module-float64(counts[3])) < variance &&
+		math.Abs(module-float64(counts[4])) < variance
+}
+
+// Checks a horizontal finder candidate ending before x in the vertical and again in the horizontal direction
+func confirmQRFinder(img *bitImage, counts [5]int, x, y int) (qrFinder, bool) {
+	total := counts[0] + counts[1] + counts[2] + counts[3] + counts[4]
+	centerX := float64(x-counts[4]-counts[3]) - float64(counts[2])/2
+	centerY, ok := qrCrossCheck(img, int(centerX), y, 0, 1, counts[2], total)
+	if !ok {
+		return qrFinder{}, false
+	}
+	centerX, ok = qrCrossCheck(img, int(centerX), int(centerY), 1, 0, counts[2], total)
+	if !ok {
+		return qrFinder{}, false
+	}
+	// A diagonal check weeds out most false positives in busy photos
+	if !qrCrossCheckDiagonal(img, int(centerX), int(centerY), counts[2], total) {
+		return qrFinder{}, false
+	}
+	return qrFinder{qrPoint: qrPoint{centerX, centerY}, moduleSize: float64(total) / 7, count: 1}, true
+}
+
+// Counts the finder pattern runs through (x, y) in the direction (dx, dy) and returns the center along that direction
+func qrCrossCheck(img *bitImage, x, y, dx, dy int, maxCount, originalTotal int) (float64, bool) {
+	var counts [5]int
+	inside := func(x, y int) bool { return x >= 0 && y >= 0 && x < img.width && y < img.height }
+	// Walk backwards from the center
+	cx, cy := x, y
+	for inside(cx, cy) && img.black(cx, cy) {
+		counts[2]++
+		cx, cy = cx-dx, cy-dy
+	}
+	for inside(cx, cy) && !img.black(cx, cy) && counts[1] <= maxCount {
+		counts[1]++
+		cx, cy = cx-dx, cy-dy
+	}
+	if !inside(cx, cy) || counts[1] > maxCount {
+		return 0, false
+	}
+	for inside(cx, cy) && img.black(cx, cy) && counts[0] <= maxCount {
+		counts[0]++
+		cx, cy = cx-dx, cy-dy
+	}
+	if counts[0] > maxCount {
+		return 0, false
+	}
+	// And forwards
+	cx, cy = x+dx, y+dy
+	for inside(cx, cy) && img.black(cx, cy) {
+		counts[2]++
+		cx, cy = cx+dx, cy+dy
+	}
+	for inside(cx, cy) && !img.black(cx, cy) && counts[3] <= maxCount {
+		counts[3]++
+		cx, cy = cx+dx, cy+dy
+	}
+	if !inside(cx, cy) || counts[3] > maxCount {
+		return 0, false
+	}
+	for inside(cx, cy) && img.black(cx, cy) && counts[4] <= maxCount {
+		counts[4]++
+		cx, cy = cx+dx, cy+dy
+	}
+	if counts[4] > maxCount {
+		return 0, false
+	}
+	total := counts[0] + counts[1] + counts[2] + counts[3] + counts[4]
+	if 5*abs(total-originalTotal) >= 2*originalTotal || !qrFinderRatio(counts) {
+		return 0, false
+	}
+	// Position of the first pixel after the pattern along the direction
+	end := cx*dx + cy*dy
+	return float64(end-counts[4]-counts[3]) - float64(counts[2])/2, true
+}
+
+// Checks the finder pattern along the diagonal through its center
+func qrCrossCheckDiagonal(img *bitImage, x, y, maxCount, originalTotal int) bool {
+	var counts [5]int
+	i := 0
+	for img.black(x-i, y-i) && x-i >= 0 && y-i >= 0 {
+		counts[2]++
+		i++
+	}
+	for !img.black(x-i, y-i) && x-i >= 0 && y-i >= 0 && counts[1] <= maxCount {
+		counts[1]++
+		i++
+	}
+	for img.black(x-i, y-i) && counts[0] <= maxCount {
+		counts[0]++
+		i++
+	}
+	i = 1
+	for img.black(x+i, y+i) {
+		counts[2]++
+		i++
+	}
+	for !img.black(x+i, y+i) && x+i < img.width && y+i < img.height && counts[3] <= maxCount {
+		counts[3]++
+		i++
+	}
+	for img.black(x+i, y+i) && counts[4] <= maxCount {
+		counts[4]++
+		i++
+	}
+	total := counts[0] + counts[1] + counts[2] + counts[3] + counts[4]
+	// Diagonal runs are longer by up to the square root of two
+	return total < 2*originalTotal && qrFinderRatioLoose(counts)
+}
+
+// Like qrFinderRatio but with more tolerance, used for the diagonal check
+func qrFinderRatioLoose(counts [5]int) bool {
+	total := 0
+	for _, c := range counts {
+		if c == 0 {
+			return false
+		}
+		total += c
+	}
+	module := float64(total) / 7
+	variance := module / 1.333
+	return math.Abs(module-float64(counts[0])) < variance &&
+		math.Abs(module-float64(counts[1])) < variance &&
+		math.Abs(3*module-float64(counts[2])) < 3*variance &&
+		math.Abs(module-float64(counts[3])) < variance &&
+		math.Abs(module-float64(counts[4])) < variance
+}
+
+// Merges a confirmed finder with an earlier one at the same place or adds it as a new candidate
+func addQRFinder(finders []qrFinder, finder qrFinder) []qrFinder {
+	for i, f := range finders {
+		if math.Abs(f.x-finder.x) <= f.moduleSize && math.Abs(f.y-finder.y) <= f.moduleSize &&
+			math.Abs(f.moduleSize-finder.moduleSize) <= math.Max(1, f.moduleSize) {
+			n := float64(f.count)
+			finders[i] = qrFinder{
+				qrPoint:    qrPoint{(f.x*n + finder.x) / (n + 1), (f.y*n + finder.y) / (n + 1)},
+				moduleSize: (f.moduleSize*n + finder.moduleSize) / (n + 1),
+				count:      f.count + 1,
+			}
+			return finders
+		}
+	}
+	return append(finders, finder)
+}
+
+// Returns plausible combinations of three finder patterns, the most likely first.
+// The three patterns of a code have about the same module size and form a right isosceles triangle.
+func qrFinderTriples(finders []qrFinder) [][3]qrFinder {
+	sort.Slice(finders, func(i, j int) bool { return finders[i].count > finders[j].count })
+	if len(finders) > 12 {
+		finders = finders[:12]
+	}
+	type scored struct {
+		triple [3]qrFinder
+		score  float64
+	}
+	var candidates []scored
+	for i := 0; i < len(finders); i++ {
+		for j := i + 1; j < len(finders); j++ {
+			for k := j + 1; k < len(finders); k++ {
+				a, b, c := finders[i], finders[j], finders[k]
+				minSize := math.Min(a.moduleSize, math.Min(b.moduleSize, c.moduleSize))
+				maxSize := math.Max(a.moduleSize, math.Max(b.moduleSize, c.moduleSize))
+				if maxSize > 1.5*minSize {
+					continue
+				}
+				sides := []float64{qrDistance(a.qrPoint, b.qrPoint), qrDistance(b.qrPoint, c.qrPoint), qrDistance(a.qrPoint, c.qrPoint)}
+				sort.Float64s(sides)
+				// The finder centers are at least 14 modules apart
+				if sides[0] < 10*maxSize {
+					continue
+				}
+				legs := sides[0]/sides[1] - 1
+				hypotenuse := sides[2]*sides[2]/(sides[0]*sides[0]+sides[1]*sides[1]) - 1
+				if math.Abs(legs) > 0.5 || math.Abs(hypotenuse) > 0.5 {
+					continue
+				}
+				score := math.Abs(legs) + math.Abs(hypotenuse) + (maxSize-minSize)/maxSize - 0.01*float64(a.count+b.count+c.count)
+				candidates = append(candidates, scored{[3]qrFinder{a, b, c}, score})
+			}
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].score < candidates[j].score })
+	triples := make([][3]qrFinder, 0, len(candidates))
+	for _, c := range candidates {
+		triples = append(triples, c.triple)
+	}
+	return triples
+}
+
+// Orders three finder patterns as bottom left, top left and top right.
+// The top left one is opposite the longest side, the other two follow from the winding order.
+func orderQRFinders(f [3]qrFinder) (qrFinder, qrFinder, qrFinder) {
+	d01 := qrDistance(f[0].qrPoint, f[1].qrPoint)
+	d12 := qrDistance(f[1].qrPoint, f[2].qrPoint)
+	d02 := qrDistance(f[0].qrPoint, f[2].qrPoint)
+	var a, b, c qrFinder
+	switch {
+	case d12 >= d01 && d12 >= d02:
+		b, a, c = f[0], f[1], f[2]
+	case d02 >= d01 && d02 >= d12:
+		b, a, c = f[1], f[0], f[2]
+	default:
+		b, a, c = f[2], f[0], f[1]
+	}
+	if (c.x-b.x)*(a.y-b.y)-(c.y-b.y)*(a.x-b.x) < 0 {
+		a, c = c, a
+	}
+	return a, b, c
+}
+
+// Finds the alignment pattern (a dark module in a light ring) closest to the estimated position
+func findQRAlignment(img *bitImage, estimate qrPoint, moduleSize float64, allowance float64) (qrPoint, bool) {
+	radius := int(allowance * moduleSize)
+	cx, cy := int(estimate.x), int(estimate.y)
+	best, found := qrPoint{}, false
+	bestDistance := math.MaxFloat64
+	maxRun := int(2*moduleSize) + 1
+	for y := max(cy-radius, 0); y <= min(cy+radius, img.height-1); y++ {
+		for x := max(cx-radius, 0); x <= min(cx+radius, img.width-1); x++ {
+			// Only look at dark pixels with light on both sides
+			if !img.black(x, y) || img.black(x-1, y) {
+				continue
+			}
+			run := 0
+			for img.black(x+run, y) && run <= maxRun {
+				run++
+			}
+			if math.Abs(float64(run)-moduleSize) > moduleSize/2+1 {
+				continue
+			}
+			center := qrPoint{float64(x) + float64(run)/2, float64(y) + 0.5}
+			if !qrAlignmentRing(img, center, moduleSize) {
+				continue
+			}
+			if d := qrDistance(center, estimate); d < bestDistance {
+				best, bestDistance, found = center, d, true
+			}
+		}
+	}
+	if !found {
+		return best, false
+	}
+	// Center the pattern vertically as well
+	x := int(best.x)
+	top, bottom := int(best.y), int(best.y)
+	for img.black(x, top-1) {
+		top--
+	}
+	for img.black(x, bottom+1) {
+		bottom++
+	}
+	best.y = float64(top+bottom+1) / 2
+	return best, true
+}
+
+// Checks for the light ring around a dark module and the dark ring around that
+func qrAlignmentRing(img *bitImage, center qrPoint, moduleSize float64) bool {
+	for _, d := range [][2]float64{{1, 0}, {-1, 0}, {0, 1}, {0, -1}} {
+		light := qrPoint{center.x + d[0]*moduleSize, center.y + d[1]*moduleSize}
+		dark := qrPoint{center.x + d[0]*2*moduleSize, center.y + d[1]*2*moduleSize}
+		if img.black(int(light.x), int(light.y)) || !img.black(int(dark.x), int(dark.y)) {
+			return false
+		}
+	}
+	return img.black(int(center.x), int(center.y))
+}
+
+// Maps module coordinates of the code onto image coordinates
+type qrTransform [8]float64
+
+// Solves the projective transform mapping the four source points onto the four destination points
+func newQRTransform(src, dst [4]qrPoint) (qrTransform, bool) {
+	// x' = (a*x + b*y + c) / (g*x + h*y + 1), y' = (d*x + e*y + f) / (g*x + h*y + 1)
+	var m [8][9]float64
+	for i := 0; i < 4; i++ {
+		x, y, u, v := src[i].x, src[i].y, dst[i].x, dst[i].y
+		m[2*i] = [9]float64{x, y, 1, 0, 0, 0, -x * u, -y * u, u}
+		m[2*i+1] = [9]float64{0, 0, 0, x, y, 1, -x * v, -y * v, v}
+	}
+	// Gaussian elimination with partial pivoting
+	for col := 0; col < 8; col++ {
+		pivot := col
+		for row := col + 1; row < 8; row++ {
+			if math.Abs(m[row][col]) > math.Abs(m[pivot][col]) {
+				pivot = row
+			}
+		}
+		if math.Abs(m[pivot][col]) < 1e-12 {
+			return qrTransform{}, false
+		}
+		m[col], m[pivot] = m[pivot], m[col]
+		for row := 0; row < 8; row++ {
+			if row == col {
+				continue
+			}
+			factor := m[row][col] / m[col][col]
+			for k := col; k < 9; k++ {
+				m[row][k] -= factor * m[col][k]
+			}
+		}
+	}
+	var t qrTransform
+	for i := 0; i < 8; i++ {
+		t[i] = m[i][8] / m[i][i]
+	}
+	return t, true
+}
+
+func (t qrTransform) apply(x, y float64) qrPoint {
+	w := t[6]*x + t[7]*y + 1
+	return qrPoint{(t[0]*x + t[1]*y + t[2]) / w, (t[3]*x + t[4]*y + t[5]) / w}
+}
+
+// Samples a code of the given size between the finder patterns and decodes it.
+// The alignment pattern in the bottom right corner, if present, corrects for perspective.
+func decodeQRGrid(img *bitImage, topLeft, topRight, bottomLeft qrPoint, moduleSize float64, size int) (string, error) {
+	version := (size - 17) / 4
+	far := float64(size) - 3.5
+	bottomRight := qrPoint{topRight.x - topLeft.x + bottomLeft.x, topRight.y - topLeft.y + bottomLeft.y}
+	corners := [][2]qrPoint{{{far, far}, bottomRight}}
+	if version >= 2 {
+		// The bottom right alignment pattern is three modules closer to the center than the finder centers
+		correction := 1 - 3/(float64(size)-7)
+		estimate := qrPoint{topLeft.x + correction*(bottomRight.x-topLeft.x), topLeft.y + correction*(bottomRight.y-topLeft.y)}
+		for _, allowance := range []float64{4, 8, 16} {
+			if alignment, ok := findQRAlignment(img, estimate, moduleSize, allowance); ok {
+				corners = append([][2]qrPoint{{{far - 3, far - 3}, alignment}}, corners...)
+				break
+			}
+		}
+	}
+	var lastErr error = ErrNoQRCode
+	for _, corner := range corners {
+		transform, ok := newQRTransform(
+			[4]qrPoint{{3.5, 3.5}, {far, 3.5}, {3.5, far}, corner[0]},
+			[4]qrPoint{topLeft, topRight, bottomLeft, corner[1]},
+		)
+		if !ok {
+			continue
+		}
+		grid := make([][]bool, size)
+		for y := range grid {
+			grid[y] = make([]bool, size)
+			for x := range grid[y] {
+				p := transform.apply(float64(x)+0.5, float64(y)+0.5)
+				grid[y][x] = img.black(int(p.x), int(p.y))
+			}
+		}
+		text, err := decodeQRModules(grid)
+		if err == nil {
+			return text, nil
+		}
+		lastErr = err
+	}
+	return "", lastErr
+}
+
+// Computes the BCH protected format information for a level and mask as stored in the code
+func qrFormatBits(data int) int {
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	return (data<<10 | rem) ^ 0x5412
+}
+
+// Computes the BCH protected version information stored in codes of version 7 and up
+func qrVersionBits(version int) int {
+	rem := version
+	for i := 0; i < 12; i++ {
+		rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+	}
+	return version<<12 | rem
+}
+
+// Finds the valid code word closest to the read bits, allowing up to three wrong bits
+func qrClosestBits(read int, candidates func(int) int, count int) (int, bool) {
+	best, bestDistance := -1, 4
+	for i := 0; i < count; i++ {
+		if d := popCount(read ^ candidates(i)); d < bestDistance {
+			best, bestDistance = i, d
+		}
+	}
+	return best, best >= 0
+}
+
+func popCount(v int) int {
+	n := 0
+	for ; v != 0; v &= v - 1 {
+		n++
+	}
+	return n
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// Positions of the alignment pattern centers along each axis
+func qrAlignmentPositions(version int) []int {
+	if version == 1 {
+		return nil
+	}
+	count := version/7 + 2
+	step := 26
+	if version != 32 {
+		step = (version*4 + count*2 + 1) / (2*count - 2) * 2
+	}
+	positions := make([]int, count)
+	positions[0] = 6
+	for i, pos := count-1, version*4+10; i >= 1; i, pos = i-1, pos-step {
+		positions[i] = pos
+	}
+	return positions
+}
+
+// Marks all modules which are not part of the data: finder, timing and alignment patterns, format and version information
+func qrFunctionModules(version int) [][]bool {
+	size := version*4 + 17
+	function := make([][]bool, size)
+	for i := range function {
+		function[i] = make([]bool, size)
+	}
+	mark := func(left, top, width, height int) {
+		for y := top; y < top+height; y++ {
+			for x := left; x < left+width; x++ {
+				function[y][x] = true
+			}
+		}
+	}
+	mark(0, 0, 9, 9)
+	mark(size-8, 0, 8, 9)
+	mark(0, size-8, 9, 8)
+	positions := qrAlignmentPositions(version)
+	for i, x := range positions {
+		for j, y := range positions {
+			// Skip the three positions overlapping the finder patterns
+			if (i == 0 && j == 0) || (i == 0 && j == len(positions)-1) || (i == len(positions)-1 && j == 0) {
+				continue
+			}
+			mark(x-2, y-2, 5, 5)
+		}
+	}
+	mark(6, 9, 1, size-17)
+	mark(9, 6, size-17, 1)
+	if version >= 7 {
+		mark(size-11, 0, 3, 6)
+		mark(0, size-11, 6, 3)
+	}
+	return function
+}
+
+// Reports whether a data mask inverts the module at column x and row y
+func qrMask(mask, x, y int) bool {
+	switch mask {
+	case 0:
+		return (x+y)%2 == 0
+	case 1:
+		return y%2 == 0
+	case 2:
+		return x%3 == 0
+	case 3:
+		return (x+y)%3 == 0
+	case 4:
+		return (x/3+y/2)%2 == 0
+	case 5:
+		return x*y%2+x*y%3 == 0
+	case 6:
+		return (x*y%2+x*y%3)%2 == 0
+	default:
+		return ((x+y)%2+x*y%3)%2 == 0
+	}
+}
+
+// Decodes the sampled modules of a code, grid[y][x] is true for dark modules
+func decodeQRModules(grid [][]bool) (string, error) {
+	size := len(grid)
+	bit := func(x, y int) int {
+		if grid[y][x] {
+			return 1
+		}
+		return 0
+	}
+
+	// Format information, both copies are tried
+	var first, second int
+	for i := 0; i <= 5; i++ {
+		first |= bit(8, i) << i
+	}
+	first |= bit(8, 7)<<6 | bit(8, 8)<<7 | bit(7, 8)<<8
+	for i := 9; i < 15; i++ {
+		first |= bit(14-i, 8) << i
+	}
+	for i := 0; i < 8; i++ {
+		second |= bit(size-1-i, 8) << i
+	}
+	for i := 8; i < 15; i++ {
+		second |= bit(8, size-15+i) << i
+	}
+	format, ok := qrClosestBits(first, qrFormatBits, 32)
+	if !ok {
+		if format, ok = qrClosestBits(second, qrFormatBits, 32); !ok {
+			return "", errors.New("unreadable format information")
+		}
+	}
+	level := qrFormatLevels[format>>3]
+	mask := format & 7
+
+	version := (size - 17) / 4
+	if version >= 7 {
+		var first, second int
+		for i := 0; i < 18; i++ {
+			first |= bit(size-11+i%3, i/3) << i
+			second |= bit(i/3, size-11+i%3) << i
+		}
+		found, ok := qrClosestBits(first, func(v int) int { return qrVersionBits(v + 7) }, 34)
+		if !ok {
+			if found, ok = qrClosestBits(second, func(v int) int { return qrVersionBits(v + 7) }, 34); !ok {
+				return "", errors.New("unreadable version information")
+			}
+		}
+		if found+7 != version {
+			return "", errors.New("version does not match the size")
+		}
+	}
+
+	// Read the codewords in the zigzag order from the bottom right, two columns at a time
+	function := qrFunctionModules(version)
+	var codewords []byte
+	var current byte
+	bits := 0
+	for right := size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+				if (right+1)&2 == 0 {
+					y = size - 1 - vert
+				}
+				if function[y][x] {
+					continue
+				}
+				dark := grid[y][x] != qrMask(mask, x, y)
+				current <<= 1
+				if dark {
+					current |= 1
+				}
+				bits++
+				if bits == 8 {
+					codewords = append(codewords, current)
+					current, bits = 0, 0
+				}
+			}
+		}
+	}
+
+	data, err := qrCorrectBlocks(codewords, version, level)
+	if err != nil {
+		return "", err
+	}
+	return qrDecodeData(data, version)
+}
+
+// Splits the interleaved codewords into their blocks, corrects errors and returns the data codewords
+func qrCorrectBlocks(codewords []byte, version, level int) ([]byte, error) {
+	numBlocks := qrNumBlocks[level][version]
+	eccLen := qrECCodewordsPerBlock[level][version]
+	raw := len(codewords)
+	shortBlocks := numBlocks - raw%numBlocks
+	shortLen := raw / numBlocks
+	blocks := make([][]byte, numBlocks)
+	dataLens := make([]int, numBlocks)
+	for i := range blocks {
+		length := shortLen
+		if i >= shortBlocks {
+			length++
+		}
+		blocks[i] = make([]byte, length)
+		dataLens[i] = length - eccLen
+	}
+	// Data codewords are interleaved first, then the error correction codewords
+	k := 0
+	for i := 0; i < shortLen-eccLen+1; i++ {
+		for j := range blocks {
+			if i < dataLens[j] {
+				blocks[j][i] = codewords[k]
+				k++
+			}
+		}
+	}
+	for i := 0; i < eccLen; i++ {
+		for j := range blocks {
+			blocks[j][dataLens[j]+i] = codewords[k]
+			k++
+		}
+	}
+	var data []byte
+	for j, block := range blocks {
+		if err := reedSolomonCorrect(block, eccLen); err != nil {
+			return nil, err
+		}
+		data = append(data, block[:dataLens[j]]...)
+	}
+	return data, nil
+}
+
+// Reads bits from the data codewords
+type qrBitReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *qrBitReader) available() int {
+	return len(r.data)*8 - r.pos
+}
+
+func (r *qrBitReader) read(n int) int {
+	v := 0
+	for i := 0; i < n; i++ {
+		v <<= 1
+		if r.pos < len(r.data)*8 && r.data[r.pos/8]&(0x80>>(r.pos%8)) != 0 {
+			v |= 1
+		}
+		r.pos++
+	}
+	return v
+}
+
+// Characters of the alphanumeric mode
+const qrAlphanumeric = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ $%*+-./:"
+
+// Decodes the segments of the data codewords into text
+func qrDecodeData(data []byte, version int) (string, error) {
+	r := &qrBitReader{data: data}
+	// Length of the character count field for numeric, alphanumeric, byte and kanji segments
+	sizeClass := 0
+	if version >= 27 {
+		sizeClass = 2
+	} else if version >= 10 {
+		sizeClass = 1
+	}
+	countBits := map[int][3]int{1: {10, 12, 14}, 2: {9, 11, 13}, 4: {8, 16, 16}, 8: {8, 10, 12}}
+
+	var text strings.Builder
+	utf8ECI := false
+	for r.available() >= 4 {
+		mode := r.read(4)
+		switch mode {
+		case 0:
+			return text.String(), nil
+		case 1:
+			count := r.read(countBits[1][sizeClass])
+			for ; count >= 3; count -= 3 {
+				v := r.read(10)
+				if v > 999 {
+					return "", errors.New("invalid numeric segment")
+				}
+				fmt.Fprintf(&text, "%03d", v)
+			}
+			switch count {
+			case 2:
+				v := r.read(7)
+				if v > 99 {
+					return "", errors.New("invalid numeric segment")
+				}
+				fmt.Fprintf(&text, "%02d", v)
+			case 1:
+				v := r.read(4)
+				if v > 9 {
+					return "", errors.New("invalid numeric segment")
+				}
+				fmt.Fprintf(&text, "%d", v)
+			}
+		case 2:
+			count := r.read(countBits[2][sizeClass])
+			for ; count >= 2; count -= 2 {
+				v := r.read(11)
+				if v/45 >= 45 {
+					return "", errors.New("invalid alphanumeric segment")
+				}
+				text.WriteByte(qrAlphanumeric[v/45])
+				text.WriteByte(qrAlphanumeric[v%45])
+			}
+			if count == 1 {
+				v := r.read(6)
+				if v >= 45 {
+					return "", errors.New("invalid alphanumeric segment")
+				}
+				text.WriteByte(qrAlphanumeric[v])
+			}
+		case 4:
+			count := r.read(countBits[4][sizeClass])
+			if count*8 > r.available() {
+				return "", errors.New("byte segment is too long")
+			}
+			segment := make([]byte, count)
+			for i := range segment {
+				segment[i] = byte(r.read(8))
+			}
+			// Most encoders write UTF-8 without announcing it, the standard says ISO-8859-1
+			if utf8ECI || utf8.Valid(segment) {
+				text.Write(segment)
+			} else {
+				for _, b := range segment {
+					text.WriteRune(rune(b))
+				}
+			}
+		case 8:
+			count := r.read(countBits[8][sizeClass])
+			if count*13 > r.available() {
+				return "", errors.New("kanji segment is too long")
+			}
+			sjis := make([]byte, 0, count*2)
+			for i := 0; i < count; i++ {
+				v := r.read(13)
+				c := (v/0xC0)<<8 | v%0xC0
+				if c < 0x1F00 {
+					c += 0x8140
+				} else {
+					c += 0xC140
+				}
+				sjis = append(sjis, byte(c>>8), byte(c))
+			}
+			decoded, err := japanese.ShiftJIS.NewDecoder().Bytes(sjis)
+			if err != nil {
+				return "", err
+			}
+			text.Write(decoded)
+		case 7:
+			// Extended channel interpretation, only UTF-8 changes how this decoder reads bytes
+			designator := r.read(8)
+			switch {
+			case designator&0x80 == 0:
+			case designator&0xC0 == 0x80:
+				designator = (designator&0x3F)<<8 | r.read(8)
+			case designator&0xE0 == 0xC0:
+				designator = (designator&0x1F)<<16 | r.read(16)
+			default:
+				return "", errors.New("invalid ECI designator")
+			}
+			utf8ECI = designator == 26
+		case 3:
+			// Structured append header, the parts are decoded on their own
+			r.read(16)
+		case 5:
+			// FNC1 in first position
+		case 9:
+			// FNC1 in second position with its application indicator
+			r.read(8)
+		default:
+			return "", errors.New("unknown segment mode")
+		}
+	}
+	return text.String(), nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	"image"
+	"image/draw"
+	"image/png"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/skip2/go-qrcode"
+)
+
+// Formats of QR code images
+const (
+	qrFormatSVG = "svg"
+	qrFormatPNG = "png"
+)
+
+// Limits and defaults of the QR code image options
+const (
+	defaultQRSize      = 256
+	minQRSize          = 64
+	maxQRSize          = 4096
+	defaultQRQuietZone = 4
+	maxQRQuietZone     = 16
+)
+
+// Captions that can be shown below a QR code
+const (
+	qrCaptionName = "name"
+	qrCaptionCode = "code"
+)
+
+// Each caption line is this fraction of the width of the QR code high
+const qrCaptionLineRatio = 8
+
+// Error correction levels by their letter as printed in the QR code standard
+var qrLevels = map[string]qrcode.RecoveryLevel{
+	"L": qrcode.Low,
+	"M": qrcode.Medium,
+	"Q": qrcode.High,
+	"H": qrcode.Highest,
+}
+
+// Returned for invalid QR code image options
+var ErrInvalidQROptions = errors.New("invalid QR code options")
+
+// How a QR code image is rendered
+type QROptions struct {
+	// Either "svg" or "png"
+	Format string
+	// Width of the image in pixels
+	Size  int
+	Level qrcode.RecoveryLevel
+	// Width of the empty border around the code in modules
+	QuietZone int
+	// Captions shown below the code in order, "name" and "code"
+	Captions []string
+}
+
+// Parses the query parameters format, size, level, quiet and caption of a QR code image
+func parseQROptions(query url.Values) (QROptions, error) {
+	options := QROptions{Format: qrFormatSVG, Size: defaultQRSize, Level: qrcode.Medium, QuietZone: defaultQRQuietZone}
+	if format := strings.ToLower(query.Get("format")); format != "" {
+		if format != qrFormatSVG && format != qrFormatPNG {
+			return options, fmt.Errorf("%w: format must be svg or png", ErrInvalidQROptions)
+		}
+		options.Format = format
+	}
+	if size := query.Get("size"); size != "" {
+		parsed, err := strconv.Atoi(size)
+		if err != nil || parsed < minQRSize || parsed > maxQRSize {
+			return options, fmt.Errorf("%w: size must be between %d and %d", ErrInvalidQROptions, minQRSize, maxQRSize)
+		}
+		options.Size = parsed
+	}
+	if level := strings.ToUpper(query.Get("level")); level != "" {
+		parsed, ok := qrLevels[level]
+		if !ok {
+			return options, fmt.Errorf("%w: level must be L, M, Q or H", ErrInvalidQROptions)
+		}
+		options.Level = parsed
+	}
+	if quiet := query.Get("quiet"); quiet != "" {
+		parsed, err := strconv.Atoi(quiet)
+		if err != nil || parsed < 0 || parsed > maxQRQuietZone {
+			return options, fmt.Errorf("%w: quiet must be between 0 and %d", ErrInvalidQROptions, maxQRQuietZone)
+		}
+		options.QuietZone = parsed
+	}
+	for _, caption := range strings.Split(query.Get("caption"), ",") {
+		switch caption = strings.ToLower(strings.TrimSpace(caption)); caption {
+		case qrCaptionName, qrCaptionCode:
+			options.Captions = append(options.Captions, caption)
+		case "":
+		default:
+			return options, fmt.Errorf("%w: caption must be name, code or both", ErrInvalidQROptions)
+		}
+	}
+	return options, nil
+}
+
+// Renders the QR code of a link as an image. Captions maps "name" and "code" to their text,
+// empty ones are left out. Returns the image and its content type.
+func renderQRImage(link string, options QROptions, captions map[string]string) ([]byte, string, error) {
+	qr, err := qrcode.New(link, options.Level)
+	if err != nil {
+		return nil, "", err
+	}
+	qr.DisableBorder = true
+	modules := qr.Bitmap()
+
+	var lines []string
+	for _, caption := range options.Captions {
+		if text := strings.TrimSpace(captions[caption]); text != "" {
+			lines = append(lines, text)
+		}
+	}
+	if options.Format == qrFormatPNG {
+		data, err := renderQRPNG(modules, options, lines)
+		return data, "image/png", err
+	}
+	return renderQRSVG(modules, options, lines), "image/svg+xml", nil
+}
+
+// Draws QR modules as an SVG scaled to the requested width, one path for all dark modules
+func renderQRSVG(modules [][]bool, options QROptions, lines []string) []byte {
+	quiet := options.QuietZone
+	width := len(modules) + 2*quiet
+	lineHeight := float64(width) / qrCaptionLineRatio
+	height := float64(width) + lineHeight*float64(len(lines))
+
+	var svg bytes.Buffer
+	fmt.Fprintf(&svg, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %g" width="%d" height="%d" shape-rendering="crispEdges">`,
+		width, height, options.Size, int(float64(options.Size)*height/float64(width)))
+	svg.WriteString(`<rect width="100%" height="100%" fill="#fff"/><path fill="#000" d="`)
+	for y, row := range modules {
+		// Runs of dark modules become a single rectangle
+		for x := 0; x < len(row); x++ {
+			if !row[x] {
+				continue
+			}
+			run := 1
+			for x+run < len(row) && row[x+run] {
+				run++
+			}
+			fmt.Fprintf(&svg, "M%d %dh%dv1h-%dz", x+quiet, y+quiet, run, run)
+			x += run - 1
+		}
+	}
+	svg.WriteString(`"/>`)
+	for i, line := range lines {
+		// Place the baseline so the text sits in the middle of its line
+		y := float64(width) + lineHeight*(float64(i)+0.8)
+		fontSize := lineHeight * 0.8
+		// Squeeze lines that would be wider than the code, assuming glyphs about 0.6 times as wide as high
+		fit := ""
+		if available := float64(width - 2); fontSize*0.6*float64(len([]rune(line))) > available {
+			fit = fmt.Sprintf(` textLength="%g" lengthAdjust="spacingAndGlyphs"`, available)
+		}
+		fmt.Fprintf(&svg, `<text x="%g" y="%g" font-size="%g" font-family="sans-serif" text-anchor="middle"%s>%s</text>`,
+			float64(width)/2, y, fontSize, fit, html.EscapeString(line))
+	}
+	svg.WriteString(`</svg>`)
+	return svg.Bytes()
+}
+
+// Draws QR modules as a PNG with whole pixels per module, as close to the requested width as possible
+func renderQRPNG(modules [][]bool, options QROptions, lines []string) ([]byte, error) {
+	quiet := options.QuietZone
+	size := len(modules) + 2*quiet
+	scale := max(1, options.Size/size)
+	width := size * scale
+	lineHeight := width / qrCaptionLineRatio
+
+	img := image.NewGray(image.Rect(0, 0, width, width+lineHeight*len(lines)))
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+	drawQR(img, modules, image.Rect(quiet*scale, quiet*scale, (size-quiet)*scale, (size-quiet)*scale))
+	for i, line := range lines {
+		top := width + lineHeight*i
+		drawText(img, line, image.Rect(scale, top, width-scale, top+lineHeight), true)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import "errors"
+
+// Returned when a block has more errors than its error correction codewords can repair
+var ErrTooManyErrors = errors.New("too many errors to correct")
+
+// Exponent and logarithm tables of GF(256) with the polynomial x^8 + x^4 + x^3 + x^2 + 1 used by QR codes
+var gfExp, gfLog = func() ([512]byte, [256]byte) {
+	var exp [512]byte
+	var log [256]byte
+	x := 1
+	for i := 0; i < 255; i++ {
+		exp[i] = byte(x)
+		log[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11D
+		}
+	}
+	for i := 255; i < 512; i++ {
+		exp[i] = exp[i-255]
+	}
+	return exp, log
+}()
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+func gfDiv(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+255-int(gfLog[b])]
+}
+
+// Evaluates a polynomial with the coefficient of x^i at index i
+func gfEval(poly []byte, x byte) byte {
+	var result byte
+	for i := len(poly) - 1; i >= 0; i-- {
+		result = gfMul(result, x) ^ poly[i]
+	}
+	return result
+}
+
+// Corrects a Reed-Solomon block in place. The block holds the data codewords followed by eccLen
+// error correction codewords, the first codeword being the coefficient of the highest power.
+// Up to eccLen/2 wrong codewords are repaired.
+func reedSolomonCorrect(block []byte, eccLen int) error {
+	n := len(block)
+	// Syndromes are the received polynomial evaluated at the generator roots a^0 ... a^(eccLen-1)
+	syndromes := make([]byte, eccLen)
+	clean := true
+	for i := range syndromes {
+		var s byte
+		x := gfExp[i]
+		for _, c := range block {
+			s = gfMul(s, x) ^ c
+		}
+		syndromes[i] = s
+		if s != 0 {
+			clean = false
+		}
+	}
+	if clean {
+		return nil
+	}
+
+	// Berlekamp-Massey finds the error locator polynomial
+	locator := []byte{1}
+	previous := []byte{1}
+	length, shift := 0, 1
+	var lastDiscrepancy byte = 1
+	for i := 0; i < eccLen; i++ {
+		discrepancy := syndromes[i]
+		for j := 1; j <= length && j < len(locator); j++ {
+			discrepancy ^= gfMul(locator[j], syndromes[i-j])
+		}
+		if discrepancy == 0 {
+			shift++
+			continue
+		}
+		factor := gfDiv(discrepancy, lastDiscrepancy)
+		updated := make([]byte, max(len(locator), len(previous)+shift))
+		copy(updated, locator)
+		for j, c := range previous {
+			updated[j+shift] ^= gfMul(factor, c)
+		}
+		if 2*length <= i {
+			previous = locator
+			length = i + 1 - length
+			lastDiscrepancy = discrepancy
+			shift = 1
+		} else {
+			shift++
+		}
+		locator = updated
+	}
+	if 2*length > eccLen {
+		return ErrTooManyErrors
+	}
+
+	// The error evaluator is the syndrome polynomial times the locator, modulo x^eccLen
+	evaluator := make([]byte, eccLen)
+	for i := range evaluator {
+		for j := 0; j <= i && j < len(locator); j++ {
+			evaluator[i] ^= gfMul(locator[j], syndromes[i-j])
+		}
+	}
+	// The formal derivative of the locator keeps the odd powers only
+	derivative := make([]byte, len(locator))
+	for i := 1; i < len(locator); i += 2 {
+		derivative[i-1] = locator[i]
+	}
+
+	// Chien search: an error at the coefficient of x^p makes a^-p a root of the locator
+	found := 0
+	for p := 0; p < n; p++ {
+		inverse := gfExp[(255-p)%255]
+		if gfEval(locator, inverse) != 0 {
+			continue
+		}
+		// Forney's formula for the error value
+		denominator := gfEval(derivative, inverse)
+		if denominator == 0 {
+			return ErrTooManyErrors
+		}
+		magnitude := gfMul(gfExp[p], gfDiv(gfEval(evaluator, inverse), denominator))
+		block[n-1-p] ^= magnitude
+		found++
+	}
+	if found != length {
+		return ErrTooManyErrors
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// What a decoded code points to
+const (
+	ResolvedBox     = "box"
+	ResolvedItem    = "item"
+	ResolvedItems   = "items"
+	ResolvedProduct = "product"
+	ResolvedNone    = "none"
+)
+
+// Prefix of the short codes of items such as "I45"
+const itemCodePrefix = "I"
+
+// A decoded code together with what it points to in the inventory
+type CodeResolution struct {
+	Code DecodedCode `json:"code"`
+	// Either "box", "item", "items", "product" or "none".
+	// An item code resolves to exactly one item, a barcode to all items carrying it
+	// or to the catalog product if no item carries it yet.
+	Kind  string `json:"kind"`
+	BoxID int    `json:"box_id,omitempty"`
+	// The normalized barcode if the code is one
+	Barcode string        `json:"barcode,omitempty"`
+	Items   []LocatedItem `json:"items,omitempty"`
+	Product *Product      `json:"product,omitempty"`
+}
+
+// Returns the short code of an item
+func itemCode(id int) string {
+	return itemCodePrefix + strconv.Itoa(id)
+}
+
+// Returns the short code of the item
+func (i Item) Code() string {
+	return itemCode(i.ID)
+}
+
+// Returns the short code of the item
+func (c BoxContent) ItemCode() string {
+	return itemCode(int(c.ContentID.Int64))
+}
+
+// Parses a short code with the given prefix, ignoring case
+func parseShortCode(code, prefix string) (int, bool) {
+	if len(code) <= len(prefix) || !strings.EqualFold(code[:len(prefix)], prefix) {
+		return 0, false
+	}
+	id, err := strconv.Atoi(code[len(prefix):])
+	if err != nil || id < 1 {
+		return 0, false
+	}
+	return id, true
+}
+
+// Get an item together with its box
+func (d *Database) GetLocatedItem(db *sql.DB, id int) (LocatedItem, error) {
+	query := `
+	SELECT ` + itemColumns + `, boxes.name
+	FROM contents
+	JOIN boxes ON boxes.id = contents.box_id
+	WHERE contents.id = ?`
+	items, err := d.queryLocatedItems(db, query, id)
+	if err != nil {
+		return LocatedItem{}, err
+	}
+	if len(items) == 0 {
+		return LocatedItem{}, sql.ErrNoRows
+	}
+	return items[0], nil
+}
+
+// Get all items carrying the given normalized barcode together with their boxes
+func (d *Database) GetItemsByBarcode(db *sql.DB, barcode string) ([]LocatedItem, error) {
+	query := `
+	SELECT ` + itemColumns + `, boxes.name
+	FROM contents
+	JOIN boxes ON boxes.id = contents.box_id
+	WHERE contents.barcode = ?
+	ORDER BY boxes.name, contents.id`
+	return d.queryLocatedItems(db, query, barcode)
+}
+
+// Looks up what a decoded code points to.
+// Codes can be links to a box page or to the /s/ resolver of an inventory, box codes, item codes or barcodes.
+// Box codes are looked up first, so they win over item codes of the same form.
+func (d *Database) ResolveCode(db *sql.DB, code DecodedCode) (CodeResolution, error) {
+	resolution := CodeResolution{Code: code, Kind: ResolvedNone}
+	text := strings.TrimSpace(code.Text)
+	if u, err := url.Parse(text); err == nil && u.Scheme != "" {
+		path := strings.TrimSuffix(u.Path, "/")
+		// Codes are unique across inventories, so the inventory in the link is not needed to find them
+		if rest, ok := strings.CutPrefix(path, "/i/"); ok {
+			if _, short, ok := strings.Cut(rest, "/"); ok {
+				path = "/" + short
+			}
+		}
+		if id, ok := strings.CutPrefix(path, "/box/"); ok {
+			// Box QR codes printed before box codes existed link to the box id
+			boxID, err := strconv.Atoi(id)
+			if err != nil {
+				return resolution, nil
+			}
+			return resolution.withBox(d.GetBox(db, boxID))
+		} else if short, ok := strings.CutPrefix(path, "/s/"); ok {
+			text = short
+		} else {
+			return resolution, nil
+		}
+	}
+
+	box, err := d.GetBoxByCode(db, text)
+	if !errors.Is(err, sql.ErrNoRows) {
+		return resolution.withBox(box, err)
+	}
+	if itemID, ok := parseShortCode(text, itemCodePrefix); ok {
+		item, err := d.GetLocatedItem(db, itemID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return resolution, nil
+		}
+		if err != nil {
+			return resolution, err
+		}
+		resolution.Kind = ResolvedItem
+		resolution.BoxID = item.BoxID
+		resolution.Items = []LocatedItem{item}
+		return resolution, nil
+	}
+
+	barcode, err := normalizeBarcode(text)
+	if err != nil {
+		return resolution, nil
+	}
+	resolution.Barcode = barcode
+	items, err := d.GetItemsByBarcode(db, barcode)
+	if err != nil {
+		return resolution, err
+	}
+	if len(items) > 0 {
+		resolution.Kind = ResolvedItems
+		resolution.Items = items
+		return resolution, nil
+	}
+	product, err := d.GetProduct(db, barcode)
+	if errors.Is(err, sql.ErrNoRows) {
+		return resolution, nil
+	}
+	if err != nil {
+		return resolution, err
+	}
+	resolution.Kind = ResolvedProduct
+	resolution.Product = &product
+	return resolution, nil
+}
+
+// Completes a resolution with the result of looking up a box
+func (resolution CodeResolution) withBox(box Box, err error) (CodeResolution, error) {
+	if errors.Is(err, sql.ErrNoRows) {
+		return resolution, nil
+	}
+	if err != nil {
+		return resolution, err
+	}
+	resolution.Kind = ResolvedBox
+	resolution.BoxID = box.ID
+	return resolution, nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Name of the cookie holding the scan session of a browser
+const scanSessionCookie = "witb_scan_session"
+
+// Scan sessions end after this long without a scan
+const scanSessionIdleTimeout = 12 * time.Hour
+
+// A scan workflow in one browser: scan a box to make it active,
+// then every scanned item is moved into it and every scanned barcode added to it.
+// A session with a status sets that status on every box scanned instead.
+type ScanSession struct {
+	ID      string
+	BoxID   sql.NullInt64
+	BoxName sql.NullString
+	Status  sql.NullString
+	// Outcome of the last scan to show to the user
+	Message   sql.NullString
+	UpdatedAt time.Time
+}
+
+// Generates a random id for a new scan session
+func newScanSessionID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// Starts a new scan session without an active box and removes sessions that timed out.
+// A status other than "" makes it a session marking the boxes scanned with it.
+func (d *Database) CreateScanSession(db *sql.DB, status string) (ScanSession, error) {
+	if status != "" && !validBoxStatus(status) {
+		return ScanSession{}, ErrInvalidStatus
+	}
+	message := "Scan a box to start"
+	if status != "" {
+		message = fmt.Sprintf("Scan boxes to mark them as %s", strings.ToLower(boxStatusLabel(status)))
+	}
+	id, err := newScanSessionID()
+	if err != nil {
+		return ScanSession{}, err
+	}
+	_, err = db.Exec(`DELETE FROM scan_sessions WHERE updated_at < ?`, time.Now().Add(-scanSessionIdleTimeout).UTC())
+	if err != nil {
+		return ScanSession{}, err
+	}
+	query := `INSERT INTO scan_sessions (id, status, message, updated_at) VALUES (?, ?, ?, ?)`
+	_, err = db.Exec(query, id, sql.NullString{String: status, Valid: status != ""}, message, time.Now().UTC())
+	if err != nil {
+		return ScanSession{}, err
+	}
+	return d.GetScanSession(db, id)
+}
+
+// Get a scan session that has not timed out yet
+func (d *Database) GetScanSession(db *sql.DB, id string) (ScanSession, error) {
+	query := `
+	SELECT scan_sessions.id, scan_sessions.box_id, boxes.name, scan_sessions.status, scan_sessions.message, scan_sessions.updated_at
+	FROM scan_sessions
+	LEFT JOIN boxes ON boxes.id = scan_sessions.box_id
+	WHERE scan_sessions.id = ? AND scan_sessions.updated_at >= ?`
+	var session ScanSession
+	err := db.QueryRow(query, id, time.Now().Add(-scanSessionIdleTimeout).UTC()).Scan(&session.ID, &session.BoxID, &session.BoxName, &session.Status, &session.Message, &session.UpdatedAt)
+	return session, err
+}
+
+// Ends a scan session
+func (d *Database) EndScanSession(db *sql.DB, id string) error {
+	_, err := db.Exec(`DELETE FROM scan_sessions WHERE id = ?`, id)
+	return err
+}
+
+// Stores the active box and the outcome of the last scan of a session
+func (d *Database) updateScanSession(db *sql.DB, session ScanSession, message string) error {
+	query := `UPDATE scan_sessions SET box_id = ?, message = ?, updated_at = ? WHERE id = ?`
+	_, err := db.Exec(query, session.BoxID, message, time.Now().UTC(), session.ID)
+	return err
+}
+
+// Applies a scanned code to a scan session.
+// A box becomes the active box, an item is moved into the active box and a barcode is added to it.
+// The session is updated in place and stored together with the message shown to the user.
+func (d *Database) ApplyScan(db *sql.DB, session *ScanSession, resolution CodeResolution) error {
+	message, err := d.applyScan(db, session, resolution)
+	if err != nil {
+		return err
+	}
+	session.Message = sql.NullString{String: message, Valid: true}
+	return d.updateScanSession(db, *session, message)
+}
+
+// Applies a scan to the session in memory and returns the message for the user
+func (d *Database) applyScan(db *sql.DB, session *ScanSession, resolution CodeResolution) (string, error) {
+	if session.Status.Valid {
+		return d.applyStatusScan(db, session, resolution)
+	}
+	if resolution.Kind == ResolvedBox {
+		box, err := d.GetBox(db, resolution.BoxID)
+		if err != nil {
+			return "", err
+		}
+		session.BoxID = sql.NullInt64{Int64: int64(box.ID), Valid: true}
+		return fmt.Sprintf("Putting items into %s", box.Name), nil
+	}
+	if !session.BoxID.Valid {
+		return fmt.Sprintf("Scan a box first, %s is not a box", resolution.Code.Text), nil
+	}
+	boxID := int(session.BoxID.Int64)
+	box, err := d.GetBox(db, boxID)
+	if err != nil {
+		return "", err
+	}
+
+	if resolution.Kind == ResolvedItem {
+		item := resolution.Items[0]
+		if item.BoxID == boxID {
+			return fmt.Sprintf("%s is already in %s", item.Name, box.Name), nil
+		}
+		if err := d.MoveItem(db, item.BoxID, boxID, item.ID); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Moved %s from %s to %s", item.Name, item.BoxName, box.Name), nil
+	}
+
+	if resolution.Barcode == "" {
+		return fmt.Sprintf("Unknown code %s", resolution.Code.Text), nil
+	}
+	result, err := d.ScanBarcode(db, boxID, resolution.Barcode, 0)
+	if errors.Is(err, ErrUnknownBarcode) {
+		if len(resolution.Items) == 0 {
+			return fmt.Sprintf("Barcode %s is not in the product catalog", resolution.Barcode), nil
+		}
+		// Items in other boxes carrying the barcode are as good as the catalog
+		attrs := ItemAttributes{Barcode: sql.NullString{String: resolution.Barcode, Valid: true}}
+		name := resolution.Items[0].Name
+		if _, err := d.CreateItem(db, boxID, name, 1, attrs); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s now has 1 x %s", box.Name, name), nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s now has %d x %s", box.Name, result.Item.Quantity, result.Item.Name), nil
+}
+
+// Sets the status of the session on a scanned box, which becomes the box shown in the session
+func (d *Database) applyStatusScan(db *sql.DB, session *ScanSession, resolution CodeResolution) (string, error) {
+	if resolution.Kind != ResolvedBox {
+		return fmt.Sprintf("%s is not a box", resolution.Code.Text), nil
+	}
+	box, err := d.GetBox(db, resolution.BoxID)
+	if err != nil {
+		return "", err
+	}
+	session.BoxID = sql.NullInt64{Int64: int64(box.ID), Valid: true}
+	label := strings.ToLower(boxStatusLabel(session.Status.String))
+	changed, err := d.SetBoxStatus(db, []int{box.ID}, session.Status.String)
+	if err != nil {
+		return "", err
+	}
+	if changed == 0 {
+		return fmt.Sprintf("%s is already %s", box.Name, label), nil
+	}
+	return fmt.Sprintf("%s is now %s", box.Name, label), nil
+}
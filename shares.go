@@ -0,0 +1,211 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Setting holding the server secret share links are signed with
+const settingShareSecret = "share_secret"
+
+// Limits of the lifetime of a share link
+const (
+	defaultShareDays = 7
+	maxShareDays     = 365
+)
+
+// Returned for share links that are malformed, forged, expired or revoked
+var ErrInvalidShareToken = errors.New("invalid or expired share link")
+
+// A read-only link to the contents of a single box.
+// The token is not stored. It is signed with the server secret and can be rebuilt from the share.
+type Share struct {
+	ID        int            `json:"id"`
+	BoxID     int            `json:"box_id"`
+	BoxName   string         `json:"box_name"`
+	Note      JSONNullString `json:"note"`
+	ExpiresAt time.Time      `json:"expires_at"`
+	CreatedAt time.Time      `json:"created_at"`
+	Token     string         `json:"token"`
+}
+
+// Returns the path of the read-only page of the share
+func (s Share) Path() string {
+	return "/shared/" + s.Token
+}
+
+// Returns the secret share links are signed with, creating it on first use
+func (d *Database) shareSecret(db *sql.DB) ([]byte, error) {
+	secret, err := d.GetSetting(db, settingShareSecret)
+	if err != nil {
+		return nil, err
+	}
+	if secret == "" {
+		random := make([]byte, 32)
+		if _, err := rand.Read(random); err != nil {
+			return nil, err
+		}
+		// Another request may have created the secret in the meantime, the first one wins
+		query := `INSERT INTO settings (key, value) VALUES (?, ?) ON CONFLICT (key) DO NOTHING`
+		if _, err := db.Exec(query, settingShareSecret, hex.EncodeToString(random)); err != nil {
+			return nil, err
+		}
+		if secret, err = d.GetSetting(db, settingShareSecret); err != nil {
+			return nil, err
+		}
+	}
+	return hex.DecodeString(secret)
+}
+
+// Signs the id, box and expiry of a share
+func signShare(secret []byte, id int, boxID int, expires int64) []byte {
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "share:%d:%d:%d", id, boxID, expires)
+	return mac.Sum(nil)
+}
+
+// Builds the token of a share: its id, its expiry and the signature
+func shareToken(secret []byte, share Share) string {
+	expires := share.ExpiresAt.Unix()
+	signature := base64.RawURLEncoding.EncodeToString(signShare(secret, share.ID, share.BoxID, expires))
+	return fmt.Sprintf("%d.%d.%s", share.ID, expires, signature)
+}
+
+// Creates a share link for a box that is valid until the given time
+func (d *Database) CreateShare(db *sql.DB, boxID int, expiresAt time.Time, note string) (Share, error) {
+	if _, err := d.GetBox(db, boxID); err != nil {
+		return Share{}, err
+	}
+	expiresAt = expiresAt.UTC().Truncate(time.Second)
+	query := `INSERT INTO shares (box_id, note, expires_at) VALUES (?, ?, ?)`
+	result, err := db.Exec(query, boxID, sql.NullString{String: note, Valid: note != ""}, expiresAt)
+	if err != nil {
+		return Share{}, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return Share{}, err
+	}
+	return d.getShare(db, int(id))
+}
+
+// Get a share that has not been revoked together with its token
+func (d *Database) getShare(db *sql.DB, id int) (Share, error) {
+	shares, err := d.queryShares(db, `WHERE shares.id = ? AND shares.revoked_at IS NULL`, id)
+	if err != nil {
+		return Share{}, err
+	}
+	if len(shares) == 0 {
+		return Share{}, sql.ErrNoRows
+	}
+	return shares[0], nil
+}
+
+// Get all shares that are neither expired nor revoked, expiring first
+func (d *Database) GetActiveShares(db *sql.DB) ([]Share, error) {
+	return d.queryShares(db, `WHERE shares.revoked_at IS NULL AND shares.expires_at > ? ORDER BY shares.expires_at, shares.id`, time.Now().UTC())
+}
+
+// Queries shares with their box names and fills in their tokens
+func (d *Database) queryShares(db *sql.DB, where string, args ...any) ([]Share, error) {
+	secret, err := d.shareSecret(db)
+	if err != nil {
+		return nil, err
+	}
+	query := `
+	SELECT shares.id, shares.box_id, boxes.name, shares.note, shares.expires_at, shares.created_at
+	FROM shares
+	JOIN boxes ON boxes.id = shares.box_id
+	` + where
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	shares := make([]Share, 0)
+	for rows.Next() {
+		var share Share
+		if err := rows.Scan(&share.ID, &share.BoxID, &share.BoxName, &share.Note.NullString, &share.ExpiresAt, &share.CreatedAt); err != nil {
+			return nil, err
+		}
+		share.Token = shareToken(secret, share)
+		shares = append(shares, share)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return shares, nil
+}
+
+// Revokes a share so its link stops working
+func (d *Database) RevokeShare(db *sql.DB, id int) error {
+	result, err := db.Exec(`UPDATE shares SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL`, time.Now().UTC(), id)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// Returns the inventory of the box of a share, sql.ErrNoRows if the share does not exist
+func (d *Database) shareInventory(db *sql.DB, shareID int) (int, error) {
+	var inventoryID int
+	query := `SELECT boxes.inventory_id FROM shares JOIN boxes ON boxes.id = shares.box_id WHERE shares.id = ?`
+	err := db.QueryRow(query, shareID).Scan(&inventoryID)
+	return inventoryID, err
+}
+
+// Checks the token of a share link and returns the share it belongs to
+func (d *Database) ResolveShareToken(db *sql.DB, token string) (Share, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Share{}, ErrInvalidShareToken
+	}
+	id, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return Share{}, ErrInvalidShareToken
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return Share{}, ErrInvalidShareToken
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Share{}, ErrInvalidShareToken
+	}
+
+	share, err := d.getShare(db, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Share{}, ErrInvalidShareToken
+	}
+	if err != nil {
+		return Share{}, err
+	}
+	secret, err := d.shareSecret(db)
+	if err != nil {
+		return Share{}, err
+	}
+	if !hmac.Equal(signature, signShare(secret, share.ID, share.BoxID, expires)) || expires != share.ExpiresAt.Unix() {
+		return Share{}, ErrInvalidShareToken
+	}
+	if time.Now().Unix() >= expires {
+		return Share{}, ErrInvalidShareToken
+	}
+	return share, nil
+}
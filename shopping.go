@@ -0,0 +1,186 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Returned when checking off an item which is not on the shopping list
+var ErrNotOnShoppingList = errors.New("item is not on the shopping list")
+
+// An item below its minimum quantity as part of a shopping list entry
+type ShoppingListItem struct {
+	ItemID   int    `json:"item_id"`
+	BoxID    int    `json:"box_id"`
+	BoxName  string `json:"box_name"`
+	Quantity int    `json:"quantity"`
+	Needed   int    `json:"needed"`
+}
+
+// Shortfalls of all items sharing the same name (ignoring case) across all boxes
+type ShoppingListEntry struct {
+	Name   string             `json:"name"`
+	Have   int                `json:"have"`
+	Needed int                `json:"needed"`
+	Items  []ShoppingListItem `json:"items"`
+}
+
+// Items need restocking once they fall below the minimum (or the target if no minimum is set)
+// and are restocked up to the target (or the minimum if no target is set).
+const shoppingListQuery = `
+	SELECT contents.id, contents.box_id, boxes.name, contents.name, contents.quantity,
+		MAX(COALESCE(contents.target_quantity, contents.min_quantity), COALESCE(contents.min_quantity, contents.target_quantity)) - contents.quantity AS needed
+	FROM contents
+	JOIN boxes ON boxes.id = contents.box_id
+	WHERE (contents.min_quantity IS NOT NULL OR contents.target_quantity IS NOT NULL)
+	AND contents.quantity < COALESCE(contents.min_quantity, contents.target_quantity)
+	AND (? = 0 OR boxes.inventory_id = ?)`
+
+// Builds the shopping list from all items of an inventory below their minimum quantity.
+// The inventory 0 builds it from all inventories.
+func (d *Database) GetShoppingList(db *sql.DB, inventoryID int) ([]ShoppingListEntry, error) {
+	rows, err := db.Query(shoppingListQuery+` ORDER BY LOWER(TRIM(contents.name)), needed DESC`, inventoryID, inventoryID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]ShoppingListEntry, 0)
+	index := make(map[string]int)
+	for rows.Next() {
+		var item ShoppingListItem
+		var name string
+		if err := rows.Scan(&item.ItemID, &item.BoxID, &item.BoxName, &name, &item.Quantity, &item.Needed); err != nil {
+			return nil, err
+		}
+		key := strings.ToLower(strings.TrimSpace(name))
+		i, ok := index[key]
+		if !ok {
+			i = len(entries)
+			index[key] = i
+			entries = append(entries, ShoppingListEntry{Name: strings.TrimSpace(name)})
+		}
+		entries[i].Have += item.Quantity
+		entries[i].Needed += item.Needed
+		entries[i].Items = append(entries[i].Items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(entries, func(a, b int) bool {
+		return strings.ToLower(entries[a].Name) < strings.ToLower(entries[b].Name)
+	})
+	return entries, nil
+}
+
+// Adds the bought quantity to the items of a shopping list entry with an atomic transaction.
+// Items are filled up to their target in the order of their shortfall, anything left over goes to the first item.
+// Only items of the inventory are restocked, or of all inventories if it is 0.
+func (d *Database) CheckOffShoppingListEntry(db *sql.DB, inventoryID int, name string, bought int) ([]ShoppingListItem, error) {
+	// Start a transaction
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	// Defer a rollback in case something fails.
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	query := shoppingListQuery + ` AND LOWER(TRIM(contents.name)) = LOWER(TRIM(?)) ORDER BY needed DESC, contents.id`
+	rows, err := tx.Query(query, inventoryID, inventoryID, name)
+	if err != nil {
+		return nil, err
+	}
+	var items []ShoppingListItem
+	for rows.Next() {
+		var item ShoppingListItem
+		var itemName string
+		if err = rows.Scan(&item.ItemID, &item.BoxID, &item.BoxName, &itemName, &item.Quantity, &item.Needed); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		err = ErrNotOnShoppingList
+		return nil, err
+	}
+
+	// Distribute the bought quantity
+	added := make([]int, len(items))
+	remaining := bought
+	for i, item := range items {
+		added[i] = min(item.Needed, remaining)
+		remaining -= added[i]
+	}
+	added[0] += remaining
+
+	updateQuery := `UPDATE contents SET quantity = quantity + ?, version = version + 1, updated_at = CURRENT_TIMESTAMP WHERE id = ?`
+	var restocked []ShoppingListItem
+	var events []Event
+	for i, item := range items {
+		if added[i] == 0 {
+			continue
+		}
+		if _, err = tx.Exec(updateQuery, added[i], item.ItemID); err != nil {
+			return nil, fmt.Errorf("failed to restock item %d: %w", item.ItemID, err)
+		}
+		if _, err = recordStockMovement(tx, item.ItemID, added[i], MovementRestock, "shopping list"); err != nil {
+			return nil, err
+		}
+		item.Quantity += added[i]
+		item.Needed = max(item.Needed-added[i], 0)
+		restocked = append(restocked, item)
+		var event Event
+		if event, err = d.recordEvent(tx, Event{Type: EventItemUpdated, BoxID: item.BoxID, ItemID: item.ItemID}); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	// Commit the transaction
+	err = tx.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	for _, event := range events {
+		d.publish(event)
+	}
+	return restocked, nil
+}
+
+// Renders the shopping list as plain text, one line per entry
+func shoppingListText(entries []ShoppingListEntry) string {
+	var b strings.Builder
+	for _, entry := range entries {
+		fmt.Fprintf(&b, "%d x %s\n", entry.Needed, entry.Name)
+	}
+	return b.String()
+}
+
+// Renders the shopping list as a Markdown checklist including the boxes to put things into
+func shoppingListMarkdown(entries []ShoppingListEntry) string {
+	var b strings.Builder
+	b.WriteString("# Shopping list\n\n")
+	if len(entries) == 0 {
+		b.WriteString("Nothing to buy.\n")
+	}
+	for _, entry := range entries {
+		boxes := make([]string, len(entry.Items))
+		for i, item := range entry.Items {
+			boxes[i] = item.BoxName
+		}
+		fmt.Fprintf(&b, "- [ ] %d x %s (%s)\n", entry.Needed, entry.Name, strings.Join(boxes, ", "))
+	}
+	return b.String()
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Reasons for a change of an items quantity as recorded in the stock movements ledger
+const (
+	MovementConsume = "consume"
+	MovementRestock = "restock"
+	MovementAdjust  = "adjust"
+)
+
+// Returned when consuming more than an item has left
+var ErrInsufficientStock = errors.New("not enough stock left")
+
+// A single change of an items quantity
+type StockMovement struct {
+	ID            int            `json:"id"`
+	ItemID        int            `json:"item_id"`
+	Delta         int            `json:"delta"`
+	Reason        string         `json:"reason"`
+	QuantityAfter int            `json:"quantity_after"`
+	Note          JSONNullString `json:"note"`
+	CreatedAt     time.Time      `json:"created_at"`
+}
+
+// How fast an item is used up within the last days
+type ConsumptionStats struct {
+	ItemID       int          `json:"item_id"`
+	Quantity     int          `json:"quantity"`
+	Days         int          `json:"days"`
+	Consumed     int          `json:"consumed"`
+	Restocked    int          `json:"restocked"`
+	UnitsPerWeek float64      `json:"units_per_week"`
+	RunsOutAt    JSONNullTime `json:"runs_out_at"`
+}
+
+// Writes an entry to the stock movements ledger within a transaction which already changed the quantity
+func recordStockMovement(tx *sql.Tx, itemID, delta int, reason string, note string) (StockMovement, error) {
+	query := `
+	INSERT INTO stock_movements (content_id, delta, reason, quantity_after, note)
+	SELECT id, ?, ?, quantity, ? FROM contents WHERE id = ?
+	RETURNING id, content_id, delta, reason, quantity_after, note, created_at`
+	var movement StockMovement
+	err := tx.QueryRow(query, delta, reason, sql.NullString{String: note, Valid: note != ""}, itemID).
+		Scan(&movement.ID, &movement.ItemID, &movement.Delta, &movement.Reason, &movement.QuantityAfter, &movement.Note.NullString, &movement.CreatedAt)
+	if err != nil {
+		return movement, fmt.Errorf("failed to record stock movement: %w", err)
+	}
+	return movement, nil
+}
+
+// Changes the quantity of an item by delta and records the change in the ledger with an atomic transaction.
+// The quantity never drops below zero, ErrInsufficientStock is returned instead.
+func (d *Database) AdjustItemQuantity(db *sql.DB, itemID, delta int, reason string, note string) (StockMovement, error) {
+	var movement StockMovement
+	// Start a transaction
+	tx, err := db.Begin()
+	if err != nil {
+		return movement, err
+	}
+	// Defer a rollback in case something fails.
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var boxID int
+	query := `
+	UPDATE contents
+	SET quantity = quantity + ?, version = version + 1, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND quantity + ? >= 0
+	RETURNING box_id`
+	err = tx.QueryRow(query, delta, itemID, delta).Scan(&boxID)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Either the item does not exist or it does not have enough left
+		if _, getErr := d.GetItem(db, itemID); getErr == nil {
+			err = ErrInsufficientStock
+		}
+		return movement, err
+	}
+	if err != nil {
+		return movement, err
+	}
+	movement, err = recordStockMovement(tx, itemID, delta, reason, note)
+	if err != nil {
+		return movement, err
+	}
+	event, err := d.recordEvent(tx, Event{Type: EventItemUpdated, BoxID: boxID, ItemID: itemID})
+	if err != nil {
+		return movement, err
+	}
+
+	// Commit the transaction
+	err = tx.Commit()
+	if err != nil {
+		return movement, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	d.publish(event)
+	return movement, nil
+}
+
+// Get the latest entries of the stock movements ledger of an item
+func (d *Database) GetStockMovements(db *sql.DB, itemID int, limit int) ([]StockMovement, error) {
+	query := `
+	SELECT id, content_id, delta, reason, quantity_after, note, created_at
+	FROM stock_movements
+	WHERE content_id = ?
+	ORDER BY id DESC
+	LIMIT ?`
+	rows, err := db.Query(query, itemID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	movements := make([]StockMovement, 0)
+	for rows.Next() {
+		var movement StockMovement
+		if err := rows.Scan(&movement.ID, &movement.ItemID, &movement.Delta, &movement.Reason, &movement.QuantityAfter, &movement.Note.NullString, &movement.CreatedAt); err != nil {
+			return nil, err
+		}
+		movements = append(movements, movement)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return movements, nil
+}
+
+// Calculates the consumption rate of an item over the last days and projects when it runs out.
+// Items added within that period are only measured since they were added.
+func (d *Database) GetConsumptionStats(db *sql.DB, itemID int, days int) (ConsumptionStats, error) {
+	item, err := d.GetItem(db, itemID)
+	if err != nil {
+		return ConsumptionStats{}, err
+	}
+	stats := ConsumptionStats{ItemID: item.ID, Quantity: item.Quantity, Days: days}
+
+	query := `
+	SELECT
+		COALESCE(SUM(CASE WHEN reason = ? THEN -delta END), 0),
+		COALESCE(SUM(CASE WHEN reason = ? THEN delta END), 0)
+	FROM stock_movements
+	WHERE content_id = ? AND created_at >= datetime('now', ?)`
+	err = db.QueryRow(query, MovementConsume, MovementRestock, itemID, fmt.Sprintf("-%d days", days)).Scan(&stats.Consumed, &stats.Restocked)
+	if err != nil {
+		return stats, err
+	}
+
+	now := time.Now()
+	since := now.AddDate(0, 0, -days)
+	if item.AddedAt.After(since) {
+		since = item.AddedAt
+	}
+	// Measure at least a day so a few fresh movements do not result in absurd rates
+	period := max(now.Sub(since), 24*time.Hour)
+	stats.UnitsPerWeek = float64(stats.Consumed) / period.Hours() * 24 * 7
+	if stats.UnitsPerWeek > 0 {
+		weeksLeft := float64(item.Quantity) / stats.UnitsPerWeek
+		stats.RunsOutAt.NullTime = sql.NullTime{Time: now.Add(time.Duration(weeksLeft * float64(7*24*time.Hour))), Valid: true}
+	}
+	return stats, nil
+}
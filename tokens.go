@@ -0,0 +1,268 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Scopes of API tokens. Each scope includes the ones before it.
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+	ScopeAdmin = "admin"
+)
+
+// Scopes in order of increasing rights
+var apiScopes = []string{ScopeRead, ScopeWrite, ScopeAdmin}
+
+// Prefix of API tokens, which makes them easy to recognize in configs and secret scanners
+const apiTokenPrefix = "witb_"
+
+// Key of the API token of the request in the gin context
+const apiTokenKey = "api_token"
+
+// Returned for unknown scopes
+var ErrInvalidScope = errors.New("scope must be read, write or admin")
+
+// Returned for API tokens that don't exist or have been revoked
+var ErrInvalidAPIToken = errors.New("invalid API token")
+
+// A token for scripts and integrations to access the API.
+// Only a hash of the token is stored. The token itself is only known when it is created.
+type APIToken struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	// The start of the token to tell tokens apart
+	Prefix     string       `json:"prefix"`
+	Scopes     []string     `json:"scopes"`
+	CreatedAt  time.Time    `json:"created_at"`
+	LastUsedAt sql.NullTime `json:"-"`
+	// The inventories the token may use, empty for all of them
+	Inventories []int `json:"inventories"`
+}
+
+// Reports whether the token has the given scope or one that includes it
+func (t APIToken) Allows(scope string) bool {
+	needed := scopeRank(scope)
+	for _, s := range t.Scopes {
+		if scopeRank(s) >= needed {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the position of a scope in apiScopes, -1 if it is unknown
+func scopeRank(scope string) int {
+	for i, s := range apiScopes {
+		if s == scope {
+			return i
+		}
+	}
+	return -1
+}
+
+// Parses a comma separated list of scopes such as "read,write"
+func parseScopes(value string) ([]string, error) {
+	var scopes []string
+	for _, scope := range strings.Split(value, ",") {
+		scope = strings.ToLower(strings.TrimSpace(scope))
+		if scope == "" {
+			continue
+		}
+		if scopeRank(scope) < 0 {
+			return nil, ErrInvalidScope
+		}
+		scopes = append(scopes, scope)
+	}
+	if len(scopes) == 0 {
+		return nil, ErrInvalidScope
+	}
+	return scopes, nil
+}
+
+// Hashes an API or session token for storage. Tokens are long random strings, so a plain SHA-256 is enough.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Creates an API token limited to the given inventories, or for all of them if there are none,
+// and returns it together with the token itself, which is not stored
+func (d *Database) CreateAPIToken(db *sql.DB, name string, scopes []string, inventoryIDs []int) (APIToken, string, error) {
+	random := make([]byte, 24)
+	if _, err := rand.Read(random); err != nil {
+		return APIToken{}, "", err
+	}
+	secret := apiTokenPrefix + hex.EncodeToString(random)
+	prefix := secret[:len(apiTokenPrefix)+6]
+
+	// Start a transaction
+	tx, err := db.Begin()
+	if err != nil {
+		return APIToken{}, "", err
+	}
+	// Defer a rollback in case something fails.
+	defer tx.Rollback()
+
+	query := `INSERT INTO api_tokens (name, prefix, token_hash, scopes) VALUES (?, ?, ?, ?)`
+	result, err := tx.Exec(query, name, prefix, hashToken(secret), strings.Join(scopes, ","))
+	if err != nil {
+		return APIToken{}, "", err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return APIToken{}, "", err
+	}
+	if err := setGrants(tx, `api_token_inventories`, `token_id`, int(id), inventoryIDs); err != nil {
+		return APIToken{}, "", err
+	}
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		return APIToken{}, "", err
+	}
+	tokens, err := d.queryAPITokens(db, `WHERE id = ?`, id)
+	if err != nil {
+		return APIToken{}, "", err
+	}
+	return tokens[0], secret, nil
+}
+
+// Get all API tokens that have not been revoked
+func (d *Database) GetAPITokens(db *sql.DB) ([]APIToken, error) {
+	return d.queryAPITokens(db, `WHERE revoked_at IS NULL ORDER BY id`)
+}
+
+// Queries API tokens
+func (d *Database) queryAPITokens(db *sql.DB, where string, args ...any) ([]APIToken, error) {
+	query := `
+	SELECT id, name, prefix, scopes, created_at, last_used_at,
+		(SELECT GROUP_CONCAT(inventory_id) FROM api_token_inventories WHERE token_id = api_tokens.id)
+	FROM api_tokens ` + where
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := make([]APIToken, 0)
+	for rows.Next() {
+		var token APIToken
+		var scopes string
+		var inventories sql.NullString
+		if err := rows.Scan(&token.ID, &token.Name, &token.Prefix, &scopes, &token.CreatedAt, &token.LastUsedAt, &inventories); err != nil {
+			return nil, err
+		}
+		token.Inventories = scanInventoryGrants(inventories)
+		token.Scopes = strings.Split(scopes, ",")
+		tokens = append(tokens, token)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// Revokes an API token so it can't be used anymore
+func (d *Database) RevokeAPIToken(db *sql.DB, id int) error {
+	result, err := db.Exec(`UPDATE api_tokens SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL`, time.Now().UTC(), id)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// Looks up the API token a request was made with and records that it was used
+func (d *Database) UseAPIToken(db *sql.DB, secret string) (APIToken, error) {
+	tokens, err := d.queryAPITokens(db, `WHERE token_hash = ? AND revoked_at IS NULL`, hashToken(secret))
+	if err != nil {
+		return APIToken{}, err
+	}
+	if len(tokens) == 0 {
+		return APIToken{}, ErrInvalidAPIToken
+	}
+	_, err = db.Exec(`UPDATE api_tokens SET last_used_at = ? WHERE id = ?`, time.Now().UTC(), tokens[0].ID)
+	return tokens[0], err
+}
+
+// Returns the scope a request to the API needs: read for reading, write for everything else
+func requestScope(c *gin.Context) string {
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return ScopeRead
+	}
+	return ScopeWrite
+}
+
+// Checks the API token of requests to the API, sent as "Authorization: Bearer <token>".
+// A token must be valid and have the scope the request needs. Without a token the request passes,
+// unless tokens are required and the user is not known from the authentication proxy.
+func apiTokenAuth(required bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		header := c.GetHeader("Authorization")
+		if header == "" {
+			if required && currentActor(c) == "" {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"fail": "API token required"})
+				return
+			}
+			c.Next()
+			return
+		}
+		secret, ok := strings.CutPrefix(header, "Bearer ")
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"fail": "use Authorization: Bearer <token>"})
+			return
+		}
+		token, err := database.UseAPIToken(client, strings.TrimSpace(secret))
+		if errors.Is(err, ErrInvalidAPIToken) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"fail": err.Error()})
+			return
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"fail": "could not check API token"})
+			return
+		}
+		if !token.Allows(requestScope(c)) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"fail": fmt.Sprintf("API token lacks the %s scope", requestScope(c))})
+			return
+		}
+		c.Set(apiTokenKey, token)
+		// Changes made with the token are recorded for it unless the proxy named a user
+		if currentActor(c) == "" {
+			c.Set(actorKey, "token:"+token.Name)
+		}
+		c.Next()
+	}
+}
+
+// Only lets admins through: requests with an admin token or, with user accounts, signed in admins.
+// Requests without either were already checked by apiTokenAuth and userAuth. They only get this far without
+// user accounts, where everyone may do everything unless the authentication proxy decides who gets in.
+func requireAdmin(c *gin.Context) {
+	if value, ok := c.Get(apiTokenKey); ok {
+		if !value.(APIToken).Allows(ScopeAdmin) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"fail": "API token lacks the admin scope"})
+			return
+		}
+	} else if user, ok := currentUser(c); ok && !user.Allows(RoleAdmin) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"fail": "only admins may do this"})
+		return
+	}
+	c.Next()
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Roles of user accounts. Each role includes the ones before it.
+const (
+	// May look at boxes and items
+	RoleViewer = "viewer"
+	// May also change boxes and items
+	RoleEditor = "editor"
+	// May also manage users, API tokens and webhooks
+	RoleAdmin = "admin"
+)
+
+// Roles in order of increasing rights
+var userRoles = []string{RoleViewer, RoleEditor, RoleAdmin}
+
+// Name of the cookie holding the session of a signed in user
+const sessionCookie = "witb_session"
+
+// How long a user stays signed in
+const sessionLifetime = 30 * 24 * time.Hour
+
+// Passwords shorter than this are rejected
+const minPasswordLength = 8
+
+// Key of the signed in user in the gin context
+const userKey = "user"
+
+// Returned for unknown roles
+var ErrInvalidRole = errors.New("role must be viewer, editor or admin")
+
+// Returned for passwords that are too short
+var ErrWeakPassword = errors.New("password must be at least 8 characters")
+
+// Returned for unknown users and wrong passwords alike, so they can't be told apart
+var ErrInvalidLogin = errors.New("invalid username or password")
+
+// Returned for sessions that don't exist or have expired
+var ErrInvalidSession = errors.New("invalid or expired session")
+
+// Returned when a change would leave no admin to manage the users
+var ErrLastAdmin = errors.New("at least one admin is required")
+
+// Compared against when a user does not exist, so failed logins take as long for unknown users
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("what's in the box"), bcrypt.DefaultCost)
+
+// A user account of the built-in user management
+type User struct {
+	ID        int       `json:"id"`
+	Username  string    `json:"username"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+	// The inventories the user may use, empty for all of them
+	Inventories []int `json:"inventories"`
+}
+
+// Reports whether the user has the given role or one that includes it
+func (u User) Allows(role string) bool {
+	return roleRank(u.Role) >= roleRank(role)
+}
+
+// Returns the position of a role in userRoles, -1 if it is unknown
+func roleRank(role string) int {
+	for i, r := range userRoles {
+		if r == role {
+			return i
+		}
+	}
+	return -1
+}
+
+// Parses the name of a role
+func parseRole(value string) (string, error) {
+	role := strings.ToLower(strings.TrimSpace(value))
+	if roleRank(role) < 0 {
+		return "", ErrInvalidRole
+	}
+	return role, nil
+}
+
+// Hashes a password with bcrypt
+func hashPassword(password string) (string, error) {
+	if len(password) < minPasswordLength {
+		return "", ErrWeakPassword
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// Creates a user account with the given password and role
+func (d *Database) CreateUser(db *sql.DB, username string, password string, role string) (User, error) {
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return User{}, errors.New("username is required")
+	}
+	role, err := parseRole(role)
+	if err != nil {
+		return User{}, err
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return User{}, err
+	}
+	result, err := db.Exec(`INSERT INTO users (username, password_hash, role) VALUES (?, ?, ?)`, username, hash, role)
+	if err != nil {
+		return User{}, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return User{}, err
+	}
+	return d.getUser(db, `WHERE id = ?`, id)
+}
+
+// Get all user accounts ordered by name
+func (d *Database) GetUsers(db *sql.DB) ([]User, error) {
+	return d.queryUsers(db, `ORDER BY username`)
+}
+
+// Get a single user account by its name
+func (d *Database) GetUserByName(db *sql.DB, username string) (User, error) {
+	return d.getUser(db, `WHERE username = ?`, username)
+}
+
+// Get a single user account, sql.ErrNoRows if there is none
+func (d *Database) getUser(db *sql.DB, where string, args ...any) (User, error) {
+	users, err := d.queryUsers(db, where, args...)
+	if err != nil {
+		return User{}, err
+	}
+	if len(users) == 0 {
+		return User{}, sql.ErrNoRows
+	}
+	return users[0], nil
+}
+
+// Queries user accounts
+func (d *Database) queryUsers(db *sql.DB, where string, args ...any) ([]User, error) {
+	query := `
+	SELECT id, username, role, created_at,
+		(SELECT GROUP_CONCAT(inventory_id) FROM user_inventories WHERE user_id = users.id)
+	FROM users ` + where
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := make([]User, 0)
+	for rows.Next() {
+		var user User
+		var inventories sql.NullString
+		if err := rows.Scan(&user.ID, &user.Username, &user.Role, &user.CreatedAt, &inventories); err != nil {
+			return nil, err
+		}
+		user.Inventories = scanInventoryGrants(inventories)
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// Returns ErrLastAdmin if the user is the only admin
+func (d *Database) checkOtherAdmin(tx *sql.Tx, id int) error {
+	var others int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM users WHERE role = ? AND id != ?`, RoleAdmin, id).Scan(&others); err != nil {
+		return err
+	}
+	var role string
+	if err := tx.QueryRow(`SELECT role FROM users WHERE id = ?`, id).Scan(&role); err != nil {
+		return err
+	}
+	if role == RoleAdmin && others == 0 {
+		return ErrLastAdmin
+	}
+	return nil
+}
+
+// Changes the role of a user. The last admin can't be demoted.
+func (d *Database) SetUserRole(db *sql.DB, id int, role string) error {
+	role, err := parseRole(role)
+	if err != nil {
+		return err
+	}
+	// Start a transaction
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	// Defer a rollback in case something fails.
+	defer tx.Rollback()
+
+	if role != RoleAdmin {
+		if err := d.checkOtherAdmin(tx, id); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`UPDATE users SET role = ? WHERE id = ?`, role, id); err != nil {
+		return err
+	}
+	// Commit the transaction
+	return tx.Commit()
+}
+
+// Deletes a user and signs them out everywhere. The last admin can't be deleted.
+func (d *Database) DeleteUser(db *sql.DB, id int) error {
+	// Start a transaction
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	// Defer a rollback in case something fails.
+	defer tx.Rollback()
+
+	if err := d.checkOtherAdmin(tx, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM sessions WHERE user_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM user_inventories WHERE user_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM users WHERE id = ?`, id); err != nil {
+		return err
+	}
+	// Commit the transaction
+	return tx.Commit()
+}
+
+// Checks the password of a user
+func (d *Database) Authenticate(db *sql.DB, username string, password string) (User, error) {
+	var id int
+	var hash string
+	err := db.QueryRow(`SELECT id, password_hash FROM users WHERE username = ?`, strings.TrimSpace(username)).Scan(&id, &hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		return User{}, ErrInvalidLogin
+	}
+	if err != nil {
+		return User{}, err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return User{}, ErrInvalidLogin
+	}
+	return d.getUser(db, `WHERE id = ?`, id)
+}
+
+// Starts a session for a user and returns its token, which is only stored as a hash
+func (d *Database) CreateSession(db *sql.DB, userID int) (string, time.Time, error) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return "", time.Time{}, err
+	}
+	token := hex.EncodeToString(random)
+	now := time.Now().UTC()
+	expiresAt := now.Add(sessionLifetime).Truncate(time.Second)
+	// Clean up expired sessions while at it
+	if _, err := db.Exec(`DELETE FROM sessions WHERE expires_at <= ?`, now); err != nil {
+		return "", time.Time{}, err
+	}
+	query := `INSERT INTO sessions (user_id, token_hash, expires_at) VALUES (?, ?, ?)`
+	if _, err := db.Exec(query, userID, hashToken(token), expiresAt); err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
+}
+
+// Get the user a session belongs to
+func (d *Database) GetSessionUser(db *sql.DB, token string) (User, error) {
+	user, err := d.getUser(db, `WHERE id = (SELECT user_id FROM sessions WHERE token_hash = ? AND expires_at > ?)`, hashToken(token), time.Now().UTC())
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, ErrInvalidSession
+	}
+	return user, err
+}
+
+// Ends a session
+func (d *Database) DeleteSession(db *sql.DB, token string) error {
+	_, err := db.Exec(`DELETE FROM sessions WHERE token_hash = ?`, hashToken(token))
+	return err
+}
+
+// Returns the role a request needs: viewer for reading, editor for everything else
+func requestRole(c *gin.Context) string {
+	if requestScope(c) == ScopeRead {
+		return RoleViewer
+	}
+	return RoleEditor
+}
+
+// Returns the signed in user of the request
+func currentUser(c *gin.Context) (User, bool) {
+	value, ok := c.Get(userKey)
+	if !ok {
+		return User{}, false
+	}
+	return value.(User), true
+}
+
+// Requires a signed in user with a role that allows the request on every page and API endpoint.
+// The user comes from the session cookie or, behind the authentication proxy, from the account named like the proxy user.
+// API requests with a token are left to apiTokenAuth. Share links are meant for people without an account.
+func userAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		path := c.Request.URL.Path
+		if path == "/login" || strings.HasPrefix(path, "/shared/") {
+			c.Next()
+			return
+		}
+		isAPI := strings.HasPrefix(path, "/api/")
+		user, err := sessionUser(c)
+		if err != nil && !errors.Is(err, ErrInvalidSession) && !errors.Is(err, sql.ErrNoRows) {
+			log.Println(err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"fail": "could not check session"})
+			return
+		}
+		if err != nil {
+			if isAPI && c.GetHeader("Authorization") != "" {
+				c.Next()
+				return
+			}
+			if isAPI || c.Request.Method != http.MethodGet {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"fail": "sign in required"})
+				return
+			}
+			c.Redirect(http.StatusFound, "/login?next="+url.QueryEscape(c.Request.URL.RequestURI()))
+			c.Abort()
+			return
+		}
+		c.Set(userKey, user)
+		c.Set(actorKey, user.Username)
+		// Every user may sign out
+		if path != "/logout" && !user.Allows(requestRole(c)) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"fail": "the " + user.Role + " role may not do this"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// Looks up the user of a request by the session cookie or the name passed by the authentication proxy
+func sessionUser(c *gin.Context) (User, error) {
+	if token, err := c.Cookie(sessionCookie); err == nil && token != "" {
+		user, err := database.GetSessionUser(client, token)
+		if !errors.Is(err, ErrInvalidSession) {
+			return user, err
+		}
+	}
+	if actor := currentActor(c); actor != "" {
+		return database.GetUserByName(client, actor)
+	}
+	return User{}, ErrInvalidSession
+}
+
+// Sets or, with an empty token, clears the session cookie
+func setSessionCookie(c *gin.Context, token string, maxAge int) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(sessionCookie, token, maxAge, "/", "", secureRequest(c), true)
+}
+
+// Returns the page to go to after signing in. Only local paths are accepted.
+func loginTarget(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
@@ -0,0 +1,453 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Outbox states of a webhook delivery
+const (
+	outboxPending   = "pending"
+	outboxDelivered = "delivered"
+	outboxFailed    = "failed"
+)
+
+// Define webhook struct with json marshalling config
+// The secret is only returned once when the webhook is created.
+type Webhook struct {
+	ID        int       `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"secret,omitempty"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Reports whether the webhook is subscribed to the given event type
+func (w Webhook) Wants(eventType string) bool {
+	return slices.Contains(w.Events, "*") || slices.Contains(w.Events, eventType)
+}
+
+// Define webhook delivery struct with json marshalling config
+type WebhookDelivery struct {
+	ID          int            `json:"id"`
+	OutboxID    int            `json:"outbox_id"`
+	EventType   string         `json:"event_type"`
+	Attempt     int            `json:"attempt"`
+	StatusCode  JSONNullInt64  `json:"status_code"`
+	Error       JSONNullString `json:"error"`
+	DurationMS  int            `json:"duration_ms"`
+	AttemptedAt time.Time      `json:"attempted_at"`
+}
+
+// A queued webhook delivery
+type outboxEntry struct {
+	ID        int
+	WebhookID int
+	URL       string
+	Secret    string
+	EventType string
+	Payload   []byte
+	Attempts  int
+}
+
+// JSON body sent to webhook receivers
+type webhookPayload struct {
+	Event     string    `json:"event"`
+	Time      time.Time `json:"time"`
+	BoxID     int       `json:"box_id"`
+	ItemID    int       `json:"item_id,omitempty"`
+	FromBoxID int       `json:"from_box_id,omitempty"`
+	Actor     string    `json:"actor,omitempty"`
+	Box       *Box      `json:"box,omitempty"`
+	Item      *Item     `json:"item,omitempty"`
+}
+
+// Generates a random secret used to sign webhook payloads
+func newWebhookSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
+
+// Signs a payload with HMAC-SHA256, formatted like the X-WITB-Signature header
+func signWebhookPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Checks the webhook url and event types before a subscription is stored
+func validateWebhook(rawURL string, events []string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid webhook url %q", rawURL)
+	}
+	if len(events) == 0 {
+		return errors.New("at least one event type is required")
+	}
+	for _, event := range events {
+		if event != "*" && !slices.Contains(eventTypes, event) {
+			return fmt.Errorf("unknown event type %q", event)
+		}
+	}
+	return nil
+}
+
+// Inserts a new webhook subscription
+func (d *Database) CreateWebhook(db *sql.DB, rawURL string, events []string, secret string) (Webhook, error) {
+	query := `INSERT INTO webhooks (url, events, secret) VALUES (?, ?, ?)`
+	result, err := db.Exec(query, rawURL, strings.Join(events, ","), secret)
+	if err != nil {
+		return Webhook{}, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return Webhook{}, err
+	}
+	webhook, err := d.GetWebhook(db, int(id))
+	webhook.Secret = secret
+	return webhook, err
+}
+
+// Get a single webhook subscription without its secret
+func (d *Database) GetWebhook(db *sql.DB, id int) (Webhook, error) {
+	query := `SELECT id, url, events, active, created_at FROM webhooks WHERE id = ?`
+	var webhook Webhook
+	var events string
+	err := db.QueryRow(query, id).Scan(&webhook.ID, &webhook.URL, &events, &webhook.Active, &webhook.CreatedAt)
+	webhook.Events = strings.Split(events, ",")
+	return webhook, err
+}
+
+// Get all webhook subscriptions without their secrets
+func (d *Database) GetWebhooks(db queryer) ([]Webhook, error) {
+	query := `SELECT id, url, events, active, created_at FROM webhooks ORDER BY id`
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := make([]Webhook, 0)
+	for rows.Next() {
+		var webhook Webhook
+		var events string
+		if err := rows.Scan(&webhook.ID, &webhook.URL, &events, &webhook.Active, &webhook.CreatedAt); err != nil {
+			return nil, err
+		}
+		webhook.Events = strings.Split(events, ",")
+		webhooks = append(webhooks, webhook)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+// Deletes a webhook together with its queued deliveries and delivery log
+func (d *Database) DeleteWebhook(db *sql.DB, id int) error {
+	// Start a transaction
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	// Defer a rollback in case something fails.
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	for _, query := range []string{
+		`DELETE FROM webhook_deliveries WHERE webhook_id = ?`,
+		`DELETE FROM webhook_outbox WHERE webhook_id = ?`,
+		`DELETE FROM webhooks WHERE id = ?`,
+	} {
+		if _, err = tx.Exec(query, id); err != nil {
+			return fmt.Errorf("failed to delete webhook: %w", err)
+		}
+	}
+
+	// Commit the transaction
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// Get the most recent delivery attempts of a webhook
+func (d *Database) GetWebhookDeliveries(db *sql.DB, webhookID int, limit int) ([]WebhookDelivery, error) {
+	query := `
+	SELECT id, outbox_id, event_type, attempt, status_code, error, duration_ms, attempted_at
+	FROM webhook_deliveries
+	WHERE webhook_id = ?
+	ORDER BY id DESC
+	LIMIT ?`
+	rows, err := db.Query(query, webhookID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := make([]WebhookDelivery, 0)
+	for rows.Next() {
+		var delivery WebhookDelivery
+		if err := rows.Scan(&delivery.ID, &delivery.OutboxID, &delivery.EventType, &delivery.Attempt, &delivery.StatusCode, &delivery.Error, &delivery.DurationMS, &delivery.AttemptedAt); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// Queues the event for every active webhook subscribed to it within the transaction of the change,
+// so a delivery is stored exactly when the change is. The payload carries the box or item as the change leaves it.
+func (d *Database) queueWebhooks(tx *sql.Tx, event Event) error {
+	webhooks, err := d.GetWebhooks(tx)
+	if err != nil {
+		return err
+	}
+	webhooks = slices.DeleteFunc(webhooks, func(webhook Webhook) bool {
+		return !webhook.Active || !webhook.Wants(event.Type)
+	})
+	if len(webhooks) == 0 {
+		return nil
+	}
+
+	payload := webhookPayload{
+		Event:     event.Type,
+		Time:      event.Time,
+		BoxID:     event.BoxID,
+		ItemID:    event.ItemID,
+		FromBoxID: event.FromBoxID,
+		Actor:     event.Actor,
+	}
+	// Attach the box or item unless it has been deleted
+	if event.ItemID != 0 && event.Type != EventItemDeleted {
+		item, err := d.GetItem(tx, event.ItemID)
+		if err != nil {
+			return err
+		}
+		payload.Item = &item
+	} else if event.ItemID == 0 && event.Type != EventBoxDeleted {
+		box, err := d.GetBox(tx, event.BoxID)
+		if err != nil {
+			return err
+		}
+		payload.Box = &box
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	query := `INSERT INTO webhook_outbox (webhook_id, event_type, payload, next_attempt_at) VALUES (?, ?, ?, ?)`
+	for _, webhook := range webhooks {
+		if _, err := tx.Exec(query, webhook.ID, event.Type, string(body), time.Now().Unix()); err != nil {
+			return fmt.Errorf("failed to queue webhook: %w", err)
+		}
+	}
+	return nil
+}
+
+// Get queued deliveries which are due for their next attempt
+func (d *Database) GetDueWebhookDeliveries(db *sql.DB, now time.Time, limit int) ([]outboxEntry, error) {
+	query := `
+	SELECT webhook_outbox.id, webhooks.id, webhooks.url, webhooks.secret, webhook_outbox.event_type, webhook_outbox.payload, webhook_outbox.attempts
+	FROM webhook_outbox
+	JOIN webhooks ON webhooks.id = webhook_outbox.webhook_id
+	WHERE webhook_outbox.status = ? AND webhook_outbox.next_attempt_at <= ?
+	ORDER BY webhook_outbox.id
+	LIMIT ?`
+	rows, err := db.Query(query, outboxPending, now.Unix(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []outboxEntry
+	for rows.Next() {
+		var entry outboxEntry
+		var payload string
+		if err := rows.Scan(&entry.ID, &entry.WebhookID, &entry.URL, &entry.Secret, &entry.EventType, &payload, &entry.Attempts); err != nil {
+			return nil, err
+		}
+		entry.Payload = []byte(payload)
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// Records a delivery attempt in the log and moves the outbox entry to its next state
+func (d *Database) RecordWebhookAttempt(db *sql.DB, entry outboxEntry, statusCode int, deliveryErr error, duration time.Duration, status string, nextAttempt time.Time) error {
+	// Start a transaction
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	// Defer a rollback in case something fails.
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var code sql.NullInt64
+	if statusCode > 0 {
+		code = sql.NullInt64{Int64: int64(statusCode), Valid: true}
+	}
+	var message sql.NullString
+	if deliveryErr != nil {
+		message = sql.NullString{String: deliveryErr.Error(), Valid: true}
+	}
+	logQuery := `
+	INSERT INTO webhook_deliveries (outbox_id, webhook_id, event_type, attempt, status_code, error, duration_ms)
+	VALUES (?, ?, ?, ?, ?, ?, ?)`
+	_, err = tx.Exec(logQuery, entry.ID, entry.WebhookID, entry.EventType, entry.Attempts+1, code, message, duration.Milliseconds())
+	if err != nil {
+		return fmt.Errorf("failed to log webhook delivery: %w", err)
+	}
+	outboxQuery := `UPDATE webhook_outbox SET status = ?, attempts = attempts + 1, next_attempt_at = ? WHERE id = ?`
+	_, err = tx.Exec(outboxQuery, status, nextAttempt.Unix(), entry.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update webhook outbox: %w", err)
+	}
+
+	// Commit the transaction
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// Sends the deliveries queued in the outbox with exponential backoff.
+// Deliveries are persisted in the outbox first so they survive restarts.
+type WebhookDispatcher struct {
+	db          *sql.DB
+	client      *http.Client
+	maxAttempts int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+	wake        chan struct{}
+}
+
+// Creates a dispatcher for the given database connection
+func NewWebhookDispatcher(db *sql.DB, maxAttempts int) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		db:          db,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		maxAttempts: maxAttempts,
+		baseDelay:   10 * time.Second,
+		maxDelay:    time.Hour,
+		wake:        make(chan struct{}, 1),
+	}
+}
+
+// Starts delivering queued webhooks in the background.
+// Changes queue their deliveries themselves, events on the bus only wake the dispatcher up early.
+func (w *WebhookDispatcher) Start(bus *EventBus) {
+	events, _ := bus.Subscribe(1, nil)
+	go func() {
+		for range events {
+			select {
+			case w.wake <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	go w.deliverLoop()
+}
+
+// Periodically sends all due deliveries, or immediately when new ones are queued
+func (w *WebhookDispatcher) deliverLoop() {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for {
+		entries, err := database.GetDueWebhookDeliveries(w.db, time.Now(), 50)
+		if err != nil {
+			log.Printf("could not read webhook outbox: %v", err)
+		}
+		for _, entry := range entries {
+			w.deliver(entry)
+		}
+		select {
+		case <-ticker.C:
+		case <-w.wake:
+		}
+	}
+}
+
+// Sends a single delivery and schedules a retry if the receiver did not accept it
+func (w *WebhookDispatcher) deliver(entry outboxEntry) {
+	start := time.Now()
+	statusCode, err := w.post(entry)
+	duration := time.Since(start)
+
+	status := outboxDelivered
+	nextAttempt := time.Now()
+	if err != nil {
+		status = outboxPending
+		nextAttempt = nextAttempt.Add(w.backoff(entry.Attempts + 1))
+		if entry.Attempts+1 >= w.maxAttempts {
+			status = outboxFailed
+			log.Printf("giving up on webhook delivery %d to %s: %v", entry.ID, entry.URL, err)
+		}
+	}
+	if err := database.RecordWebhookAttempt(w.db, entry, statusCode, err, duration, status, nextAttempt); err != nil {
+		log.Println(err)
+	}
+}
+
+// Posts the signed payload to the receiver. Any non 2xx response counts as a failure.
+func (w *WebhookDispatcher) post(entry outboxEntry) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, entry.URL, bytes.NewReader(entry.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "witb/"+version)
+	req.Header.Set("X-WITB-Event", entry.EventType)
+	req.Header.Set("X-WITB-Delivery", fmt.Sprint(entry.ID))
+	req.Header.Set("X-WITB-Signature", signWebhookPayload(entry.Secret, entry.Payload))
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("receiver responded with %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// Delay before the given attempt: the base delay doubled for every failed attempt, capped at maxDelay
+func (w *WebhookDispatcher) backoff(attempt int) time.Duration {
+	delay := w.baseDelay
+	for i := 1; i < attempt && delay < w.maxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, w.maxDelay)
+}
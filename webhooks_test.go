@@ -0,0 +1,300 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Opens a new migrated database in a temporary directory
+func testDatabase(t *testing.T) (*Database, *sql.DB) {
+	t.Helper()
+	d := &Database{
+		DBFilePath:     filepath.Join(t.TempDir(), "witb.db"),
+		Events:         NewEventBus(),
+		BoxCodePattern: defaultBoxCodePattern,
+	}
+	db := d.Init()
+	t.Cleanup(func() { db.Close() })
+	return d, db
+}
+
+// Reads the state of a queued delivery
+func outboxState(t *testing.T, db *sql.DB, id int) (status string, attempts int, nextAttempt time.Time) {
+	t.Helper()
+	var next int64
+	err := db.QueryRow(`SELECT status, attempts, next_attempt_at FROM webhook_outbox WHERE id = ?`, id).Scan(&status, &attempts, &next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return status, attempts, time.Unix(next, 0)
+}
+
+func TestSignWebhookPayload(t *testing.T) {
+	// Well known HMAC-SHA256 example
+	got := signWebhookPayload("key", []byte("The quick brown fox jumps over the lazy dog"))
+	want := "sha256=f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8"
+	if got != want {
+		t.Errorf("signature = %s, want %s", got, want)
+	}
+	if signWebhookPayload("other", []byte("payload")) == signWebhookPayload("key", []byte("payload")) {
+		t.Error("signatures with different secrets are equal")
+	}
+}
+
+func TestQueueWebhooksWithChange(t *testing.T) {
+	d, db := testDatabase(t)
+	items, err := d.CreateWebhook(db, "http://example.com/items", []string{EventItemCreated, EventItemUpdated}, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.CreateWebhook(db, "http://example.com/boxes", []string{EventBoxDeleted}, "secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.As("alice").CreateBox(db, 1, "Tools", "Garage"); err != nil {
+		t.Fatal(err)
+	}
+	boxes, err := d.GetBoxes(db, 1)
+	if err != nil || len(boxes) != 1 {
+		t.Fatalf("boxes = %v, %v", boxes, err)
+	}
+	itemID, err := d.As("alice").CreateItem(db, boxes[0].ID, "Hammer", 2, ItemAttributes{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A conflicting update changes nothing and must not queue anything
+	if err := d.UpdateBoxContent(db, 0, itemID, "Mallet", 3, ItemAttributes{}, 42); err != ErrVersionConflict {
+		t.Fatalf("update with stale version = %v, want %v", err, ErrVersionConflict)
+	}
+
+	entries, err := d.GetDueWebhookDeliveries(db, time.Now().Add(time.Minute), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("queued %d deliveries, want 1: %+v", len(entries), entries)
+	}
+	entry := entries[0]
+	if entry.WebhookID != items.ID || entry.EventType != EventItemCreated || entry.Secret != "secret" {
+		t.Errorf("queued %+v", entry)
+	}
+	var payload webhookPayload
+	if err := json.Unmarshal(entry.Payload, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Event != EventItemCreated || payload.BoxID != boxes[0].ID || payload.ItemID != itemID || payload.Actor != "alice" {
+		t.Errorf("payload = %+v", payload)
+	}
+	if payload.Item == nil || payload.Item.Name != "Hammer" || payload.Item.Quantity != 2 {
+		t.Errorf("payload item = %+v", payload.Item)
+	}
+	if payload.Time.IsZero() {
+		t.Error("payload has no time")
+	}
+
+	if err := d.DeleteBox(db, boxes[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	entries, err = d.GetDueWebhookDeliveries(db, time.Now().Add(time.Minute), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[1].EventType != EventBoxDeleted {
+		t.Fatalf("queued %+v, want a delivery for the deleted box", entries)
+	}
+	payload = webhookPayload{}
+	if err := json.Unmarshal(entries[1].Payload, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Box != nil || payload.BoxID != boxes[0].ID {
+		t.Errorf("payload for deleted box = %+v", payload)
+	}
+}
+
+// Webhook receiver which answers with the given status codes in turn and remembers the requests
+type testReceiver struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (r *testReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	status := r.statuses[min(len(r.requests), len(r.statuses)-1)]
+	r.requests = append(r.requests, req)
+	r.bodies = append(r.bodies, body)
+	w.WriteHeader(status)
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	d, db := testDatabase(t)
+	receiver := &testReceiver{statuses: []int{http.StatusNoContent}}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	webhook, err := d.CreateWebhook(db, server.URL, []string{"*"}, "s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.CreateBox(db, 1, "Books", ""); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := d.GetDueWebhookDeliveries(db, time.Now(), 10)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("due deliveries = %+v, %v", entries, err)
+	}
+
+	dispatcher := NewWebhookDispatcher(db, 3)
+	dispatcher.deliver(entries[0])
+
+	if len(receiver.requests) != 1 {
+		t.Fatalf("receiver got %d requests, want 1", len(receiver.requests))
+	}
+	req, body := receiver.requests[0], receiver.bodies[0]
+	if req.Method != http.MethodPost || req.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("request %s with content type %q", req.Method, req.Header.Get("Content-Type"))
+	}
+	if got := req.Header.Get("X-WITB-Event"); got != EventBoxCreated {
+		t.Errorf("X-WITB-Event = %q", got)
+	}
+	if got, want := req.Header.Get("X-WITB-Signature"), signWebhookPayload("s3cret", body); got != want {
+		t.Errorf("X-WITB-Signature = %q, want %q", got, want)
+	}
+	if string(body) != string(entries[0].Payload) {
+		t.Errorf("body = %s, want %s", body, entries[0].Payload)
+	}
+
+	if status, attempts, _ := outboxState(t, db, entries[0].ID); status != outboxDelivered || attempts != 1 {
+		t.Errorf("outbox entry is %s after %d attempts", status, attempts)
+	}
+	deliveries, err := d.GetWebhookDeliveries(db, webhook.ID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 {
+		t.Fatalf("logged %d deliveries, want 1", len(deliveries))
+	}
+	delivery := deliveries[0]
+	if delivery.OutboxID != entries[0].ID || delivery.Attempt != 1 || delivery.StatusCode.Int64 != http.StatusNoContent || delivery.Error.Valid {
+		t.Errorf("logged %+v", delivery)
+	}
+	if due, _ := d.GetDueWebhookDeliveries(db, time.Now().Add(time.Hour), 10); len(due) != 0 {
+		t.Errorf("delivered entry is still due: %+v", due)
+	}
+}
+
+func TestWebhookRetry(t *testing.T) {
+	d, db := testDatabase(t)
+	receiver := &testReceiver{statuses: []int{http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable}}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	webhook, err := d.CreateWebhook(db, server.URL, []string{EventBoxCreated}, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.CreateBox(db, 1, "Books", ""); err != nil {
+		t.Fatal(err)
+	}
+	dispatcher := NewWebhookDispatcher(db, 3)
+	dispatcher.baseDelay = time.Minute
+
+	// Every failed attempt is retried later, the last one gives up
+	wantStatus := []string{outboxPending, outboxPending, outboxFailed}
+	for attempt := 1; attempt <= 3; attempt++ {
+		entries, err := d.GetDueWebhookDeliveries(db, time.Now().Add(time.Hour), 10)
+		if err != nil || len(entries) != 1 {
+			t.Fatalf("attempt %d: due deliveries = %+v, %v", attempt, entries, err)
+		}
+		before := time.Now()
+		dispatcher.deliver(entries[0])
+
+		status, attempts, nextAttempt := outboxState(t, db, entries[0].ID)
+		if status != wantStatus[attempt-1] || attempts != attempt {
+			t.Errorf("attempt %d: outbox entry is %s after %d attempts", attempt, status, attempts)
+		}
+		if status == outboxPending {
+			delay := dispatcher.backoff(attempt)
+			if nextAttempt.Before(before.Add(delay).Truncate(time.Second)) || nextAttempt.After(time.Now().Add(delay)) {
+				t.Errorf("attempt %d: next attempt at %v, want %v later", attempt, nextAttempt, delay)
+			}
+			if due, _ := d.GetDueWebhookDeliveries(db, time.Now(), 10); len(due) != 0 {
+				t.Errorf("attempt %d: retried before the backoff: %+v", attempt, due)
+			}
+		}
+	}
+	if due, _ := d.GetDueWebhookDeliveries(db, time.Now().Add(24*time.Hour), 10); len(due) != 0 {
+		t.Errorf("failed entry is still due: %+v", due)
+	}
+
+	deliveries, err := d.GetWebhookDeliveries(db, webhook.ID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 3 {
+		t.Fatalf("logged %d deliveries, want 3", len(deliveries))
+	}
+	// Newest first
+	for i, want := range []int{http.StatusServiceUnavailable, http.StatusBadGateway, http.StatusInternalServerError} {
+		delivery := deliveries[i]
+		if delivery.Attempt != 3-i || delivery.StatusCode.Int64 != int64(want) || !delivery.Error.Valid || delivery.EventType != EventBoxCreated {
+			t.Errorf("delivery %d = %+v", i, delivery)
+		}
+	}
+}
+
+func TestWebhookUnreachable(t *testing.T) {
+	d, db := testDatabase(t)
+	server := httptest.NewServer(http.NotFoundHandler())
+	url := server.URL
+	server.Close()
+
+	webhook, err := d.CreateWebhook(db, url, []string{"*"}, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.CreateBox(db, 1, "Books", ""); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := d.GetDueWebhookDeliveries(db, time.Now(), 10)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("due deliveries = %+v, %v", entries, err)
+	}
+	NewWebhookDispatcher(db, 5).deliver(entries[0])
+
+	deliveries, err := d.GetWebhookDeliveries(db, webhook.ID, 10)
+	if err != nil || len(deliveries) != 1 {
+		t.Fatalf("deliveries = %+v, %v", deliveries, err)
+	}
+	if deliveries[0].StatusCode.Valid || !deliveries[0].Error.Valid {
+		t.Errorf("logged %+v, want an error without status code", deliveries[0])
+	}
+	if status, _, _ := outboxState(t, db, entries[0].ID); status != outboxPending {
+		t.Errorf("outbox entry is %s, want %s", status, outboxPending)
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	dispatcher := &WebhookDispatcher{baseDelay: 10 * time.Second, maxDelay: time.Minute}
+	for attempt, want := range map[int]time.Duration{
+		1:  10 * time.Second,
+		2:  20 * time.Second,
+		3:  40 * time.Second,
+		4:  time.Minute,
+		20: time.Minute,
+	} {
+		if got := dispatcher.backoff(attempt); got != want {
+			t.Errorf("backoff(%d) = %v, want %v", attempt, got, want)
+		}
+	}
+}
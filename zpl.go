@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Largest magnification of ZPL QR codes
+const zplMaxQRMagnification = 10
+
+// Characters with a meaning in ZPL are written as hex escapes after ^FH_
+var zplEscaper = strings.NewReplacer("_", "_5F", "^", "_5E", "~", "_7E")
+
+// Renders labels as ZPL for Zebra printers, one ^XA ... ^XZ format per label.
+// Text uses the scalable font 0 in UTF-8, QR codes the printer's own QR generator.
+func renderZPL(template LabelTemplate, dpi int, labels []Label, baseURL string) ([]byte, error) {
+	width := mmToDots(template.WidthMM, dpi)
+	height := mmToDots(template.HeightMM, dpi)
+	var zpl bytes.Buffer
+	for _, label := range labels {
+		zpl.WriteString("^XA\n^CI28\n")
+		fmt.Fprintf(&zpl, "^PW%d\n^LL%d\n^LH0,0\n", width, height)
+		for _, block := range template.layout(label, baseURL, width, height) {
+			if block.Field == labelFieldQR {
+				modules, err := qrModules(block.Text)
+				if err != nil {
+					return nil, err
+				}
+				magnification := max(1, min(zplMaxQRMagnification, block.Rect.Dx()/len(modules)))
+				// M is the error correction level and A the automatic choice of the data mode
+				fmt.Fprintf(&zpl, "^FO%d,%d^BQN,2,%d^FH_^FDMA,%s^FS\n", block.Rect.Min.X, block.Rect.Min.Y, magnification, zplEscaper.Replace(block.Text))
+				continue
+			}
+			// Font 0 glyphs are about 0.6 times as wide as they are high
+			size := min(block.Rect.Dy()*4/5, block.Rect.Dx()*10/(6*len([]rune(block.Text))))
+			size = max(10, size)
+			top := block.Rect.Min.Y + (block.Rect.Dy()-size)/2
+			// ^FB keeps the text on a single line within the block
+			fmt.Fprintf(&zpl, "^FO%d,%d^A0N,%d,%d^FB%d,1,0,L^FH_^FD%s^FS\n", block.Rect.Min.X, top, size, size, block.Rect.Dx(), zplEscaper.Replace(block.Text))
+		}
+		zpl.WriteString("^XZ\n")
+	}
+	return zpl.Bytes(), nil
+}
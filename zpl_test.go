@@ -0,0 +1,65 @@
+package main
+
+import "testing"
+
+// Labels of a box and an item with characters that have to be escaped in ZPL
+var testLabels = []Label{
+	{Kind: "box", ID: 1, InventoryID: 1, Name: "Tools", Text: "Garage ^shelf_2~", Code: "B-0001"},
+	{Kind: "item", ID: 7, InventoryID: 2, Name: "Hammer", Code: "I-0007"},
+}
+
+// Template of a 62 x 29 mm label with all fields
+var testLabelTemplate = LabelTemplate{Fields: []string{labelFieldQR, labelFieldName, labelFieldLabel, labelFieldCode}, WidthMM: 62, HeightMM: 29}
+
+func TestRenderZPL(t *testing.T) {
+	got, err := renderZPL(testLabelTemplate, 203, testLabels, "https://witb.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 62 x 29 mm are 496 x 232 dots at 203 dpi. The QR code fills the height within the margin of 14 dots,
+	// the text lines share the rest in the ratio 3:2:3. The item has no label text, so its lines are higher.
+	want := `^XA
+^CI28
+^PW496
+^LL232
+^LH0,0
+^FO14,14^BQN,2,7^FH_^FDMA,https://witb.example/i/1/s/B-0001^FS
+^FO232,22^A0N,60,60^FB250,1,0,L^FH_^FDTools^FS
+^FO232,102^A0N,26,26^FB250,1,0,L^FH_^FDGarage _5Eshelf_5F2_7E^FS
+^FO232,149^A0N,60,60^FB250,1,0,L^FH_^FDB-0001^FS
+^XZ
+^XA
+^CI28
+^PW496
+^LL232
+^LH0,0
+^FO14,14^BQN,2,7^FH_^FDMA,https://witb.example/i/2/s/I-0007^FS
+^FO232,30^A0N,69,69^FB250,1,0,L^FH_^FDHammer^FS
+^FO232,132^A0N,69,69^FB250,1,0,L^FH_^FDI-0007^FS
+^XZ
+`
+	if string(got) != want {
+		t.Errorf("renderZPL =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestRenderZPLNarrowLabel(t *testing.T) {
+	// Too narrow for text next to the QR code, which moves above the text
+	template := LabelTemplate{Fields: []string{labelFieldQR, labelFieldCode}, WidthMM: 25, HeightMM: 50}
+	got, err := renderZPL(template, 300, testLabels[:1], "http://localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `^XA
+^CI28
+^PW295
+^LL591
+^LH0,0
+^FO18,18^BQN,2,8^FH_^FDMA,http://localhost/i/1/s/B-0001^FS
+^FO18,398^A0N,71,71^FB259,1,0,L^FH_^FDB-0001^FS
+^XZ
+`
+	if string(got) != want {
+		t.Errorf("renderZPL =\n%s\nwant\n%s", got, want)
+	}
+}